@@ -0,0 +1,325 @@
+package application
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"messaging-app/domain"
+	"messaging-app/infrastructure/blob"
+	"messaging-app/infrastructure/repository"
+	"messaging-app/pkg/apistatus"
+)
+
+// maxFileNameLength is the longest file name kept for an attachment, in bytes.
+const maxFileNameLength = 255
+
+// sniffLength is the number of leading bytes used to detect the content type.
+const sniffLength = 512
+
+type AttachmentService interface {
+	Upload(ctx context.Context, chatID, uploaderID int64, fileName string, content io.Reader) (*domain.Attachment, apistatus.Status)
+	GetAttachment(ctx context.Context, attachmentID, userID int64) (*domain.Attachment, apistatus.Status)
+	OpenAttachment(ctx context.Context, attachmentID, userID, expires int64, signature string) (*domain.Attachment, io.ReadCloser, apistatus.Status)
+	OpenThumbnail(ctx context.Context, attachmentID, userID, expires int64, signature string) (*domain.Attachment, io.ReadCloser, apistatus.Status)
+	DescribeAttachments(ctx context.Context, userID int64, msgs []*domain.Message) ([]*domain.Message, apistatus.Status)
+}
+
+type attachmentService struct {
+	messageRepo repository.MessageRepository
+	chatRepo    repository.ChatRepository
+	store       blob.Store
+	maxBytes    int64
+	secret      []byte
+	urlTTL      time.Duration
+}
+
+// NewAttachmentService creates the service. Uploads larger than maxBytes are
+// rejected, and download URLs are signed with secret and stay valid for
+// urlTTL.
+func NewAttachmentService(messageRepo repository.MessageRepository, chatRepo repository.ChatRepository, store blob.Store, maxBytes int64, secret []byte, urlTTL time.Duration) AttachmentService {
+	return &attachmentService{
+		messageRepo: messageRepo,
+		chatRepo:    chatRepo,
+		store:       store,
+		maxBytes:    maxBytes,
+		secret:      secret,
+		urlTTL:      urlTTL,
+	}
+}
+
+// Upload stores content as a file shared with chatID. The content type is
+// detected from the content itself; only PDF, JPEG and PNG files are
+// accepted. An attachment.uploaded event lets the thumbnail worker pick up
+// images.
+func (s *attachmentService) Upload(ctx context.Context, chatID, uploaderID int64, fileName string, content io.Reader) (*domain.Attachment, apistatus.Status) {
+	if chatID <= 0 || uploaderID <= 0 {
+		return nil, apistatus.New("invalid chatID or uploaderID").UnprocessableEntity()
+	}
+	// Browsers may send the full client path; keep the last element only.
+	fileName = strings.TrimSpace(fileName[strings.LastIndexAny(fileName, `/\`)+1:])
+	if fileName == "" || len(fileName) > maxFileNameLength || !utf8.ValidString(fileName) {
+		return nil, apistatus.New("file name must be between 1 and %d bytes of UTF-8", maxFileNameLength).UnprocessableEntity()
+	}
+	chat, as := s.chatRepo.GetChatByID(ctx, chatID)
+	if as != nil {
+		return nil, as
+	}
+	if !chat.HasParticipant(uploaderID) {
+		return nil, apistatus.New("user is not a participant of the chat").UnprocessableEntity()
+	}
+
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(content, head)
+	if err == io.EOF {
+		return nil, apistatus.New("file is empty").UnprocessableEntity()
+	}
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, uploadError(err)
+	}
+	head = head[:n]
+	contentType := http.DetectContentType(head)
+	if !domain.IsAllowedAttachmentType(contentType) {
+		return nil, apistatus.New("files of type %s cannot be shared", contentType).UnsupportedMediaType()
+	}
+
+	key, err := newStorageKey()
+	if err != nil {
+		return nil, apistatus.New(err).InternalServerError()
+	}
+	// Read one byte past the limit to tell a file of exactly maxBytes from a
+	// larger one.
+	size, err := s.store.Put(ctx, key, io.LimitReader(io.MultiReader(bytes.NewReader(head), content), s.maxBytes+1))
+	if err == nil && size > s.maxBytes {
+		err = errTooLarge
+	}
+	if err != nil {
+		s.deleteBlob(key)
+		return nil, uploadError(err)
+	}
+
+	attachment, as := s.messageRepo.CreateAttachmentWithOutbox(ctx, &domain.Attachment{
+		ChatID:      chatID,
+		UploaderID:  uploaderID,
+		FileName:    fileName,
+		ContentType: contentType,
+		Size:        size,
+		StorageKey:  key,
+		CreatedAt:   time.Now(),
+	}, func(a *domain.Attachment) (*domain.OutboxEntry, error) {
+		return newOutboxEntry(ctx, domain.EventTypeAttachmentUploaded, chatID, a.ID, &domain.AttachmentUploadedEvent{
+			AttachmentID: a.ID,
+			ChatID:       chatID,
+			UploaderID:   uploaderID,
+			ContentType:  contentType,
+			Size:         size,
+		})
+	})
+	if as != nil {
+		s.deleteBlob(key)
+		return nil, as
+	}
+	return s.withURL(attachment, uploaderID), nil
+}
+
+// errTooLarge is returned by Upload for content longer than maxBytes.
+var errTooLarge = errors.New("file is too large")
+
+// uploadError maps a failure to read or store an upload to a status.
+func uploadError(err error) apistatus.Status {
+	var maxBytesErr *http.MaxBytesError
+	if errors.Is(err, errTooLarge) || errors.As(err, &maxBytesErr) {
+		return apistatus.New("file is too large").RequestEntityTooLarge()
+	}
+	return apistatus.New(err).InternalServerError()
+}
+
+// deleteBlob removes the content of an upload that was not stored. It runs
+// detached from the request, which may have been cancelled.
+func (s *attachmentService) deleteBlob(key string) {
+	if err := s.store.Delete(context.Background(), key); err != nil {
+		log.Printf("failed to delete blob %s: %v", key, err)
+	}
+}
+
+// newStorageKey returns a random key for a new blob.
+func newStorageKey() (string, error) {
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(key), nil
+}
+
+// GetAttachment returns an attachment with a download URL for userID.
+func (s *attachmentService) GetAttachment(ctx context.Context, attachmentID, userID int64) (*domain.Attachment, apistatus.Status) {
+	if attachmentID <= 0 || userID <= 0 {
+		return nil, apistatus.New("invalid attachmentID or userID").UnprocessableEntity()
+	}
+	attachment, as := s.authorize(ctx, attachmentID, userID)
+	if as != nil {
+		return nil, as
+	}
+	return s.withURL(attachment, userID), nil
+}
+
+// OpenAttachment checks a download URL signed for userID and returns the
+// attachment with its content, which the caller closes. Access is checked
+// again, so leaving the chat revokes URLs handed out earlier.
+func (s *attachmentService) OpenAttachment(ctx context.Context, attachmentID, userID, expires int64, signature string) (*domain.Attachment, io.ReadCloser, apistatus.Status) {
+	attachment, as := s.checkURL(ctx, attachmentID, userID, expires, signature)
+	if as != nil {
+		return nil, nil, as
+	}
+	content, as := s.open(ctx, attachment.StorageKey)
+	if as != nil {
+		return nil, nil, as
+	}
+	return attachment, content, nil
+}
+
+// OpenThumbnail is OpenAttachment for the thumbnail of an image attachment.
+// The thumbnail URL is signed like the download URL of the attachment.
+func (s *attachmentService) OpenThumbnail(ctx context.Context, attachmentID, userID, expires int64, signature string) (*domain.Attachment, io.ReadCloser, apistatus.Status) {
+	attachment, as := s.checkURL(ctx, attachmentID, userID, expires, signature)
+	if as != nil {
+		return nil, nil, as
+	}
+	if attachment.Thumbnail == nil {
+		return nil, nil, apistatus.New("attachment has no thumbnail").NotFound()
+	}
+	content, as := s.open(ctx, attachment.ThumbnailKey())
+	if as != nil {
+		return nil, nil, as
+	}
+	return attachment, content, nil
+}
+
+// checkURL verifies the signature and expiry of a URL signed for userID and
+// returns the attachment if they can still read it.
+func (s *attachmentService) checkURL(ctx context.Context, attachmentID, userID, expires int64, signature string) (*domain.Attachment, apistatus.Status) {
+	if !hmac.Equal([]byte(signature), []byte(s.sign(attachmentID, userID, expires))) {
+		return nil, apistatus.New("invalid download signature").Forbidden()
+	}
+	if time.Now().Unix() > expires {
+		return nil, apistatus.New("download URL has expired").Forbidden()
+	}
+	return s.authorize(ctx, attachmentID, userID)
+}
+
+// open returns the blob stored under key.
+func (s *attachmentService) open(ctx context.Context, key string) (io.ReadCloser, apistatus.Status) {
+	content, err := s.store.Open(ctx, key)
+	if errors.Is(err, blob.ErrNotFound) {
+		return nil, apistatus.New("attachment content not found").NotFound()
+	}
+	if err != nil {
+		return nil, apistatus.New(err).InternalServerError()
+	}
+	return content, nil
+}
+
+// authorize returns the attachment if userID can read it: they take part in
+// its chat and, until it is sent, uploaded it. Attachments of messages
+// deleted for everyone are gone.
+func (s *attachmentService) authorize(ctx context.Context, attachmentID, userID int64) (*domain.Attachment, apistatus.Status) {
+	attachment, as := s.messageRepo.GetAttachment(ctx, attachmentID)
+	if as != nil {
+		return nil, as
+	}
+	chat, as := s.chatRepo.GetChatByID(ctx, attachment.ChatID)
+	if as != nil {
+		return nil, as
+	}
+	if !chat.HasParticipant(userID) {
+		return nil, apistatus.New("user is not a participant of the chat").UnprocessableEntity()
+	}
+	if attachment.MessageID == 0 && attachment.UploaderID != userID {
+		return nil, apistatus.New("attachment not found").NotFound()
+	}
+	if attachment.MessageID != 0 {
+		msg, as := s.messageRepo.GetMessageByID(ctx, attachment.MessageID)
+		if as != nil {
+			return nil, as
+		}
+		if msg.IsDeleted() {
+			return nil, apistatus.New("attachment not found").NotFound()
+		}
+	}
+	return attachment, nil
+}
+
+// DescribeAttachments returns msgs with the attachments they carry filled
+// in. Unless userID is zero, each attachment gets a download URL for them.
+// Messages with attachments are copied rather than changed.
+func (s *attachmentService) DescribeAttachments(ctx context.Context, userID int64, msgs []*domain.Message) ([]*domain.Message, apistatus.Status) {
+	var ids []int64
+	for _, msg := range msgs {
+		ids = append(ids, msg.AttachmentIDs...)
+	}
+	if len(ids) == 0 {
+		return msgs, nil
+	}
+	attachments, as := s.messageRepo.GetAttachments(ctx, ids)
+	if as != nil {
+		return nil, as
+	}
+	byID := make(map[int64]*domain.Attachment, len(attachments))
+	for _, attachment := range attachments {
+		byID[attachment.ID] = attachment
+	}
+	result := make([]*domain.Message, len(msgs))
+	for i, msg := range msgs {
+		result[i] = msg
+		if len(msg.AttachmentIDs) == 0 {
+			continue
+		}
+		described := *msg
+		described.Attachments = make([]*domain.Attachment, 0, len(msg.AttachmentIDs))
+		for _, id := range msg.AttachmentIDs {
+			if attachment, ok := byID[id]; ok {
+				described.Attachments = append(described.Attachments, s.withURL(attachment, userID))
+			}
+		}
+		result[i] = &described
+	}
+	return result, nil
+}
+
+// withURL returns a copy of attachment with download URLs of the content and
+// any thumbnail signed for userID, or without them when userID is zero.
+func (s *attachmentService) withURL(attachment *domain.Attachment, userID int64) *domain.Attachment {
+	described := *attachment
+	if attachment.Thumbnail != nil {
+		thumbnail := *attachment.Thumbnail
+		described.Thumbnail = &thumbnail
+	}
+	if userID != 0 {
+		expires := time.Now().Add(s.urlTTL).Unix()
+		query := fmt.Sprintf("userId=%d&expires=%d&signature=%s", userID, expires, s.sign(attachment.ID, userID, expires))
+		described.URL = fmt.Sprintf("/attachments/%d/content?%s", attachment.ID, query)
+		if described.Thumbnail != nil {
+			described.Thumbnail.URL = fmt.Sprintf("/attachments/%d/thumbnail?%s", attachment.ID, query)
+		}
+	}
+	return &described
+}
+
+// sign returns the signature of a download URL of attachmentID for userID
+// expiring at expires, in Unix seconds.
+func (s *attachmentService) sign(attachmentID, userID, expires int64) string {
+	mac := hmac.New(sha256.New, s.secret)
+	fmt.Fprintf(mac, "%d:%d:%d", attachmentID, userID, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
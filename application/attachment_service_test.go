@@ -0,0 +1,175 @@
+package application
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"messaging-app/domain"
+	"messaging-app/infrastructure/blob"
+	"messaging-app/infrastructure/repository"
+)
+
+// pngContent is the start of a PNG file, enough for content sniffing.
+const pngContent = "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"
+
+func newTestAttachmentService(t *testing.T, maxBytes int64, urlTTL time.Duration) (AttachmentService, MessageService, string) {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := blob.NewLocalStore(dir)
+	if err != nil {
+		t.Fatalf("NewLocalStore failed: %v", err)
+	}
+	msgRepo := repository.NewInMemoryMessageRepository()
+	chatRepo := repository.NewInMemoryChatRepository(msgRepo)
+	messages := NewMessageService(msgRepo, chatRepo, repository.NewInMemoryUserRepository(), nil, 0)
+	return NewAttachmentService(msgRepo, chatRepo, store, maxBytes, []byte("secret"), urlTTL), messages, dir
+}
+
+// openURL downloads an attachment or its thumbnail through a URL handed out
+// by the service.
+func openURL(t *testing.T, service AttachmentService, rawURL string) (string, int) {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatalf("invalid URL %q: %v", rawURL, err)
+	}
+	id, _ := strconv.ParseInt(strings.Split(u.Path, "/")[2], 10, 64)
+	userID, _ := strconv.ParseInt(u.Query().Get("userId"), 10, 64)
+	expires, _ := strconv.ParseInt(u.Query().Get("expires"), 10, 64)
+	open := service.OpenAttachment
+	if strings.HasSuffix(u.Path, "/thumbnail") {
+		open = service.OpenThumbnail
+	}
+	_, content, apistatus := open(context.Background(), id, userID, expires, u.Query().Get("signature"))
+	if apistatus != nil {
+		return "", apistatus.GetStatus()
+	}
+	defer content.Close()
+	body, _ := io.ReadAll(content)
+	return string(body), http.StatusOK
+}
+
+// TestUploadAttachment checks content sniffing, size limits and membership.
+func TestUploadAttachment(t *testing.T) {
+	service, messages, dir := newTestAttachmentService(t, 64, time.Hour)
+	ctx := context.Background()
+	chat, _ := messages.CreateChat(ctx, 1, 2)
+
+	attachment, apistatus := service.Upload(ctx, chat.ID, 1, `C:\photos\cat.txt`, strings.NewReader(pngContent))
+	if apistatus != nil {
+		t.Fatalf("Upload failed: %s", apistatus.GetMessage())
+	}
+	// The name is kept but the type comes from the content.
+	if attachment.FileName != "cat.txt" || attachment.ContentType != domain.AttachmentTypePNG || attachment.Size != int64(len(pngContent)) {
+		t.Errorf("unexpected attachment %+v", attachment)
+	}
+	if body, status := openURL(t, service, attachment.URL); status != http.StatusOK || body != pngContent {
+		t.Errorf("expected the uploader to download the content, got %d %q", status, body)
+	}
+	if pdf, apistatus := service.Upload(ctx, chat.ID, 2, "plan.pdf", strings.NewReader("%PDF-1.7\n")); apistatus != nil || pdf.ContentType != domain.AttachmentTypePDF {
+		t.Errorf("expected a PDF, got %+v, %v", pdf, apistatus)
+	}
+
+	tests := []struct {
+		name     string
+		chatID   int64
+		userID   int64
+		fileName string
+		content  string
+		status   int
+	}{
+		{"text", chat.ID, 1, "notes.png", "just some notes", http.StatusUnsupportedMediaType},
+		{"too large", chat.ID, 1, "big.png", pngContent + strings.Repeat("x", 64), http.StatusRequestEntityTooLarge},
+		{"empty", chat.ID, 1, "empty.png", "", http.StatusUnprocessableEntity},
+		{"no name", chat.ID, 1, "photos/", pngContent, http.StatusUnprocessableEntity},
+		{"not a participant", chat.ID, 3, "cat.png", pngContent, http.StatusUnprocessableEntity},
+		{"unknown chat", 999, 1, "cat.png", pngContent, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, apistatus := service.Upload(ctx, tt.chatID, tt.userID, tt.fileName, strings.NewReader(tt.content)); apistatus == nil || apistatus.GetStatus() != tt.status {
+				t.Errorf("expected %d, got %v", tt.status, apistatus)
+			}
+		})
+	}
+	// Rejected uploads leave no blobs behind.
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("expected the blobs of the two uploads only, got %d entries", len(entries))
+	}
+}
+
+// TestAttachmentAccess checks who can read an attachment before and after it
+// is sent, and that download URLs cannot be forged.
+func TestAttachmentAccess(t *testing.T) {
+	service, messages, _ := newTestAttachmentService(t, 1<<20, time.Hour)
+	ctx := context.Background()
+	chat, _ := messages.CreateChat(ctx, 1, 2)
+	attachment, _ := service.Upload(ctx, chat.ID, 1, "cat.png", strings.NewReader(pngContent))
+
+	// Until it is sent, only the uploader sees the attachment.
+	if _, apistatus := service.GetAttachment(ctx, attachment.ID, 2); apistatus == nil || apistatus.GetStatus() != http.StatusNotFound {
+		t.Errorf("expected not found for the other participant, got %v", apistatus)
+	}
+	if _, apistatus := messages.SendMessageWithAttachments(ctx, chat.ID, 2, 0, "mine now", []int64{attachment.ID}); apistatus == nil || apistatus.GetStatus() != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422 sending someone else's attachment, got %v", apistatus)
+	}
+	msg, apistatus := messages.SendMessageWithAttachments(ctx, chat.ID, 1, 0, "look", []int64{attachment.ID})
+	if apistatus != nil {
+		t.Fatalf("SendMessageWithAttachments failed: %s", apistatus.GetMessage())
+	}
+	if _, apistatus := messages.SendMessageWithAttachments(ctx, chat.ID, 1, 0, "again", []int64{attachment.ID}); apistatus == nil || apistatus.GetStatus() != http.StatusConflict {
+		t.Errorf("expected a conflict sending an attachment twice, got %v", apistatus)
+	}
+
+	described, apistatus := service.DescribeAttachments(ctx, 2, []*domain.Message{msg})
+	if apistatus != nil {
+		t.Fatalf("DescribeAttachments failed: %s", apistatus.GetMessage())
+	}
+	if len(described[0].Attachments) != 1 || described[0].Attachments[0].URL == "" || msg.Attachments != nil {
+		t.Fatalf("expected a described copy of the message, got %+v", described[0])
+	}
+	download := described[0].Attachments[0].URL
+	if body, status := openURL(t, service, download); status != http.StatusOK || body != pngContent {
+		t.Errorf("expected the recipient to download the content, got %d %q", status, body)
+	}
+	if _, status := openURL(t, service, strings.Replace(download, "userId=2", "userId=1", 1)); status != http.StatusForbidden {
+		t.Errorf("expected 403 for a URL signed for someone else, got %d", status)
+	}
+	if _, _, apistatus := service.OpenAttachment(ctx, attachment.ID, 3, time.Now().Add(time.Hour).Unix(), "forged"); apistatus == nil || apistatus.GetStatus() != http.StatusForbidden {
+		t.Errorf("expected 403 for a forged signature, got %v", apistatus)
+	}
+	if _, apistatus := service.GetAttachment(ctx, attachment.ID, 3); apistatus == nil || apistatus.GetStatus() != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422 for a non-participant, got %v", apistatus)
+	}
+
+	// The attachment goes away with its message.
+	if apistatus := messages.DeleteMessage(ctx, msg.ID, 1, domain.DeleteForEveryone); apistatus != nil {
+		t.Fatalf("DeleteMessage failed: %s", apistatus.GetMessage())
+	}
+	if _, status := openURL(t, service, download); status != http.StatusNotFound {
+		t.Errorf("expected 404 once the message is deleted, got %d", status)
+	}
+}
+
+// TestAttachmentURLExpiry checks that download URLs stop working once they
+// expire.
+func TestAttachmentURLExpiry(t *testing.T) {
+	service, messages, _ := newTestAttachmentService(t, 1<<20, -time.Minute)
+	ctx := context.Background()
+	chat, _ := messages.CreateChat(ctx, 1, 2)
+	attachment, apistatus := service.Upload(ctx, chat.ID, 1, "cat.png", bytes.NewReader([]byte(pngContent)))
+	if apistatus != nil {
+		t.Fatalf("Upload failed: %s", apistatus.GetMessage())
+	}
+	if _, status := openURL(t, service, attachment.URL); status != http.StatusForbidden {
+		t.Errorf("expected 403 for an expired URL, got %d", status)
+	}
+}
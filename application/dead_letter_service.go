@@ -0,0 +1,78 @@
+package application
+
+import (
+	"context"
+	"errors"
+
+	"messaging-app/domain"
+	"messaging-app/infrastructure/mq"
+	"messaging-app/infrastructure/repository"
+	"messaging-app/pkg/apistatus"
+)
+
+// DeadLetterService collects messages the broker parked after repeated
+// processing failures and lets operators inspect, replay or purge them.
+type DeadLetterService interface {
+	CollectDeadLetters(ctx context.Context) error
+	ListDeadLetters(ctx context.Context) ([]*domain.DeadLetter, apistatus.Status)
+	GetDeadLetter(ctx context.Context, id int64) (*domain.DeadLetter, apistatus.Status)
+	ReplayDeadLetter(ctx context.Context, id int64) apistatus.Status
+	DeleteDeadLetter(ctx context.Context, id int64) apistatus.Status
+	PurgeDeadLetters(ctx context.Context) (int, apistatus.Status)
+}
+
+type deadLetterService struct {
+	deadLetterRepo repository.DeadLetterRepository
+	broker         mq.RabbitMQInterface
+}
+
+func NewDeadLetterService(deadLetterRepo repository.DeadLetterRepository, broker mq.RabbitMQInterface) DeadLetterService {
+	return &deadLetterService{deadLetterRepo: deadLetterRepo, broker: broker}
+}
+
+// CollectDeadLetters moves messages from the dead-letter queue into the
+// repository until ctx is cancelled.
+func (s *deadLetterService) CollectDeadLetters(ctx context.Context) error {
+	return s.broker.ConsumeDeadLetters(ctx, func(ctx context.Context, dl *domain.DeadLetter) error {
+		if _, as := s.deadLetterRepo.AddDeadLetter(ctx, dl); as != nil {
+			return errors.New(as.GetMessage())
+		}
+		return nil
+	})
+}
+
+func (s *deadLetterService) ListDeadLetters(ctx context.Context) ([]*domain.DeadLetter, apistatus.Status) {
+	return s.deadLetterRepo.ListDeadLetters(ctx)
+}
+
+func (s *deadLetterService) GetDeadLetter(ctx context.Context, id int64) (*domain.DeadLetter, apistatus.Status) {
+	if id <= 0 {
+		return nil, apistatus.New("invalid dead letter ID").UnprocessableEntity()
+	}
+	return s.deadLetterRepo.GetDeadLetter(ctx, id)
+}
+
+// ReplayDeadLetter puts the message back on the service queue with its
+// original properties and a fresh retry budget, and removes it from the store
+// once the broker confirmed it.
+func (s *deadLetterService) ReplayDeadLetter(ctx context.Context, id int64) apistatus.Status {
+	dl, as := s.GetDeadLetter(ctx, id)
+	if as != nil {
+		return as
+	}
+	if err := s.broker.Republish(ctx, dl); err != nil {
+		return apistatus.New("failed to replay dead letter: %v", err).ServiceUnavailable()
+	}
+	return s.deadLetterRepo.DeleteDeadLetter(ctx, id)
+}
+
+func (s *deadLetterService) DeleteDeadLetter(ctx context.Context, id int64) apistatus.Status {
+	if id <= 0 {
+		return apistatus.New("invalid dead letter ID").UnprocessableEntity()
+	}
+	return s.deadLetterRepo.DeleteDeadLetter(ctx, id)
+}
+
+func (s *deadLetterService) PurgeDeadLetters(ctx context.Context) (int, apistatus.Status) {
+	return s.deadLetterRepo.DeleteAllDeadLetters(ctx)
+}
@@ -0,0 +1,106 @@
+package application
+
+import (
+	"context"
+	"testing"
+
+	"messaging-app/domain"
+	"messaging-app/infrastructure/mq"
+	"messaging-app/infrastructure/repository"
+)
+
+// deadLetterRabbitMQ hands out a fixed set of dead letters and records replays.
+type deadLetterRabbitMQ struct {
+	flakyRabbitMQ
+	deadLetters []*domain.DeadLetter
+	republished []*domain.DeadLetter
+}
+
+func (d *deadLetterRabbitMQ) Republish(ctx context.Context, dl *domain.DeadLetter) error {
+	if err := d.flakyRabbitMQ.Republish(ctx, dl); err != nil {
+		return err
+	}
+	d.republished = append(d.republished, dl)
+	return nil
+}
+
+func (d *deadLetterRabbitMQ) ConsumeDeadLetters(ctx context.Context, handler mq.DeadLetterHandler) error {
+	for _, dl := range d.deadLetters {
+		if err := handler(ctx, dl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestDeadLetterService(t *testing.T) {
+	broker := &deadLetterRabbitMQ{deadLetters: []*domain.DeadLetter{
+		{
+			MessageID:     "a",
+			CorrelationID: "req-1",
+			EventType:     domain.EventTypeMessageSent,
+			RoutingKey:    "chat.1.message.sent",
+			ContentType:   "application/json",
+			Reason:        "retries exhausted",
+			Body:          `{"id":"a"}`,
+		},
+		{Reason: "rejected", Body: "not json"},
+	}}
+	service := NewDeadLetterService(repository.NewInMemoryDeadLetterRepository(), broker)
+	ctx := context.Background()
+
+	if err := service.CollectDeadLetters(ctx); err != nil {
+		t.Fatalf("CollectDeadLetters failed: %v", err)
+	}
+	deadLetters, apistatus := service.ListDeadLetters(ctx)
+	if apistatus != nil {
+		t.Fatalf("ListDeadLetters failed: %s", apistatus.GetMessage())
+	}
+	if len(deadLetters) != 2 {
+		t.Fatalf("expected 2 dead letters, got %d", len(deadLetters))
+	}
+
+	// A failed replay keeps the dead letter.
+	broker.down = true
+	if apistatus := service.ReplayDeadLetter(ctx, 1); apistatus == nil {
+		t.Fatal("expected error when the broker is down, got nil")
+	}
+	if _, apistatus := service.GetDeadLetter(ctx, 1); apistatus != nil {
+		t.Errorf("expected dead letter to be kept after failed replay: %s", apistatus.GetMessage())
+	}
+
+	// A confirmed replay republishes the message as it was parked and
+	// removes the dead letter.
+	broker.down = false
+	if apistatus := service.ReplayDeadLetter(ctx, 1); apistatus != nil {
+		t.Fatalf("ReplayDeadLetter failed: %s", apistatus.GetMessage())
+	}
+	if len(broker.published) != 1 || string(broker.published[0]) != `{"id":"a"}` {
+		t.Errorf("expected the original body to be republished, got %q", broker.published)
+	}
+	if len(broker.republished) != 1 {
+		t.Fatalf("expected one republished dead letter, got %d", len(broker.republished))
+	}
+	if replayed := broker.republished[0]; replayed.MessageID != "a" || replayed.CorrelationID != "req-1" ||
+		replayed.EventType != domain.EventTypeMessageSent || replayed.ContentType != "application/json" {
+		t.Errorf("expected the original properties to be republished, got %+v", replayed)
+	}
+	if _, apistatus := service.GetDeadLetter(ctx, 1); apistatus == nil {
+		t.Error("expected replayed dead letter to be removed")
+	}
+
+	purged, apistatus := service.PurgeDeadLetters(ctx)
+	if apistatus != nil || purged != 1 {
+		t.Errorf("expected 1 purged dead letter, got %d", purged)
+	}
+}
+
+func TestDeadLetterService_InvalidID(t *testing.T) {
+	service := NewDeadLetterService(repository.NewInMemoryDeadLetterRepository(), &deadLetterRabbitMQ{})
+	if _, apistatus := service.GetDeadLetter(context.Background(), 0); apistatus == nil {
+		t.Error("expected error for invalid ID, got nil")
+	}
+	if apistatus := service.DeleteDeadLetter(context.Background(), 9); apistatus == nil {
+		t.Error("expected error for unknown ID, got nil")
+	}
+}
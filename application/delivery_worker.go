@@ -0,0 +1,115 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+
+	"messaging-app/domain"
+	"messaging-app/infrastructure/mq"
+	"messaging-app/infrastructure/realtime"
+	"messaging-app/pkg/correlation"
+)
+
+// errRecipientsBusy is returned when connected recipients could not accept a
+// message, so the broker retries the event.
+var errRecipientsBusy = errors.New("connected recipients could not accept the message")
+
+// DeliveryWorker consumes message events from the queue, pushes each message
+// to its connected recipients and records the outcome through
+// MessageService.MarkMessageDelivered and UpdateMessageStatus.
+type DeliveryWorker struct {
+	consumer    mq.Consumer
+	service     MessageService
+	hub         *realtime.Hub
+	maxAttempts int
+}
+
+// NewDeliveryWorker creates a worker that tries to reach connected recipients
+// on up to maxAttempts deliveries of an event. Retries are left to the
+// broker, so maxAttempts should not exceed its retries plus one.
+func NewDeliveryWorker(consumer mq.Consumer, service MessageService, hub *realtime.Hub, maxAttempts int) *DeliveryWorker {
+	if maxAttempts <= 0 {
+		maxAttempts = 1
+	}
+	return &DeliveryWorker{
+		consumer:    consumer,
+		service:     service,
+		hub:         hub,
+		maxAttempts: maxAttempts,
+	}
+}
+
+// Run consumes events until ctx is cancelled.
+func (w *DeliveryWorker) Run(ctx context.Context) error {
+	return w.consumer.Consume(ctx, w.HandleEvent)
+}
+
+// HandleEvent delivers a single message.sent event; other event types are
+// acknowledged and ignored.
+//
+// Recipients that are offline are skipped; they pick the message up through
+// the history or the SSE replay when they reconnect, and the message stays
+// "sent". Every connected recipient that accepts the message gets a delivery
+// receipt, which makes the message "delivered". If no connected recipient
+// accepts it, HandleEvent returns errRecipientsBusy so the broker retries
+// the event instead of this handler waiting on the shared queue; on the
+// maxAttempts-th delivery the message is marked "failed" instead.
+func (w *DeliveryWorker) HandleEvent(ctx context.Context, body []byte) error {
+	var envelope domain.Event
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return err
+	}
+	if envelope.Type != domain.EventTypeMessageSent {
+		return nil
+	}
+	if envelope.SchemaVersion > domain.EventSchemaVersion {
+		log.Printf("skipping %s event %s with unsupported schema version %d", envelope.Type, envelope.ID, envelope.SchemaVersion)
+		return nil
+	}
+	var event domain.MessageSentEvent
+	if err := envelope.DecodePayload(&event); err != nil {
+		return err
+	}
+	if event.Message == nil {
+		return nil
+	}
+	// Status changes caused by this delivery share the sender's correlation ID.
+	ctx = correlation.WithID(ctx, envelope.CorrelationID)
+	msg := event.Message
+	rtEvent := &realtime.Event{ID: msg.ID, Type: realtime.EventMessageCreated, ChatID: msg.ChatID, Data: msg}
+
+	// Keep the sender's other sessions in sync; this does not count as delivery.
+	w.hub.Deliver(msg.SenderID, rtEvent)
+
+	var delivered []int64
+	busy := false
+	for _, id := range event.RecipientIDs {
+		if !w.hub.IsConnected(id) {
+			continue
+		}
+		if w.hub.Deliver(id, rtEvent) {
+			delivered = append(delivered, id)
+		} else {
+			busy = true
+		}
+	}
+
+	if len(delivered) > 0 {
+		if as := w.service.MarkMessageDelivered(ctx, msg.ID, delivered); as != nil {
+			log.Printf("failed to record delivery of message %d: %s", msg.ID, as.GetMessage())
+		}
+		return nil
+	}
+	if !busy {
+		return nil
+	}
+	if mq.RetryCountFromContext(ctx)+1 < w.maxAttempts {
+		return errRecipientsBusy
+	}
+	if as := w.service.UpdateMessageStatus(ctx, msg.ID, domain.MessageStatusFailed); as != nil {
+		log.Printf("failed to mark message %d as %s: %s", msg.ID, domain.MessageStatusFailed, as.GetMessage())
+	}
+	return nil
+}
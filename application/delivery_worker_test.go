@@ -0,0 +1,184 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"messaging-app/domain"
+	"messaging-app/infrastructure/mq"
+	"messaging-app/infrastructure/realtime"
+	"messaging-app/infrastructure/repository"
+)
+
+// setupDeliveryTest creates a service with a chat between users 1 and 2 and a
+// message sent by user 1, and returns the encoded message event.
+func setupDeliveryTest(t *testing.T, hub *realtime.Hub) (MessageService, repository.MessageRepository, []byte) {
+	t.Helper()
+	msgRepo := repository.NewInMemoryMessageRepository()
+	service := NewMessageService(msgRepo, repository.NewInMemoryChatRepository(msgRepo), repository.NewInMemoryUserRepository(), hub, 0)
+	ctx := context.Background()
+
+	chat, apistatus := service.CreateChat(ctx, 1, 2)
+	if apistatus != nil {
+		t.Fatalf("CreateChat failed: %s", apistatus.GetMessage())
+	}
+	msg, apistatus := service.SendMessage(ctx, chat.ID, 1, "Hello")
+	if apistatus != nil {
+		t.Fatalf("SendMessage failed: %s", apistatus.GetMessage())
+	}
+	event, err := domain.NewEvent(domain.EventTypeMessageSent, chat.ID, "", domain.NewMessageSentEvent(msg, chat))
+	if err != nil {
+		t.Fatalf("failed to build event: %v", err)
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		t.Fatalf("failed to marshal event: %v", err)
+	}
+	return service, msgRepo, body
+}
+
+func messageStatus(t *testing.T, repo repository.MessageRepository, messageID int64) domain.MessageStatus {
+	t.Helper()
+	msg, apistatus := repo.GetMessageByID(context.Background(), messageID)
+	if apistatus != nil {
+		t.Fatalf("GetMessageByID failed: %s", apistatus.GetMessage())
+	}
+	return msg.Status
+}
+
+// TestDeliveryWorker_Delivered tests that a connected recipient receives the message and it is marked delivered.
+func TestDeliveryWorker_Delivered(t *testing.T) {
+	hub := realtime.NewHub(4)
+	service, msgRepo, body := setupDeliveryTest(t, hub)
+	recipient := hub.Subscribe(2)
+	defer recipient.Close()
+
+	worker := NewDeliveryWorker(&dummyRabbitMQ{}, service, hub, 3)
+	if err := worker.HandleEvent(context.Background(), body); err != nil {
+		t.Fatalf("HandleEvent failed: %v", err)
+	}
+
+	select {
+	case event := <-recipient.Events():
+		if event.Type != realtime.EventMessageCreated || event.ID != 1 {
+			t.Errorf("expected message.created for message 1, got %+v", event)
+		}
+	default:
+		t.Fatal("expected recipient to receive the message")
+	}
+	if status := messageStatus(t, msgRepo, 1); status != domain.MessageStatusDelivered {
+		t.Errorf("expected status 'delivered', got %s", status)
+	}
+	msg, _ := msgRepo.GetMessageByID(context.Background(), 1)
+	if receipt := msg.ReceiptFor(2); receipt == nil || receipt.DeliveredAt == nil || receipt.ReadAt != nil {
+		t.Errorf("expected a delivery receipt for user 2, got %+v", msg.Receipts)
+	}
+}
+
+// TestDeliveryWorker_Offline tests that messages for offline recipients stay sent.
+func TestDeliveryWorker_Offline(t *testing.T) {
+	hub := realtime.NewHub(4)
+	service, msgRepo, body := setupDeliveryTest(t, hub)
+
+	worker := NewDeliveryWorker(&dummyRabbitMQ{}, service, hub, 3)
+	if err := worker.HandleEvent(context.Background(), body); err != nil {
+		t.Fatalf("HandleEvent failed: %v", err)
+	}
+	if status := messageStatus(t, msgRepo, 1); status != domain.MessageStatusSent {
+		t.Errorf("expected status 'sent', got %s", status)
+	}
+}
+
+// TestDeliveryWorker_Failed tests that a recipient who cannot accept the
+// message leaves the retries to the broker and makes it fail on the last one.
+func TestDeliveryWorker_Failed(t *testing.T) {
+	hub := realtime.NewHub(1)
+	service, msgRepo, body := setupDeliveryTest(t, hub)
+	recipient := hub.Subscribe(2)
+	defer recipient.Close()
+	// Fill the recipient's buffer so every attempt fails.
+	hub.Deliver(2, &realtime.Event{Type: realtime.EventChatCreated})
+
+	worker := NewDeliveryWorker(&dummyRabbitMQ{}, service, hub, 3)
+	for retries := 0; retries < 2; retries++ {
+		if err := worker.HandleEvent(mq.WithRetryCount(context.Background(), retries), body); err == nil {
+			t.Fatalf("expected an error for the broker to retry on attempt %d", retries+1)
+		}
+		if status := messageStatus(t, msgRepo, 1); status != domain.MessageStatusSent {
+			t.Errorf("expected status 'sent' before the last attempt, got %s", status)
+		}
+	}
+	if err := worker.HandleEvent(mq.WithRetryCount(context.Background(), 2), body); err != nil {
+		t.Fatalf("HandleEvent failed: %v", err)
+	}
+	if status := messageStatus(t, msgRepo, 1); status != domain.MessageStatusFailed {
+		t.Errorf("expected status 'failed', got %s", status)
+	}
+}
+
+// TestDeliveryWorker_MalformedEvent tests that undecodable events are rejected.
+func TestDeliveryWorker_MalformedEvent(t *testing.T) {
+	hub := realtime.NewHub(1)
+	worker := NewDeliveryWorker(&dummyRabbitMQ{}, nil, hub, 1)
+	if err := worker.HandleEvent(context.Background(), []byte("not json")); err == nil {
+		t.Error("expected error for malformed event, got nil")
+	}
+}
+
+// TestDeliveryWorker_IgnoresOtherEvents tests that events other than
+// message.sent are acknowledged without touching any message.
+func TestDeliveryWorker_IgnoresOtherEvents(t *testing.T) {
+	hub := realtime.NewHub(1)
+	worker := NewDeliveryWorker(&dummyRabbitMQ{}, nil, hub, 1)
+	event, err := domain.NewEvent(domain.EventTypeChatCreated, 1, "", &domain.Chat{ID: 1})
+	if err != nil {
+		t.Fatalf("failed to build event: %v", err)
+	}
+	body, _ := json.Marshal(event)
+	if err := worker.HandleEvent(context.Background(), body); err != nil {
+		t.Errorf("expected chat.created to be ignored, got %v", err)
+	}
+}
+
+// TestDeliveryWorker_EndToEnd tests that a sent message travels through the
+// outbox relay and the in-memory broker to a connected recipient.
+func TestDeliveryWorker_EndToEnd(t *testing.T) {
+	hub := realtime.NewHub(4)
+	service, msgRepo, _ := setupDeliveryTest(t, hub)
+	recipient := hub.Subscribe(2)
+	defer recipient.Close()
+
+	broker := mq.NewInMemoryBroker(mq.Topology{
+		Exchange:    "events",
+		Queue:       "messages",
+		BindingKeys: []string{"chat.*.message.sent"},
+	})
+	defer broker.Close()
+	relay := NewOutboxRelay(msgRepo, broker, time.Millisecond, 10, time.Millisecond, time.Millisecond, time.Second, time.Minute)
+	if n := relay.RelayPending(context.Background()); n != 2 {
+		t.Fatalf("expected 2 relayed entries, got %d", n)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	worker := NewDeliveryWorker(broker, service, hub, 3)
+	go worker.Run(ctx)
+
+	select {
+	case event := <-recipient.Events():
+		if event.Type != realtime.EventMessageCreated || event.ID != 1 {
+			t.Errorf("expected message.created for message 1, got %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected recipient to receive the message")
+	}
+	deadline := time.Now().Add(time.Second)
+	for messageStatus(t, msgRepo, 1) != domain.MessageStatusDelivered {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected status 'delivered', got %s", messageStatus(t, msgRepo, 1))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
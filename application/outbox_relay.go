@@ -0,0 +1,111 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"messaging-app/domain"
+	"messaging-app/infrastructure/mq"
+	"messaging-app/infrastructure/repository"
+)
+
+// OutboxRelay publishes pending outbox entries to the broker. An entry is
+// marked sent only after the broker confirmed it; failed entries are retried
+// with exponential backoff, so a broker outage delays events but never drops
+// them. Entries are claimed for a lease before publishing, so several
+// instances can relay the same outbox.
+type OutboxRelay struct {
+	outbox         repository.OutboxRepository
+	publisher      mq.RabbitMQInterface
+	interval       time.Duration
+	batchSize      int
+	backoff        time.Duration
+	maxBackoff     time.Duration
+	publishTimeout time.Duration
+	lease          time.Duration
+}
+
+// NewOutboxRelay creates a relay that polls the outbox every interval and
+// publishes up to batchSize entries at a time, waiting up to publishTimeout
+// for each confirm. Claimed entries are hidden from other relays for lease,
+// which should cover publishing a whole batch; LoadConfig enforces that.
+func NewOutboxRelay(outbox repository.OutboxRepository, publisher mq.RabbitMQInterface, interval time.Duration, batchSize int, backoff, maxBackoff, publishTimeout, lease time.Duration) *OutboxRelay {
+	return &OutboxRelay{
+		outbox:         outbox,
+		publisher:      publisher,
+		interval:       interval,
+		batchSize:      batchSize,
+		backoff:        backoff,
+		maxBackoff:     maxBackoff,
+		publishTimeout: publishTimeout,
+		lease:          lease,
+	}
+}
+
+// Run relays entries until ctx is cancelled.
+func (r *OutboxRelay) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			r.RelayPending(ctx)
+		}
+	}
+}
+
+// RelayPending claims and publishes the entries that are currently due and
+// returns how many reached the broker.
+func (r *OutboxRelay) RelayPending(ctx context.Context) int {
+	entries, as := r.outbox.ClaimOutboxEntries(ctx, time.Now(), r.lease, r.batchSize)
+	if as != nil {
+		log.Printf("failed to load outbox entries: %s", as.GetMessage())
+		return 0
+	}
+	sent := 0
+	for _, entry := range entries {
+		if err := r.publish(ctx, entry); err != nil {
+			next := time.Now().Add(r.retryDelay(entry.Attempts + 1))
+			if as := r.outbox.MarkOutboxEntryFailed(ctx, entry.ID, err.Error(), next); as != nil {
+				log.Printf("failed to reschedule outbox entry %d: %s", entry.ID, as.GetMessage())
+			}
+			continue
+		}
+		if as := r.outbox.MarkOutboxEntrySent(ctx, entry.ID); as != nil {
+			log.Printf("failed to mark outbox entry %d as sent: %s", entry.ID, as.GetMessage())
+			continue
+		}
+		sent++
+	}
+	return sent
+}
+
+// publish sends the event stored in entry and waits for the broker to
+// confirm it. An unconfirmed publish is retried, so consumers may see an
+// event more than once; they can deduplicate on the event ID.
+func (r *OutboxRelay) publish(ctx context.Context, entry *domain.OutboxEntry) error {
+	var event domain.Event
+	if err := json.Unmarshal(entry.Payload, &event); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.publishTimeout)
+	defer cancel()
+	return r.publisher.PublishEvent(ctx, &event)
+}
+
+// retryDelay returns the wait before the given attempt, doubling from
+// backoff up to maxBackoff.
+func (r *OutboxRelay) retryDelay(attempt int) time.Duration {
+	delay := r.backoff
+	for i := 1; i < attempt && delay < r.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > r.maxBackoff {
+		delay = r.maxBackoff
+	}
+	return delay
+}
@@ -0,0 +1,151 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"messaging-app/domain"
+	"messaging-app/infrastructure/mq"
+	"messaging-app/infrastructure/repository"
+)
+
+// flakyRabbitMQ fails every publish while down is true.
+type flakyRabbitMQ struct {
+	down      bool
+	published [][]byte
+}
+
+func (f *flakyRabbitMQ) PublishMessage(body []byte) error {
+	return f.PublishWithConfirm(context.Background(), body)
+}
+
+func (f *flakyRabbitMQ) PublishWithConfirm(ctx context.Context, body []byte) error {
+	if f.down {
+		return errors.New("broker unavailable")
+	}
+	f.published = append(f.published, body)
+	return nil
+}
+
+func (f *flakyRabbitMQ) PublishEvent(ctx context.Context, event *domain.Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return f.PublishWithConfirm(ctx, body)
+}
+
+func (f *flakyRabbitMQ) Republish(ctx context.Context, dl *domain.DeadLetter) error {
+	return f.PublishWithConfirm(ctx, []byte(dl.Body))
+}
+
+func (f *flakyRabbitMQ) Consume(ctx context.Context, handler mq.Handler) error {
+	<-ctx.Done()
+	return nil
+}
+
+func (f *flakyRabbitMQ) ConsumeDeadLetters(ctx context.Context, handler mq.DeadLetterHandler) error {
+	<-ctx.Done()
+	return nil
+}
+
+func (f *flakyRabbitMQ) Close() {}
+
+func (f *flakyRabbitMQ) State() mq.ConnectionState {
+	if f.down {
+		return mq.StateReconnecting
+	}
+	return mq.StateConnected
+}
+
+// TestOutboxRelay_RetriesUntilBrokerRecovers tests that events survive a broker outage.
+func TestOutboxRelay_RetriesUntilBrokerRecovers(t *testing.T) {
+	msgRepo := repository.NewInMemoryMessageRepository()
+	service := NewMessageService(msgRepo, repository.NewInMemoryChatRepository(msgRepo), repository.NewInMemoryUserRepository(), nil, 0)
+	broker := &flakyRabbitMQ{down: true}
+	relay := NewOutboxRelay(msgRepo, broker, time.Second, 10, time.Millisecond, 10*time.Millisecond, time.Second, time.Minute)
+	ctx := context.Background()
+
+	chat, _ := service.CreateChat(ctx, 1, 2)
+	if _, apistatus := service.SendMessage(ctx, chat.ID, 1, "Hello"); apistatus != nil {
+		t.Fatalf("SendMessage failed: %s", apistatus.GetMessage())
+	}
+
+	// The broker is down: nothing is sent and the chat.created and
+	// message.sent entries stay pending.
+	if sent := relay.RelayPending(ctx); sent != 0 {
+		t.Fatalf("expected 0 relayed events, got %d", sent)
+	}
+	entries, _ := msgRepo.GetPendingOutboxEntries(ctx, time.Now().Add(time.Second), 10)
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 pending entries, got %d", len(entries))
+	}
+	for _, entry := range entries {
+		if entry.Attempts != 1 || entry.LastError != "broker unavailable" {
+			t.Errorf("expected 1 failed attempt, got %+v", entry)
+		}
+	}
+
+	// Once the broker is back and the backoff has passed, the entries are sent.
+	broker.down = false
+	time.Sleep(5 * time.Millisecond)
+	if sent := relay.RelayPending(ctx); sent != 2 {
+		t.Fatalf("expected 2 relayed events, got %d", sent)
+	}
+	if len(broker.published) != 2 {
+		t.Errorf("expected 2 published events, got %d", len(broker.published))
+	}
+	entries, _ = msgRepo.GetPendingOutboxEntries(ctx, time.Now().Add(time.Second), 10)
+	if len(entries) != 0 {
+		t.Errorf("expected no pending entries, got %d", len(entries))
+	}
+}
+
+// unconfirmedRabbitMQ never confirms a publish.
+type unconfirmedRabbitMQ struct{ flakyRabbitMQ }
+
+func (u *unconfirmedRabbitMQ) PublishEvent(ctx context.Context, event *domain.Event) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// TestOutboxRelay_ConfirmTimeout tests that an unconfirmed publish is retried.
+func TestOutboxRelay_ConfirmTimeout(t *testing.T) {
+	msgRepo := repository.NewInMemoryMessageRepository()
+	service := NewMessageService(msgRepo, repository.NewInMemoryChatRepository(msgRepo), repository.NewInMemoryUserRepository(), nil, 0)
+	relay := NewOutboxRelay(msgRepo, &unconfirmedRabbitMQ{}, time.Second, 10, time.Millisecond, time.Millisecond, 10*time.Millisecond, time.Minute)
+	ctx := context.Background()
+
+	chat, _ := service.CreateChat(ctx, 1, 2)
+	service.SendMessage(ctx, chat.ID, 1, "Hello")
+
+	if sent := relay.RelayPending(ctx); sent != 0 {
+		t.Fatalf("expected 0 relayed events, got %d", sent)
+	}
+	entries, _ := msgRepo.GetPendingOutboxEntries(ctx, time.Now().Add(time.Second), 10)
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 pending entries, got %d", len(entries))
+	}
+	for _, entry := range entries {
+		if entry.LastError != context.DeadlineExceeded.Error() {
+			t.Errorf("expected entry pending after confirm timeout, got %+v", entry)
+		}
+	}
+}
+
+func TestOutboxRelay_RetryDelay(t *testing.T) {
+	relay := NewOutboxRelay(nil, nil, time.Second, 10, 100*time.Millisecond, time.Second, time.Second, time.Minute)
+	for attempt, expected := range map[int]time.Duration{
+		1: 100 * time.Millisecond,
+		2: 200 * time.Millisecond,
+		4: 800 * time.Millisecond,
+		9: time.Second,
+	} {
+		if delay := relay.retryDelay(attempt); delay != expected {
+			t.Errorf("attempt %d: expected %s, got %s", attempt, expected, delay)
+		}
+	}
+}
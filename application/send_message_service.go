@@ -4,39 +4,123 @@ import (
 	"context"
 	"encoding/json"
 	"log"
+	"net/http"
+	"strings"
 	"time"
 
 	"messaging-app/domain"
-	"messaging-app/infrastructure/mq"
+	"messaging-app/infrastructure/realtime"
 	"messaging-app/infrastructure/repository"
 	"messaging-app/pkg/apistatus"
+	"messaging-app/pkg/correlation"
 )
 
 type MessageService interface {
 	SendMessage(ctx context.Context, chatID, senderID int64, content string) (*domain.Message, apistatus.Status)
-	GetMessages(ctx context.Context, chatID int64) ([]*domain.Message, apistatus.Status)
-	ListChatsForUser(ctx context.Context, userID int64) ([]*domain.Chat, apistatus.Status)
+	SendReply(ctx context.Context, chatID, senderID, replyToMessageID int64, content string) (*domain.Message, apistatus.Status)
+	SendMessageWithAttachments(ctx context.Context, chatID, senderID, replyToMessageID int64, content string, attachmentIDs []int64) (*domain.Message, apistatus.Status)
+	GetMessages(ctx context.Context, chatID int64, query domain.MessageQuery) (*domain.MessagePage, apistatus.Status)
+	ListChatsForUser(ctx context.Context, userID int64) ([]*domain.ChatSummary, apistatus.Status)
 	UpdateMessageStatus(ctx context.Context, messageID int64, status domain.MessageStatus) apistatus.Status
+	GetStatusHistory(ctx context.Context, messageID int64) ([]*domain.StatusTransition, apistatus.Status)
+	EditMessage(ctx context.Context, messageID, senderID int64, content string) (*domain.Message, apistatus.Status)
+	GetMessageRevisions(ctx context.Context, messageID int64) ([]*domain.MessageRevision, apistatus.Status)
+	GetThread(ctx context.Context, messageID, userID int64) ([]*domain.Message, apistatus.Status)
+	DeleteMessage(ctx context.Context, messageID, userID int64, scope domain.DeletionScope) apistatus.Status
+	ReactToMessage(ctx context.Context, messageID, userID int64, emoji string) (*domain.Message, apistatus.Status)
+	RemoveReaction(ctx context.Context, messageID, userID int64) apistatus.Status
+	MarkMessageDelivered(ctx context.Context, messageID int64, userIDs []int64) apistatus.Status
+	MarkChatRead(ctx context.Context, chatID, userID, upToMessageID int64) (*domain.ChatRead, apistatus.Status)
 	CreateChat(ctx context.Context, participant1ID, participant2ID int64) (*domain.Chat, apistatus.Status)
+	CreateGroupChat(ctx context.Context, title string, participantIDs []int64) (*domain.Chat, apistatus.Status)
+	AddChatMember(ctx context.Context, chatID, userID int64) (*domain.Chat, apistatus.Status)
+	RemoveChatMember(ctx context.Context, chatID, userID int64) (*domain.Chat, apistatus.Status)
+	GetMissedMessages(ctx context.Context, userID, lastMessageID int64) ([]*domain.Message, apistatus.Status)
 }
 
 type messageService struct {
 	messageRepo repository.MessageRepository
 	chatRepo    repository.ChatRepository
-	rabbitMQ    mq.RabbitMQInterface
+	userRepo    repository.UserRepository
+	notifier    realtime.Publisher
+	editWindow  time.Duration
 }
 
-func NewMessageService(messageRepo repository.MessageRepository, chatRepo repository.ChatRepository, rabbitMQ mq.RabbitMQInterface) MessageService {
+// NewMessageService creates the service. Senders can edit a message for
+// editWindow after sending it; a non-positive editWindow sets no limit.
+func NewMessageService(messageRepo repository.MessageRepository, chatRepo repository.ChatRepository, userRepo repository.UserRepository, notifier realtime.Publisher, editWindow time.Duration) MessageService {
 	return &messageService{
 		messageRepo: messageRepo,
 		chatRepo:    chatRepo,
-		rabbitMQ:    rabbitMQ,
+		userRepo:    userRepo,
+		notifier:    notifier,
+		editWindow:  editWindow,
 	}
 }
 
+// notify pushes an event to the connected participants of chat.
+func (s *messageService) notify(chat *domain.Chat, event *realtime.Event) {
+	if s.notifier == nil {
+		return
+	}
+	event.ChatID = chat.ID
+	s.notifier.Publish(chat.Participants(), event)
+}
+
+// newOutboxEntry wraps payload in an event envelope carrying the correlation
+// ID of ctx.
+func newOutboxEntry(ctx context.Context, eventType string, chatID, aggregateID int64, payload interface{}) (*domain.OutboxEntry, error) {
+	event, err := domain.NewEvent(eventType, chatID, correlation.FromContext(ctx), payload)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	return &domain.OutboxEntry{
+		EventType:   eventType,
+		AggregateID: aggregateID,
+		Payload:     body,
+	}, nil
+}
+
+// isValidUser returns true if the user exists in the user repository.
+func (s *messageService) isValidUser(ctx context.Context, userID int64) bool {
+	_, as := s.userRepo.GetUserByID(ctx, userID)
+	return as == nil
+}
+
 func (s *messageService) SendMessage(ctx context.Context, chatID, senderID int64, content string) (*domain.Message, apistatus.Status) {
-	// Validate that the sender is one of the hardcoded users.
-	if !domain.IsValidUser(senderID) {
+	return s.send(ctx, chatID, senderID, 0, content, nil)
+}
+
+// SendReply sends a message quoting replyToMessageID, which must belong to
+// the same chat.
+func (s *messageService) SendReply(ctx context.Context, chatID, senderID, replyToMessageID int64, content string) (*domain.Message, apistatus.Status) {
+	if replyToMessageID <= 0 {
+		return nil, apistatus.New("invalid replyToMessageID").UnprocessableEntity()
+	}
+	return s.send(ctx, chatID, senderID, replyToMessageID, content, nil)
+}
+
+// SendMessageWithAttachments sends a message carrying attachmentIDs, files
+// the sender uploaded to the chat that no message carries yet. Unless
+// replyToMessageID is zero, the message replies to it.
+func (s *messageService) SendMessageWithAttachments(ctx context.Context, chatID, senderID, replyToMessageID int64, content string, attachmentIDs []int64) (*domain.Message, apistatus.Status) {
+	if replyToMessageID < 0 {
+		return nil, apistatus.New("invalid replyToMessageID").UnprocessableEntity()
+	}
+	if len(attachmentIDs) == 0 || len(attachmentIDs) > domain.MaxAttachmentsPerMessage {
+		return nil, apistatus.New("messages carry between 1 and %d attachments", domain.MaxAttachmentsPerMessage).UnprocessableEntity()
+	}
+	return s.send(ctx, chatID, senderID, replyToMessageID, content, attachmentIDs)
+}
+
+// send stores a message, replying to replyToMessageID unless it is zero.
+func (s *messageService) send(ctx context.Context, chatID, senderID, replyToMessageID int64, content string, attachmentIDs []int64) (*domain.Message, apistatus.Status) {
+	// Validate that the sender is a registered user.
+	if !s.isValidUser(ctx, senderID) {
 		return nil, apistatus.New("invalid sender").UnprocessableEntity()
 	}
 
@@ -47,57 +131,103 @@ func (s *messageService) SendMessage(ctx context.Context, chatID, senderID int64
 	}
 
 	// Validate that the sender is part of the chat.
-	if chat.Participant1ID != senderID && chat.Participant2ID != senderID {
+	if !chat.HasParticipant(senderID) {
 		return nil, apistatus.New("sender is not a participant of the chat").UnprocessableEntity()
 	}
 
+	// Validate that the quoted message is part of the same chat.
+	if replyToMessageID != 0 {
+		parent, as := s.messageRepo.GetMessageByID(ctx, replyToMessageID)
+		if as != nil && as.GetStatus() != http.StatusNotFound {
+			return nil, as
+		}
+		if parent == nil || parent.ChatID != chat.ID {
+			return nil, apistatus.New("message %d is not part of the chat", replyToMessageID).UnprocessableEntity()
+		}
+	}
+
+	// Validate that the attachments are unsent uploads of the sender to the
+	// chat. The repository checks again when storing the message.
+	if as := s.checkAttachments(ctx, chat.ID, senderID, attachmentIDs); as != nil {
+		return nil, as
+	}
+
 	// Create the message.
 	msg := &domain.Message{
-		ChatID:    chat.ID,
-		SenderID:  senderID,
-		Content:   content,
-		Timestamp: time.Now(),
-		Status:    domain.MessageStatusSent,
+		ChatID:           chat.ID,
+		SenderID:         senderID,
+		Content:          content,
+		Timestamp:        time.Now(),
+		Status:           domain.MessageStatusSent,
+		ReplyToMessageID: replyToMessageID,
+		AttachmentIDs:    attachmentIDs,
 	}
-	createdMsg, as := s.messageRepo.CreateMessage(ctx, msg)
+	// Store the message and its event together; the OutboxRelay publishes
+	// the event and the DeliveryWorker pushes it to connected recipients.
+	createdMsg, as := s.messageRepo.CreateMessageWithOutbox(ctx, msg, func(m *domain.Message) (*domain.OutboxEntry, error) {
+		return newOutboxEntry(ctx, domain.EventTypeMessageSent, m.ChatID, m.ID, domain.NewMessageSentEvent(m, chat))
+	})
 	if as != nil {
 		return nil, as
 	}
+	return createdMsg, nil
+}
 
-	// Publish asynchronously.
-	go func(m *domain.Message) {
-		eventData, err := json.Marshal(m)
-		if err != nil {
-			log.Printf("failed to marshal message: %v", err)
-			return
+// checkAttachments verifies that senderID can send attachmentIDs to chatID.
+func (s *messageService) checkAttachments(ctx context.Context, chatID, senderID int64, attachmentIDs []int64) apistatus.Status {
+	if len(attachmentIDs) == 0 {
+		return nil
+	}
+	attachments, as := s.messageRepo.GetAttachments(ctx, attachmentIDs)
+	if as != nil {
+		return as
+	}
+	found := make(map[int64]*domain.Attachment, len(attachments))
+	for _, attachment := range attachments {
+		found[attachment.ID] = attachment
+	}
+	for i, id := range attachmentIDs {
+		attachment := found[id]
+		if attachment == nil || attachment.ChatID != chatID || attachment.UploaderID != senderID {
+			return apistatus.New("attachment %d was not uploaded to the chat by the sender", id).UnprocessableEntity()
 		}
-		if s.rabbitMQ != nil {
-			if err := s.rabbitMQ.PublishMessage(eventData); err != nil {
-				log.Printf("failed to publish message event: %v", err)
+		for _, earlier := range attachmentIDs[:i] {
+			if earlier == id {
+				return apistatus.New("attachment %d is listed twice", id).UnprocessableEntity()
 			}
 		}
-	}(createdMsg)
-
-	return createdMsg, nil
+		if attachment.MessageID != 0 {
+			return apistatus.New("attachment %d has already been sent", id).Conflict()
+		}
+	}
+	return nil
 }
 
-func (s *messageService) GetMessages(ctx context.Context, chatID int64) ([]*domain.Message, apistatus.Status) {
+func (s *messageService) GetMessages(ctx context.Context, chatID int64, query domain.MessageQuery) (*domain.MessagePage, apistatus.Status) {
 	if chatID <= 0 {
 		return nil, apistatus.New("unprocessable entity: invalid chatID").UnprocessableEntity()
 	}
+	if query.Limit < 0 || query.Limit > domain.MaxMessagePageSize {
+		return nil, apistatus.New("limit must be between 1 and %d", domain.MaxMessagePageSize).UnprocessableEntity()
+	}
+	if query.Limit == 0 {
+		query.Limit = domain.DefaultMessagePageSize
+	}
 	// Verify that the chat exists.
 	_, as := s.chatRepo.GetChatByID(ctx, chatID)
 	if as != nil {
 		return nil, as
 	}
-	return s.messageRepo.GetMessagesByChatID(ctx, chatID)
+	return s.messageRepo.GetMessagePage(ctx, chatID, query)
 }
 
-func (s *messageService) ListChatsForUser(ctx context.Context, userID int64) ([]*domain.Chat, apistatus.Status) {
+// ListChatsForUser returns the chats of userID with their last message and
+// unread count, most recently active first.
+func (s *messageService) ListChatsForUser(ctx context.Context, userID int64) ([]*domain.ChatSummary, apistatus.Status) {
 	if userID <= 0 {
 		return nil, apistatus.New("invalid userID").UnprocessableEntity()
 	}
-	if !domain.IsValidUser(userID) {
+	if !s.isValidUser(ctx, userID) {
 		return nil, apistatus.New("user does not exist").UnprocessableEntity()
 	}
 	chats, as := s.chatRepo.GetChatsByUserID(ctx, userID)
@@ -107,31 +237,361 @@ func (s *messageService) ListChatsForUser(ctx context.Context, userID int64) ([]
 	if len(chats) == 0 {
 		return nil, apistatus.New("user has no chats").UnprocessableEntity()
 	}
-	return chats, nil
+	summaries, as := s.messageRepo.SummarizeChats(ctx, userID, chats)
+	if as != nil {
+		return nil, as
+	}
+	domain.SortChatSummaries(summaries)
+	return summaries, nil
 }
 
 func (s *messageService) UpdateMessageStatus(ctx context.Context, messageID int64, status domain.MessageStatus) apistatus.Status {
 	if messageID <= 0 {
 		return apistatus.New("invalid messageID").UnprocessableEntity()
 	}
-	// Validate the status.
-	switch status {
-	case domain.MessageStatusSent, domain.MessageStatusDelivered, domain.MessageStatusRead, domain.MessageStatusFailed:
-		// valid
-	default:
+	if !status.IsValid() {
 		return apistatus.New("invalid message status").UnprocessableEntity()
 	}
 	// Check if the message exists.
-	_, as := s.messageRepo.GetMessageByID(ctx, messageID)
+	msg, as := s.messageRepo.GetMessageByID(ctx, messageID)
+	if as != nil {
+		return as
+	}
+	if msg.Status == status {
+		return nil
+	}
+	if !msg.Status.CanTransitionTo(status) {
+		return apistatus.New("cannot change message status from %s to %s", msg.Status, status).Conflict()
+	}
+	return s.setStatus(ctx, msg, msg.Status, status)
+}
+
+// setStatus moves msg from status from to status to, records the change and
+// lets connected participants know.
+func (s *messageService) setStatus(ctx context.Context, msg *domain.Message, from, to domain.MessageStatus) apistatus.Status {
+	change := &domain.MessageStatusChangedEvent{
+		MessageID: msg.ID,
+		ChatID:    msg.ChatID,
+		Status:    to,
+	}
+	as := s.messageRepo.UpdateMessageStatusWithOutbox(ctx, msg.ID, from, to, func(m *domain.Message) (*domain.OutboxEntry, error) {
+		return newOutboxEntry(ctx, domain.EventTypeMessageStatusChanged, m.ChatID, m.ID, change)
+	})
+	if as != nil {
+		return as
+	}
+
+	// Let connected participants know about the new status.
+	if chat, as := s.chatRepo.GetChatByID(ctx, msg.ChatID); as == nil {
+		s.notify(chat, &realtime.Event{Type: realtime.EventStatusChanged, Data: change})
+	}
+	return nil
+}
+
+// advanceStatus walks msg through the transitions leading to target, if
+// target can be reached. When the status changes underneath, the walk
+// resumes from the new status.
+func (s *messageService) advanceStatus(ctx context.Context, msg *domain.Message, target domain.MessageStatus) apistatus.Status {
+	current := msg.Status
+	for attempt := 0; attempt < 3; attempt++ {
+		var as apistatus.Status
+		for _, next := range current.PathTo(target) {
+			if as = s.setStatus(ctx, msg, current, next); as != nil {
+				break
+			}
+			current = next
+		}
+		if as == nil || as.GetStatus() != http.StatusConflict {
+			return as
+		}
+		latest, as := s.messageRepo.GetMessageByID(ctx, msg.ID)
+		if as != nil {
+			return as
+		}
+		current = latest.Status
+	}
+	return apistatus.New("message status keeps changing").Conflict()
+}
+
+// GetStatusHistory returns the status transitions of a message, oldest first.
+func (s *messageService) GetStatusHistory(ctx context.Context, messageID int64) ([]*domain.StatusTransition, apistatus.Status) {
+	if messageID <= 0 {
+		return nil, apistatus.New("invalid messageID").UnprocessableEntity()
+	}
+	return s.messageRepo.GetStatusHistory(ctx, messageID)
+}
+
+// EditMessage replaces the content of a message on behalf of its sender and
+// keeps the previous content as a revision.
+func (s *messageService) EditMessage(ctx context.Context, messageID, senderID int64, content string) (*domain.Message, apistatus.Status) {
+	if messageID <= 0 {
+		return nil, apistatus.New("invalid messageID").UnprocessableEntity()
+	}
+	if strings.TrimSpace(content) == "" {
+		return nil, apistatus.New("content must not be empty").UnprocessableEntity()
+	}
+	msg, as := s.messageRepo.GetMessageByID(ctx, messageID)
 	if as != nil {
+		return nil, as
+	}
+	if msg.SenderID != senderID {
+		return nil, apistatus.New("only the sender can edit a message").Forbidden()
+	}
+	if msg.IsDeleted() {
+		return nil, apistatus.New("message has been deleted").Conflict()
+	}
+	now := time.Now()
+	if s.editWindow > 0 && now.Sub(msg.Timestamp) > s.editWindow {
+		return nil, apistatus.New("messages can only be edited within %s of sending", s.editWindow).Forbidden()
+	}
+	if content == msg.Content {
+		return msg, nil
+	}
+	// The repository checks for a tombstone again under its lock.
+	var event *domain.MessageEditedEvent
+	edited, as := s.messageRepo.EditMessageWithOutbox(ctx, messageID, content, now, func(m *domain.Message) (*domain.OutboxEntry, error) {
+		event = &domain.MessageEditedEvent{
+			MessageID: m.ID,
+			ChatID:    m.ChatID,
+			SenderID:  m.SenderID,
+			Content:   m.Content,
+			EditedAt:  now,
+		}
+		return newOutboxEntry(ctx, domain.EventTypeMessageEdited, m.ChatID, m.ID, event)
+	})
+	if as != nil {
+		return nil, as
+	}
+	if chat, as := s.chatRepo.GetChatByID(ctx, edited.ChatID); as == nil {
+		s.notify(chat, &realtime.Event{Type: realtime.EventMessageEdited, Data: event})
+	}
+	return edited, nil
+}
+
+// GetMessageRevisions returns the earlier contents of a message, oldest first.
+func (s *messageService) GetMessageRevisions(ctx context.Context, messageID int64) ([]*domain.MessageRevision, apistatus.Status) {
+	if messageID <= 0 {
+		return nil, apistatus.New("invalid messageID").UnprocessableEntity()
+	}
+	return s.messageRepo.GetMessageRevisions(ctx, messageID)
+}
+
+// GetThread returns a message and every reply under it, oldest first.
+// Unless userID is zero, messages they deleted for themselves are left out.
+func (s *messageService) GetThread(ctx context.Context, messageID, userID int64) ([]*domain.Message, apistatus.Status) {
+	if messageID <= 0 {
+		return nil, apistatus.New("invalid messageID").UnprocessableEntity()
+	}
+	return s.messageRepo.GetThread(ctx, messageID, userID)
+}
+
+// DeleteMessage deletes a message for userID alone or, when the sender asks,
+// replaces it with a tombstone for every participant.
+func (s *messageService) DeleteMessage(ctx context.Context, messageID, userID int64, scope domain.DeletionScope) apistatus.Status {
+	if messageID <= 0 {
+		return apistatus.New("invalid messageID").UnprocessableEntity()
+	}
+	if !scope.IsValid() {
+		return apistatus.New("invalid deletion scope").UnprocessableEntity()
+	}
+	msg, as := s.messageRepo.GetMessageByID(ctx, messageID)
+	if as != nil {
+		return as
+	}
+	chat, as := s.chatRepo.GetChatByID(ctx, msg.ChatID)
+	if as != nil {
+		return as
+	}
+
+	event := &domain.MessageDeletedEvent{
+		MessageID: msg.ID,
+		ChatID:    msg.ChatID,
+		UserID:    userID,
+		Scope:     scope,
+		DeletedAt: time.Now(),
+	}
+	buildEntry := func(m *domain.Message) (*domain.OutboxEntry, error) {
+		return newOutboxEntry(ctx, domain.EventTypeMessageDeleted, m.ChatID, m.ID, event)
+	}
+	if scope == domain.DeleteForEveryone {
+		if msg.SenderID != userID {
+			return apistatus.New("only the sender can delete a message for everyone").Forbidden()
+		}
+		if msg.IsDeleted() {
+			return nil
+		}
+		if _, as := s.messageRepo.DeleteMessageWithOutbox(ctx, messageID, event.DeletedAt, buildEntry); as != nil {
+			return as
+		}
+		s.notify(chat, &realtime.Event{Type: realtime.EventMessageDeleted, Data: event})
+		return nil
+	}
+
+	if !chat.HasParticipant(userID) && msg.SenderID != userID {
+		return apistatus.New("user is not a participant of the chat").UnprocessableEntity()
+	}
+	if as := s.messageRepo.HideMessageWithOutbox(ctx, messageID, userID, buildEntry); as != nil {
 		return as
 	}
-	return s.messageRepo.UpdateMessageStatus(ctx, messageID, status)
+	// Only the user's own connections need to drop the message.
+	if s.notifier != nil {
+		s.notifier.Publish([]int64{userID}, &realtime.Event{Type: realtime.EventMessageDeleted, ChatID: msg.ChatID, Data: event})
+	}
+	return nil
+}
+
+// ReactToMessage sets the reaction of userID, who must take part in the chat,
+// to a message and returns the message with its updated reaction counts.
+func (s *messageService) ReactToMessage(ctx context.Context, messageID, userID int64, emoji string) (*domain.Message, apistatus.Status) {
+	if messageID <= 0 {
+		return nil, apistatus.New("invalid messageID").UnprocessableEntity()
+	}
+	if !domain.IsValidReaction(emoji) {
+		return nil, apistatus.New("invalid reaction").UnprocessableEntity()
+	}
+	msg, as := s.messageRepo.GetMessageByID(ctx, messageID)
+	if as != nil {
+		return nil, as
+	}
+	if msg.IsDeleted() {
+		return nil, apistatus.New("message has been deleted").Conflict()
+	}
+	chat, as := s.chatRepo.GetChatByID(ctx, msg.ChatID)
+	if as != nil {
+		return nil, as
+	}
+	if !chat.HasParticipant(userID) {
+		return nil, apistatus.New("user is not a participant of the chat").UnprocessableEntity()
+	}
+	reactions, as := s.messageRepo.GetReactions(ctx, messageID)
+	if as != nil {
+		return nil, as
+	}
+	event := &domain.MessageReactionEvent{
+		MessageID: msg.ID,
+		ChatID:    msg.ChatID,
+		UserID:    userID,
+		Emoji:     emoji,
+		At:        time.Now(),
+	}
+	for _, reaction := range reactions {
+		if reaction.UserID == userID {
+			event.Replaced = reaction.Emoji
+		}
+	}
+	if event.Replaced == emoji {
+		return msg, nil
+	}
+	reaction := &domain.Reaction{MessageID: msg.ID, UserID: userID, Emoji: emoji, CreatedAt: event.At}
+	buildEntry := func(m *domain.Message) (*domain.OutboxEntry, error) {
+		return newOutboxEntry(ctx, domain.EventTypeReactionAdded, m.ChatID, m.ID, event)
+	}
+	if as := s.messageRepo.SetReactionWithOutbox(ctx, reaction, buildEntry); as != nil {
+		return nil, as
+	}
+	s.notify(chat, &realtime.Event{Type: realtime.EventReactionAdded, Data: event})
+	return s.messageRepo.GetMessageByID(ctx, messageID)
+}
+
+// RemoveReaction removes the reaction of userID to a message.
+func (s *messageService) RemoveReaction(ctx context.Context, messageID, userID int64) apistatus.Status {
+	if messageID <= 0 {
+		return apistatus.New("invalid messageID").UnprocessableEntity()
+	}
+	msg, as := s.messageRepo.GetMessageByID(ctx, messageID)
+	if as != nil {
+		return as
+	}
+	event := &domain.MessageReactionEvent{
+		MessageID: msg.ID,
+		ChatID:    msg.ChatID,
+		UserID:    userID,
+		At:        time.Now(),
+	}
+	_, as = s.messageRepo.RemoveReactionWithOutbox(ctx, messageID, userID, func(removed *domain.Reaction) (*domain.OutboxEntry, error) {
+		event.Emoji = removed.Emoji
+		return newOutboxEntry(ctx, domain.EventTypeReactionRemoved, msg.ChatID, msg.ID, event)
+	})
+	if as != nil {
+		return as
+	}
+	if chat, as := s.chatRepo.GetChatByID(ctx, msg.ChatID); as == nil {
+		s.notify(chat, &realtime.Event{Type: realtime.EventReactionRemoved, Data: event})
+	}
+	return nil
+}
+
+// MarkMessageDelivered records that userIDs received the message. The message
+// becomes "delivered" with its first delivery.
+func (s *messageService) MarkMessageDelivered(ctx context.Context, messageID int64, userIDs []int64) apistatus.Status {
+	if messageID <= 0 {
+		return apistatus.New("invalid messageID").UnprocessableEntity()
+	}
+	msg, as := s.messageRepo.MarkMessageDelivered(ctx, messageID, userIDs, time.Now())
+	if as != nil {
+		return as
+	}
+	if len(msg.Receipts) == 0 {
+		return nil
+	}
+	return s.advanceStatus(ctx, msg, domain.MessageStatusDelivered)
+}
+
+// MarkChatRead records that userID read chatID up to and including message
+// upToMessageID. Messages read by every other member become "read".
+func (s *messageService) MarkChatRead(ctx context.Context, chatID, userID, upToMessageID int64) (*domain.ChatRead, apistatus.Status) {
+	if userID <= 0 || upToMessageID <= 0 {
+		return nil, apistatus.New("invalid userID or messageID").UnprocessableEntity()
+	}
+	chat, as := s.chatRepo.GetChatByID(ctx, chatID)
+	if as != nil {
+		return nil, as
+	}
+	if !chat.HasParticipant(userID) {
+		return nil, apistatus.New("user is not a participant of the chat").UnprocessableEntity()
+	}
+	upTo, as := s.messageRepo.GetMessageByID(ctx, upToMessageID)
+	if as != nil {
+		return nil, as
+	}
+	if upTo.ChatID != chatID {
+		return nil, apistatus.New("message does not belong to the chat").UnprocessableEntity()
+	}
+
+	read := &domain.ChatRead{
+		ChatID:        chatID,
+		UserID:        userID,
+		UpToMessageID: upToMessageID,
+		ReadAt:        time.Now(),
+		MessageIDs:    []int64{},
+	}
+	messages, as := s.messageRepo.MarkChatReadWithOutbox(ctx, chatID, userID, upToMessageID, read.ReadAt, func(messages []*domain.Message) (*domain.OutboxEntry, error) {
+		for _, msg := range messages {
+			read.MessageIDs = append(read.MessageIDs, msg.ID)
+		}
+		return newOutboxEntry(ctx, domain.EventTypeChatRead, chatID, chatID, read)
+	})
+	if as != nil {
+		return nil, as
+	}
+	if len(messages) == 0 {
+		return read, nil
+	}
+	for _, msg := range messages {
+		if !msg.ReadByAll(chat.Participants()) {
+			continue
+		}
+		if as := s.advanceStatus(ctx, msg, domain.MessageStatusRead); as != nil {
+			log.Printf("failed to mark message %d as read: %s", msg.ID, as.GetMessage())
+		}
+	}
+	s.notify(chat, &realtime.Event{Type: realtime.EventChatRead, Data: read})
+	return read, nil
 }
 
 func (s *messageService) CreateChat(ctx context.Context, participant1ID, participant2ID int64) (*domain.Chat, apistatus.Status) {
 	// Validate that both participants are valid.
-	if !domain.IsValidUser(participant1ID) || !domain.IsValidUser(participant2ID) {
+	if !s.isValidUser(ctx, participant1ID) || !s.isValidUser(ctx, participant2ID) {
 		return nil, apistatus.New("one or both participants are invalid").UnprocessableEntity()
 	}
 	// Ensure the participants are not the same.
@@ -140,10 +600,116 @@ func (s *messageService) CreateChat(ctx context.Context, participant1ID, partici
 	}
 
 	newChat := &domain.Chat{
+		Type:           domain.ChatTypeDirect,
 		Participant1ID: participant1ID,
 		Participant2ID: participant2ID,
+		ParticipantIDs: []int64{participant1ID, participant2ID},
 		Metadata:       "Created chat",
 		CreatedAt:      time.Now(),
 	}
-	return s.chatRepo.CreateChat(ctx, newChat)
+	return s.createChat(ctx, newChat)
+}
+
+func (s *messageService) CreateGroupChat(ctx context.Context, title string, participantIDs []int64) (*domain.Chat, apistatus.Status) {
+	title = strings.TrimSpace(title)
+	if title == "" {
+		return nil, apistatus.New("group chats require a title").UnprocessableEntity()
+	}
+	// Drop duplicates while keeping the order the members were given in.
+	seen := make(map[int64]bool, len(participantIDs))
+	members := make([]int64, 0, len(participantIDs))
+	for _, id := range participantIDs {
+		if seen[id] {
+			continue
+		}
+		if !s.isValidUser(ctx, id) {
+			return nil, apistatus.New("one or more participants are invalid").UnprocessableEntity()
+		}
+		seen[id] = true
+		members = append(members, id)
+	}
+	if len(members) < 2 {
+		return nil, apistatus.New("group chats require at least two participants").UnprocessableEntity()
+	}
+
+	newChat := &domain.Chat{
+		Type:           domain.ChatTypeGroup,
+		Title:          title,
+		ParticipantIDs: members,
+		Metadata:       "Created group chat",
+		CreatedAt:      time.Now(),
+	}
+	return s.createChat(ctx, newChat)
+}
+
+// createChat stores chat and notifies its participants.
+func (s *messageService) createChat(ctx context.Context, chat *domain.Chat) (*domain.Chat, apistatus.Status) {
+	created, as := s.chatRepo.CreateChatWithOutbox(ctx, chat, func(c *domain.Chat) (*domain.OutboxEntry, error) {
+		return newOutboxEntry(ctx, domain.EventTypeChatCreated, c.ID, c.ID, c)
+	})
+	if as != nil {
+		return nil, as
+	}
+	s.notify(created, &realtime.Event{Type: realtime.EventChatCreated, Data: created})
+	return created, nil
+}
+
+func (s *messageService) AddChatMember(ctx context.Context, chatID, userID int64) (*domain.Chat, apistatus.Status) {
+	chat, as := s.chatRepo.GetChatByID(ctx, chatID)
+	if as != nil {
+		return nil, as
+	}
+	if !chat.IsGroup() {
+		return nil, apistatus.New("members can only be added to group chats").UnprocessableEntity()
+	}
+	if !s.isValidUser(ctx, userID) {
+		return nil, apistatus.New("user does not exist").UnprocessableEntity()
+	}
+	if chat.HasParticipant(userID) {
+		return nil, apistatus.New("user is already a member of the chat").UnprocessableEntity()
+	}
+	return s.chatRepo.AddParticipantWithOutbox(ctx, chatID, userID, memberEntry(ctx, domain.EventTypeChatMemberAdded, userID))
+}
+
+func (s *messageService) RemoveChatMember(ctx context.Context, chatID, userID int64) (*domain.Chat, apistatus.Status) {
+	chat, as := s.chatRepo.GetChatByID(ctx, chatID)
+	if as != nil {
+		return nil, as
+	}
+	if !chat.IsGroup() {
+		return nil, apistatus.New("members can only be removed from group chats").UnprocessableEntity()
+	}
+	if !chat.HasParticipant(userID) {
+		return nil, apistatus.New("user is not a member of the chat").NotFound()
+	}
+	return s.chatRepo.RemoveParticipantWithOutbox(ctx, chatID, userID, memberEntry(ctx, domain.EventTypeChatMemberRemoved, userID))
+}
+
+// memberEntry builds the outbox entry of a membership change of userID.
+func memberEntry(ctx context.Context, eventType string, userID int64) repository.ChatOutboxEntryBuilder {
+	return func(chat *domain.Chat) (*domain.OutboxEntry, error) {
+		return newOutboxEntry(ctx, eventType, chat.ID, chat.ID, &domain.ChatMemberEvent{ChatID: chat.ID, UserID: userID})
+	}
+}
+
+// GetMissedMessages returns the messages of every chat of userID sent after
+// lastMessageID, so reconnecting clients can catch up. Like the chat history,
+// it leaves out messages userID deleted for themselves and returns messages
+// deleted for everyone as tombstones.
+func (s *messageService) GetMissedMessages(ctx context.Context, userID, lastMessageID int64) ([]*domain.Message, apistatus.Status) {
+	if userID <= 0 {
+		return nil, apistatus.New("invalid userID").UnprocessableEntity()
+	}
+	chats, as := s.chatRepo.GetChatsByUserID(ctx, userID)
+	if as != nil {
+		return nil, as
+	}
+	if len(chats) == 0 {
+		return []*domain.Message{}, nil
+	}
+	chatIDs := make([]int64, 0, len(chats))
+	for _, chat := range chats {
+		chatIDs = append(chatIDs, chat.ID)
+	}
+	return s.messageRepo.GetMessagesSince(ctx, chatIDs, userID, lastMessageID)
 }
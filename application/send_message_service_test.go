@@ -3,11 +3,16 @@ package application
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"net/http"
 	"testing"
 	"time"
 
 	"messaging-app/domain"
+	"messaging-app/infrastructure/mq"
+	"messaging-app/infrastructure/realtime"
 	"messaging-app/infrastructure/repository"
+	"messaging-app/pkg/correlation"
 )
 
 // dummyRabbitMQ is a stub implementation of the RabbitMQ interface for testing.
@@ -18,13 +23,37 @@ func (d *dummyRabbitMQ) PublishMessage(body []byte) error {
 	return nil
 }
 
+func (d *dummyRabbitMQ) PublishWithConfirm(ctx context.Context, body []byte) error {
+	return d.PublishMessage(body)
+}
+
+func (d *dummyRabbitMQ) PublishEvent(ctx context.Context, event *domain.Event) error {
+	return nil
+}
+
+func (d *dummyRabbitMQ) Republish(ctx context.Context, dl *domain.DeadLetter) error {
+	return nil
+}
+
+func (d *dummyRabbitMQ) Consume(ctx context.Context, handler mq.Handler) error {
+	<-ctx.Done()
+	return nil
+}
+
+func (d *dummyRabbitMQ) ConsumeDeadLetters(ctx context.Context, handler mq.DeadLetterHandler) error {
+	<-ctx.Done()
+	return nil
+}
+
 func (d *dummyRabbitMQ) Close() {}
 
+func (d *dummyRabbitMQ) State() mq.ConnectionState { return mq.StateConnected }
+
 // TestSendMessageAndUpdateStatus tests sending a message and then updating its status.
 func TestSendMessageAndUpdateStatus(t *testing.T) {
 	// Create in-memory repositories.
 	msgRepo := repository.NewInMemoryMessageRepository()
-	chatRepo := repository.NewInMemoryChatRepository()
+	chatRepo := repository.NewInMemoryChatRepository(msgRepo)
 
 	ctx := context.Background()
 	// Create a new chat so that SendMessage can succeed.
@@ -39,8 +68,7 @@ func TestSendMessageAndUpdateStatus(t *testing.T) {
 		t.Fatalf("failed to create chat: %v", apistatus.GetError())
 	}
 
-	rabbitMQ := &dummyRabbitMQ{}
-	service := NewMessageService(msgRepo, chatRepo, rabbitMQ)
+	service := NewMessageService(msgRepo, chatRepo, repository.NewInMemoryUserRepository(), nil, 0)
 
 	// Test sending a message.
 	msg, apistatus := service.SendMessage(ctx, chat.ID, 1, "Hello from test")
@@ -58,7 +86,7 @@ func TestSendMessageAndUpdateStatus(t *testing.T) {
 	}
 
 	// Verify update.
-	messages, apistatus := msgRepo.GetMessagesByChatID(ctx, chat.ID)
+	messages, apistatus := msgRepo.GetMessagesByChatID(ctx, chat.ID, 0)
 	if apistatus != nil {
 		t.Fatalf("GetMessagesByChatID failed: %v", apistatus.GetError())
 	}
@@ -85,11 +113,12 @@ func TestSendMessageAndUpdateStatus(t *testing.T) {
 
 // TestListChatsForUser tests ListChatsForUser when chats exist.
 func TestListChatsForUser(t *testing.T) {
-	chatRepo := repository.NewInMemoryChatRepository()
+	msgRepo := repository.NewInMemoryMessageRepository()
+	chatRepo := repository.NewInMemoryChatRepository(msgRepo)
 	ctx := context.Background()
 
 	// Create two chats for user 1.
-	_, err := chatRepo.CreateChat(ctx, &domain.Chat{
+	first, err := chatRepo.CreateChat(ctx, &domain.Chat{
 		Participant1ID: 1,
 		Participant2ID: 2,
 		Metadata:       "Chat 1",
@@ -98,7 +127,7 @@ func TestListChatsForUser(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to create chat: %v", err)
 	}
-	_, err = chatRepo.CreateChat(ctx, &domain.Chat{
+	second, err := chatRepo.CreateChat(ctx, &domain.Chat{
 		Participant1ID: 3,
 		Participant2ID: 1,
 		Metadata:       "Chat 2",
@@ -108,8 +137,13 @@ func TestListChatsForUser(t *testing.T) {
 		t.Fatalf("failed to create chat: %v", err)
 	}
 
-	// Create a dummy message service that wraps the chatRepo.
-	service := NewMessageService(nil, chatRepo, &dummyRabbitMQ{})
+	service := NewMessageService(msgRepo, chatRepo, repository.NewInMemoryUserRepository(), nil, 0)
+	// The first chat becomes the most recently active one.
+	for _, content := range []string{"Hi", "Anyone?"} {
+		if _, apistatus := service.SendMessage(ctx, first.ID, 2, content); apistatus != nil {
+			t.Fatalf("SendMessage failed: %s", apistatus.GetMessage())
+		}
+	}
 	chats, apistatus := service.ListChatsForUser(ctx, 1)
 	if apistatus != nil {
 		t.Fatalf("ListChatsForUser failed: %s", apistatus.GetMessage())
@@ -118,17 +152,35 @@ func TestListChatsForUser(t *testing.T) {
 	// Expect exactly 2 chats for user 1.
 	expectedChats := 2
 	if len(chats) != expectedChats {
-		t.Errorf("expected %d chats for user 1, got %d", expectedChats, len(chats))
+		t.Fatalf("expected %d chats for user 1, got %d", expectedChats, len(chats))
+	}
+	if chats[0].ID != first.ID || chats[1].ID != second.ID {
+		t.Errorf("expected chat %d first, got %d", first.ID, chats[0].ID)
+	}
+	if chats[0].UnreadCount != 2 || chats[0].LastMessage == nil || chats[0].LastMessage.Content != "Anyone?" {
+		t.Errorf("unexpected summary %+v", chats[0])
+	}
+	if chats[1].UnreadCount != 0 || chats[1].LastMessage != nil {
+		t.Errorf("expected an empty summary for chat %d, got %+v", second.ID, chats[1])
+	}
+
+	// Reading the chat clears its unread count.
+	if _, apistatus := service.MarkChatRead(ctx, first.ID, 1, chats[0].LastMessage.ID); apistatus != nil {
+		t.Fatalf("MarkChatRead failed: %s", apistatus.GetMessage())
+	}
+	chats, _ = service.ListChatsForUser(ctx, 1)
+	if chats[0].UnreadCount != 0 {
+		t.Errorf("expected no unread messages after reading, got %d", chats[0].UnreadCount)
 	}
 }
 
 // TestListChatsForUser_NoChats tests that ListChatsForUser returns an error if the user has no chats.
 func TestListChatsForUser_NoChats(t *testing.T) {
-	chatRepo := repository.NewInMemoryChatRepository()
+	chatRepo := repository.NewInMemoryChatRepository(nil)
 	ctx := context.Background()
 
 	// No chats are created here.
-	service := NewMessageService(nil, chatRepo, &dummyRabbitMQ{})
+	service := NewMessageService(nil, chatRepo, repository.NewInMemoryUserRepository(), nil, 0)
 	_, apistatus := service.ListChatsForUser(ctx, 1)
 	if apistatus == nil {
 		t.Error("expected error when listing chats for user with no chats, got nil")
@@ -143,10 +195,9 @@ func TestListChatsForUser_NoChats(t *testing.T) {
 // TestUpdateMessageStatus_NonExistent tests that updating a message that does not exist returns an error.
 func TestUpdateMessageStatus_NonExistent(t *testing.T) {
 	msgRepo := repository.NewInMemoryMessageRepository()
-	chatRepo := repository.NewInMemoryChatRepository()
-	rabbitMQ := &dummyRabbitMQ{}
+	chatRepo := repository.NewInMemoryChatRepository(msgRepo)
 
-	service := NewMessageService(msgRepo, chatRepo, rabbitMQ)
+	service := NewMessageService(msgRepo, chatRepo, repository.NewInMemoryUserRepository(), nil, 0)
 	ctx := context.Background()
 
 	// Attempt to update a message with an ID that doesn't exist.
@@ -164,10 +215,9 @@ func TestUpdateMessageStatus_NonExistent(t *testing.T) {
 // TestCreateChatAndSendMessage tests the full flow: create a chat then send a message.
 func TestCreateChatAndSendMessage(t *testing.T) {
 	msgRepo := repository.NewInMemoryMessageRepository()
-	chatRepo := repository.NewInMemoryChatRepository()
-	rabbitMQ := &dummyRabbitMQ{}
+	chatRepo := repository.NewInMemoryChatRepository(msgRepo)
 
-	service := NewMessageService(msgRepo, chatRepo, rabbitMQ)
+	service := NewMessageService(msgRepo, chatRepo, repository.NewInMemoryUserRepository(), nil, 0)
 	ctx := context.Background()
 
 	// Create a chat.
@@ -192,10 +242,9 @@ func TestCreateChatAndSendMessage(t *testing.T) {
 // TestSendMessageInvalidChat tests that SendMessage returns an error for a non-existent chat.
 func TestSendMessageInvalidChat(t *testing.T) {
 	msgRepo := repository.NewInMemoryMessageRepository()
-	chatRepo := repository.NewInMemoryChatRepository()
-	rabbitMQ := &dummyRabbitMQ{}
+	chatRepo := repository.NewInMemoryChatRepository(msgRepo)
 
-	service := NewMessageService(msgRepo, chatRepo, rabbitMQ)
+	service := NewMessageService(msgRepo, chatRepo, repository.NewInMemoryUserRepository(), nil, 0)
 	ctx := context.Background()
 
 	// Attempt to send a message to a non-existent chat (ID 999).
@@ -209,3 +258,680 @@ func TestSendMessageInvalidChat(t *testing.T) {
 		}
 	}
 }
+
+// recordingRabbitMQ captures published events for inspection.
+type recordingRabbitMQ struct {
+	events []*domain.Event
+}
+
+func (r *recordingRabbitMQ) PublishMessage(body []byte) error {
+	return nil
+}
+
+func (r *recordingRabbitMQ) PublishWithConfirm(ctx context.Context, body []byte) error {
+	return nil
+}
+
+func (r *recordingRabbitMQ) PublishEvent(ctx context.Context, event *domain.Event) error {
+	r.events = append(r.events, event)
+	return nil
+}
+
+func (r *recordingRabbitMQ) Republish(ctx context.Context, dl *domain.DeadLetter) error {
+	return nil
+}
+
+// eventsOfType returns the recorded events of the given type.
+func (r *recordingRabbitMQ) eventsOfType(eventType string) []*domain.Event {
+	var events []*domain.Event
+	for _, event := range r.events {
+		if event.Type == eventType {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+func (r *recordingRabbitMQ) Consume(ctx context.Context, handler mq.Handler) error {
+	<-ctx.Done()
+	return nil
+}
+
+func (r *recordingRabbitMQ) ConsumeDeadLetters(ctx context.Context, handler mq.DeadLetterHandler) error {
+	<-ctx.Done()
+	return nil
+}
+
+func (r *recordingRabbitMQ) Close() {}
+
+func (r *recordingRabbitMQ) State() mq.ConnectionState { return mq.StateConnected }
+
+// TestGroupChat tests creating a group chat, messaging it and managing its members.
+func TestGroupChat(t *testing.T) {
+	msgRepo := repository.NewInMemoryMessageRepository()
+	chatRepo := repository.NewInMemoryChatRepository(msgRepo)
+	rabbitMQ := &recordingRabbitMQ{}
+
+	service := NewMessageService(msgRepo, chatRepo, repository.NewInMemoryUserRepository(), nil, 0)
+	ctx := context.Background()
+
+	chat, apistatus := service.CreateGroupChat(ctx, "Team", []int64{1, 2, 3, 2})
+	if apistatus != nil {
+		t.Fatalf("CreateGroupChat failed: %s", apistatus.GetMessage())
+	}
+	if len(chat.ParticipantIDs) != 3 {
+		t.Errorf("expected 3 distinct participants, got %v", chat.ParticipantIDs)
+	}
+
+	// Any member can send, and the event fans out to every other member.
+	if _, apistatus := service.SendMessage(ctx, chat.ID, 3, "Hello team"); apistatus != nil {
+		t.Fatalf("SendMessage failed: %s", apistatus.GetMessage())
+	}
+	relay := NewOutboxRelay(msgRepo, rabbitMQ, time.Second, 10, time.Millisecond, time.Second, time.Second, time.Minute)
+	relay.RelayPending(ctx)
+	sent := rabbitMQ.eventsOfType(domain.EventTypeMessageSent)
+	if len(sent) != 1 {
+		t.Fatalf("expected 1 message.sent event, got %d", len(sent))
+	}
+	var event domain.MessageSentEvent
+	if err := sent[0].DecodePayload(&event); err != nil {
+		t.Fatalf("failed to decode payload: %v", err)
+	}
+	if len(event.RecipientIDs) != 2 || event.ChatType != domain.ChatTypeGroup || sent[0].ChatID != chat.ID {
+		t.Errorf("unexpected event: %s", sent[0].Payload)
+	}
+
+	// User 4 is not a member yet.
+	if _, apistatus := service.SendMessage(ctx, chat.ID, 4, "Let me in"); apistatus == nil {
+		t.Error("expected error when a non-member sends a message, got nil")
+	}
+	if _, apistatus := service.AddChatMember(ctx, chat.ID, 4); apistatus != nil {
+		t.Fatalf("AddChatMember failed: %s", apistatus.GetMessage())
+	}
+	if _, apistatus := service.AddChatMember(ctx, chat.ID, 4); apistatus == nil {
+		t.Error("expected error when adding an existing member, got nil")
+	}
+	chats, apistatus := service.ListChatsForUser(ctx, 4)
+	if apistatus != nil {
+		t.Fatalf("ListChatsForUser failed: %s", apistatus.GetMessage())
+	}
+	if len(chats) != 1 {
+		t.Errorf("expected 1 chat for user 4, got %d", len(chats))
+	}
+
+	updated, apistatus := service.RemoveChatMember(ctx, chat.ID, 1)
+	if apistatus != nil {
+		t.Fatalf("RemoveChatMember failed: %s", apistatus.GetMessage())
+	}
+	if updated.HasParticipant(1) {
+		t.Error("expected user 1 to be removed")
+	}
+}
+
+// TestAddChatMember_DirectChat tests that direct chats cannot gain members.
+func TestAddChatMember_DirectChat(t *testing.T) {
+	msgRepo := repository.NewInMemoryMessageRepository()
+	service := NewMessageService(msgRepo, repository.NewInMemoryChatRepository(msgRepo), repository.NewInMemoryUserRepository(), nil, 0)
+	ctx := context.Background()
+
+	chat, apistatus := service.CreateChat(ctx, 1, 2)
+	if apistatus != nil {
+		t.Fatalf("CreateChat failed: %s", apistatus.GetMessage())
+	}
+	_, apistatus = service.AddChatMember(ctx, chat.ID, 3)
+	if apistatus == nil {
+		t.Fatal("expected error when adding a member to a direct chat, got nil")
+	}
+	expected := "unprocessable entity: members can only be added to group chats"
+	if apistatus.GetMessage() != expected {
+		t.Errorf("expected error %q, got %q", expected, apistatus.GetMessage())
+	}
+}
+
+// TestSendMessage_NotifiesParticipants tests that status changes are pushed to the hub.
+func TestSendMessage_NotifiesParticipants(t *testing.T) {
+	hub := realtime.NewHub(4)
+	msgRepo := repository.NewInMemoryMessageRepository()
+	service := NewMessageService(msgRepo, repository.NewInMemoryChatRepository(msgRepo), repository.NewInMemoryUserRepository(), hub, 0)
+	ctx := context.Background()
+
+	chat, apistatus := service.CreateChat(ctx, 1, 2)
+	if apistatus != nil {
+		t.Fatalf("CreateChat failed: %s", apistatus.GetMessage())
+	}
+	recipient := hub.Subscribe(2)
+	defer recipient.Close()
+	outsider := hub.Subscribe(3)
+	defer outsider.Close()
+
+	msg, apistatus := service.SendMessage(ctx, chat.ID, 1, "Hello")
+	if apistatus != nil {
+		t.Fatalf("SendMessage failed: %s", apistatus.GetMessage())
+	}
+	if apistatus := service.UpdateMessageStatus(ctx, msg.ID, domain.MessageStatusDelivered); apistatus != nil {
+		t.Fatalf("UpdateMessageStatus failed: %s", apistatus.GetMessage())
+	}
+
+	// message.created is pushed by the DeliveryWorker, not SendMessage.
+	for _, expected := range []string{realtime.EventStatusChanged} {
+		select {
+		case event := <-recipient.Events():
+			if event.Type != expected || event.ChatID != chat.ID {
+				t.Errorf("expected %s event for chat %d, got %+v", expected, chat.ID, event)
+			}
+		default:
+			t.Fatalf("expected %s event", expected)
+		}
+	}
+	select {
+	case event := <-outsider.Events():
+		t.Errorf("expected no events for non-participant, got %+v", event)
+	default:
+	}
+}
+
+// TestGetMissedMessages tests catching up on messages from every chat of a user.
+func TestGetMissedMessages(t *testing.T) {
+	hub := realtime.NewHub(4)
+	msgRepo := repository.NewInMemoryMessageRepository()
+	service := NewMessageService(msgRepo, repository.NewInMemoryChatRepository(msgRepo), repository.NewInMemoryUserRepository(), hub, 0)
+	ctx := context.Background()
+
+	sub := hub.Subscribe(3)
+	defer sub.Close()
+	chat1, _ := service.CreateChat(ctx, 1, 2)
+	chat2, _ := service.CreateChat(ctx, 2, 3)
+
+	// User 3 is told about the chat they were added to.
+	select {
+	case event := <-sub.Events():
+		if event.Type != realtime.EventChatCreated || event.ChatID != chat2.ID {
+			t.Errorf("expected chat.created for chat %d, got %+v", chat2.ID, event)
+		}
+	default:
+		t.Fatal("expected chat.created event")
+	}
+
+	first, _ := service.SendMessage(ctx, chat2.ID, 2, "First")
+	service.SendMessage(ctx, chat1.ID, 1, "Not for user 3")
+	third, _ := service.SendMessage(ctx, chat2.ID, 3, "Third")
+
+	missed, apistatus := service.GetMissedMessages(ctx, 3, first.ID)
+	if apistatus != nil {
+		t.Fatalf("GetMissedMessages failed: %s", apistatus.GetMessage())
+	}
+	if len(missed) != 1 || missed[0].ID != third.ID {
+		t.Errorf("expected only message %d, got %+v", third.ID, missed)
+	}
+}
+
+// TestGetMessages_Pagination tests paging through a chat's history.
+func TestGetMessages_Pagination(t *testing.T) {
+	msgRepo := repository.NewInMemoryMessageRepository()
+	service := NewMessageService(msgRepo, repository.NewInMemoryChatRepository(msgRepo), repository.NewInMemoryUserRepository(), nil, 0)
+	ctx := context.Background()
+
+	chat, _ := service.CreateChat(ctx, 1, 2)
+	for i := 0; i < 3; i++ {
+		if _, apistatus := service.SendMessage(ctx, chat.ID, 1, "Hello"); apistatus != nil {
+			t.Fatalf("SendMessage failed: %s", apistatus.GetMessage())
+		}
+	}
+
+	page, apistatus := service.GetMessages(ctx, chat.ID, domain.MessageQuery{Limit: 2})
+	if apistatus != nil {
+		t.Fatalf("GetMessages failed: %s", apistatus.GetMessage())
+	}
+	if len(page.Messages) != 2 || page.Messages[0].ID != 2 || page.Messages[1].ID != 3 {
+		t.Fatalf("expected messages 2 and 3, got %+v", page.Messages)
+	}
+	cursor, err := domain.DecodeMessageCursor(page.NextCursor)
+	if err != nil {
+		t.Fatalf("failed to decode next cursor: %v", err)
+	}
+	page, _ = service.GetMessages(ctx, chat.ID, domain.MessageQuery{Before: cursor})
+	if len(page.Messages) != 1 || page.Messages[0].ID != 1 || page.NextCursor != "" {
+		t.Errorf("expected only message 1 and no cursor, got %+v", page)
+	}
+
+	// Limits above the maximum are rejected.
+	_, apistatus = service.GetMessages(ctx, chat.ID, domain.MessageQuery{Limit: domain.MaxMessagePageSize + 1})
+	if apistatus == nil {
+		t.Fatal("expected error for oversized limit, got nil")
+	}
+	expected := "unprocessable entity: limit must be between 1 and 100"
+	if apistatus.GetMessage() != expected {
+		t.Errorf("expected error %q, got %q", expected, apistatus.GetMessage())
+	}
+}
+
+// TestEventEnvelope tests that changes are published as versioned events
+// carrying the request's correlation ID.
+func TestEventEnvelope(t *testing.T) {
+	msgRepo := repository.NewInMemoryMessageRepository()
+	service := NewMessageService(msgRepo, repository.NewInMemoryChatRepository(msgRepo), repository.NewInMemoryUserRepository(), nil, 0)
+	rabbitMQ := &recordingRabbitMQ{}
+	relay := NewOutboxRelay(msgRepo, rabbitMQ, time.Second, 10, time.Millisecond, time.Second, time.Second, time.Minute)
+	ctx := correlation.WithID(context.Background(), "req-1")
+
+	chat, _ := service.CreateGroupChat(ctx, "Team", []int64{1, 2})
+	msg, _ := service.SendMessage(ctx, chat.ID, 1, "Hello")
+	service.UpdateMessageStatus(ctx, msg.ID, domain.MessageStatusDelivered)
+	service.AddChatMember(ctx, chat.ID, 3)
+	service.RemoveChatMember(ctx, chat.ID, 3)
+	relay.RelayPending(ctx)
+
+	expected := []string{
+		domain.EventTypeChatCreated,
+		domain.EventTypeMessageSent,
+		domain.EventTypeMessageStatusChanged,
+		domain.EventTypeChatMemberAdded,
+		domain.EventTypeChatMemberRemoved,
+	}
+	if len(rabbitMQ.events) != len(expected) {
+		t.Fatalf("expected %d events, got %d", len(expected), len(rabbitMQ.events))
+	}
+	seen := make(map[string]bool)
+	for i, event := range rabbitMQ.events {
+		if event.Type != expected[i] {
+			t.Errorf("event %d: expected type %s, got %s", i, expected[i], event.Type)
+		}
+		if event.ID == "" || seen[event.ID] {
+			t.Errorf("event %d: expected a unique ID, got %q", i, event.ID)
+		}
+		seen[event.ID] = true
+		if event.SchemaVersion != domain.EventSchemaVersion || event.CorrelationID != "req-1" || event.ChatID != chat.ID {
+			t.Errorf("event %d: unexpected envelope %+v", i, event)
+		}
+	}
+
+	var change domain.MessageStatusChangedEvent
+	if err := rabbitMQ.events[2].DecodePayload(&change); err != nil {
+		t.Fatalf("failed to decode payload: %v", err)
+	}
+	if change.MessageID != msg.ID || change.Status != domain.MessageStatusDelivered {
+		t.Errorf("unexpected status change payload: %+v", change)
+	}
+}
+
+// TestMarkChatRead tests that reading a group chat records receipts and that
+// messages become read once every other member has read them.
+func TestMarkChatRead(t *testing.T) {
+	msgRepo := repository.NewInMemoryMessageRepository()
+	service := NewMessageService(msgRepo, repository.NewInMemoryChatRepository(msgRepo), repository.NewInMemoryUserRepository(), nil, 0)
+	ctx := context.Background()
+
+	chat, apistatus := service.CreateGroupChat(ctx, "Team", []int64{1, 2, 3})
+	if apistatus != nil {
+		t.Fatalf("CreateGroupChat failed: %s", apistatus.GetMessage())
+	}
+	first, _ := service.SendMessage(ctx, chat.ID, 1, "one")
+	second, _ := service.SendMessage(ctx, chat.ID, 1, "two")
+	if apistatus := service.MarkMessageDelivered(ctx, first.ID, []int64{2, 3}); apistatus != nil {
+		t.Fatalf("MarkMessageDelivered failed: %s", apistatus.GetMessage())
+	}
+
+	read, apistatus := service.MarkChatRead(ctx, chat.ID, 2, second.ID)
+	if apistatus != nil {
+		t.Fatalf("MarkChatRead failed: %s", apistatus.GetMessage())
+	}
+	if len(read.MessageIDs) != 2 {
+		t.Errorf("expected 2 messages marked read, got %v", read.MessageIDs)
+	}
+	msg, _ := msgRepo.GetMessageByID(ctx, first.ID)
+	if msg.Status != domain.MessageStatusDelivered || msg.ReceiptFor(2).ReadAt == nil {
+		t.Errorf("expected message %d delivered and read by user 2 only, got %s %+v", first.ID, msg.Status, msg.Receipts)
+	}
+
+	// User 3 reads only the first message, which is now read by everyone.
+	if _, apistatus := service.MarkChatRead(ctx, chat.ID, 3, first.ID); apistatus != nil {
+		t.Fatalf("MarkChatRead failed: %s", apistatus.GetMessage())
+	}
+	if msg, _ := msgRepo.GetMessageByID(ctx, first.ID); msg.Status != domain.MessageStatusRead {
+		t.Errorf("expected message %d to be read, got %s", first.ID, msg.Status)
+	}
+	if history, _ := service.GetStatusHistory(ctx, first.ID); len(history) != 2 {
+		t.Errorf("expected the message to go through delivered to read, got %+v", history)
+	}
+	if msg, _ := msgRepo.GetMessageByID(ctx, second.ID); msg.Status != domain.MessageStatusSent {
+		t.Errorf("expected message %d to stay sent, got %s", second.ID, msg.Status)
+	}
+
+	// Only members can mark a chat read, and only up to its own messages.
+	if _, apistatus := service.MarkChatRead(ctx, chat.ID, 4, first.ID); apistatus == nil || apistatus.GetStatus() != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422 for a non-member, got %v", apistatus)
+	}
+	other, _ := service.CreateChat(ctx, 1, 2)
+	if _, apistatus := service.MarkChatRead(ctx, other.ID, 2, first.ID); apistatus == nil || apistatus.GetStatus() != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422 for a message of another chat, got %v", apistatus)
+	}
+	if _, apistatus := service.MarkChatRead(ctx, chat.ID, 2, 999); apistatus == nil || apistatus.GetStatus() != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown message, got %v", apistatus)
+	}
+}
+
+// TestUpdateMessageStatus_Transitions tests that only allowed status changes
+// are applied and that each one is recorded.
+func TestUpdateMessageStatus_Transitions(t *testing.T) {
+	msgRepo := repository.NewInMemoryMessageRepository()
+	service := NewMessageService(msgRepo, repository.NewInMemoryChatRepository(msgRepo), repository.NewInMemoryUserRepository(), nil, 0)
+	ctx := context.Background()
+	chat, _ := service.CreateChat(ctx, 1, 2)
+	msg, _ := service.SendMessage(ctx, chat.ID, 1, "Hello")
+
+	steps := []struct {
+		status   domain.MessageStatus
+		expected int
+	}{
+		{domain.MessageStatusRead, http.StatusConflict},
+		{domain.MessageStatusFailed, 0},
+		{domain.MessageStatusDelivered, http.StatusConflict},
+		{domain.MessageStatusSent, 0},
+		{domain.MessageStatusDelivered, 0},
+		{domain.MessageStatusDelivered, 0},
+		{domain.MessageStatusRead, 0},
+		{domain.MessageStatusSent, http.StatusConflict},
+		{"lost", http.StatusUnprocessableEntity},
+	}
+	for i, step := range steps {
+		apistatus := service.UpdateMessageStatus(ctx, msg.ID, step.status)
+		got := 0
+		if apistatus != nil {
+			got = apistatus.GetStatus()
+		}
+		if got != step.expected {
+			t.Errorf("step %d (%s): expected %d, got %d", i, step.status, step.expected, got)
+		}
+	}
+
+	// Repeating a status is not a transition.
+	history, apistatus := service.GetStatusHistory(ctx, msg.ID)
+	if apistatus != nil {
+		t.Fatalf("GetStatusHistory failed: %s", apistatus.GetMessage())
+	}
+	var path []domain.MessageStatus
+	for _, transition := range history {
+		path = append(path, transition.To)
+	}
+	if fmt.Sprint(path) != "[failed sent delivered read]" {
+		t.Errorf("unexpected history %v", path)
+	}
+}
+
+// TestEditMessage tests that senders can edit their messages within the edit
+// window and that every edit is recorded and pushed to the chat.
+func TestEditMessage(t *testing.T) {
+	msgRepo := repository.NewInMemoryMessageRepository()
+	hub := realtime.NewHub(4)
+	service := NewMessageService(msgRepo, repository.NewInMemoryChatRepository(msgRepo), repository.NewInMemoryUserRepository(), hub, time.Minute)
+	ctx := context.Background()
+	chat, _ := service.CreateChat(ctx, 1, 2)
+	msg, _ := service.SendMessage(ctx, chat.ID, 1, "helo")
+	recipient := hub.Subscribe(2)
+	defer recipient.Close()
+
+	edited, apistatus := service.EditMessage(ctx, msg.ID, 1, "hello")
+	if apistatus != nil {
+		t.Fatalf("EditMessage failed: %s", apistatus.GetMessage())
+	}
+	if edited.Content != "hello" || !edited.IsEdited() {
+		t.Errorf("unexpected edited message %+v", edited)
+	}
+	// Saving the same content again is not an edit.
+	if _, apistatus := service.EditMessage(ctx, msg.ID, 1, "hello"); apistatus != nil {
+		t.Fatalf("EditMessage failed: %s", apistatus.GetMessage())
+	}
+	revisions, _ := service.GetMessageRevisions(ctx, msg.ID)
+	if len(revisions) != 1 || revisions[0].Content != "helo" {
+		t.Errorf("expected the original content as the only revision, got %+v", revisions)
+	}
+	select {
+	case event := <-recipient.Events():
+		if event.Type != realtime.EventMessageEdited || event.ChatID != chat.ID {
+			t.Errorf("expected %s event for chat %d, got %+v", realtime.EventMessageEdited, chat.ID, event)
+		}
+	default:
+		t.Fatal("expected a message.edited event")
+	}
+	entries, _ := msgRepo.GetPendingOutboxEntries(ctx, time.Now(), 10)
+	var recorded int
+	for _, entry := range entries {
+		if entry.EventType == domain.EventTypeMessageEdited && entry.AggregateID == msg.ID {
+			recorded++
+		}
+	}
+	if recorded != 1 {
+		t.Errorf("expected 1 message.edited event, got %d", recorded)
+	}
+
+	old, _ := msgRepo.CreateMessage(ctx, &domain.Message{ChatID: chat.ID, SenderID: 1, Content: "old", Timestamp: time.Now().Add(-time.Hour), Status: domain.MessageStatusSent})
+	tests := []struct {
+		name      string
+		messageID int64
+		senderID  int64
+		content   string
+		expected  int
+	}{
+		{"not the sender", msg.ID, 2, "hijacked", http.StatusForbidden},
+		{"window passed", old.ID, 1, "new", http.StatusForbidden},
+		{"empty content", msg.ID, 1, "  ", http.StatusUnprocessableEntity},
+		{"unknown message", 999, 1, "hello", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if _, apistatus := service.EditMessage(ctx, tt.messageID, tt.senderID, tt.content); apistatus == nil || apistatus.GetStatus() != tt.expected {
+			t.Errorf("%s: expected status %d, got %v", tt.name, tt.expected, apistatus)
+		}
+	}
+
+	// Without a window messages stay editable.
+	unlimited := NewMessageService(msgRepo, repository.NewInMemoryChatRepository(msgRepo), repository.NewInMemoryUserRepository(), nil, 0)
+	if _, apistatus := unlimited.EditMessage(ctx, old.ID, 1, "new"); apistatus != nil {
+		t.Errorf("expected the edit to succeed without a window, got %s", apistatus.GetMessage())
+	}
+}
+
+// TestDeleteMessage tests deleting a message for one user and for everyone.
+func TestDeleteMessage(t *testing.T) {
+	msgRepo := repository.NewInMemoryMessageRepository()
+	hub := realtime.NewHub(4)
+	service := NewMessageService(msgRepo, repository.NewInMemoryChatRepository(msgRepo), repository.NewInMemoryUserRepository(), hub, 0)
+	ctx := context.Background()
+	chat, _ := service.CreateGroupChat(ctx, "Team", []int64{1, 2, 3})
+	first, _ := service.SendMessage(ctx, chat.ID, 1, "one")
+	second, _ := service.SendMessage(ctx, chat.ID, 1, "two")
+	deleter := hub.Subscribe(2)
+	defer deleter.Close()
+	other := hub.Subscribe(3)
+	defer other.Close()
+
+	// User 2 deletes the first message for themselves only.
+	if apistatus := service.DeleteMessage(ctx, first.ID, 2, domain.DeleteForMe); apistatus != nil {
+		t.Fatalf("DeleteMessage failed: %s", apistatus.GetMessage())
+	}
+	page, _ := service.GetMessages(ctx, chat.ID, domain.MessageQuery{UserID: 2})
+	if len(page.Messages) != 1 || page.Messages[0].ID != second.ID {
+		t.Errorf("expected only message %d for user 2, got %+v", second.ID, page.Messages)
+	}
+	if page, _ := service.GetMessages(ctx, chat.ID, domain.MessageQuery{UserID: 3}); len(page.Messages) != 2 {
+		t.Errorf("expected both messages for user 3, got %d", len(page.Messages))
+	}
+	select {
+	case event := <-other.Events():
+		t.Errorf("expected no event for user 3, got %+v", event)
+	case event := <-deleter.Events():
+		if event.Type != realtime.EventMessageDeleted {
+			t.Errorf("expected %s event, got %+v", realtime.EventMessageDeleted, event)
+		}
+	}
+
+	// Only the sender can delete for everyone.
+	if apistatus := service.DeleteMessage(ctx, second.ID, 2, domain.DeleteForEveryone); apistatus == nil || apistatus.GetStatus() != http.StatusForbidden {
+		t.Errorf("expected 403 for someone else's message, got %v", apistatus)
+	}
+	if apistatus := service.DeleteMessage(ctx, second.ID, 1, domain.DeleteForEveryone); apistatus != nil {
+		t.Fatalf("DeleteMessage failed: %s", apistatus.GetMessage())
+	}
+	// Deleting a tombstone again is a no-op.
+	if apistatus := service.DeleteMessage(ctx, second.ID, 1, domain.DeleteForEveryone); apistatus != nil {
+		t.Fatalf("DeleteMessage failed: %s", apistatus.GetMessage())
+	}
+	msg, _ := msgRepo.GetMessageByID(ctx, second.ID)
+	if !msg.IsDeleted() || msg.Content != "" {
+		t.Errorf("expected a tombstone, got %+v", msg)
+	}
+	if event := <-other.Events(); event.Type != realtime.EventMessageDeleted {
+		t.Errorf("expected %s event for user 3, got %+v", realtime.EventMessageDeleted, event)
+	}
+	if _, apistatus := service.EditMessage(ctx, second.ID, 1, "three"); apistatus == nil || apistatus.GetStatus() != http.StatusConflict {
+		t.Errorf("expected 409 when editing a deleted message, got %v", apistatus)
+	}
+
+	entries, _ := msgRepo.GetPendingOutboxEntries(ctx, time.Now(), 20)
+	var scopes []domain.DeletionScope
+	for _, entry := range entries {
+		if entry.EventType != domain.EventTypeMessageDeleted {
+			continue
+		}
+		var event domain.Event
+		var deleted domain.MessageDeletedEvent
+		if err := json.Unmarshal(entry.Payload, &event); err != nil || event.DecodePayload(&deleted) != nil {
+			t.Fatalf("failed to decode %s", entry.Payload)
+		}
+		scopes = append(scopes, deleted.Scope)
+	}
+	if fmt.Sprint(scopes) != "[me everyone]" {
+		t.Errorf("expected message.deleted events for [me everyone], got %v", scopes)
+	}
+
+	if apistatus := service.DeleteMessage(ctx, first.ID, 4, domain.DeleteForMe); apistatus == nil || apistatus.GetStatus() != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422 for a non-member, got %v", apistatus)
+	}
+	if apistatus := service.DeleteMessage(ctx, first.ID, 2, "nobody"); apistatus == nil || apistatus.GetStatus() != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422 for an unknown scope, got %v", apistatus)
+	}
+	if apistatus := service.DeleteMessage(ctx, 999, 2, domain.DeleteForMe); apistatus == nil || apistatus.GetStatus() != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown message, got %v", apistatus)
+	}
+}
+
+// TestSendReply checks that replies stay within their chat and show up in
+// threads and reply counts.
+func TestSendReply(t *testing.T) {
+	msgRepo := repository.NewInMemoryMessageRepository()
+	service := NewMessageService(msgRepo, repository.NewInMemoryChatRepository(msgRepo), repository.NewInMemoryUserRepository(), nil, 0)
+	ctx := context.Background()
+	chat, _ := service.CreateChat(ctx, 1, 2)
+	other, _ := service.CreateChat(ctx, 1, 3)
+	root, _ := service.SendMessage(ctx, chat.ID, 1, "Lunch?")
+	elsewhere, _ := service.SendMessage(ctx, other.ID, 1, "Hi")
+
+	reply, apistatus := service.SendReply(ctx, chat.ID, 2, root.ID, "Sure")
+	if apistatus != nil {
+		t.Fatalf("SendReply failed: %s", apistatus.GetMessage())
+	}
+	if reply.ReplyToMessageID != root.ID {
+		t.Errorf("expected a reply to message %d, got %+v", root.ID, reply)
+	}
+	if _, apistatus := service.SendReply(ctx, chat.ID, 1, reply.ID, "Noon"); apistatus != nil {
+		t.Fatalf("SendReply failed: %s", apistatus.GetMessage())
+	}
+
+	for _, replyTo := range []int64{elsewhere.ID, 999, -1} {
+		if _, apistatus := service.SendReply(ctx, chat.ID, 2, replyTo, "Sure"); apistatus == nil || apistatus.GetStatus() != http.StatusUnprocessableEntity {
+			t.Errorf("expected unprocessable entity replying to message %d, got %v", replyTo, apistatus)
+		}
+	}
+
+	thread, apistatus := service.GetThread(ctx, root.ID, 0)
+	if apistatus != nil {
+		t.Fatalf("GetThread failed: %s", apistatus.GetMessage())
+	}
+	if len(thread) != 3 || thread[0].ID != root.ID || thread[0].ReplyCount != 1 || thread[1].ReplyCount != 1 {
+		t.Errorf("expected the root and both replies, got %+v", thread)
+	}
+	page, _ := service.GetMessages(ctx, chat.ID, domain.MessageQuery{})
+	if len(page.Messages) != 3 || page.Messages[0].ReplyCount != 1 {
+		t.Errorf("expected the history to carry reply counts, got %+v", page.Messages)
+	}
+	if _, apistatus := service.GetThread(ctx, 999, 0); apistatus == nil || apistatus.GetStatus() != http.StatusNotFound {
+		t.Errorf("expected not found for a non-existent message, got %v", apistatus)
+	}
+}
+
+// TestReactions tests adding, replacing and removing reactions.
+func TestReactions(t *testing.T) {
+	msgRepo := repository.NewInMemoryMessageRepository()
+	hub := realtime.NewHub(8)
+	service := NewMessageService(msgRepo, repository.NewInMemoryChatRepository(msgRepo), repository.NewInMemoryUserRepository(), hub, 0)
+	ctx := context.Background()
+	chat, _ := service.CreateGroupChat(ctx, "Team", []int64{1, 2, 3})
+	msg, _ := service.SendMessage(ctx, chat.ID, 1, "Ship it?")
+	member := hub.Subscribe(3)
+	defer member.Close()
+
+	if _, apistatus := service.ReactToMessage(ctx, msg.ID, 2, "👍"); apistatus != nil {
+		t.Fatalf("ReactToMessage failed: %s", apistatus.GetMessage())
+	}
+	reacted, apistatus := service.ReactToMessage(ctx, msg.ID, 3, "👍")
+	if apistatus != nil {
+		t.Fatalf("ReactToMessage failed: %s", apistatus.GetMessage())
+	}
+	if len(reacted.Reactions) != 1 || reacted.Reactions[0].Count != 2 {
+		t.Errorf("expected two 👍 reactions, got %+v", reacted.Reactions)
+	}
+	// Reacting with the same emoji again changes nothing.
+	if _, apistatus := service.ReactToMessage(ctx, msg.ID, 3, "👍"); apistatus != nil {
+		t.Fatalf("ReactToMessage failed: %s", apistatus.GetMessage())
+	}
+	reacted, _ = service.ReactToMessage(ctx, msg.ID, 3, "🚀")
+	if fmt.Sprint(reacted.Reactions) != "[{👍 1 [2]} {🚀 1 [3]}]" {
+		t.Errorf("expected user 3 to have switched to 🚀, got %+v", reacted.Reactions)
+	}
+	if apistatus := service.RemoveReaction(ctx, msg.ID, 2); apistatus != nil {
+		t.Fatalf("RemoveReaction failed: %s", apistatus.GetMessage())
+	}
+	page, _ := service.GetMessages(ctx, chat.ID, domain.MessageQuery{})
+	if fmt.Sprint(page.Messages[0].Reactions) != "[{🚀 1 [3]}]" {
+		t.Errorf("expected only the 🚀 reaction in the history, got %+v", page.Messages[0].Reactions)
+	}
+
+	var types []string
+	for i := 0; i < 4; i++ {
+		types = append(types, (<-member.Events()).Type)
+	}
+	if fmt.Sprint(types) != "[message.reaction_added message.reaction_added message.reaction_added message.reaction_removed]" {
+		t.Errorf("unexpected events %v", types)
+	}
+	entries, _ := msgRepo.GetPendingOutboxEntries(ctx, time.Now(), 20)
+	var recorded []string
+	for _, entry := range entries {
+		if entry.EventType != domain.EventTypeReactionAdded && entry.EventType != domain.EventTypeReactionRemoved {
+			continue
+		}
+		var event domain.Event
+		var reaction domain.MessageReactionEvent
+		if err := json.Unmarshal(entry.Payload, &event); err != nil || event.DecodePayload(&reaction) != nil {
+			t.Fatalf("failed to decode %s", entry.Payload)
+		}
+		recorded = append(recorded, reaction.Emoji+reaction.Replaced)
+	}
+	if fmt.Sprint(recorded) != "[👍 👍 🚀👍 👍]" {
+		t.Errorf("unexpected reaction events %v", recorded)
+	}
+
+	if _, apistatus := service.ReactToMessage(ctx, msg.ID, 4, "👍"); apistatus == nil || apistatus.GetStatus() != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422 for a non-member, got %v", apistatus)
+	}
+	if _, apistatus := service.ReactToMessage(ctx, msg.ID, 2, "thumbs up"); apistatus == nil || apistatus.GetStatus() != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422 for an invalid reaction, got %v", apistatus)
+	}
+	if apistatus := service.RemoveReaction(ctx, msg.ID, 2); apistatus == nil || apistatus.GetStatus() != http.StatusNotFound {
+		t.Errorf("expected 404 for a missing reaction, got %v", apistatus)
+	}
+	if apistatus := service.DeleteMessage(ctx, msg.ID, 1, domain.DeleteForEveryone); apistatus != nil {
+		t.Fatalf("DeleteMessage failed: %s", apistatus.GetMessage())
+	}
+	if _, apistatus := service.ReactToMessage(ctx, msg.ID, 2, "👍"); apistatus == nil || apistatus.GetStatus() != http.StatusConflict {
+		t.Errorf("expected 409 for a deleted message, got %v", apistatus)
+	}
+}
@@ -0,0 +1,117 @@
+package application
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"messaging-app/domain"
+	"messaging-app/infrastructure/blob"
+	"messaging-app/infrastructure/mq"
+	"messaging-app/infrastructure/repository"
+	"messaging-app/pkg/thumbnail"
+)
+
+// ThumbnailWorker consumes attachment events from the queue and generates a
+// thumbnail for each uploaded JPEG or PNG image. The thumbnail is stored in
+// the blob store next to the image and recorded on the attachment, which
+// then describes it wherever the attachment is returned.
+type ThumbnailWorker struct {
+	messageRepo repository.MessageRepository
+	store       blob.Store
+	maxSize     int
+}
+
+// NewThumbnailWorker creates a worker scaling images down to fit in a
+// maxSize square.
+func NewThumbnailWorker(messageRepo repository.MessageRepository, store blob.Store, maxSize int) *ThumbnailWorker {
+	return &ThumbnailWorker{
+		messageRepo: messageRepo,
+		store:       store,
+		maxSize:     maxSize,
+	}
+}
+
+// HandleEvent generates the thumbnail of the attachment of a single
+// attachment.uploaded event; other event types are acknowledged and ignored.
+//
+// Images that cannot be decoded or are too large get no thumbnail, and the
+// event is acknowledged since retrying would not help. Failures to read or
+// store blobs are returned so the event is retried.
+func (w *ThumbnailWorker) HandleEvent(ctx context.Context, body []byte) error {
+	var envelope domain.Event
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return err
+	}
+	if envelope.Type != domain.EventTypeAttachmentUploaded {
+		return nil
+	}
+	if envelope.SchemaVersion > domain.EventSchemaVersion {
+		log.Printf("skipping %s event %s with unsupported schema version %d", envelope.Type, envelope.ID, envelope.SchemaVersion)
+		return nil
+	}
+	var event domain.AttachmentUploadedEvent
+	if err := envelope.DecodePayload(&event); err != nil {
+		return err
+	}
+	if !domain.HasThumbnail(event.ContentType) {
+		return nil
+	}
+	attachment, as := w.messageRepo.GetAttachment(ctx, event.AttachmentID)
+	if as != nil {
+		if as.GetStatus() == http.StatusNotFound {
+			return nil
+		}
+		return errors.New(as.GetMessage())
+	}
+	// Events can be delivered more than once.
+	if attachment.Thumbnail != nil {
+		return nil
+	}
+
+	content, err := w.store.Open(ctx, attachment.StorageKey)
+	if errors.Is(err, blob.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	var scaled bytes.Buffer
+	contentType, size, err := thumbnail.Generate(&scaled, content, w.maxSize)
+	if err != nil {
+		log.Printf("no thumbnail for attachment %d: %v", attachment.ID, err)
+		return nil
+	}
+	n, err := w.store.Put(ctx, attachment.ThumbnailKey(), &scaled)
+	if err != nil {
+		return err
+	}
+	as = w.messageRepo.SetAttachmentThumbnail(ctx, attachment.ID, &domain.Thumbnail{
+		Width:       size.X,
+		Height:      size.Y,
+		ContentType: contentType,
+		Size:        n,
+	})
+	if as != nil {
+		return errors.New(as.GetMessage())
+	}
+	return nil
+}
+
+// HandleEvents returns a handler passing each event to handlers in turn,
+// stopping at the first error. Since every worker ignores the event types it
+// does not process, one queue can feed several of them.
+func HandleEvents(handlers ...mq.Handler) mq.Handler {
+	return func(ctx context.Context, body []byte) error {
+		for _, handle := range handlers {
+			if err := handle(ctx, body); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
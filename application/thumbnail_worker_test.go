@@ -0,0 +1,142 @@
+package application
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"messaging-app/domain"
+	"messaging-app/infrastructure/blob"
+	"messaging-app/infrastructure/repository"
+)
+
+// setupThumbnailTest creates an attachment service with a chat between users
+// 1 and 2, and a worker sharing its repository and blob store.
+func setupThumbnailTest(t *testing.T) (AttachmentService, repository.MessageRepository, *ThumbnailWorker, int64) {
+	t.Helper()
+	store, err := blob.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStore failed: %v", err)
+	}
+	msgRepo := repository.NewInMemoryMessageRepository()
+	chatRepo := repository.NewInMemoryChatRepository(msgRepo)
+	messages := NewMessageService(msgRepo, chatRepo, repository.NewInMemoryUserRepository(), nil, 0)
+	chat, apistatus := messages.CreateChat(context.Background(), 1, 2)
+	if apistatus != nil {
+		t.Fatalf("CreateChat failed: %s", apistatus.GetMessage())
+	}
+	service := NewAttachmentService(msgRepo, chatRepo, store, 1<<20, []byte("secret"), time.Hour)
+	return service, msgRepo, NewThumbnailWorker(msgRepo, store, 64), chat.ID
+}
+
+// uploadedEvents returns the bodies of the pending attachment.uploaded events.
+func uploadedEvents(t *testing.T, repo repository.MessageRepository) [][]byte {
+	t.Helper()
+	entries, apistatus := repo.GetPendingOutboxEntries(context.Background(), time.Now(), 100)
+	if apistatus != nil {
+		t.Fatalf("GetPendingOutboxEntries failed: %s", apistatus.GetMessage())
+	}
+	var bodies [][]byte
+	for _, entry := range entries {
+		if entry.EventType == domain.EventTypeAttachmentUploaded {
+			bodies = append(bodies, entry.Payload)
+		}
+	}
+	return bodies
+}
+
+// TestThumbnailWorker tests that uploaded images get a thumbnail that can be
+// downloaded through the signed URL of the attachment.
+func TestThumbnailWorker(t *testing.T) {
+	service, msgRepo, worker, chatID := setupThumbnailTest(t)
+	ctx := context.Background()
+	var photo bytes.Buffer
+	png.Encode(&photo, image.NewRGBA(image.Rect(0, 0, 200, 100)))
+	attachment, apistatus := service.Upload(ctx, chatID, 1, "photo.png", &photo)
+	if apistatus != nil {
+		t.Fatalf("Upload failed: %s", apistatus.GetMessage())
+	}
+	if attachment.Thumbnail != nil {
+		t.Fatalf("expected no thumbnail before the worker ran, got %+v", attachment.Thumbnail)
+	}
+
+	events := uploadedEvents(t, msgRepo)
+	if len(events) != 1 {
+		t.Fatalf("expected one attachment.uploaded event, got %d", len(events))
+	}
+	// Handling the event again keeps the first thumbnail.
+	for i := 0; i < 2; i++ {
+		if err := worker.HandleEvent(ctx, events[0]); err != nil {
+			t.Fatalf("HandleEvent failed: %v", err)
+		}
+	}
+
+	described, apistatus := service.GetAttachment(ctx, attachment.ID, 1)
+	if apistatus != nil {
+		t.Fatalf("GetAttachment failed: %s", apistatus.GetMessage())
+	}
+	thumbnail := described.Thumbnail
+	if thumbnail == nil || thumbnail.Width != 64 || thumbnail.Height != 32 || thumbnail.ContentType != domain.AttachmentTypePNG || thumbnail.URL == "" {
+		t.Fatalf("expected a 64x32 PNG thumbnail with a URL, got %+v", thumbnail)
+	}
+	body, status := openURL(t, service, thumbnail.URL)
+	if status != http.StatusOK {
+		t.Fatalf("expected the thumbnail to download, got %d", status)
+	}
+	if decoded, err := png.Decode(strings.NewReader(body)); err != nil || decoded.Bounds().Dx() != 64 || int64(len(body)) != thumbnail.Size {
+		t.Errorf("expected the %d byte thumbnail 64 pixels wide, got %d bytes, %v", thumbnail.Size, len(body), err)
+	}
+	if _, status := openURL(t, service, strings.Replace(thumbnail.URL, "userId=1", "userId=2", 1)); status != http.StatusForbidden {
+		t.Errorf("expected 403 for a URL signed for someone else, got %d", status)
+	}
+}
+
+// TestThumbnailWorker_Skips tests that files without a thumbnail leave the
+// attachment as it is and are acknowledged.
+func TestThumbnailWorker_Skips(t *testing.T) {
+	service, msgRepo, worker, chatID := setupThumbnailTest(t)
+	ctx := context.Background()
+	pdf, _ := service.Upload(ctx, chatID, 1, "plan.pdf", strings.NewReader("%PDF-1.7\n"))
+	broken, _ := service.Upload(ctx, chatID, 1, "broken.png", strings.NewReader(pngContent))
+	for _, body := range uploadedEvents(t, msgRepo) {
+		if err := worker.HandleEvent(ctx, body); err != nil {
+			t.Errorf("HandleEvent failed: %v", err)
+		}
+	}
+	for _, attachment := range []*domain.Attachment{pdf, broken} {
+		if stored, _ := msgRepo.GetAttachment(ctx, attachment.ID); stored.Thumbnail != nil {
+			t.Errorf("expected no thumbnail for %s, got %+v", attachment.FileName, stored.Thumbnail)
+		}
+		if _, status := openURL(t, service, strings.Replace(attachment.URL, "/content?", "/thumbnail?", 1)); status != http.StatusNotFound {
+			t.Errorf("expected 404 for the thumbnail of %s, got %d", attachment.FileName, status)
+		}
+	}
+	if err := worker.HandleEvent(ctx, []byte(`{"type":"message.sent","payload":{}}`)); err != nil {
+		t.Errorf("expected other events to be ignored, got %v", err)
+	}
+}
+
+// TestHandleEvents tests that every handler sees the event until one fails.
+func TestHandleEvents(t *testing.T) {
+	var calls []string
+	handler := func(name string, err error) func(context.Context, []byte) error {
+		return func(ctx context.Context, body []byte) error {
+			calls = append(calls, name)
+			return err
+		}
+	}
+	failure := errors.New("failed")
+	if err := HandleEvents(handler("a", nil), handler("b", nil))(context.Background(), nil); err != nil || len(calls) != 2 {
+		t.Errorf("expected both handlers to run, got %v, %v", calls, err)
+	}
+	calls = nil
+	if err := HandleEvents(handler("a", failure), handler("b", nil))(context.Background(), nil); err != failure || len(calls) != 1 {
+		t.Errorf("expected to stop at the failure, got %v, %v", calls, err)
+	}
+}
@@ -0,0 +1,70 @@
+package application
+
+import (
+	"context"
+	"strings"
+
+	"messaging-app/domain"
+	"messaging-app/infrastructure/repository"
+	"messaging-app/pkg/apistatus"
+)
+
+type UserService interface {
+	CreateUser(ctx context.Context, name string) (*domain.User, apistatus.Status)
+	GetUser(ctx context.Context, userID int64) (*domain.User, apistatus.Status)
+	ListUsers(ctx context.Context) ([]*domain.User, apistatus.Status)
+	UpdateUser(ctx context.Context, userID int64, name string) (*domain.User, apistatus.Status)
+	DeleteUser(ctx context.Context, userID int64) apistatus.Status
+}
+
+type userService struct {
+	userRepo repository.UserRepository
+}
+
+func NewUserService(userRepo repository.UserRepository) UserService {
+	return &userService{userRepo: userRepo}
+}
+
+func (s *userService) CreateUser(ctx context.Context, name string) (*domain.User, apistatus.Status) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, apistatus.New("name is required").UnprocessableEntity()
+	}
+	return s.userRepo.CreateUser(ctx, &domain.User{Name: name})
+}
+
+func (s *userService) GetUser(ctx context.Context, userID int64) (*domain.User, apistatus.Status) {
+	if userID <= 0 {
+		return nil, apistatus.New("invalid userID").UnprocessableEntity()
+	}
+	return s.userRepo.GetUserByID(ctx, userID)
+}
+
+func (s *userService) ListUsers(ctx context.Context) ([]*domain.User, apistatus.Status) {
+	return s.userRepo.ListUsers(ctx)
+}
+
+func (s *userService) UpdateUser(ctx context.Context, userID int64, name string) (*domain.User, apistatus.Status) {
+	if userID <= 0 {
+		return nil, apistatus.New("invalid userID").UnprocessableEntity()
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, apistatus.New("name is required").UnprocessableEntity()
+	}
+	// Make sure the user exists before replacing it.
+	user, as := s.userRepo.GetUserByID(ctx, userID)
+	if as != nil {
+		return nil, as
+	}
+	updated := *user
+	updated.Name = name
+	return s.userRepo.UpdateUser(ctx, &updated)
+}
+
+func (s *userService) DeleteUser(ctx context.Context, userID int64) apistatus.Status {
+	if userID <= 0 {
+		return apistatus.New("invalid userID").UnprocessableEntity()
+	}
+	return s.userRepo.DeleteUser(ctx, userID)
+}
@@ -0,0 +1,56 @@
+package application
+
+import (
+	"context"
+	"testing"
+
+	"messaging-app/infrastructure/repository"
+)
+
+// TestCreatedUserCanChat tests that a user created through the service can take part in chats.
+func TestCreatedUserCanChat(t *testing.T) {
+	userRepo := repository.NewInMemoryUserRepository()
+	userService := NewUserService(userRepo)
+	msgRepo := repository.NewInMemoryMessageRepository()
+	msgService := NewMessageService(msgRepo, repository.NewInMemoryChatRepository(msgRepo), userRepo, nil, 0)
+	ctx := context.Background()
+
+	user, apistatus := userService.CreateUser(ctx, "Ayo")
+	if apistatus != nil {
+		t.Fatalf("CreateUser failed: %s", apistatus.GetMessage())
+	}
+
+	chat, apistatus := msgService.CreateChat(ctx, 1, user.ID)
+	if apistatus != nil {
+		t.Fatalf("CreateChat failed: %s", apistatus.GetMessage())
+	}
+	if _, apistatus := msgService.SendMessage(ctx, chat.ID, user.ID, "Hi Red"); apistatus != nil {
+		t.Fatalf("SendMessage failed: %s", apistatus.GetMessage())
+	}
+
+	// Once deleted, the user can no longer send messages.
+	if apistatus := userService.DeleteUser(ctx, user.ID); apistatus != nil {
+		t.Fatalf("DeleteUser failed: %s", apistatus.GetMessage())
+	}
+	_, apistatus = msgService.SendMessage(ctx, chat.ID, user.ID, "Still here?")
+	if apistatus == nil {
+		t.Fatal("expected error when a deleted user sends a message, got nil")
+	}
+	expected := "unprocessable entity: invalid sender"
+	if apistatus.GetMessage() != expected {
+		t.Errorf("expected error %q, got %q", expected, apistatus.GetMessage())
+	}
+}
+
+// TestCreateUser_EmptyName tests that a blank name is rejected.
+func TestCreateUser_EmptyName(t *testing.T) {
+	service := NewUserService(repository.NewInMemoryUserRepository())
+	_, apistatus := service.CreateUser(context.Background(), "   ")
+	if apistatus == nil {
+		t.Fatal("expected error for blank name, got nil")
+	}
+	expected := "unprocessable entity: name is required"
+	if apistatus.GetMessage() != expected {
+		t.Errorf("expected error %q, got %q", expected, apistatus.GetMessage())
+	}
+}
@@ -1,18 +1,56 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
+	"os"
 	"time"
+
+	"messaging-app/application"
 )
 
 func main() {
+	// "messaging-service migrate" only applies the database migrations.
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(); err != nil {
+			log.Fatalf("migration failed: %v", err)
+		}
+		return
+	}
+
 	// Initialize the application using Wire.
 	app, err := InitializeApp()
 	if err != nil {
 		log.Fatalf("failed to initialize app: %v", err)
 	}
 	defer app.RabbitMQ.Close()
+	if app.DB != nil {
+		defer app.DB.Close()
+	}
+	if app.Journal != nil {
+		defer app.Journal.Close()
+	}
+
+	// Run the outbox relay, event workers and dead-letter collector in-process until the server stops.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go app.OutboxRelay.Run(ctx)
+	if app.Journal != nil {
+		go app.Journal.Run(ctx, time.Duration(app.Config.JournalSnapshotMs)*time.Millisecond)
+	}
+	go func() {
+		// Both workers share the service queue; each ignores the events of the other.
+		handler := application.HandleEvents(app.DeliveryWorker.HandleEvent, app.ThumbnailWorker.HandleEvent)
+		if err := app.RabbitMQ.Consume(ctx, handler); err != nil {
+			log.Printf("event workers stopped: %v", err)
+		}
+	}()
+	go func() {
+		if err := app.DeadLetters.CollectDeadLetters(ctx); err != nil {
+			log.Printf("dead-letter collector stopped: %v", err)
+		}
+	}()
 
 	srv := &http.Server{
 		Addr:         ":" + app.Config.HTTPPort,
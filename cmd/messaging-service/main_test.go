@@ -9,6 +9,9 @@ func TestInitializeApp(t *testing.T) {
 	// Enable test mode.
 	os.Setenv("TEST_MODE", "true")
 	defer os.Unsetenv("TEST_MODE")
+	// Keep attachment blobs out of the source tree.
+	os.Setenv("BLOB_DIR", t.TempDir())
+	defer os.Unsetenv("BLOB_DIR")
 
 	app, err := InitializeApp()
 	if err != nil {
@@ -23,5 +26,17 @@ func TestInitializeApp(t *testing.T) {
 	if app.RabbitMQ == nil {
 		t.Fatal("app.RabbitMQ is nil")
 	}
+	if app.DeliveryWorker == nil {
+		t.Fatal("app.DeliveryWorker is nil")
+	}
+	if app.OutboxRelay == nil {
+		t.Fatal("app.OutboxRelay is nil")
+	}
+	if app.ThumbnailWorker == nil {
+		t.Fatal("app.ThumbnailWorker is nil")
+	}
+	if app.DeadLetters == nil {
+		t.Fatal("app.DeadLetters is nil")
+	}
 	app.RabbitMQ.Close()
 }
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"messaging-app/config"
+	"messaging-app/infrastructure/repository"
+)
+
+// runMigrate applies pending migrations to the database selected by STORAGE
+// and exits, for deployments that run with MIGRATE_ON_START=false.
+func runMigrate() error {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	db, err := openDatabase(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	if db == nil {
+		return errors.New("STORAGE=memory has no schema to migrate")
+	}
+	defer db.Close()
+
+	applied, err := repository.Migrate(ctx, db, repository.Dialect(cfg.Storage))
+	for _, m := range applied {
+		log.Printf("Applied migration %s", m.Name)
+	}
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		log.Printf("Database is up to date")
+	}
+	return nil
+}
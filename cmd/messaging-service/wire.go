@@ -4,7 +4,11 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"database/sql"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
 	"time"
@@ -15,37 +19,59 @@ import (
 	"messaging-app/application"
 	"messaging-app/config"
 	"messaging-app/infrastructure/api"
+	"messaging-app/infrastructure/blob"
 	"messaging-app/infrastructure/mq"
+	"messaging-app/infrastructure/realtime"
 	"messaging-app/infrastructure/repository"
 )
 
 // App aggregates the dependencies needed to run the application.
 type App struct {
-	Config   *config.Config
-	Router   http.Handler
-	RabbitMQ mq.RabbitMQInterface
+	Config          *config.Config
+	Router          http.Handler
+	DB              *sql.DB
+	Journal         *repository.Journal
+	RabbitMQ        mq.RabbitMQInterface
+	DeliveryWorker  *application.DeliveryWorker
+	ThumbnailWorker *application.ThumbnailWorker
+	OutboxRelay     *application.OutboxRelay
+	DeadLetters     application.DeadLetterService
 }
 
 // NewApp is a constructor for App that requires configuration.
-func NewApp(cfg *config.Config, router http.Handler, rabbitMQ mq.RabbitMQInterface) *App {
+func NewApp(cfg *config.Config, router http.Handler, db *sql.DB, journal *repository.Journal, rabbitMQ mq.RabbitMQInterface, deliveryWorker *application.DeliveryWorker, thumbnailWorker *application.ThumbnailWorker, outboxRelay *application.OutboxRelay, deadLetters application.DeadLetterService) *App {
 	return &App{
-		Config:   cfg,
-		Router:   router,
-		RabbitMQ: rabbitMQ,
+		Config:          cfg,
+		Router:          router,
+		DB:              db,
+		Journal:         journal,
+		RabbitMQ:        rabbitMQ,
+		DeliveryWorker:  deliveryWorker,
+		ThumbnailWorker: thumbnailWorker,
+		OutboxRelay:     outboxRelay,
+		DeadLetters:     deadLetters,
 	}
 }
 
-// ProvideRabbitMQ initializes the RabbitMQ connection.
+// ProvideRabbitMQ initializes the message broker: an in-process broker when
+// BROKER=memory (or in test mode), a RabbitMQ connection otherwise.
 func ProvideRabbitMQ(cfg *config.Config) (mq.RabbitMQInterface, error) {
-	// If in test mode, return a dummy implementation.
-	if os.Getenv("TEST_MODE") == "true" {
-		return &dummyRabbitMQ{}, nil
+	if cfg.Broker == config.BrokerMemory || os.Getenv("TEST_MODE") == "true" {
+		return mq.NewInMemoryBroker(topology(cfg)), nil
+	}
+	if cfg.Broker != config.BrokerRabbitMQ {
+		return nil, fmt.Errorf("unknown BROKER %q", cfg.Broker)
 	}
 	var r mq.RabbitMQInterface
 	var err error
 	// Increase to 10 attempts with a 5-second delay between attempts.
 	for i := 0; i < 10; i++ {
-		r, err = mq.NewRabbitMQ(cfg.RabbitMQDSN, cfg.RabbitMQQueue)
+		r, err = mq.NewRabbitMQ(
+			cfg.RabbitMQDSN,
+			topology(cfg),
+			time.Duration(cfg.RabbitMQBackoffMs)*time.Millisecond,
+			time.Duration(cfg.RabbitMQMaxBackoffMs)*time.Millisecond,
+		)
 		if err == nil {
 			return r, nil
 		}
@@ -55,6 +81,180 @@ func ProvideRabbitMQ(cfg *config.Config) (mq.RabbitMQInterface, error) {
 	return nil, err
 }
 
+// topology builds the broker topology from the RabbitMQ settings.
+func topology(cfg *config.Config) mq.Topology {
+	return mq.Topology{
+		Exchange:           cfg.RabbitMQExchange,
+		Queue:              cfg.RabbitMQQueue,
+		BindingKeys:        cfg.RabbitMQBindingKeys,
+		DeadLetterExchange: cfg.RabbitMQDLX,
+		DeadLetterQueue:    cfg.RabbitMQDLQ,
+		MaxRetries:         cfg.RabbitMQMaxRetries,
+		RetryDelay:         time.Duration(cfg.RabbitMQRetryDelayMs) * time.Millisecond,
+	}
+}
+
+// ProvideDatabase opens the SQL database selected by STORAGE and, unless
+// MIGRATE_ON_START is false, applies pending migrations. It returns nil when
+// the in-memory repositories are used.
+func ProvideDatabase(cfg *config.Config) (*sql.DB, error) {
+	ctx := context.Background()
+	db, err := openDatabase(ctx, cfg)
+	if err != nil || db == nil || !cfg.MigrateOnStart {
+		return db, err
+	}
+	if _, err := repository.Migrate(ctx, db, repository.Dialect(cfg.Storage)); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// openDatabase opens the SQL database selected by STORAGE without migrating
+// it, or returns nil for in-memory storage.
+func openDatabase(ctx context.Context, cfg *config.Config) (*sql.DB, error) {
+	switch cfg.Storage {
+	case config.StorageMemory:
+		return nil, nil
+	case config.StorageSQLite:
+		return repository.OpenSQLite(cfg.SQLitePath)
+	case config.StoragePostgres:
+		return repository.OpenPostgres(ctx, cfg.PostgresDSN, repository.PoolConfig{
+			MaxOpenConns:    cfg.DBMaxOpenConns,
+			MaxIdleConns:    cfg.DBMaxIdleConns,
+			ConnMaxLifetime: time.Duration(cfg.DBConnMaxLifetimeMs) * time.Millisecond,
+			ConnMaxIdleTime: time.Duration(cfg.DBConnMaxIdleTimeMs) * time.Millisecond,
+		})
+	}
+	return nil, fmt.Errorf("unknown STORAGE %q", cfg.Storage)
+}
+
+// ProvideJournal restores the in-memory chats and messages from JOURNAL_DIR.
+// It returns nil unless STORAGE=memory and JOURNAL_DIR is set.
+func ProvideJournal(cfg *config.Config) (*repository.Journal, error) {
+	if cfg.Storage != config.StorageMemory || cfg.JournalDir == "" {
+		return nil, nil
+	}
+	return repository.OpenJournal(cfg.JournalDir, cfg.JournalSnapshotEvery, cfg.JournalFsync)
+}
+
+// ProvideUserRepository stores users like ProvideChatRepository stores chats,
+// so chats and messages never outlive the users they reference.
+func ProvideUserRepository(cfg *config.Config, db *sql.DB, journal *repository.Journal) repository.UserRepository {
+	switch {
+	case db != nil:
+		return repository.NewSQLUserRepository(db, repository.Dialect(cfg.Storage))
+	case journal != nil:
+		return journal.UserRepository()
+	}
+	return repository.NewInMemoryUserRepository()
+}
+
+// ProvideChatRepository stores chats in db, in journaled memory when journal
+// is set, or in plain memory otherwise. The SQL storage names double as
+// dialects. Chat events go to the outbox of messageRepo.
+func ProvideChatRepository(cfg *config.Config, db *sql.DB, journal *repository.Journal, messageRepo repository.MessageRepository) repository.ChatRepository {
+	switch {
+	case db != nil:
+		return repository.NewSQLChatRepository(db, repository.Dialect(cfg.Storage))
+	case journal != nil:
+		return journal.ChatRepository()
+	}
+	return repository.NewInMemoryChatRepository(messageRepo)
+}
+
+// ProvideMessageRepository stores messages and their outbox like
+// ProvideChatRepository stores chats.
+func ProvideMessageRepository(cfg *config.Config, db *sql.DB, journal *repository.Journal) repository.MessageRepository {
+	switch {
+	case db != nil:
+		return repository.NewSQLMessageRepository(db, repository.Dialect(cfg.Storage))
+	case journal != nil:
+		return journal.MessageRepository()
+	}
+	return repository.NewInMemoryMessageRepository()
+}
+
+// ProvideDeadLetterRepository stores collected dead letters in the same place
+// as chats, so they survive a restart like the dead-letter queue they were
+// taken from.
+func ProvideDeadLetterRepository(cfg *config.Config, db *sql.DB, journal *repository.Journal) repository.DeadLetterRepository {
+	switch {
+	case db != nil:
+		return repository.NewSQLDeadLetterRepository(db, repository.Dialect(cfg.Storage))
+	case journal != nil:
+		return journal.DeadLetterRepository()
+	}
+	return repository.NewInMemoryDeadLetterRepository()
+}
+
+// ProvideHub creates the hub that pushes chat events to connected clients.
+func ProvideHub(cfg *config.Config) *realtime.Hub {
+	return realtime.NewHub(cfg.WSSendBuffer)
+}
+
+// ProvideMessageService creates the message service with the edit window set
+// by MESSAGE_EDIT_WINDOW_MS.
+func ProvideMessageService(cfg *config.Config, messageRepo repository.MessageRepository, chatRepo repository.ChatRepository, userRepo repository.UserRepository, notifier realtime.Publisher) application.MessageService {
+	editWindow := time.Duration(cfg.MessageEditWindowMs) * time.Millisecond
+	return application.NewMessageService(messageRepo, chatRepo, userRepo, notifier, editWindow)
+}
+
+// ProvideBlobStore creates the store selected by BLOB_STORE that keeps the
+// content of attachments.
+func ProvideBlobStore(cfg *config.Config) (blob.Store, error) {
+	if cfg.BlobStore != config.BlobStoreLocal {
+		return nil, fmt.Errorf("unknown BLOB_STORE %q", cfg.BlobStore)
+	}
+	return blob.NewLocalStore(cfg.BlobDir)
+}
+
+// ProvideAttachmentService creates the attachment service with the limits and
+// download URL settings from the config. Without ATTACHMENT_URL_SECRET a
+// random secret is used, so download URLs stop working on restart.
+func ProvideAttachmentService(cfg *config.Config, messageRepo repository.MessageRepository, chatRepo repository.ChatRepository, store blob.Store) (application.AttachmentService, error) {
+	secret := []byte(cfg.AttachmentURLSecret)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		log.Printf("ATTACHMENT_URL_SECRET is not set; download URLs will not survive a restart")
+	}
+	urlTTL := time.Duration(cfg.AttachmentURLTTLMs) * time.Millisecond
+	return application.NewAttachmentService(messageRepo, chatRepo, store, cfg.AttachmentMaxBytes, secret, urlTTL), nil
+}
+
+// ProvideDeliveryWorker creates the worker that consumes message events and
+// pushes them to connected recipients. Each attempt is a delivery by the
+// broker; LoadConfig rejects more attempts than the broker's retries plus
+// one.
+func ProvideDeliveryWorker(cfg *config.Config, rabbitMQ mq.RabbitMQInterface, service application.MessageService, hub *realtime.Hub) *application.DeliveryWorker {
+	return application.NewDeliveryWorker(rabbitMQ, service, hub, cfg.DeliveryMaxAttempts)
+}
+
+// ProvideThumbnailWorker creates the worker that consumes attachment events
+// and generates thumbnails of images, at most THUMBNAIL_MAX_SIZE pixels wide
+// and high.
+func ProvideThumbnailWorker(cfg *config.Config, messageRepo repository.MessageRepository, store blob.Store) *application.ThumbnailWorker {
+	return application.NewThumbnailWorker(messageRepo, store, cfg.ThumbnailMaxSize)
+}
+
+// ProvideOutboxRelay creates the relay that publishes outbox entries stored
+// alongside messages.
+func ProvideOutboxRelay(cfg *config.Config, messageRepo repository.MessageRepository, rabbitMQ mq.RabbitMQInterface) *application.OutboxRelay {
+	return application.NewOutboxRelay(
+		messageRepo,
+		rabbitMQ,
+		time.Duration(cfg.OutboxPollIntervalMs)*time.Millisecond,
+		cfg.OutboxBatchSize,
+		time.Duration(cfg.OutboxRetryBackoffMs)*time.Millisecond,
+		time.Duration(cfg.OutboxMaxBackoffMs)*time.Millisecond,
+		time.Duration(cfg.OutboxPublishTimeoutMs)*time.Millisecond,
+		time.Duration(cfg.OutboxClaimLeaseMs)*time.Millisecond,
+	)
+}
+
 // InitializeApp sets up and returns an App with all dependencies injected.
 func InitializeApp() (*App, error) {
 	wire.Build(
@@ -62,11 +262,28 @@ func InitializeApp() (*App, error) {
 		config.LoadConfig,
 		// Provide RabbitMQ using the config.
 		ProvideRabbitMQ,
-		// In-memory repository implementations.
-		repository.NewInMemoryMessageRepository,
-		repository.NewInMemoryChatRepository,
-		// Application service.
-		application.NewMessageService,
+		// Users, chats, messages and dead letters in the database selected
+		// by STORAGE.
+		ProvideDatabase,
+		ProvideJournal,
+		ProvideMessageRepository,
+		ProvideChatRepository,
+		ProvideUserRepository,
+		ProvideDeadLetterRepository,
+		// Real-time event hub shared by the service and the WebSocket endpoint.
+		ProvideHub,
+		wire.Bind(new(realtime.Publisher), new(*realtime.Hub)),
+		// Application services.
+		ProvideMessageService,
+		application.NewUserService,
+		application.NewDeadLetterService,
+		// Attachments, with their content in the blob store.
+		ProvideBlobStore,
+		ProvideAttachmentService,
+		// In-process workers relaying and consuming events.
+		ProvideOutboxRelay,
+		ProvideDeliveryWorker,
+		ProvideThumbnailWorker,
 		// API handler and router.
 		api.NewHandler,
 		api.NewRouter,
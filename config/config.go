@@ -1,18 +1,76 @@
 package config
 
-import "github.com/kelseyhightower/envconfig"
+import (
+	"fmt"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+// Supported values of BROKER.
+const (
+	BrokerRabbitMQ = "rabbitmq"
+	BrokerMemory   = "memory"
+)
+
+// Supported values of STORAGE.
+const (
+	StorageMemory   = "memory"
+	StorageSQLite   = "sqlite"
+	StoragePostgres = "postgres"
+)
+
+// Supported values of BLOB_STORE.
+const (
+	BlobStoreLocal = "local"
+)
 
 // Config holds application configuration.
 type Config struct {
-	RabbitMQDSN   string `envconfig:"RABBITMQ_DSN"`
-	RabbitMQQueue string `envconfig:"RABBITMQ_QUEUE"`
-	HTTPPort      string `envconfig:"HTTP_PORT"`
-	AuthUsername  string `envconfig:"AUTH_USERNAME"`
-	AuthPassword  string `envconfig:"AUTH_PASSWORD"`
-	RateLimit     int    `envconfig:"RATE_LIMIT"`
-	ReadTimeout   int    `envconfig:"READ_TIMEOUT"`
-	WriteTimeout  int    `envconfig:"WRITE_TIMEOUT"`
-	IdleTimeout   int    `envconfig:"IDLE_TIMEOUT"`
+	Broker                 string   `envconfig:"BROKER" default:"rabbitmq"`
+	Storage                string   `envconfig:"STORAGE" default:"memory"`
+	SQLitePath             string   `envconfig:"SQLITE_PATH" default:"data/messaging.db"`
+	PostgresDSN            string   `envconfig:"POSTGRES_DSN"`
+	MigrateOnStart         bool     `envconfig:"MIGRATE_ON_START" default:"true"`
+	DBMaxOpenConns         int      `envconfig:"DB_MAX_OPEN_CONNS" default:"20"`
+	DBMaxIdleConns         int      `envconfig:"DB_MAX_IDLE_CONNS" default:"10"`
+	DBConnMaxLifetimeMs    int      `envconfig:"DB_CONN_MAX_LIFETIME_MS" default:"1800000"`
+	DBConnMaxIdleTimeMs    int      `envconfig:"DB_CONN_MAX_IDLE_TIME_MS" default:"300000"`
+	JournalDir             string   `envconfig:"JOURNAL_DIR"`
+	JournalSnapshotMs      int      `envconfig:"JOURNAL_SNAPSHOT_INTERVAL_MS" default:"60000"`
+	JournalSnapshotEvery   int      `envconfig:"JOURNAL_SNAPSHOT_EVERY" default:"10000"`
+	JournalFsync           bool     `envconfig:"JOURNAL_FSYNC" default:"true"`
+	RabbitMQDSN            string   `envconfig:"RABBITMQ_DSN"`
+	RabbitMQQueue          string   `envconfig:"RABBITMQ_QUEUE"`
+	RabbitMQExchange       string   `envconfig:"RABBITMQ_EXCHANGE" default:"messaging.events"`
+	RabbitMQBindingKeys    []string `envconfig:"RABBITMQ_BINDING_KEYS" default:"chat.*.message.sent,chat.*.attachment.uploaded"`
+	RabbitMQDLX            string   `envconfig:"RABBITMQ_DEAD_LETTER_EXCHANGE" default:"messaging.dead-letter"`
+	RabbitMQDLQ            string   `envconfig:"RABBITMQ_DEAD_LETTER_QUEUE" default:"messages.dead-letter"`
+	RabbitMQMaxRetries     int      `envconfig:"RABBITMQ_MAX_RETRIES" default:"3"`
+	RabbitMQRetryDelayMs   int      `envconfig:"RABBITMQ_RETRY_DELAY_MS" default:"1000"`
+	RabbitMQBackoffMs      int      `envconfig:"RABBITMQ_RECONNECT_BACKOFF_MS" default:"1000"`
+	RabbitMQMaxBackoffMs   int      `envconfig:"RABBITMQ_MAX_RECONNECT_BACKOFF_MS" default:"30000"`
+	HTTPPort               string   `envconfig:"HTTP_PORT"`
+	AuthUsername           string   `envconfig:"AUTH_USERNAME"`
+	AuthPassword           string   `envconfig:"AUTH_PASSWORD"`
+	RateLimit              int      `envconfig:"RATE_LIMIT"`
+	ReadTimeout            int      `envconfig:"READ_TIMEOUT"`
+	WriteTimeout           int      `envconfig:"WRITE_TIMEOUT"`
+	IdleTimeout            int      `envconfig:"IDLE_TIMEOUT"`
+	WSSendBuffer           int      `envconfig:"WS_SEND_BUFFER" default:"64"`
+	MessageEditWindowMs    int      `envconfig:"MESSAGE_EDIT_WINDOW_MS" default:"900000"`
+	DeliveryMaxAttempts    int      `envconfig:"DELIVERY_MAX_ATTEMPTS" default:"4"`
+	OutboxPollIntervalMs   int      `envconfig:"OUTBOX_POLL_INTERVAL_MS" default:"200"`
+	OutboxBatchSize        int      `envconfig:"OUTBOX_BATCH_SIZE" default:"20"`
+	OutboxRetryBackoffMs   int      `envconfig:"OUTBOX_RETRY_BACKOFF_MS" default:"500"`
+	OutboxMaxBackoffMs     int      `envconfig:"OUTBOX_MAX_BACKOFF_MS" default:"30000"`
+	OutboxPublishTimeoutMs int      `envconfig:"OUTBOX_PUBLISH_TIMEOUT_MS" default:"3000"`
+	OutboxClaimLeaseMs     int      `envconfig:"OUTBOX_CLAIM_LEASE_MS" default:"60000"`
+	BlobStore              string   `envconfig:"BLOB_STORE" default:"local"`
+	BlobDir                string   `envconfig:"BLOB_DIR" default:"data/blobs"`
+	AttachmentMaxBytes     int64    `envconfig:"ATTACHMENT_MAX_BYTES" default:"10485760"`
+	AttachmentURLSecret    string   `envconfig:"ATTACHMENT_URL_SECRET"`
+	AttachmentURLTTLMs     int      `envconfig:"ATTACHMENT_URL_TTL_MS" default:"3600000"`
+	ThumbnailMaxSize       int      `envconfig:"THUMBNAIL_MAX_SIZE" default:"320"`
 }
 
 // LoadConfig processes environment variables into a Config struct.
@@ -22,5 +80,24 @@ func LoadConfig() (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
 	return &cfg, nil
 }
+
+// validate rejects settings that contradict each other.
+func (cfg *Config) validate() error {
+	// Every delivery attempt is a delivery by the broker, which dead-letters
+	// the event after its retries; later attempts would never happen.
+	if cfg.DeliveryMaxAttempts > cfg.RabbitMQMaxRetries+1 {
+		return fmt.Errorf("DELIVERY_MAX_ATTEMPTS (%d) must not exceed RABBITMQ_MAX_RETRIES + 1 (%d)", cfg.DeliveryMaxAttempts, cfg.RabbitMQMaxRetries+1)
+	}
+	// A lease that ends while the batch is still being published lets
+	// another relay claim and publish the rest of it a second time.
+	batchMs := int64(cfg.OutboxBatchSize) * int64(cfg.OutboxPublishTimeoutMs)
+	if int64(cfg.OutboxClaimLeaseMs) < batchMs {
+		return fmt.Errorf("OUTBOX_CLAIM_LEASE_MS (%d) must cover OUTBOX_BATCH_SIZE * OUTBOX_PUBLISH_TIMEOUT_MS (%d)", cfg.OutboxClaimLeaseMs, batchMs)
+	}
+	return nil
+}
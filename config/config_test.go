@@ -27,4 +27,61 @@ func TestLoadConfig(t *testing.T) {
 	if cfg.HTTPPort != "3000" {
 		t.Errorf("expected HTTP_PORT '3000', got '%s'", cfg.HTTPPort)
 	}
+	if cfg.Broker != BrokerRabbitMQ {
+		t.Errorf("expected default BROKER 'rabbitmq', got '%s'", cfg.Broker)
+	}
+	if cfg.Storage != StorageMemory {
+		t.Errorf("expected default STORAGE 'memory', got '%s'", cfg.Storage)
+	}
+	if !cfg.MigrateOnStart || cfg.DBMaxOpenConns != 20 {
+		t.Errorf("expected migrations on start and 20 connections by default, got %v and %d", cfg.MigrateOnStart, cfg.DBMaxOpenConns)
+	}
+	if cfg.JournalDir != "" || !cfg.JournalFsync || cfg.JournalSnapshotEvery != 10000 {
+		t.Errorf("expected the journal disabled, fsynced and snapshotted every 10000 writes by default, got %q, %v and %d", cfg.JournalDir, cfg.JournalFsync, cfg.JournalSnapshotEvery)
+	}
+	if cfg.MessageEditWindowMs != 900000 {
+		t.Errorf("expected a default edit window of 15 minutes, got %dms", cfg.MessageEditWindowMs)
+	}
+	if cfg.BlobStore != BlobStoreLocal || cfg.AttachmentMaxBytes != 10<<20 || cfg.AttachmentURLTTLMs != 3600000 {
+		t.Errorf("expected local blobs, 10 MiB attachments and hour-long download URLs by default, got %q, %d and %dms", cfg.BlobStore, cfg.AttachmentMaxBytes, cfg.AttachmentURLTTLMs)
+	}
+	if cfg.ThumbnailMaxSize != 320 {
+		t.Errorf("expected thumbnails of at most 320 pixels by default, got %d", cfg.ThumbnailMaxSize)
+	}
+	if cfg.RabbitMQExchange != "messaging.events" {
+		t.Errorf("expected default RABBITMQ_EXCHANGE 'messaging.events', got '%s'", cfg.RabbitMQExchange)
+	}
+	if len(cfg.RabbitMQBindingKeys) != 2 || cfg.RabbitMQBindingKeys[0] != "chat.*.message.sent" || cfg.RabbitMQBindingKeys[1] != "chat.*.attachment.uploaded" {
+		t.Errorf("expected default RABBITMQ_BINDING_KEYS 'chat.*.message.sent,chat.*.attachment.uploaded', got %v", cfg.RabbitMQBindingKeys)
+	}
+	if cfg.OutboxClaimLeaseMs < cfg.OutboxBatchSize*cfg.OutboxPublishTimeoutMs {
+		t.Errorf("expected the default lease to cover a batch, got %dms for %d entries of up to %dms", cfg.OutboxClaimLeaseMs, cfg.OutboxBatchSize, cfg.OutboxPublishTimeoutMs)
+	}
+	if cfg.RabbitMQRetryDelayMs != 1000 || cfg.DeliveryMaxAttempts != cfg.RabbitMQMaxRetries+1 {
+		t.Errorf("expected retries a second apart and one delivery attempt per broker delivery by default, got %dms, %d attempts and %d retries", cfg.RabbitMQRetryDelayMs, cfg.DeliveryMaxAttempts, cfg.RabbitMQMaxRetries)
+	}
+}
+
+func TestLoadConfig_LeaseShorterThanBatch(t *testing.T) {
+	os.Setenv("OUTBOX_BATCH_SIZE", "100")
+	os.Setenv("OUTBOX_PUBLISH_TIMEOUT_MS", "5000")
+	os.Setenv("OUTBOX_CLAIM_LEASE_MS", "60000")
+	defer os.Unsetenv("OUTBOX_BATCH_SIZE")
+	defer os.Unsetenv("OUTBOX_PUBLISH_TIMEOUT_MS")
+	defer os.Unsetenv("OUTBOX_CLAIM_LEASE_MS")
+
+	if _, err := LoadConfig(); err == nil {
+		t.Error("expected an error for a lease shorter than publishing a batch, got nil")
+	}
+}
+
+func TestLoadConfig_DeliveryAttemptsBeyondRetries(t *testing.T) {
+	os.Setenv("RABBITMQ_MAX_RETRIES", "3")
+	os.Setenv("DELIVERY_MAX_ATTEMPTS", "5")
+	defer os.Unsetenv("RABBITMQ_MAX_RETRIES")
+	defer os.Unsetenv("DELIVERY_MAX_ATTEMPTS")
+
+	if _, err := LoadConfig(); err == nil {
+		t.Error("expected an error for more delivery attempts than broker deliveries, got nil")
+	}
 }
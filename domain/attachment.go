@@ -0,0 +1,66 @@
+package domain
+
+import "time"
+
+// Attachment content types accepted for upload. The type is sniffed from the
+// content rather than taken from the file name or the request.
+const (
+	AttachmentTypePDF  = "application/pdf"
+	AttachmentTypeJPEG = "image/jpeg"
+	AttachmentTypePNG  = "image/png"
+)
+
+// MaxAttachmentsPerMessage is the most attachments one message can carry.
+const MaxAttachmentsPerMessage = 10
+
+// IsAllowedAttachmentType returns true if files of contentType can be shared.
+func IsAllowedAttachmentType(contentType string) bool {
+	switch contentType {
+	case AttachmentTypePDF, AttachmentTypeJPEG, AttachmentTypePNG:
+		return true
+	}
+	return false
+}
+
+// HasThumbnail returns true if thumbnails are generated for files of
+// contentType.
+func HasThumbnail(contentType string) bool {
+	return contentType == AttachmentTypeJPEG || contentType == AttachmentTypePNG
+}
+
+// Attachment is a file uploaded to a chat. It can be referenced by a single
+// message of that chat, sent by its uploader.
+type Attachment struct {
+	ID          int64  `json:"id"`
+	ChatID      int64  `json:"chatId"`
+	UploaderID  int64  `json:"uploaderId"`
+	FileName    string `json:"fileName"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+	// StorageKey locates the content in the blob store.
+	StorageKey string    `json:"-"`
+	CreatedAt  time.Time `json:"createdAt"`
+	// MessageID is the message referencing the attachment, or zero.
+	MessageID int64 `json:"messageId,omitempty"`
+	// URL is a download URL signed for the user reading the attachment.
+	URL string `json:"url,omitempty"`
+	// Thumbnail is set for images once their thumbnail has been generated.
+	Thumbnail *Thumbnail `json:"thumbnail,omitempty"`
+}
+
+// ThumbnailKey returns the blob store key of the thumbnail of the attachment,
+// stored next to its content.
+func (a *Attachment) ThumbnailKey() string {
+	return a.StorageKey + ".thumb"
+}
+
+// Thumbnail is a scaled-down copy of an image attachment, in the format of
+// the original.
+type Thumbnail struct {
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+	// URL is a download URL signed for the user reading the attachment.
+	URL string `json:"url,omitempty"`
+}
@@ -1,12 +1,88 @@
 package domain
 
-import "time"
+import (
+	"sort"
+	"time"
+)
 
-// Chat represents a private conversation between two users.
+// ChatType distinguishes private conversations from group chats.
+type ChatType string
+
+const (
+	ChatTypeDirect ChatType = "direct"
+	ChatTypeGroup  ChatType = "group"
+)
+
+// Chat represents a conversation. Direct chats are between exactly two users
+// (Participant1ID and Participant2ID); group chats have a title and any number
+// of members in ParticipantIDs.
 type Chat struct {
 	ID             int64     `json:"id"`
-	Participant1ID int64     `json:"participant1Id"`
-	Participant2ID int64     `json:"participant2Id"`
+	Type           ChatType  `json:"type"`
+	Title          string    `json:"title,omitempty"`
+	Participant1ID int64     `json:"participant1Id,omitempty"`
+	Participant2ID int64     `json:"participant2Id,omitempty"`
+	ParticipantIDs []int64   `json:"participantIds"`
 	Metadata       string    `json:"metadata"`
 	CreatedAt      time.Time `json:"createdAt"`
 }
+
+// IsGroup returns true if the chat is a group chat.
+func (c *Chat) IsGroup() bool {
+	return c.Type == ChatTypeGroup
+}
+
+// Participants returns the IDs of every member of the chat.
+func (c *Chat) Participants() []int64 {
+	if c.IsGroup() || len(c.ParticipantIDs) > 0 {
+		return c.ParticipantIDs
+	}
+	return []int64{c.Participant1ID, c.Participant2ID}
+}
+
+// HasParticipant returns true if userID is a member of the chat.
+func (c *Chat) HasParticipant(userID int64) bool {
+	for _, id := range c.Participants() {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// ChatSummary is a chat as it appears in a user's chat list: the chat, its
+// most recent message and the number of messages the user has not read.
+type ChatSummary struct {
+	*Chat
+	LastMessage *Message `json:"lastMessage,omitempty"`
+	UnreadCount int      `json:"unreadCount"`
+	// LastActivityAt is the time of the last message, or the creation time
+	// of a chat without messages.
+	LastActivityAt time.Time `json:"lastActivityAt"`
+}
+
+// NewChatSummary summarizes chat given its last message, which may be nil.
+func NewChatSummary(chat *Chat, lastMessage *Message, unreadCount int) *ChatSummary {
+	summary := &ChatSummary{
+		Chat:           chat,
+		LastMessage:    lastMessage,
+		UnreadCount:    unreadCount,
+		LastActivityAt: chat.CreatedAt,
+	}
+	if lastMessage != nil && lastMessage.Timestamp.After(summary.LastActivityAt) {
+		summary.LastActivityAt = lastMessage.Timestamp
+	}
+	return summary
+}
+
+// SortChatSummaries orders summaries by last activity, most recent first.
+// Chats active at the same time are ordered by ID, newest first.
+func SortChatSummaries(summaries []*ChatSummary) {
+	sort.Slice(summaries, func(i, j int) bool {
+		a, b := summaries[i], summaries[j]
+		if !a.LastActivityAt.Equal(b.LastActivityAt) {
+			return a.LastActivityAt.After(b.LastActivityAt)
+		}
+		return a.ID > b.ID
+	})
+}
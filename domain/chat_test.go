@@ -0,0 +1,67 @@
+package domain
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChatParticipants(t *testing.T) {
+	direct := &Chat{Participant1ID: 1, Participant2ID: 2}
+	if !direct.HasParticipant(1) || !direct.HasParticipant(2) {
+		t.Error("expected both direct chat participants to be members")
+	}
+	if direct.HasParticipant(3) {
+		t.Error("expected user 3 not to be a member of the direct chat")
+	}
+
+	group := &Chat{Type: ChatTypeGroup, Title: "Team", ParticipantIDs: []int64{1, 2, 3}}
+	if !group.HasParticipant(3) {
+		t.Error("expected user 3 to be a member of the group chat")
+	}
+	if group.HasParticipant(4) {
+		t.Error("expected user 4 not to be a member of the group chat")
+	}
+}
+
+func TestNewMessageSentEvent(t *testing.T) {
+	chat := &Chat{ID: 1, Type: ChatTypeGroup, ParticipantIDs: []int64{1, 2, 3}}
+	msg := &Message{ID: 1, ChatID: 1, SenderID: 2, Content: "Hi all"}
+
+	event := NewMessageSentEvent(msg, chat)
+	if len(event.RecipientIDs) != 2 || event.RecipientIDs[0] != 1 || event.RecipientIDs[1] != 3 {
+		t.Errorf("expected recipients [1 3], got %v", event.RecipientIDs)
+	}
+}
+
+func TestSortChatSummaries(t *testing.T) {
+	base := time.Now()
+	quiet := &Chat{ID: 1, CreatedAt: base}
+	busy := &Chat{ID: 2, CreatedAt: base}
+	fresh := &Chat{ID: 3, CreatedAt: base.Add(time.Minute)}
+
+	summaries := []*ChatSummary{
+		NewChatSummary(quiet, nil, 0),
+		NewChatSummary(busy, &Message{ID: 7, ChatID: 2, Timestamp: base.Add(2 * time.Minute)}, 1),
+		NewChatSummary(fresh, nil, 0),
+	}
+	if !summaries[0].LastActivityAt.Equal(base) || !summaries[1].LastActivityAt.Equal(base.Add(2*time.Minute)) {
+		t.Errorf("unexpected last activity %v, %v", summaries[0].LastActivityAt, summaries[1].LastActivityAt)
+	}
+	SortChatSummaries(summaries)
+	var ids []int64
+	for _, summary := range summaries {
+		ids = append(ids, summary.ID)
+	}
+	if fmt.Sprint(ids) != "[2 3 1]" {
+		t.Errorf("expected chats [2 3 1], got %v", ids)
+	}
+
+	// The chat fields stay at the top level of the JSON.
+	body, _ := json.Marshal(summaries[0])
+	if !strings.Contains(string(body), `"id":2,`) || !strings.Contains(string(body), `"unreadCount":1`) {
+		t.Errorf("unexpected JSON %s", body)
+	}
+}
@@ -0,0 +1,20 @@
+package domain
+
+import "time"
+
+// DeadLetter is a message that consumers repeatedly failed to process and
+// that was parked for an operator to inspect, replay or discard.
+type DeadLetter struct {
+	ID             int64     `json:"id"`
+	MessageID      string    `json:"messageId,omitempty"`
+	CorrelationID  string    `json:"correlationId,omitempty"`
+	EventType      string    `json:"eventType,omitempty"`
+	RoutingKey     string    `json:"routingKey,omitempty"`
+	Queue          string    `json:"queue,omitempty"`
+	Reason         string    `json:"reason"`
+	LastError      string    `json:"lastError,omitempty"`
+	RetryCount     int       `json:"retryCount"`
+	ContentType    string    `json:"contentType,omitempty"`
+	Body           string    `json:"body"`
+	DeadLetteredAt time.Time `json:"deadLetteredAt"`
+}
@@ -0,0 +1,150 @@
+package domain
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// Event types published to the broker.
+const (
+	EventTypeMessageSent          = "message.sent"
+	EventTypeMessageStatusChanged = "message.status_changed"
+	EventTypeMessageEdited        = "message.edited"
+	EventTypeMessageDeleted       = "message.deleted"
+	EventTypeReactionAdded        = "message.reaction_added"
+	EventTypeReactionRemoved      = "message.reaction_removed"
+	EventTypeChatRead             = "chat.read"
+	EventTypeChatCreated          = "chat.created"
+	EventTypeChatMemberAdded      = "chat.member_added"
+	EventTypeChatMemberRemoved    = "chat.member_removed"
+	EventTypeAttachmentUploaded   = "attachment.uploaded"
+)
+
+// EventSchemaVersion is the version of the payload schemas below. Bump it
+// when a payload changes in a way older consumers cannot read.
+const EventSchemaVersion = 1
+
+// Event is the envelope every published event travels in, so consumers can
+// tell events apart before decoding the payload.
+type Event struct {
+	ID            string          `json:"id"`
+	Type          string          `json:"type"`
+	SchemaVersion int             `json:"schemaVersion"`
+	OccurredAt    time.Time       `json:"occurredAt"`
+	CorrelationID string          `json:"correlationId,omitempty"`
+	ChatID        int64           `json:"chatId"`
+	Payload       json.RawMessage `json:"payload"`
+}
+
+// NewEvent wraps payload in an envelope with a fresh ID.
+func NewEvent(eventType string, chatID int64, correlationID string, payload interface{}) (*Event, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	id, err := newEventID()
+	if err != nil {
+		return nil, err
+	}
+	return &Event{
+		ID:            id,
+		Type:          eventType,
+		SchemaVersion: EventSchemaVersion,
+		OccurredAt:    time.Now().UTC(),
+		CorrelationID: correlationID,
+		ChatID:        chatID,
+		Payload:       data,
+	}, nil
+}
+
+// DecodePayload unmarshals the payload into v.
+func (e *Event) DecodePayload(v interface{}) error {
+	return json.Unmarshal(e.Payload, v)
+}
+
+func newEventID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// MessageSentEvent is published to RabbitMQ when a message is sent. It carries
+// the chat's recipients so consumers can fan out to every member of the chat.
+type MessageSentEvent struct {
+	*Message
+	ChatType     ChatType `json:"chatType"`
+	RecipientIDs []int64  `json:"recipientIds"`
+}
+
+// NewMessageSentEvent builds the event for msg sent in chat. The sender is
+// excluded from the recipients.
+func NewMessageSentEvent(msg *Message, chat *Chat) *MessageSentEvent {
+	recipients := make([]int64, 0, len(chat.Participants()))
+	for _, id := range chat.Participants() {
+		if id != msg.SenderID {
+			recipients = append(recipients, id)
+		}
+	}
+	return &MessageSentEvent{
+		Message:      msg,
+		ChatType:     chat.Type,
+		RecipientIDs: recipients,
+	}
+}
+
+// MessageStatusChangedEvent describes a message moving to a new status.
+type MessageStatusChangedEvent struct {
+	MessageID int64         `json:"messageId"`
+	ChatID    int64         `json:"chatId"`
+	Status    MessageStatus `json:"status"`
+}
+
+// MessageEditedEvent describes the sender changing the content of a message.
+type MessageEditedEvent struct {
+	MessageID int64     `json:"messageId"`
+	ChatID    int64     `json:"chatId"`
+	SenderID  int64     `json:"senderId"`
+	Content   string    `json:"content"`
+	EditedAt  time.Time `json:"editedAt"`
+}
+
+// MessageDeletedEvent describes UserID deleting a message, for themselves or
+// for everyone depending on Scope.
+type MessageDeletedEvent struct {
+	MessageID int64         `json:"messageId"`
+	ChatID    int64         `json:"chatId"`
+	UserID    int64         `json:"userId"`
+	Scope     DeletionScope `json:"scope"`
+	DeletedAt time.Time     `json:"deletedAt"`
+}
+
+// MessageReactionEvent describes UserID adding or removing the reaction Emoji
+// to a message. Replaced is the reaction an added one took the place of.
+type MessageReactionEvent struct {
+	MessageID int64     `json:"messageId"`
+	ChatID    int64     `json:"chatId"`
+	UserID    int64     `json:"userId"`
+	Emoji     string    `json:"emoji"`
+	Replaced  string    `json:"replaced,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+// ChatMemberEvent describes a user joining or leaving a group chat.
+type ChatMemberEvent struct {
+	ChatID int64 `json:"chatId"`
+	UserID int64 `json:"userId"`
+}
+
+// AttachmentUploadedEvent describes a file uploaded to a chat, before any
+// message references it.
+type AttachmentUploadedEvent struct {
+	AttachmentID int64  `json:"attachmentId"`
+	ChatID       int64  `json:"chatId"`
+	UploaderID   int64  `json:"uploaderId"`
+	ContentType  string `json:"contentType"`
+	Size         int64  `json:"size"`
+}
@@ -0,0 +1,31 @@
+package domain
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestNewEvent(t *testing.T) {
+	payload := &ChatMemberEvent{ChatID: 7, UserID: 3}
+	event, err := NewEvent(EventTypeChatMemberAdded, 7, "req-1", payload)
+	if err != nil {
+		t.Fatalf("NewEvent failed: %v", err)
+	}
+	if event.ID == "" || event.SchemaVersion != EventSchemaVersion || event.OccurredAt.IsZero() {
+		t.Errorf("unexpected envelope: %+v", event)
+	}
+
+	// The envelope survives a round trip and the payload decodes back.
+	body, _ := json.Marshal(event)
+	var decoded Event
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		t.Fatalf("failed to unmarshal event: %v", err)
+	}
+	var got ChatMemberEvent
+	if err := decoded.DecodePayload(&got); err != nil {
+		t.Fatalf("DecodePayload failed: %v", err)
+	}
+	if decoded.Type != EventTypeChatMemberAdded || decoded.CorrelationID != "req-1" || got != *payload {
+		t.Errorf("unexpected round trip: %+v %+v", decoded, got)
+	}
+}
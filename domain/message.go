@@ -1,6 +1,12 @@
 package domain
 
-import "time"
+import (
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
 
 // MessageStatus defines the status of a message.
 type MessageStatus string
@@ -12,7 +18,9 @@ const (
 	MessageStatusFailed    MessageStatus = "failed"
 )
 
-// Message represents a chat message.
+// Message represents a chat message. Status summarizes the receipts: a
+// message is delivered once any recipient received it and read once every
+// recipient read it.
 type Message struct {
 	ID        int64         `json:"id"`
 	ChatID    int64         `json:"chatId"`
@@ -20,4 +28,159 @@ type Message struct {
 	Content   string        `json:"content"`
 	Timestamp time.Time     `json:"timestamp"`
 	Status    MessageStatus `json:"status"`
+	Receipts  []Receipt     `json:"receipts,omitempty"`
+	// ReplyToMessageID is the message of the same chat this one replies to
+	// and quotes, or zero.
+	ReplyToMessageID int64 `json:"replyToMessageId,omitempty"`
+	// ReplyCount is the number of direct replies. It is filled in, like
+	// Reactions, when a chat's history, a thread or a single message is read.
+	ReplyCount int `json:"replyCount,omitempty"`
+	// Reactions counts the reactions to the message per emoji.
+	Reactions []ReactionCount `json:"reactions,omitempty"`
+	// AttachmentIDs references the attachments sent with the message.
+	AttachmentIDs []int64 `json:"attachmentIds,omitempty"`
+	// Attachments describes AttachmentIDs with download URLs for the user
+	// reading the message. It is filled in when the API returns the message.
+	Attachments []*Attachment `json:"attachments,omitempty"`
+	// EditedAt is set once the sender changes the content.
+	EditedAt *time.Time `json:"editedAt,omitempty"`
+	// DeletedAt is set once the sender deletes the message for everyone. The
+	// message stays in the history as a tombstone without content.
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+}
+
+// IsEdited returns true if the content was changed after the message was sent.
+func (m *Message) IsEdited() bool {
+	return m.EditedAt != nil
+}
+
+// IsDeleted returns true if the message was deleted for everyone.
+func (m *Message) IsDeleted() bool {
+	return m.DeletedAt != nil
+}
+
+// DeletionScope tells whom a message is deleted for.
+type DeletionScope string
+
+const (
+	// DeleteForMe hides the message from the requesting user only.
+	DeleteForMe DeletionScope = "me"
+	// DeleteForEveryone replaces the message with a tombstone for every
+	// participant. Only the sender can do it.
+	DeleteForEveryone DeletionScope = "everyone"
+)
+
+// IsValid returns true if s is a known scope.
+func (s DeletionScope) IsValid() bool {
+	return s == DeleteForMe || s == DeleteForEveryone
+}
+
+// MessageRevision is a content a message had before an edit. CreatedAt is
+// when that content was written and ReplacedAt when the edit replaced it.
+type MessageRevision struct {
+	MessageID  int64     `json:"messageId"`
+	Content    string    `json:"content"`
+	CreatedAt  time.Time `json:"createdAt"`
+	ReplacedAt time.Time `json:"replacedAt"`
+}
+
+// MaxReactionLength is the longest reaction accepted, in bytes. It leaves
+// room for emoji built from several code points.
+const MaxReactionLength = 32
+
+// IsValidReaction returns true if emoji can be used as a reaction: a short
+// piece of text without spaces or control characters.
+func IsValidReaction(emoji string) bool {
+	if emoji == "" || len(emoji) > MaxReactionLength || !utf8.ValidString(emoji) {
+		return false
+	}
+	return strings.IndexFunc(emoji, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsControl(r)
+	}) < 0
+}
+
+// Reaction is the reaction of one user to a message. A user has at most one
+// reaction per message; reacting again replaces it.
+type Reaction struct {
+	MessageID int64     `json:"messageId"`
+	UserID    int64     `json:"userId"`
+	Emoji     string    `json:"emoji"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ReactionCount is the number of users who reacted to a message with Emoji.
+type ReactionCount struct {
+	Emoji   string  `json:"emoji"`
+	Count   int     `json:"count"`
+	UserIDs []int64 `json:"userIds"`
+}
+
+// CountReactions groups reactions by emoji. Emoji are ordered by their first
+// use and users by ID.
+func CountReactions(reactions []*Reaction) []ReactionCount {
+	if len(reactions) == 0 {
+		return nil
+	}
+	sorted := append([]*Reaction{}, reactions...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].CreatedAt.Before(sorted[j].CreatedAt) })
+	var result []ReactionCount
+	index := make(map[string]int)
+	for _, reaction := range sorted {
+		i, exists := index[reaction.Emoji]
+		if !exists {
+			i = len(result)
+			index[reaction.Emoji] = i
+			result = append(result, ReactionCount{Emoji: reaction.Emoji})
+		}
+		result[i].Count++
+		result[i].UserIDs = append(result[i].UserIDs, reaction.UserID)
+	}
+	for i := range result {
+		ids := result[i].UserIDs
+		sort.Slice(ids, func(a, b int) bool { return ids[a] < ids[b] })
+	}
+	return result
+}
+
+// Receipt records when one recipient received and read a message. Receipts
+// of a message are ordered by user ID.
+type Receipt struct {
+	UserID      int64      `json:"userId"`
+	DeliveredAt *time.Time `json:"deliveredAt,omitempty"`
+	ReadAt      *time.Time `json:"readAt,omitempty"`
+}
+
+// ReceiptFor returns the receipt of userID, or nil if the message has not
+// reached them yet.
+func (m *Message) ReceiptFor(userID int64) *Receipt {
+	for i := range m.Receipts {
+		if m.Receipts[i].UserID == userID {
+			return &m.Receipts[i]
+		}
+	}
+	return nil
+}
+
+// ReadByAll reports whether every one of recipientIDs other than the sender
+// has read the message.
+func (m *Message) ReadByAll(recipientIDs []int64) bool {
+	for _, id := range recipientIDs {
+		if id == m.SenderID {
+			continue
+		}
+		if r := m.ReceiptFor(id); r == nil || r.ReadAt == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// ChatRead records that UserID read ChatID up to and including message
+// UpToMessageID. MessageIDs are the messages it newly marked as read.
+type ChatRead struct {
+	ChatID        int64     `json:"chatId"`
+	UserID        int64     `json:"userId"`
+	UpToMessageID int64     `json:"upToMessageId"`
+	ReadAt        time.Time `json:"readAt"`
+	MessageIDs    []int64   `json:"messageIds"`
 }
@@ -0,0 +1,69 @@
+package domain
+
+import "time"
+
+// messageStatusTransitions lists the statuses each status may move to. A
+// failed message goes back to sent when it is retried; read is final.
+var messageStatusTransitions = map[MessageStatus][]MessageStatus{
+	MessageStatusSent:      {MessageStatusDelivered, MessageStatusFailed},
+	MessageStatusDelivered: {MessageStatusRead},
+	MessageStatusFailed:    {MessageStatusSent},
+}
+
+// IsValid reports whether s is a known status.
+func (s MessageStatus) IsValid() bool {
+	switch s {
+	case MessageStatusSent, MessageStatusDelivered, MessageStatusRead, MessageStatusFailed:
+		return true
+	}
+	return false
+}
+
+// CanTransitionTo reports whether a message may move from s to next.
+func (s MessageStatus) CanTransitionTo(next MessageStatus) bool {
+	for _, allowed := range messageStatusTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// PathTo returns the shortest series of transitions leading from s to
+// target, excluding s itself. It is empty when s is target and nil when
+// target cannot be reached.
+func (s MessageStatus) PathTo(target MessageStatus) []MessageStatus {
+	if s == target {
+		return []MessageStatus{}
+	}
+	previous := map[MessageStatus]MessageStatus{s: ""}
+	queue := []MessageStatus{s}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, next := range messageStatusTransitions[current] {
+			if _, seen := previous[next]; seen {
+				continue
+			}
+			previous[next] = current
+			if next != target {
+				queue = append(queue, next)
+				continue
+			}
+			var path []MessageStatus
+			for step := next; step != s; step = previous[step] {
+				path = append([]MessageStatus{step}, path...)
+			}
+			return path
+		}
+	}
+	return nil
+}
+
+// StatusTransition records a message moving from one status to another.
+type StatusTransition struct {
+	MessageID int64         `json:"messageId"`
+	From      MessageStatus `json:"from"`
+	To        MessageStatus `json:"to"`
+	At        time.Time     `json:"at"`
+}
@@ -1,6 +1,8 @@
 package domain
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 	"time"
 )
@@ -18,3 +20,103 @@ func TestMessageStatus(t *testing.T) {
 		t.Errorf("expected status %s but got %s", MessageStatusSent, msg.Status)
 	}
 }
+
+func TestMessage_Receipts(t *testing.T) {
+	now := time.Now()
+	msg := Message{
+		SenderID: 1,
+		Receipts: []Receipt{
+			{UserID: 2, DeliveredAt: &now, ReadAt: &now},
+			{UserID: 3, DeliveredAt: &now},
+		},
+	}
+	if r := msg.ReceiptFor(3); r == nil || r.ReadAt != nil {
+		t.Errorf("expected an unread receipt for user 3, got %+v", r)
+	}
+	if msg.ReceiptFor(4) != nil {
+		t.Error("expected no receipt for user 4")
+	}
+	if !msg.ReadByAll([]int64{1, 2}) {
+		t.Error("expected the message to be read by users 1 and 2")
+	}
+	if msg.ReadByAll([]int64{1, 2, 3}) {
+		t.Error("expected the message not to be read by user 3")
+	}
+}
+
+func TestMessageStatus_Transitions(t *testing.T) {
+	allowed := []struct{ from, to MessageStatus }{
+		{MessageStatusSent, MessageStatusDelivered},
+		{MessageStatusDelivered, MessageStatusRead},
+		{MessageStatusSent, MessageStatusFailed},
+		{MessageStatusFailed, MessageStatusSent},
+	}
+	for _, tr := range allowed {
+		if !tr.from.CanTransitionTo(tr.to) {
+			t.Errorf("expected %s -> %s to be allowed", tr.from, tr.to)
+		}
+	}
+	rejected := []struct{ from, to MessageStatus }{
+		{MessageStatusRead, MessageStatusSent},
+		{MessageStatusFailed, MessageStatusRead},
+		{MessageStatusDelivered, MessageStatusSent},
+		{MessageStatusSent, MessageStatusRead},
+		{MessageStatusSent, MessageStatusSent},
+	}
+	for _, tr := range rejected {
+		if tr.from.CanTransitionTo(tr.to) {
+			t.Errorf("expected %s -> %s to be rejected", tr.from, tr.to)
+		}
+	}
+	if MessageStatus("lost").IsValid() || !MessageStatusFailed.IsValid() {
+		t.Error("unexpected IsValid result")
+	}
+}
+
+func TestMessageStatus_PathTo(t *testing.T) {
+	tests := []struct {
+		from, to MessageStatus
+		expected string
+	}{
+		{MessageStatusSent, MessageStatusRead, "[delivered read]"},
+		{MessageStatusFailed, MessageStatusDelivered, "[sent delivered]"},
+		{MessageStatusDelivered, MessageStatusDelivered, "[]"},
+	}
+	for _, tt := range tests {
+		path := tt.from.PathTo(tt.to)
+		if got := fmt.Sprint(path); got != tt.expected {
+			t.Errorf("%s -> %s: expected %s, got %s", tt.from, tt.to, tt.expected, got)
+		}
+	}
+	if path := MessageStatusRead.PathTo(MessageStatusDelivered); path != nil {
+		t.Errorf("expected no path from read, got %v", path)
+	}
+}
+
+func TestIsValidReaction(t *testing.T) {
+	for _, emoji := range []string{"👍", "❤️", "👩‍👩‍👧", "+1"} {
+		if !IsValidReaction(emoji) {
+			t.Errorf("expected %q to be a valid reaction", emoji)
+		}
+	}
+	for _, emoji := range []string{"", "thumbs up", "\n", "\xff", strings.Repeat("👍", 9)} {
+		if IsValidReaction(emoji) {
+			t.Errorf("expected %q to be an invalid reaction", emoji)
+		}
+	}
+}
+
+func TestCountReactions(t *testing.T) {
+	if CountReactions(nil) != nil {
+		t.Error("expected no counts without reactions")
+	}
+	now := time.Now()
+	counts := CountReactions([]*Reaction{
+		{UserID: 3, Emoji: "❤️", CreatedAt: now.Add(time.Second)},
+		{UserID: 2, Emoji: "👍", CreatedAt: now},
+		{UserID: 1, Emoji: "❤️", CreatedAt: now.Add(2 * time.Second)},
+	})
+	if got := fmt.Sprint(counts); got != "[{👍 1 [2]} {❤️ 2 [1 3]}]" {
+		t.Errorf("unexpected counts %s", got)
+	}
+}
@@ -0,0 +1,29 @@
+package domain
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// OutboxStatus tracks whether an outbox entry reached the broker.
+type OutboxStatus string
+
+const (
+	OutboxStatusPending OutboxStatus = "pending"
+	OutboxStatusSent    OutboxStatus = "sent"
+)
+
+// OutboxEntry is an event stored alongside the data it describes, waiting to
+// be published to the broker by the outbox relay.
+type OutboxEntry struct {
+	ID            int64           `json:"id"`
+	EventType     string          `json:"eventType"`
+	AggregateID   int64           `json:"aggregateId"`
+	Payload       json.RawMessage `json:"payload"`
+	Status        OutboxStatus    `json:"status"`
+	Attempts      int             `json:"attempts"`
+	LastError     string          `json:"lastError,omitempty"`
+	CreatedAt     time.Time       `json:"createdAt"`
+	NextAttemptAt time.Time       `json:"nextAttemptAt"`
+	SentAt        *time.Time      `json:"sentAt,omitempty"`
+}
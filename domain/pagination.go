@@ -0,0 +1,94 @@
+package domain
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// DefaultMessagePageSize is used when a query does not set a limit.
+	DefaultMessagePageSize = 50
+	// MaxMessagePageSize caps the number of messages returned per page.
+	MaxMessagePageSize = 100
+)
+
+// ErrInvalidCursor is returned when a cursor cannot be decoded.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// MessageCursor identifies a position in a chat's history. Messages are
+// ordered by timestamp, then by ID.
+type MessageCursor struct {
+	Timestamp time.Time
+	ID        int64
+}
+
+// CursorFor returns the cursor pointing at msg.
+func CursorFor(msg *Message) *MessageCursor {
+	return &MessageCursor{Timestamp: msg.Timestamp, ID: msg.ID}
+}
+
+// Encode returns the opaque string form of the cursor.
+func (c *MessageCursor) Encode() string {
+	raw := fmt.Sprintf("%d:%d", c.Timestamp.UnixNano(), c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeMessageCursor parses a cursor produced by Encode.
+func DecodeMessageCursor(s string) (*MessageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var nanos, id int64
+	if _, err := fmt.Sscanf(string(raw), "%d:%d", &nanos, &id); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &MessageCursor{Timestamp: time.Unix(0, nanos).UTC(), ID: id}, nil
+}
+
+// IsBefore returns true if msg sorts before the cursor position.
+func (c *MessageCursor) IsBefore(msg *Message) bool {
+	if msg.Timestamp.Equal(c.Timestamp) {
+		return msg.ID < c.ID
+	}
+	return msg.Timestamp.Before(c.Timestamp)
+}
+
+// IsAfter returns true if msg sorts after the cursor position.
+func (c *MessageCursor) IsAfter(msg *Message) bool {
+	if msg.Timestamp.Equal(c.Timestamp) {
+		return msg.ID > c.ID
+	}
+	return msg.Timestamp.After(c.Timestamp)
+}
+
+// MessageLess reports whether a sorts before b in chat history.
+func MessageLess(a, b *Message) bool {
+	if a.Timestamp.Equal(b.Timestamp) {
+		return a.ID < b.ID
+	}
+	return a.Timestamp.Before(b.Timestamp)
+}
+
+// MessageQuery selects a page of a chat's history.
+//
+// With After set, the page holds the oldest messages after that cursor and
+// NextCursor continues forward. Otherwise the page holds the newest messages
+// before Before (or the newest overall) and NextCursor continues backward.
+// Either way, messages in a page are ordered oldest first.
+//
+// With UserID set, messages that user deleted for themselves are left out.
+type MessageQuery struct {
+	Before *MessageCursor
+	After  *MessageCursor
+	Limit  int
+	UserID int64
+}
+
+// MessagePage is one page of a chat's history.
+type MessagePage struct {
+	Messages   []*Message `json:"messages"`
+	NextCursor string     `json:"nextCursor,omitempty"`
+}
@@ -0,0 +1,37 @@
+package domain
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMessageCursorRoundTrip(t *testing.T) {
+	cursor := &MessageCursor{Timestamp: time.Unix(1700000000, 123456789).UTC(), ID: 42}
+
+	decoded, err := DecodeMessageCursor(cursor.Encode())
+	if err != nil {
+		t.Fatalf("DecodeMessageCursor failed: %v", err)
+	}
+	if !decoded.Timestamp.Equal(cursor.Timestamp) || decoded.ID != cursor.ID {
+		t.Errorf("expected %+v, got %+v", cursor, decoded)
+	}
+
+	if _, err := DecodeMessageCursor("not a cursor"); err != ErrInvalidCursor {
+		t.Errorf("expected ErrInvalidCursor, got %v", err)
+	}
+}
+
+func TestMessageCursorOrdering(t *testing.T) {
+	now := time.Now()
+	cursor := &MessageCursor{Timestamp: now, ID: 5}
+
+	if !cursor.IsBefore(&Message{ID: 4, Timestamp: now}) {
+		t.Error("expected same timestamp with lower ID to sort before the cursor")
+	}
+	if !cursor.IsAfter(&Message{ID: 1, Timestamp: now.Add(time.Second)}) {
+		t.Error("expected later timestamp to sort after the cursor")
+	}
+	if cursor.IsBefore(&Message{ID: 5, Timestamp: now}) || cursor.IsAfter(&Message{ID: 5, Timestamp: now}) {
+		t.Error("expected the cursor's own message to be excluded on both sides")
+	}
+}
@@ -5,21 +5,3 @@ type User struct {
 	ID   int64  `json:"id"`
 	Name string `json:"name"`
 }
-
-// HardcodedUsers holds the four recipients.
-var HardcodedUsers = []User{
-	{ID: 1, Name: "Red"},
-	{ID: 2, Name: "Jrue"},
-	{ID: 3, Name: "Miro"},
-	{ID: 4, Name: "Joann"},
-}
-
-// IsValidUser returns true if the provided userID is found in HardcodedUsers.
-func IsValidUser(userID int64) bool {
-	for _, u := range HardcodedUsers {
-		if u.ID == userID {
-			return true
-		}
-	}
-	return false
-}
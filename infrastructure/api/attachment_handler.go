@@ -0,0 +1,157 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"messaging-app/domain"
+	"messaging-app/pkg/apistatus"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// UploadAttachment handles POST /chats/{chatId}/attachments. The body is a
+// multipart form with an uploaderId field followed by a file part, which is
+// streamed to the blob store rather than buffered.
+func (h *Handler) UploadAttachment(w http.ResponseWriter, r *http.Request) {
+	chatID, err := strconv.ParseInt(chi.URLParam(r, "chatId"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid chatId", http.StatusBadRequest)
+		return
+	}
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var uploaderID int64
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			http.Error(w, "Missing file", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch part.FormName() {
+		case "uploaderId":
+			value, err := io.ReadAll(io.LimitReader(part, 32))
+			if err == nil {
+				uploaderID, err = strconv.ParseInt(string(value), 10, 64)
+			}
+			if err != nil {
+				http.Error(w, "Invalid uploaderId", http.StatusBadRequest)
+				return
+			}
+		case "file":
+			if uploaderID == 0 {
+				http.Error(w, "uploaderId must come before file", http.StatusBadRequest)
+				return
+			}
+			attachment, apistatus := h.attachmentService.Upload(r.Context(), chatID, uploaderID, part.FileName(), part)
+			if apistatus != nil {
+				http.Error(w, apistatus.GetMessage(), apistatus.GetStatus())
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(attachment)
+			return
+		}
+	}
+}
+
+// GetAttachment handles GET /attachments/{attachmentId}?userId=.
+func (h *Handler) GetAttachment(w http.ResponseWriter, r *http.Request) {
+	attachmentID, err := strconv.ParseInt(chi.URLParam(r, "attachmentId"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid attachmentId", http.StatusBadRequest)
+		return
+	}
+	userID, err := strconv.ParseInt(r.URL.Query().Get("userId"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid userId", http.StatusBadRequest)
+		return
+	}
+	attachment, apistatus := h.attachmentService.GetAttachment(r.Context(), attachmentID, userID)
+	if apistatus != nil {
+		http.Error(w, apistatus.GetMessage(), apistatus.GetStatus())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(attachment)
+}
+
+// DownloadAttachment handles GET
+// /attachments/{attachmentId}/content?userId=&expires=&signature=, the
+// download URLs handed out with attachments.
+func (h *Handler) DownloadAttachment(w http.ResponseWriter, r *http.Request) {
+	attachment, content, ok := h.openSigned(w, r, h.attachmentService.OpenAttachment)
+	if !ok {
+		return
+	}
+	defer content.Close()
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.FileName}))
+	// The type was sniffed on upload; browsers must not guess another one.
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, content); err != nil {
+		log.Printf("failed to send attachment %d: %v", attachment.ID, err)
+	}
+}
+
+// DownloadThumbnail handles GET
+// /attachments/{attachmentId}/thumbnail?userId=&expires=&signature=, the
+// thumbnail URLs of image attachments. Thumbnails are shown inline.
+func (h *Handler) DownloadThumbnail(w http.ResponseWriter, r *http.Request) {
+	attachment, content, ok := h.openSigned(w, r, h.attachmentService.OpenThumbnail)
+	if !ok {
+		return
+	}
+	defer content.Close()
+	w.Header().Set("Content-Type", attachment.Thumbnail.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(attachment.Thumbnail.Size, 10))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, content); err != nil {
+		log.Printf("failed to send thumbnail of attachment %d: %v", attachment.ID, err)
+	}
+}
+
+// openSigned parses a signed URL and opens it with open. It writes the error
+// response and returns false if that fails.
+func (h *Handler) openSigned(w http.ResponseWriter, r *http.Request,
+	open func(ctx context.Context, attachmentID, userID, expires int64, signature string) (*domain.Attachment, io.ReadCloser, apistatus.Status)) (*domain.Attachment, io.ReadCloser, bool) {
+	attachmentID, err := strconv.ParseInt(chi.URLParam(r, "attachmentId"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid attachmentId", http.StatusBadRequest)
+		return nil, nil, false
+	}
+	query := r.URL.Query()
+	userID, err := strconv.ParseInt(query.Get("userId"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid userId", http.StatusBadRequest)
+		return nil, nil, false
+	}
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid expires", http.StatusBadRequest)
+		return nil, nil, false
+	}
+	attachment, content, status := open(r.Context(), attachmentID, userID, expires, query.Get("signature"))
+	if status != nil {
+		http.Error(w, status.GetMessage(), status.GetStatus())
+		return nil, nil, false
+	}
+	return attachment, content, true
+}
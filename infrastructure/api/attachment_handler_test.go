@@ -0,0 +1,290 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"messaging-app/domain"
+	"messaging-app/pkg/apistatus"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// dummyAttachmentService is a dummy implementation of the AttachmentService
+// interface for testing. Attachment 1 is a PNG uploaded by user 1 to chat 1,
+// which serves as its own thumbnail.
+type dummyAttachmentService struct{}
+
+const dummyPNG = "\x89PNG\r\n\x1a\n"
+
+func dummyAttachment(userID int64) *domain.Attachment {
+	return &domain.Attachment{
+		ID: 1, ChatID: 1, UploaderID: 1, FileName: "cat.png", ContentType: domain.AttachmentTypePNG,
+		Size: int64(len(dummyPNG)), CreatedAt: time.Now().UTC(),
+		URL: fmt.Sprintf("/attachments/1/content?userId=%d&expires=1&signature=valid", userID),
+		Thumbnail: &domain.Thumbnail{
+			Width: 1, Height: 1, ContentType: domain.AttachmentTypePNG, Size: int64(len(dummyPNG)),
+			URL: fmt.Sprintf("/attachments/1/thumbnail?userId=%d&expires=1&signature=valid", userID),
+		},
+	}
+}
+
+func (s *dummyAttachmentService) Upload(ctx context.Context, chatID, uploaderID int64, fileName string, content io.Reader) (*domain.Attachment, apistatus.Status) {
+	if chatID != 1 {
+		return nil, apistatus.New("chat not found").NotFound()
+	}
+	body, _ := io.ReadAll(content)
+	if string(body) != dummyPNG {
+		return nil, apistatus.New("files of type text/plain cannot be shared").UnsupportedMediaType()
+	}
+	attachment := dummyAttachment(uploaderID)
+	attachment.ID = 2
+	attachment.UploaderID = uploaderID
+	attachment.FileName = fileName
+	return attachment, nil
+}
+
+func (s *dummyAttachmentService) GetAttachment(ctx context.Context, attachmentID, userID int64) (*domain.Attachment, apistatus.Status) {
+	if attachmentID != 1 {
+		return nil, apistatus.New("attachment not found").NotFound()
+	}
+	return dummyAttachment(userID), nil
+}
+
+func (s *dummyAttachmentService) OpenAttachment(ctx context.Context, attachmentID, userID, expires int64, signature string) (*domain.Attachment, io.ReadCloser, apistatus.Status) {
+	if signature != "valid" {
+		return nil, nil, apistatus.New("invalid download signature").Forbidden()
+	}
+	if attachmentID != 1 {
+		return nil, nil, apistatus.New("attachment not found").NotFound()
+	}
+	return dummyAttachment(userID), io.NopCloser(strings.NewReader(dummyPNG)), nil
+}
+
+func (s *dummyAttachmentService) OpenThumbnail(ctx context.Context, attachmentID, userID, expires int64, signature string) (*domain.Attachment, io.ReadCloser, apistatus.Status) {
+	return s.OpenAttachment(ctx, attachmentID, userID, expires, signature)
+}
+
+func (s *dummyAttachmentService) DescribeAttachments(ctx context.Context, userID int64, msgs []*domain.Message) ([]*domain.Message, apistatus.Status) {
+	result := make([]*domain.Message, len(msgs))
+	for i, msg := range msgs {
+		described := *msg
+		for range msg.AttachmentIDs {
+			described.Attachments = append(described.Attachments, dummyAttachment(userID))
+		}
+		result[i] = &described
+	}
+	return result, nil
+}
+
+// uploadRequest builds a multipart upload to chatID with fields in order. The
+// "file" field becomes the file part.
+func uploadRequest(t *testing.T, chatID string, fields [][2]string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for _, field := range fields {
+		if field[0] == "file" {
+			part, _ := form.CreateFormFile("file", "cat.png")
+			part.Write([]byte(field[1]))
+		} else {
+			form.WriteField(field[0], field[1])
+		}
+	}
+	form.Close()
+	req := httptest.NewRequest("POST", "/chats/"+chatID+"/attachments", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, newChiContext("chatId", chatID)))
+}
+
+// TestUploadAttachment verifies the multipart upload endpoint.
+func TestUploadAttachment(t *testing.T) {
+	handler := setupTestHandler()
+
+	tests := []struct {
+		name     string
+		chatID   string
+		fields   [][2]string
+		expected int
+	}{
+		{"png", "1", [][2]string{{"uploaderId", "1"}, {"file", dummyPNG}}, http.StatusCreated},
+		{"text", "1", [][2]string{{"uploaderId", "1"}, {"file", "hello"}}, http.StatusUnsupportedMediaType},
+		{"unknown chat", "2", [][2]string{{"uploaderId", "1"}, {"file", dummyPNG}}, http.StatusNotFound},
+		{"file before uploader", "1", [][2]string{{"file", dummyPNG}, {"uploaderId", "1"}}, http.StatusBadRequest},
+		{"invalid uploader", "1", [][2]string{{"uploaderId", "one"}, {"file", dummyPNG}}, http.StatusBadRequest},
+		{"no file", "1", [][2]string{{"uploaderId", "1"}}, http.StatusBadRequest},
+		{"invalid chat", "abc", [][2]string{{"uploaderId", "1"}, {"file", dummyPNG}}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handler.UploadAttachment(rr, uploadRequest(t, tt.chatID, tt.fields))
+			if rr.Code != tt.expected {
+				t.Fatalf("expected status code %d, got %d: %s", tt.expected, rr.Code, rr.Body.String())
+			}
+			if rr.Code != http.StatusCreated {
+				return
+			}
+			var attachment domain.Attachment
+			if err := json.NewDecoder(rr.Body).Decode(&attachment); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if attachment.ID != 2 || attachment.FileName != "cat.png" || attachment.URL == "" {
+				t.Errorf("unexpected attachment %+v", attachment)
+			}
+		})
+	}
+
+	// Plain JSON is not an upload.
+	req := httptest.NewRequest("POST", "/chats/1/attachments", strings.NewReader(`{}`))
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, newChiContext("chatId", "1")))
+	rr := httptest.NewRecorder()
+	handler.UploadAttachment(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+// TestGetAttachment verifies that attachments are returned with a URL.
+func TestGetAttachment(t *testing.T) {
+	handler := setupTestHandler()
+
+	tests := []struct {
+		name     string
+		id       string
+		query    string
+		expected int
+	}{
+		{"found", "1", "?userId=2", http.StatusOK},
+		{"not found", "9", "?userId=2", http.StatusNotFound},
+		{"missing user", "1", "", http.StatusBadRequest},
+		{"invalid id", "abc", "?userId=2", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/attachments/"+tt.id+tt.query, nil)
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, newChiContext("attachmentId", tt.id)))
+			rr := httptest.NewRecorder()
+			handler.GetAttachment(rr, req)
+			if rr.Code != tt.expected {
+				t.Fatalf("expected status code %d, got %d", tt.expected, rr.Code)
+			}
+			if rr.Code == http.StatusOK && !strings.Contains(rr.Body.String(), "userId=2") {
+				t.Errorf("expected a URL for user 2, got %s", rr.Body.String())
+			}
+		})
+	}
+}
+
+// TestDownloadAttachment verifies that signed URLs serve the content.
+func TestDownloadAttachment(t *testing.T) {
+	handler := setupTestHandler()
+
+	tests := []struct {
+		name     string
+		query    string
+		expected int
+	}{
+		{"signed", "?userId=2&expires=1&signature=valid", http.StatusOK},
+		{"forged", "?userId=2&expires=1&signature=forged", http.StatusForbidden},
+		{"missing expiry", "?userId=2&signature=valid", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/attachments/1/content"+tt.query, nil)
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, newChiContext("attachmentId", "1")))
+			rr := httptest.NewRecorder()
+			handler.DownloadAttachment(rr, req)
+			if rr.Code != tt.expected {
+				t.Fatalf("expected status code %d, got %d", tt.expected, rr.Code)
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+			if rr.Body.String() != dummyPNG || rr.Header().Get("Content-Type") != domain.AttachmentTypePNG {
+				t.Errorf("unexpected content %q of type %s", rr.Body.String(), rr.Header().Get("Content-Type"))
+			}
+			if disposition := rr.Header().Get("Content-Disposition"); disposition != "attachment; filename=cat.png" {
+				t.Errorf("unexpected Content-Disposition %q", disposition)
+			}
+		})
+	}
+}
+
+// TestDownloadThumbnail verifies that signed URLs serve thumbnails inline.
+func TestDownloadThumbnail(t *testing.T) {
+	handler := setupTestHandler()
+
+	tests := []struct {
+		name     string
+		id       string
+		query    string
+		expected int
+	}{
+		{"signed", "1", "?userId=2&expires=1&signature=valid", http.StatusOK},
+		{"forged", "1", "?userId=2&expires=1&signature=forged", http.StatusForbidden},
+		{"not found", "9", "?userId=2&expires=1&signature=valid", http.StatusNotFound},
+		{"missing user", "1", "?expires=1&signature=valid", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/attachments/"+tt.id+"/thumbnail"+tt.query, nil)
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, newChiContext("attachmentId", tt.id)))
+			rr := httptest.NewRecorder()
+			handler.DownloadThumbnail(rr, req)
+			if rr.Code != tt.expected {
+				t.Fatalf("expected status code %d, got %d", tt.expected, rr.Code)
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+			if rr.Body.String() != dummyPNG || rr.Header().Get("Content-Type") != domain.AttachmentTypePNG || rr.Header().Get("Content-Disposition") != "" {
+				t.Errorf("unexpected inline content %q of type %s", rr.Body.String(), rr.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+// TestSendMessage_Attachments verifies that sent attachments are described
+// for the sender.
+func TestSendMessage_Attachments(t *testing.T) {
+	handler := setupTestHandler()
+
+	tests := []struct {
+		name     string
+		body     string
+		expected int
+	}{
+		{"uploaded", `{"chatId": 1, "senderId": 1, "content": "Look", "attachmentIds": [1]}`, http.StatusCreated},
+		{"unknown", `{"chatId": 1, "senderId": 1, "content": "Look", "attachmentIds": [7]}`, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/messages", strings.NewReader(tt.body))
+			rr := httptest.NewRecorder()
+			handler.SendMessage(rr, req)
+			if rr.Code != tt.expected {
+				t.Fatalf("expected status code %d, got %d", tt.expected, rr.Code)
+			}
+			if rr.Code != http.StatusCreated {
+				return
+			}
+			var msg domain.Message
+			if err := json.NewDecoder(rr.Body).Decode(&msg); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if len(msg.AttachmentIDs) != 1 || len(msg.Attachments) != 1 || !strings.Contains(msg.Attachments[0].URL, "userId=1") || msg.Attachments[0].Thumbnail == nil {
+				t.Errorf("expected the attachment with URLs for the sender, got %+v", msg)
+			}
+		})
+	}
+}
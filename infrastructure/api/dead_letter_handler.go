@@ -0,0 +1,85 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// PurgeDeadLettersResponse reports how many dead letters were discarded.
+type PurgeDeadLettersResponse struct {
+	Purged int `json:"purged"`
+}
+
+// ListDeadLetters handles GET /admin/dead-letters.
+func (h *Handler) ListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	deadLetters, apistatus := h.deadLetterService.ListDeadLetters(r.Context())
+	if apistatus != nil {
+		http.Error(w, apistatus.GetMessage(), apistatus.GetStatus())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(deadLetters)
+}
+
+// GetDeadLetter handles GET /admin/dead-letters/{deadLetterId}.
+func (h *Handler) GetDeadLetter(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "deadLetterId"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid deadLetterId", http.StatusBadRequest)
+		return
+	}
+	dl, apistatus := h.deadLetterService.GetDeadLetter(r.Context(), id)
+	if apistatus != nil {
+		http.Error(w, apistatus.GetMessage(), apistatus.GetStatus())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(dl)
+}
+
+// ReplayDeadLetter handles POST /admin/dead-letters/{deadLetterId}/replay.
+func (h *Handler) ReplayDeadLetter(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "deadLetterId"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid deadLetterId", http.StatusBadRequest)
+		return
+	}
+	apistatus := h.deadLetterService.ReplayDeadLetter(r.Context(), id)
+	if apistatus != nil {
+		http.Error(w, apistatus.GetMessage(), apistatus.GetStatus())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteDeadLetter handles DELETE /admin/dead-letters/{deadLetterId}.
+func (h *Handler) DeleteDeadLetter(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "deadLetterId"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid deadLetterId", http.StatusBadRequest)
+		return
+	}
+	apistatus := h.deadLetterService.DeleteDeadLetter(r.Context(), id)
+	if apistatus != nil {
+		http.Error(w, apistatus.GetMessage(), apistatus.GetStatus())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PurgeDeadLetters handles DELETE /admin/dead-letters.
+func (h *Handler) PurgeDeadLetters(w http.ResponseWriter, r *http.Request) {
+	purged, apistatus := h.deadLetterService.PurgeDeadLetters(r.Context())
+	if apistatus != nil {
+		http.Error(w, apistatus.GetMessage(), apistatus.GetStatus())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(PurgeDeadLettersResponse{Purged: purged})
+}
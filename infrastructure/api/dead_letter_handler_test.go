@@ -0,0 +1,133 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"messaging-app/domain"
+	"messaging-app/pkg/apistatus"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// dummyDeadLetterService is a dummy implementation of the DeadLetterService interface for testing.
+type dummyDeadLetterService struct {
+	deadLetters map[int64]*domain.DeadLetter
+	replayed    []int64
+}
+
+func newDummyDeadLetterService() *dummyDeadLetterService {
+	return &dummyDeadLetterService{deadLetters: map[int64]*domain.DeadLetter{
+		1: {ID: 1, EventType: domain.EventTypeMessageSent, Reason: "retries exhausted", RetryCount: 3, Body: "{}"},
+		2: {ID: 2, Reason: "rejected", Body: "not json"},
+	}}
+}
+
+func (s *dummyDeadLetterService) CollectDeadLetters(ctx context.Context) error {
+	<-ctx.Done()
+	return nil
+}
+
+func (s *dummyDeadLetterService) ListDeadLetters(ctx context.Context) ([]*domain.DeadLetter, apistatus.Status) {
+	result := []*domain.DeadLetter{}
+	for id := int64(1); id <= 2; id++ {
+		if dl, ok := s.deadLetters[id]; ok {
+			result = append(result, dl)
+		}
+	}
+	return result, nil
+}
+
+func (s *dummyDeadLetterService) GetDeadLetter(ctx context.Context, id int64) (*domain.DeadLetter, apistatus.Status) {
+	dl, ok := s.deadLetters[id]
+	if !ok {
+		return nil, apistatus.New("dead letter not found").NotFound()
+	}
+	return dl, nil
+}
+
+func (s *dummyDeadLetterService) ReplayDeadLetter(ctx context.Context, id int64) apistatus.Status {
+	if _, ok := s.deadLetters[id]; !ok {
+		return apistatus.New("dead letter not found").NotFound()
+	}
+	s.replayed = append(s.replayed, id)
+	delete(s.deadLetters, id)
+	return nil
+}
+
+func (s *dummyDeadLetterService) DeleteDeadLetter(ctx context.Context, id int64) apistatus.Status {
+	if _, ok := s.deadLetters[id]; !ok {
+		return apistatus.New("dead letter not found").NotFound()
+	}
+	delete(s.deadLetters, id)
+	return nil
+}
+
+func (s *dummyDeadLetterService) PurgeDeadLetters(ctx context.Context) (int, apistatus.Status) {
+	n := len(s.deadLetters)
+	s.deadLetters = map[int64]*domain.DeadLetter{}
+	return n, nil
+}
+
+func deadLetterRequest(method, path, id string) *http.Request {
+	req := httptest.NewRequest(method, path, nil)
+	if id != "" {
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, newChiContext("deadLetterId", id)))
+	}
+	return req
+}
+
+// TestDeadLetterAdmin verifies listing, inspecting, replaying and purging dead letters.
+func TestDeadLetterAdmin(t *testing.T) {
+	handler := setupTestHandler()
+
+	rr := httptest.NewRecorder()
+	handler.ListDeadLetters(rr, deadLetterRequest("GET", "/admin/dead-letters", ""))
+	var deadLetters []*domain.DeadLetter
+	if err := json.NewDecoder(rr.Body).Decode(&deadLetters); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if rr.Code != http.StatusOK || len(deadLetters) != 2 {
+		t.Fatalf("expected 2 dead letters, got %d (status %d)", len(deadLetters), rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.GetDeadLetter(rr, deadLetterRequest("GET", "/admin/dead-letters/1", "1"))
+	var dl domain.DeadLetter
+	if err := json.NewDecoder(rr.Body).Decode(&dl); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if dl.RetryCount != 3 || dl.EventType != domain.EventTypeMessageSent {
+		t.Errorf("unexpected dead letter: %+v", dl)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ReplayDeadLetter(rr, deadLetterRequest("POST", "/admin/dead-letters/1/replay", "1"))
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected status code %d, got %d", http.StatusNoContent, rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	handler.GetDeadLetter(rr, deadLetterRequest("GET", "/admin/dead-letters/1", "1"))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected replayed dead letter to be gone, got status %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.PurgeDeadLetters(rr, deadLetterRequest("DELETE", "/admin/dead-letters", ""))
+	var purged PurgeDeadLettersResponse
+	if err := json.NewDecoder(rr.Body).Decode(&purged); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if purged.Purged != 1 {
+		t.Errorf("expected 1 purged dead letter, got %d", purged.Purged)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.DeleteDeadLetter(rr, deadLetterRequest("DELETE", "/admin/dead-letters/abc", "abc"))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status code %d for invalid ID, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
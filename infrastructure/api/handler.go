@@ -2,33 +2,82 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 
 	"messaging-app/application"
 	"messaging-app/domain"
+	"messaging-app/infrastructure/mq"
+	"messaging-app/infrastructure/realtime"
+	"messaging-app/pkg/apistatus"
 
 	"github.com/go-chi/chi/v5"
 )
 
 type Handler struct {
-	messageService application.MessageService
+	messageService    application.MessageService
+	userService       application.UserService
+	deadLetterService application.DeadLetterService
+	attachmentService application.AttachmentService
+	hub               *realtime.Hub
+	broker            mq.HealthChecker
 }
 
-func NewHandler(msgService application.MessageService) *Handler {
-	return &Handler{messageService: msgService}
+func NewHandler(msgService application.MessageService, userService application.UserService, deadLetterService application.DeadLetterService, attachmentService application.AttachmentService, hub *realtime.Hub, broker mq.HealthChecker) *Handler {
+	return &Handler{
+		messageService:    msgService,
+		userService:       userService,
+		deadLetterService: deadLetterService,
+		attachmentService: attachmentService,
+		hub:               hub,
+		broker:            broker,
+	}
 }
 
+// SendMessageRequest is the payload for sending a message. A non-zero
+// ReplyToMessageID quotes a message of the same chat; AttachmentIDs lists
+// files the sender uploaded to the chat.
 type SendMessageRequest struct {
-	ChatID   int64  `json:"chatId"`
+	ChatID           int64   `json:"chatId"`
+	SenderID         int64   `json:"senderId"`
+	Content          string  `json:"content"`
+	ReplyToMessageID int64   `json:"replyToMessageId"`
+	AttachmentIDs    []int64 `json:"attachmentIds"`
+}
+
+// CreateChatRequest defines the payload to create a chat. Direct chats use
+// the two participant fields; group chats set type "group", a title and
+// participantIds.
+type CreateChatRequest struct {
+	Type           domain.ChatType `json:"type"`
+	Title          string          `json:"title"`
+	Participant1ID int64           `json:"participant1Id"`
+	Participant2ID int64           `json:"participant2Id"`
+	ParticipantIDs []int64         `json:"participantIds"`
+}
+
+// AddChatMemberRequest is the payload for adding a member to a group chat.
+type AddChatMemberRequest struct {
+	UserID int64 `json:"userId"`
+}
+
+// MarkChatReadRequest is the payload for marking a chat read up to a message.
+type MarkChatReadRequest struct {
+	UserID    int64 `json:"userId"`
+	MessageID int64 `json:"messageId"`
+}
+
+// EditMessageRequest is the payload for editing a message. SenderID must be
+// the sender of the message.
+type EditMessageRequest struct {
 	SenderID int64  `json:"senderId"`
 	Content  string `json:"content"`
 }
 
-// CreateChatRequest defines the payload to create a chat.
-type CreateChatRequest struct {
-	Participant1ID int64 `json:"participant1Id"`
-	Participant2ID int64 `json:"participant2Id"`
+// ReactionRequest is the payload for reacting to a message.
+type ReactionRequest struct {
+	Emoji string `json:"emoji"`
 }
 
 // UpdateStatusRequest is the payload for updating a message status.
@@ -43,14 +92,28 @@ func (h *Handler) SendMessage(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	msg, apistatus := h.messageService.SendMessage(r.Context(), req.ChatID, req.SenderID, req.Content)
+	var msg *domain.Message
+	var apistatus apistatus.Status
+	switch {
+	case len(req.AttachmentIDs) > 0:
+		msg, apistatus = h.messageService.SendMessageWithAttachments(r.Context(), req.ChatID, req.SenderID, req.ReplyToMessageID, req.Content, req.AttachmentIDs)
+	case req.ReplyToMessageID != 0:
+		msg, apistatus = h.messageService.SendReply(r.Context(), req.ChatID, req.SenderID, req.ReplyToMessageID, req.Content)
+	default:
+		msg, apistatus = h.messageService.SendMessage(r.Context(), req.ChatID, req.SenderID, req.Content)
+	}
+	if apistatus != nil {
+		http.Error(w, apistatus.GetMessage(), apistatus.GetStatus())
+		return
+	}
+	described, apistatus := h.attachmentService.DescribeAttachments(r.Context(), req.SenderID, []*domain.Message{msg})
 	if apistatus != nil {
 		http.Error(w, apistatus.GetMessage(), apistatus.GetStatus())
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(msg)
+	json.NewEncoder(w).Encode(described[0])
 }
 
 func (h *Handler) CreateChat(w http.ResponseWriter, r *http.Request) {
@@ -59,7 +122,13 @@ func (h *Handler) CreateChat(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	chat, apistatus := h.messageService.CreateChat(r.Context(), req.Participant1ID, req.Participant2ID)
+	var chat *domain.Chat
+	var apistatus apistatus.Status
+	if req.Type == domain.ChatTypeGroup {
+		chat, apistatus = h.messageService.CreateGroupChat(r.Context(), req.Title, req.ParticipantIDs)
+	} else {
+		chat, apistatus = h.messageService.CreateChat(r.Context(), req.Participant1ID, req.Participant2ID)
+	}
 	if apistatus != nil {
 		http.Error(w, apistatus.GetMessage(), apistatus.GetStatus())
 		return
@@ -69,7 +138,51 @@ func (h *Handler) CreateChat(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(chat)
 }
 
-// GetChatMessages handles GET /chats/{chatId}/messages.
+// AddChatMember handles POST /chats/{chatId}/members.
+func (h *Handler) AddChatMember(w http.ResponseWriter, r *http.Request) {
+	chatID, err := strconv.ParseInt(chi.URLParam(r, "chatId"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid chatId", http.StatusBadRequest)
+		return
+	}
+	var req AddChatMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	chat, apistatus := h.messageService.AddChatMember(r.Context(), chatID, req.UserID)
+	if apistatus != nil {
+		http.Error(w, apistatus.GetMessage(), apistatus.GetStatus())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(chat)
+}
+
+// RemoveChatMember handles DELETE /chats/{chatId}/members/{userId}.
+func (h *Handler) RemoveChatMember(w http.ResponseWriter, r *http.Request) {
+	chatID, err := strconv.ParseInt(chi.URLParam(r, "chatId"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid chatId", http.StatusBadRequest)
+		return
+	}
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userId"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid userId", http.StatusBadRequest)
+		return
+	}
+	chat, apistatus := h.messageService.RemoveChatMember(r.Context(), chatID, userID)
+	if apistatus != nil {
+		http.Error(w, apistatus.GetMessage(), apistatus.GetStatus())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(chat)
+}
+
+// GetChatMessages handles GET /chats/{chatId}/messages?before=&after=&limit=.
 func (h *Handler) GetChatMessages(w http.ResponseWriter, r *http.Request) {
 	chatIDStr := chi.URLParam(r, "chatId")
 	chatID, err := strconv.ParseInt(chatIDStr, 10, 64)
@@ -77,14 +190,61 @@ func (h *Handler) GetChatMessages(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid chatId", http.StatusBadRequest)
 		return
 	}
-	messages, apistatus := h.messageService.GetMessages(r.Context(), chatID)
+	query, err := parseMessageQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page, apistatus := h.messageService.GetMessages(r.Context(), chatID, query)
 	if apistatus != nil {
 		http.Error(w, apistatus.GetMessage(), apistatus.GetStatus())
 		return
 	}
+	described, apistatus := h.attachmentService.DescribeAttachments(r.Context(), query.UserID, page.Messages)
+	if apistatus != nil {
+		http.Error(w, apistatus.GetMessage(), apistatus.GetStatus())
+		return
+	}
+	page.Messages = described
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(messages)
+	json.NewEncoder(w).Encode(page)
+}
+
+// parseMessageQuery reads the before, after, limit and userId query
+// parameters.
+func parseMessageQuery(r *http.Request) (domain.MessageQuery, error) {
+	var query domain.MessageQuery
+	values := r.URL.Query()
+	if v := values.Get("before"); v != "" {
+		cursor, err := domain.DecodeMessageCursor(v)
+		if err != nil {
+			return query, errors.New("Invalid before cursor")
+		}
+		query.Before = cursor
+	}
+	if v := values.Get("after"); v != "" {
+		cursor, err := domain.DecodeMessageCursor(v)
+		if err != nil {
+			return query, errors.New("Invalid after cursor")
+		}
+		query.After = cursor
+	}
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			return query, errors.New("Invalid limit")
+		}
+		query.Limit = limit
+	}
+	if v := values.Get("userId"); v != "" {
+		userID, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return query, errors.New("Invalid userId")
+		}
+		query.UserID = userID
+	}
+	return query, nil
 }
 
 // GetUserChats handles GET /users/{userId}/chats.
@@ -126,3 +286,196 @@ func (h *Handler) UpdateMessageStatus(w http.ResponseWriter, r *http.Request) {
 	}
 	w.WriteHeader(http.StatusOK)
 }
+
+// GetStatusHistory handles GET /messages/{messageId}/status/history.
+func (h *Handler) GetStatusHistory(w http.ResponseWriter, r *http.Request) {
+	messageID, err := strconv.ParseInt(chi.URLParam(r, "messageId"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+	history, apistatus := h.messageService.GetStatusHistory(r.Context(), messageID)
+	if apistatus != nil {
+		http.Error(w, apistatus.GetMessage(), apistatus.GetStatus())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(history)
+}
+
+// EditMessage handles PATCH /messages/{messageId}.
+func (h *Handler) EditMessage(w http.ResponseWriter, r *http.Request) {
+	messageID, err := strconv.ParseInt(chi.URLParam(r, "messageId"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+	var req EditMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	msg, apistatus := h.messageService.EditMessage(r.Context(), messageID, req.SenderID, req.Content)
+	if apistatus != nil {
+		http.Error(w, apistatus.GetMessage(), apistatus.GetStatus())
+		return
+	}
+	described, apistatus := h.attachmentService.DescribeAttachments(r.Context(), req.SenderID, []*domain.Message{msg})
+	if apistatus != nil {
+		http.Error(w, apistatus.GetMessage(), apistatus.GetStatus())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(described[0])
+}
+
+// GetMessageRevisions handles GET /messages/{messageId}/revisions.
+func (h *Handler) GetMessageRevisions(w http.ResponseWriter, r *http.Request) {
+	messageID, err := strconv.ParseInt(chi.URLParam(r, "messageId"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+	revisions, apistatus := h.messageService.GetMessageRevisions(r.Context(), messageID)
+	if apistatus != nil {
+		http.Error(w, apistatus.GetMessage(), apistatus.GetStatus())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(revisions)
+}
+
+// GetThread handles GET /messages/{messageId}/thread?userId=.
+func (h *Handler) GetThread(w http.ResponseWriter, r *http.Request) {
+	messageID, err := strconv.ParseInt(chi.URLParam(r, "messageId"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+	var userID int64
+	if v := r.URL.Query().Get("userId"); v != "" {
+		userID, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid userId", http.StatusBadRequest)
+			return
+		}
+	}
+	thread, apistatus := h.messageService.GetThread(r.Context(), messageID, userID)
+	if apistatus != nil {
+		http.Error(w, apistatus.GetMessage(), apistatus.GetStatus())
+		return
+	}
+	thread, apistatus = h.attachmentService.DescribeAttachments(r.Context(), userID, thread)
+	if apistatus != nil {
+		http.Error(w, apistatus.GetMessage(), apistatus.GetStatus())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(thread)
+}
+
+// DeleteMessage handles DELETE /messages/{messageId}?userId=&scope=. The
+// scope is "me" unless set to "everyone".
+func (h *Handler) DeleteMessage(w http.ResponseWriter, r *http.Request) {
+	messageID, err := strconv.ParseInt(chi.URLParam(r, "messageId"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+	userID, err := strconv.ParseInt(r.URL.Query().Get("userId"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid userId", http.StatusBadRequest)
+		return
+	}
+	scope := domain.DeleteForMe
+	if v := r.URL.Query().Get("scope"); v != "" {
+		scope = domain.DeletionScope(v)
+	}
+	apistatus := h.messageService.DeleteMessage(r.Context(), messageID, userID, scope)
+	if apistatus != nil {
+		http.Error(w, apistatus.GetMessage(), apistatus.GetStatus())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseReactionParams reads the messageId and userId path parameters.
+func parseReactionParams(r *http.Request) (messageID, userID int64, err error) {
+	messageID, err = strconv.ParseInt(chi.URLParam(r, "messageId"), 10, 64)
+	if err != nil {
+		return 0, 0, errors.New("Invalid message ID")
+	}
+	userID, err = strconv.ParseInt(chi.URLParam(r, "userId"), 10, 64)
+	if err != nil {
+		return 0, 0, errors.New("Invalid userId")
+	}
+	return messageID, userID, nil
+}
+
+// ReactToMessage handles PUT /messages/{messageId}/reactions/{userId}.
+func (h *Handler) ReactToMessage(w http.ResponseWriter, r *http.Request) {
+	messageID, userID, err := parseReactionParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var req ReactionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	msg, apistatus := h.messageService.ReactToMessage(r.Context(), messageID, userID, req.Emoji)
+	if apistatus != nil {
+		http.Error(w, apistatus.GetMessage(), apistatus.GetStatus())
+		return
+	}
+	described, apistatus := h.attachmentService.DescribeAttachments(r.Context(), userID, []*domain.Message{msg})
+	if apistatus != nil {
+		http.Error(w, apistatus.GetMessage(), apistatus.GetStatus())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(described[0])
+}
+
+// RemoveReaction handles DELETE /messages/{messageId}/reactions/{userId}.
+func (h *Handler) RemoveReaction(w http.ResponseWriter, r *http.Request) {
+	messageID, userID, err := parseReactionParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	apistatus := h.messageService.RemoveReaction(r.Context(), messageID, userID)
+	if apistatus != nil {
+		http.Error(w, apistatus.GetMessage(), apistatus.GetStatus())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// MarkChatRead handles POST /chats/{chatId}/read.
+func (h *Handler) MarkChatRead(w http.ResponseWriter, r *http.Request) {
+	chatID, err := strconv.ParseInt(chi.URLParam(r, "chatId"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid chatId", http.StatusBadRequest)
+		return
+	}
+	var req MarkChatReadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	read, apistatus := h.messageService.MarkChatRead(r.Context(), chatID, req.UserID, req.MessageID)
+	if apistatus != nil {
+		http.Error(w, apistatus.GetMessage(), apistatus.GetStatus())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(read)
+}
@@ -7,10 +7,13 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"messaging-app/domain"
+	"messaging-app/infrastructure/mq"
+	"messaging-app/infrastructure/realtime"
 	"messaging-app/pkg/apistatus"
 
 	"github.com/go-chi/chi/v5"
@@ -35,9 +38,37 @@ func (s *dummyService) SendMessage(ctx context.Context, chatID, senderID int64,
 	}, nil
 }
 
-// GetMessages returns a dummy list of messages.
-func (s *dummyService) GetMessages(ctx context.Context, chatID int64) ([]*domain.Message, apistatus.Status) {
-	return []*domain.Message{
+// SendReply replies to one of the two messages of chat 1.
+func (s *dummyService) SendReply(ctx context.Context, chatID, senderID, replyToMessageID int64, content string) (*domain.Message, apistatus.Status) {
+	if replyToMessageID != 1 && replyToMessageID != 2 {
+		return nil, apistatus.New("message %d is not part of the chat", replyToMessageID).UnprocessableEntity()
+	}
+	msg, as := s.SendMessage(ctx, chatID, senderID, content)
+	if as != nil {
+		return nil, as
+	}
+	msg.ID = 3
+	msg.ReplyToMessageID = replyToMessageID
+	return msg, nil
+}
+
+// SendMessageWithAttachments sends attachment 1, the only one uploaded.
+func (s *dummyService) SendMessageWithAttachments(ctx context.Context, chatID, senderID, replyToMessageID int64, content string, attachmentIDs []int64) (*domain.Message, apistatus.Status) {
+	if len(attachmentIDs) != 1 || attachmentIDs[0] != 1 {
+		return nil, apistatus.New("attachments were not uploaded to the chat by the sender").UnprocessableEntity()
+	}
+	msg, as := s.SendMessage(ctx, chatID, senderID, content)
+	if as != nil {
+		return nil, as
+	}
+	msg.ReplyToMessageID = replyToMessageID
+	msg.AttachmentIDs = attachmentIDs
+	return msg, nil
+}
+
+// GetMessages returns a dummy page of messages.
+func (s *dummyService) GetMessages(ctx context.Context, chatID int64, query domain.MessageQuery) (*domain.MessagePage, apistatus.Status) {
+	messages := []*domain.Message{
 		{
 			ID:        1,
 			ChatID:    chatID,
@@ -54,24 +85,30 @@ func (s *dummyService) GetMessages(ctx context.Context, chatID int64) ([]*domain
 			Timestamp: time.Now().UTC(),
 			Status:    domain.MessageStatusDelivered,
 		},
-	}, nil
+	}
+	page := &domain.MessagePage{Messages: messages}
+	if query.Limit == 1 {
+		page.Messages = messages[1:]
+		page.NextCursor = domain.CursorFor(messages[1]).Encode()
+	}
+	return page, nil
 }
 
 // ListChatsForUser returns chats for the given user.
-func (s *dummyService) ListChatsForUser(ctx context.Context, userID int64) ([]*domain.Chat, apistatus.Status) {
+func (s *dummyService) ListChatsForUser(ctx context.Context, userID int64) ([]*domain.ChatSummary, apistatus.Status) {
 	// Simulate that user with ID 999 has no chats.
 	if userID == 999 {
 		return nil, apistatus.New("user has no chats").NotFound()
 	}
-	return []*domain.Chat{
-		{
-			ID:             1,
-			Participant1ID: userID,
-			Participant2ID: 2,
-			Metadata:       "Test chat between user " + strconv.FormatInt(userID, 10) + " and user 2",
-			CreatedAt:      time.Now(),
-		},
-	}, nil
+	chat := &domain.Chat{
+		ID:             1,
+		Participant1ID: userID,
+		Participant2ID: 2,
+		Metadata:       "Test chat between user " + strconv.FormatInt(userID, 10) + " and user 2",
+		CreatedAt:      time.Now(),
+	}
+	last := &domain.Message{ID: 2, ChatID: 1, SenderID: 2, Content: "Second test message", Timestamp: time.Now(), Status: domain.MessageStatusSent}
+	return []*domain.ChatSummary{domain.NewChatSummary(chat, last, 1)}, nil
 }
 
 // UpdateMessageStatus updates a message's status.
@@ -80,10 +117,116 @@ func (s *dummyService) UpdateMessageStatus(ctx context.Context, messageID int64,
 	if messageID != 1 {
 		return apistatus.New("message does not exist").NotFound()
 	}
-	// Otherwise, simulate success.
+	// Message 1 has been delivered, so it can only be read.
+	if status != domain.MessageStatusDelivered && status != domain.MessageStatusRead {
+		return apistatus.New("cannot change message status from delivered to %s", status).Conflict()
+	}
+	return nil
+}
+
+// GetStatusHistory returns the history of message 1.
+func (s *dummyService) GetStatusHistory(ctx context.Context, messageID int64) ([]*domain.StatusTransition, apistatus.Status) {
+	if messageID != 1 {
+		return nil, apistatus.New("message not found").NotFound()
+	}
+	return []*domain.StatusTransition{
+		{MessageID: 1, From: domain.MessageStatusSent, To: domain.MessageStatusDelivered, At: time.Now()},
+		{MessageID: 1, From: domain.MessageStatusDelivered, To: domain.MessageStatusRead, At: time.Now()},
+	}, nil
+}
+
+// EditMessage edits message 1, which user 1 sent.
+func (s *dummyService) EditMessage(ctx context.Context, messageID, senderID int64, content string) (*domain.Message, apistatus.Status) {
+	if messageID != 1 {
+		return nil, apistatus.New("message not found").NotFound()
+	}
+	if senderID != 1 {
+		return nil, apistatus.New("only the sender can edit a message").Forbidden()
+	}
+	editedAt := time.Now()
+	return &domain.Message{ID: 1, ChatID: 1, SenderID: 1, Content: content, Timestamp: time.Now(), Status: domain.MessageStatusSent, AttachmentIDs: []int64{1}, EditedAt: &editedAt}, nil
+}
+
+// GetMessageRevisions returns the single earlier content of message 1.
+func (s *dummyService) GetMessageRevisions(ctx context.Context, messageID int64) ([]*domain.MessageRevision, apistatus.Status) {
+	if messageID != 1 {
+		return nil, apistatus.New("message not found").NotFound()
+	}
+	return []*domain.MessageRevision{{MessageID: 1, Content: "First tset message", CreatedAt: time.Now(), ReplacedAt: time.Now()}}, nil
+}
+
+// ReactToMessage lets users 1 and 2 react to message 1.
+func (s *dummyService) ReactToMessage(ctx context.Context, messageID, userID int64, emoji string) (*domain.Message, apistatus.Status) {
+	if !domain.IsValidReaction(emoji) {
+		return nil, apistatus.New("invalid reaction").UnprocessableEntity()
+	}
+	if messageID != 1 {
+		return nil, apistatus.New("message not found").NotFound()
+	}
+	if userID != 1 && userID != 2 {
+		return nil, apistatus.New("user is not a participant of the chat").UnprocessableEntity()
+	}
+	return &domain.Message{
+		ID: 1, ChatID: 1, SenderID: 1, Content: "First test message", Timestamp: time.Now(), Status: domain.MessageStatusRead, AttachmentIDs: []int64{1},
+		Reactions: []domain.ReactionCount{{Emoji: emoji, Count: 1, UserIDs: []int64{userID}}},
+	}, nil
+}
+
+// RemoveReaction removes the reaction user 2 left on message 1.
+func (s *dummyService) RemoveReaction(ctx context.Context, messageID, userID int64) apistatus.Status {
+	if messageID != 1 || userID != 2 {
+		return apistatus.New("reaction not found").NotFound()
+	}
+	return nil
+}
+
+// GetThread returns message 1 and its single reply.
+func (s *dummyService) GetThread(ctx context.Context, messageID, userID int64) ([]*domain.Message, apistatus.Status) {
+	if messageID != 1 {
+		return nil, apistatus.New("message not found").NotFound()
+	}
+	return []*domain.Message{
+		{ID: 1, ChatID: 1, SenderID: 1, Content: "First test message", Timestamp: time.Now(), Status: domain.MessageStatusRead, ReplyCount: 1},
+		{ID: 2, ChatID: 1, SenderID: 2, Content: "Second test message", Timestamp: time.Now(), Status: domain.MessageStatusSent, ReplyToMessageID: 1},
+	}, nil
+}
+
+// DeleteMessage deletes message 1, which user 1 sent.
+func (s *dummyService) DeleteMessage(ctx context.Context, messageID, userID int64, scope domain.DeletionScope) apistatus.Status {
+	if !scope.IsValid() {
+		return apistatus.New("invalid deletion scope").UnprocessableEntity()
+	}
+	if messageID != 1 {
+		return apistatus.New("message not found").NotFound()
+	}
+	if scope == domain.DeleteForEveryone && userID != 1 {
+		return apistatus.New("only the sender can delete a message for everyone").Forbidden()
+	}
+	return nil
+}
+
+// MarkMessageDelivered records a delivery of message 1.
+func (s *dummyService) MarkMessageDelivered(ctx context.Context, messageID int64, userIDs []int64) apistatus.Status {
+	if messageID != 1 {
+		return apistatus.New("message does not exist").NotFound()
+	}
 	return nil
 }
 
+// MarkChatRead marks chat 1 read; user 1 has read it already.
+func (s *dummyService) MarkChatRead(ctx context.Context, chatID, userID, upToMessageID int64) (*domain.ChatRead, apistatus.Status) {
+	if chatID != 1 {
+		return nil, apistatus.New("chat not found").NotFound()
+	}
+	read := &domain.ChatRead{ChatID: chatID, UserID: userID, UpToMessageID: upToMessageID, ReadAt: time.Now(), MessageIDs: []int64{}}
+	if userID != 1 {
+		for id := int64(1); id <= upToMessageID; id++ {
+			read.MessageIDs = append(read.MessageIDs, id)
+		}
+	}
+	return read, nil
+}
+
 // CreateChat creates a chat if the participants are different.
 func (s *dummyService) CreateChat(ctx context.Context, participant1ID, participant2ID int64) (*domain.Chat, apistatus.Status) {
 	if participant1ID == participant2ID {
@@ -98,10 +241,59 @@ func (s *dummyService) CreateChat(ctx context.Context, participant1ID, participa
 	}, nil
 }
 
+// CreateGroupChat creates a group chat if a title is given.
+func (s *dummyService) CreateGroupChat(ctx context.Context, title string, participantIDs []int64) (*domain.Chat, apistatus.Status) {
+	if title == "" {
+		return nil, apistatus.New("group chats require a title").UnprocessableEntity()
+	}
+	return &domain.Chat{
+		ID:             2,
+		Type:           domain.ChatTypeGroup,
+		Title:          title,
+		ParticipantIDs: participantIDs,
+		CreatedAt:      time.Now(),
+	}, nil
+}
+
+// AddChatMember adds a member to group chat 2.
+func (s *dummyService) AddChatMember(ctx context.Context, chatID, userID int64) (*domain.Chat, apistatus.Status) {
+	if chatID != 2 {
+		return nil, apistatus.New("chat not found").NotFound()
+	}
+	return &domain.Chat{
+		ID:             2,
+		Type:           domain.ChatTypeGroup,
+		Title:          "Team",
+		ParticipantIDs: []int64{1, 2, 3, userID},
+	}, nil
+}
+
+// RemoveChatMember removes a member from group chat 2.
+func (s *dummyService) RemoveChatMember(ctx context.Context, chatID, userID int64) (*domain.Chat, apistatus.Status) {
+	if chatID != 2 {
+		return nil, apistatus.New("chat not found").NotFound()
+	}
+	return &domain.Chat{
+		ID:             2,
+		Type:           domain.ChatTypeGroup,
+		Title:          "Team",
+		ParticipantIDs: []int64{1, 2},
+	}, nil
+}
+
+// GetMissedMessages returns the messages of chat 1 after lastMessageID (there are two).
+func (s *dummyService) GetMissedMessages(ctx context.Context, userID, lastMessageID int64) ([]*domain.Message, apistatus.Status) {
+	var messages []*domain.Message
+	for id := lastMessageID + 1; id <= 2; id++ {
+		messages = append(messages, &domain.Message{ID: id, ChatID: 1, SenderID: 2, Content: "Missed", Status: domain.MessageStatusSent})
+	}
+	return messages, nil
+}
+
 // setupTestHandler creates an API handler using the dummyService.
 func setupTestHandler() *Handler {
 	svc := &dummyService{}
-	return NewHandler(svc)
+	return NewHandler(svc, newDummyUserService(), newDummyDeadLetterService(), &dummyAttachmentService{}, realtime.NewHub(16), stubBroker(mq.StateConnected))
 }
 
 // newChiContext helps set URL parameters in the request context.
@@ -141,6 +333,68 @@ func TestCreateChat(t *testing.T) {
 	}
 }
 
+// TestCreateGroupChat verifies that the CreateChat endpoint creates group chats.
+func TestCreateGroupChat(t *testing.T) {
+	handler := setupTestHandler()
+
+	reqBody := `{"type": "group", "title": "Team", "participantIds": [1, 2, 3]}`
+	req := httptest.NewRequest("POST", "/chats", bytes.NewBufferString(reqBody))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+
+	handler.CreateChat(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status code %d, got %d", http.StatusCreated, rr.Code)
+	}
+	var chat domain.Chat
+	if err := json.NewDecoder(rr.Body).Decode(&chat); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if !chat.IsGroup() || chat.Title != "Team" || len(chat.ParticipantIDs) != 3 {
+		t.Errorf("unexpected group chat: %+v", chat)
+	}
+}
+
+// TestChatMembers verifies the add and remove member endpoints.
+func TestChatMembers(t *testing.T) {
+	handler := setupTestHandler()
+
+	req := httptest.NewRequest("POST", "/chats/2/members", bytes.NewBufferString(`{"userId": 4}`))
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, newChiContext("chatId", "2")))
+	rr := httptest.NewRecorder()
+	handler.AddChatMember(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+	}
+	var chat domain.Chat
+	if err := json.NewDecoder(rr.Body).Decode(&chat); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if !chat.HasParticipant(4) {
+		t.Errorf("expected user 4 to be a member, got %v", chat.ParticipantIDs)
+	}
+
+	rctx := newChiContext("chatId", "2")
+	rctx.URLParams.Add("userId", "3")
+	req = httptest.NewRequest("DELETE", "/chats/2/members/3", nil)
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	rr = httptest.NewRecorder()
+	handler.RemoveChatMember(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	// Unknown chat.
+	req = httptest.NewRequest("POST", "/chats/9/members", bytes.NewBufferString(`{"userId": 4}`))
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, newChiContext("chatId", "9")))
+	rr = httptest.NewRecorder()
+	handler.AddChatMember(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
 // TestSendMessage_ValidChat verifies sending a message with a valid chat.
 func TestSendMessage_ValidChat(t *testing.T) {
 	handler := setupTestHandler()
@@ -183,6 +437,40 @@ func TestSendMessage_InvalidChat(t *testing.T) {
 	}
 }
 
+// TestSendMessage_Reply verifies that a replyToMessageId sends a reply.
+func TestSendMessage_Reply(t *testing.T) {
+	handler := setupTestHandler()
+
+	tests := []struct {
+		name     string
+		body     string
+		expected int
+	}{
+		{"reply", `{"chatId": 1, "senderId": 1, "content": "Agreed", "replyToMessageId": 2}`, http.StatusCreated},
+		{"message of another chat", `{"chatId": 1, "senderId": 1, "content": "Agreed", "replyToMessageId": 7}`, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/messages", bytes.NewBufferString(tt.body))
+			rr := httptest.NewRecorder()
+			handler.SendMessage(rr, req)
+			if rr.Code != tt.expected {
+				t.Fatalf("expected status code %d, got %d", tt.expected, rr.Code)
+			}
+			if rr.Code != http.StatusCreated {
+				return
+			}
+			var msg domain.Message
+			if err := json.NewDecoder(rr.Body).Decode(&msg); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if msg.ReplyToMessageID != 2 {
+				t.Errorf("expected a reply to message 2, got %+v", msg)
+			}
+		})
+	}
+}
+
 // TestGetChatMessages verifies that the GetChatMessages endpoint returns the expected messages.
 func TestGetChatMessages(t *testing.T) {
 	handler := setupTestHandler()
@@ -198,12 +486,44 @@ func TestGetChatMessages(t *testing.T) {
 		t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
 	}
 
-	var messages []*domain.Message
-	if err := json.NewDecoder(rr.Body).Decode(&messages); err != nil {
+	var page domain.MessagePage
+	if err := json.NewDecoder(rr.Body).Decode(&page); err != nil {
 		t.Fatalf("failed to decode response: %v", err)
 	}
-	if len(messages) != 2 {
-		t.Errorf("expected 2 messages, got %d", len(messages))
+	if len(page.Messages) != 2 {
+		t.Errorf("expected 2 messages, got %d", len(page.Messages))
+	}
+	if page.NextCursor != "" {
+		t.Errorf("expected no next cursor, got %q", page.NextCursor)
+	}
+}
+
+// TestGetChatMessages_Pagination verifies that limit and cursors are passed through.
+func TestGetChatMessages_Pagination(t *testing.T) {
+	handler := setupTestHandler()
+
+	req := httptest.NewRequest("GET", "/chats/1/messages?limit=1", nil)
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, newChiContext("chatId", "1")))
+	rr := httptest.NewRecorder()
+	handler.GetChatMessages(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+	}
+	var page domain.MessagePage
+	if err := json.NewDecoder(rr.Body).Decode(&page); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(page.Messages) != 1 || page.NextCursor == "" {
+		t.Errorf("expected 1 message and a next cursor, got %+v", page)
+	}
+
+	// A malformed cursor is rejected.
+	req = httptest.NewRequest("GET", "/chats/1/messages?before=garbage!", nil)
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, newChiContext("chatId", "1")))
+	rr = httptest.NewRecorder()
+	handler.GetChatMessages(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
 	}
 }
 
@@ -223,16 +543,19 @@ func TestGetUserChats(t *testing.T) {
 		t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
 	}
 
-	var chats []*domain.Chat
+	var chats []*domain.ChatSummary
 	if err := json.NewDecoder(rr.Body).Decode(&chats); err != nil {
 		t.Fatalf("failed to decode response: %v", err)
 	}
 	if len(chats) != 1 {
-		t.Errorf("expected 1 chat, got %d", len(chats))
+		t.Fatalf("expected 1 chat, got %d", len(chats))
 	}
 	if chats[0].Participant1ID != 1 {
 		t.Errorf("expected Participant1ID to be 1, got %d", chats[0].Participant1ID)
 	}
+	if chats[0].UnreadCount != 1 || chats[0].LastMessage == nil || chats[0].LastMessage.ID != 2 {
+		t.Errorf("expected the last message and unread count, got %+v", chats[0])
+	}
 
 	// Error case: user 999 has no chats.
 	reqNoChats := httptest.NewRequest("GET", "/users/999/chats", nil)
@@ -275,4 +598,279 @@ func TestUpdateMessageStatus(t *testing.T) {
 	if rr2.Code == http.StatusNoContent {
 		t.Errorf("expected error when updating non-existent message, but got status %d", rr2.Code)
 	}
+
+	// Error case: illegal transition.
+	req3 := httptest.NewRequest("PUT", "/messages/1/status", bytes.NewBufferString(`{"status": "sent"}`))
+	req3 = req3.WithContext(context.WithValue(req3.Context(), chi.RouteCtxKey, newChiContext("messageId", "1")))
+	rr3 := httptest.NewRecorder()
+	handler.UpdateMessageStatus(rr3, req3)
+	if rr3.Code != http.StatusConflict {
+		t.Errorf("expected status code %d for an illegal transition, got %d", http.StatusConflict, rr3.Code)
+	}
+}
+
+// TestGetStatusHistory verifies that GetStatusHistory returns the transitions of a message.
+func TestGetStatusHistory(t *testing.T) {
+	handler := setupTestHandler()
+
+	req := httptest.NewRequest("GET", "/messages/1/status/history", nil)
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, newChiContext("messageId", "1")))
+	rr := httptest.NewRecorder()
+	handler.GetStatusHistory(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+	}
+	var history []domain.StatusTransition
+	if err := json.NewDecoder(rr.Body).Decode(&history); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(history) != 2 || history[1].To != domain.MessageStatusRead {
+		t.Errorf("unexpected history %+v", history)
+	}
+
+	req = httptest.NewRequest("GET", "/messages/2/status/history", nil)
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, newChiContext("messageId", "2")))
+	rr = httptest.NewRecorder()
+	handler.GetStatusHistory(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status code %d for unknown message, got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+// TestEditMessage verifies that only the sender can edit a message and that
+// its revisions can be listed.
+func TestEditMessage(t *testing.T) {
+	handler := setupTestHandler()
+
+	tests := []struct {
+		name      string
+		messageID string
+		payload   string
+		expected  int
+	}{
+		{"sender", "1", `{"senderId": 1, "content": "First test message"}`, http.StatusOK},
+		{"not the sender", "1", `{"senderId": 2, "content": "Hijacked"}`, http.StatusForbidden},
+		{"unknown message", "2", `{"senderId": 1, "content": "Hello"}`, http.StatusNotFound},
+		{"invalid ID", "abc", `{"senderId": 1, "content": "Hello"}`, http.StatusBadRequest},
+		{"invalid payload", "1", `{`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("PATCH", "/messages/"+tt.messageID, bytes.NewBufferString(tt.payload))
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, newChiContext("messageId", tt.messageID)))
+			rr := httptest.NewRecorder()
+			handler.EditMessage(rr, req)
+			if rr.Code != tt.expected {
+				t.Fatalf("expected status code %d, got %d", tt.expected, rr.Code)
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+			var msg domain.Message
+			if err := json.NewDecoder(rr.Body).Decode(&msg); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if msg.Content != "First test message" || !msg.IsEdited() {
+				t.Errorf("unexpected edited message %+v", msg)
+			}
+			if len(msg.Attachments) != 1 || !strings.Contains(msg.Attachments[0].URL, "userId=1") {
+				t.Errorf("expected the attachment with a URL for the sender, got %+v", msg.Attachments)
+			}
+		})
+	}
+
+	req := httptest.NewRequest("GET", "/messages/1/revisions", nil)
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, newChiContext("messageId", "1")))
+	rr := httptest.NewRecorder()
+	handler.GetMessageRevisions(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+	}
+	var revisions []domain.MessageRevision
+	if err := json.NewDecoder(rr.Body).Decode(&revisions); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(revisions) != 1 || revisions[0].Content != "First tset message" {
+		t.Errorf("unexpected revisions %+v", revisions)
+	}
+}
+
+// TestDeleteMessage verifies the responses of DeleteMessage for both scopes.
+func TestDeleteMessage(t *testing.T) {
+	handler := setupTestHandler()
+
+	tests := []struct {
+		name      string
+		messageID string
+		query     string
+		expected  int
+	}{
+		{"for me by default", "1", "userId=2", http.StatusNoContent},
+		{"for everyone by the sender", "1", "userId=1&scope=everyone", http.StatusNoContent},
+		{"for everyone by someone else", "1", "userId=2&scope=everyone", http.StatusForbidden},
+		{"unknown scope", "1", "userId=1&scope=nobody", http.StatusUnprocessableEntity},
+		{"unknown message", "2", "userId=1", http.StatusNotFound},
+		{"missing userId", "1", "", http.StatusBadRequest},
+		{"invalid ID", "abc", "userId=1", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("DELETE", "/messages/"+tt.messageID+"?"+tt.query, nil)
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, newChiContext("messageId", tt.messageID)))
+			rr := httptest.NewRecorder()
+			handler.DeleteMessage(rr, req)
+			if rr.Code != tt.expected {
+				t.Errorf("expected status code %d, got %d", tt.expected, rr.Code)
+			}
+		})
+	}
+}
+
+// TestGetThread verifies that GetThread returns a message and its replies.
+func TestGetThread(t *testing.T) {
+	handler := setupTestHandler()
+
+	tests := []struct {
+		name      string
+		messageID string
+		query     string
+		expected  int
+	}{
+		{"thread", "1", "", http.StatusOK},
+		{"for a user", "1", "?userId=2", http.StatusOK},
+		{"unknown message", "2", "", http.StatusNotFound},
+		{"invalid userId", "1", "?userId=abc", http.StatusBadRequest},
+		{"invalid ID", "abc", "", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/messages/"+tt.messageID+"/thread"+tt.query, nil)
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, newChiContext("messageId", tt.messageID)))
+			rr := httptest.NewRecorder()
+			handler.GetThread(rr, req)
+			if rr.Code != tt.expected {
+				t.Fatalf("expected status code %d, got %d", tt.expected, rr.Code)
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+			var thread []*domain.Message
+			if err := json.NewDecoder(rr.Body).Decode(&thread); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if len(thread) != 2 || thread[0].ReplyCount != 1 || thread[1].ReplyToMessageID != 1 {
+				t.Errorf("unexpected thread %+v", thread)
+			}
+		})
+	}
+}
+
+// newReactionContext sets the messageId and userId URL parameters.
+func newReactionContext(messageID, userID string) *chi.Context {
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("messageId", messageID)
+	rctx.URLParams.Add("userId", userID)
+	return rctx
+}
+
+// TestReactToMessage verifies that ReactToMessage returns the message with
+// its reaction counts.
+func TestReactToMessage(t *testing.T) {
+	handler := setupTestHandler()
+
+	tests := []struct {
+		name      string
+		messageID string
+		userID    string
+		body      string
+		expected  int
+	}{
+		{"reaction", "1", "2", `{"emoji": "👍"}`, http.StatusOK},
+		{"not a participant", "1", "3", `{"emoji": "👍"}`, http.StatusUnprocessableEntity},
+		{"invalid reaction", "1", "2", `{"emoji": ""}`, http.StatusUnprocessableEntity},
+		{"unknown message", "2", "2", `{"emoji": "👍"}`, http.StatusNotFound},
+		{"invalid body", "1", "2", `{`, http.StatusBadRequest},
+		{"invalid userId", "1", "abc", `{"emoji": "👍"}`, http.StatusBadRequest},
+		{"invalid ID", "abc", "2", `{"emoji": "👍"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("PUT", "/messages/"+tt.messageID+"/reactions/"+tt.userID, bytes.NewBufferString(tt.body))
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, newReactionContext(tt.messageID, tt.userID)))
+			rr := httptest.NewRecorder()
+			handler.ReactToMessage(rr, req)
+			if rr.Code != tt.expected {
+				t.Fatalf("expected status code %d, got %d", tt.expected, rr.Code)
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+			var msg domain.Message
+			if err := json.NewDecoder(rr.Body).Decode(&msg); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if len(msg.Reactions) != 1 || msg.Reactions[0].Emoji != "👍" || msg.Reactions[0].Count != 1 {
+				t.Errorf("unexpected reactions %+v", msg.Reactions)
+			}
+			if len(msg.Attachments) != 1 || !strings.Contains(msg.Attachments[0].URL, "userId="+tt.userID) {
+				t.Errorf("expected the attachment with a URL for user %s, got %+v", tt.userID, msg.Attachments)
+			}
+		})
+	}
+}
+
+// TestRemoveReaction verifies that RemoveReaction answers 204 No Content.
+func TestRemoveReaction(t *testing.T) {
+	handler := setupTestHandler()
+
+	tests := []struct {
+		name      string
+		messageID string
+		userID    string
+		expected  int
+	}{
+		{"reaction", "1", "2", http.StatusNoContent},
+		{"no reaction", "1", "1", http.StatusNotFound},
+		{"invalid userId", "1", "abc", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("DELETE", "/messages/"+tt.messageID+"/reactions/"+tt.userID, nil)
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, newReactionContext(tt.messageID, tt.userID)))
+			rr := httptest.NewRecorder()
+			handler.RemoveReaction(rr, req)
+			if rr.Code != tt.expected {
+				t.Errorf("expected status code %d, got %d", tt.expected, rr.Code)
+			}
+		})
+	}
+}
+
+// TestMarkChatRead verifies that MarkChatRead returns the messages it marked.
+func TestMarkChatRead(t *testing.T) {
+	handler := setupTestHandler()
+
+	req := httptest.NewRequest("POST", "/chats/1/read", bytes.NewBufferString(`{"userId": 2, "messageId": 2}`))
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, newChiContext("chatId", "1")))
+	rr := httptest.NewRecorder()
+	handler.MarkChatRead(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+	}
+	var read domain.ChatRead
+	if err := json.NewDecoder(rr.Body).Decode(&read); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if read.UserID != 2 || read.UpToMessageID != 2 || len(read.MessageIDs) != 2 {
+		t.Errorf("unexpected read marker %+v", read)
+	}
+
+	// Unknown chat.
+	req = httptest.NewRequest("POST", "/chats/9/read", bytes.NewBufferString(`{"userId": 2, "messageId": 2}`))
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, newChiContext("chatId", "9")))
+	rr = httptest.NewRecorder()
+	handler.MarkChatRead(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status code %d for unknown chat, got %d", http.StatusNotFound, rr.Code)
+	}
 }
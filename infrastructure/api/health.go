@@ -0,0 +1,28 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"messaging-app/infrastructure/mq"
+)
+
+// HealthResponse reports the service status and its broker connection.
+type HealthResponse struct {
+	Status   string             `json:"status"`
+	RabbitMQ mq.ConnectionState `json:"rabbitmq"`
+}
+
+// Health handles GET /health. It returns 503 while the broker connection is
+// down so load balancers can take the instance out of rotation.
+func (h *Handler) Health(w http.ResponseWriter, r *http.Request) {
+	resp := HealthResponse{Status: "ok", RabbitMQ: h.broker.State()}
+	code := http.StatusOK
+	if resp.RabbitMQ != mq.StateConnected {
+		resp.Status = "degraded"
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(resp)
+}
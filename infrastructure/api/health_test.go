@@ -0,0 +1,43 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"messaging-app/infrastructure/mq"
+	"messaging-app/infrastructure/realtime"
+)
+
+// stubBroker reports a fixed connection state.
+type stubBroker mq.ConnectionState
+
+func (s stubBroker) State() mq.ConnectionState { return mq.ConnectionState(s) }
+
+func TestHealth(t *testing.T) {
+	tests := []struct {
+		state      mq.ConnectionState
+		wantCode   int
+		wantStatus string
+	}{
+		{mq.StateConnected, http.StatusOK, "ok"},
+		{mq.StateReconnecting, http.StatusServiceUnavailable, "degraded"},
+	}
+	for _, tt := range tests {
+		handler := NewHandler(&dummyService{}, newDummyUserService(), newDummyDeadLetterService(), &dummyAttachmentService{}, realtime.NewHub(16), stubBroker(tt.state))
+		rr := httptest.NewRecorder()
+		handler.Health(rr, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+		if rr.Code != tt.wantCode {
+			t.Errorf("%s: expected status %d, got %d", tt.state, tt.wantCode, rr.Code)
+		}
+		var resp HealthResponse
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if resp.Status != tt.wantStatus || resp.RabbitMQ != tt.state {
+			t.Errorf("%s: unexpected response %+v", tt.state, resp)
+		}
+	}
+}
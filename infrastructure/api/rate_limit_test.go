@@ -7,13 +7,15 @@ import (
 	"testing"
 
 	"messaging-app/config"
+	"messaging-app/infrastructure/mq"
+	"messaging-app/infrastructure/realtime"
 )
 
 func TestRateLimitingIntegration(t *testing.T) {
 	// Create a dummy service.
 	ds := &dummyService{}
 	// Create the API handler using the dummy service.
-	handler := NewHandler(ds)
+	handler := NewHandler(ds, newDummyUserService(), newDummyDeadLetterService(), &dummyAttachmentService{}, realtime.NewHub(16), stubBroker(mq.StateConnected))
 
 	// Create a dummy configuration with auth and rate limit settings.
 	testConfig := &config.Config{
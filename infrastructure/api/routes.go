@@ -15,14 +15,45 @@ import (
 func NewRouter(handler *Handler, conf *config.Config) *chi.Mux {
 	r := chi.NewRouter()
 
+	r.Use(middleware.CorrelationIDMiddleware)
 	r.Use(middleware.BasicAuthMiddleware(conf.AuthUsername, conf.AuthPassword))
 	r.Use(httprate.LimitByIP(conf.RateLimit, time.Minute))
 
 	r.Post("/messages", handler.SendMessage)
 	r.Post("/chats", handler.CreateChat)
+	r.Post("/chats/{chatId}/members", handler.AddChatMember)
+	r.Delete("/chats/{chatId}/members/{userId}", handler.RemoveChatMember)
 	r.Get("/chats/{chatId}/messages", handler.GetChatMessages)
+	r.Post("/chats/{chatId}/read", handler.MarkChatRead)
 	r.Get("/users/{userId}/chats", handler.GetUserChats)
+	r.Get("/users/{userId}/events", handler.StreamUserEvents)
 	r.Put("/messages/{messageId}/status", handler.UpdateMessageStatus)
+	r.Get("/messages/{messageId}/status/history", handler.GetStatusHistory)
+	r.Patch("/messages/{messageId}", handler.EditMessage)
+	r.Delete("/messages/{messageId}", handler.DeleteMessage)
+	r.Get("/messages/{messageId}/revisions", handler.GetMessageRevisions)
+	r.Get("/messages/{messageId}/thread", handler.GetThread)
+	r.Put("/messages/{messageId}/reactions/{userId}", handler.ReactToMessage)
+	r.Delete("/messages/{messageId}/reactions/{userId}", handler.RemoveReaction)
+	r.Post("/chats/{chatId}/attachments", handler.UploadAttachment)
+	r.Get("/attachments/{attachmentId}", handler.GetAttachment)
+	r.Get("/attachments/{attachmentId}/content", handler.DownloadAttachment)
+	r.Get("/attachments/{attachmentId}/thumbnail", handler.DownloadThumbnail)
+
+	r.Post("/users", handler.CreateUser)
+	r.Get("/users", handler.ListUsers)
+	r.Get("/users/{userId}", handler.GetUser)
+	r.Patch("/users/{userId}", handler.UpdateUser)
+	r.Delete("/users/{userId}", handler.DeleteUser)
+
+	r.Get("/ws", handler.ServeWebSocket)
+	r.Get("/health", handler.Health)
+
+	r.Get("/admin/dead-letters", handler.ListDeadLetters)
+	r.Delete("/admin/dead-letters", handler.PurgeDeadLetters)
+	r.Get("/admin/dead-letters/{deadLetterId}", handler.GetDeadLetter)
+	r.Delete("/admin/dead-letters/{deadLetterId}", handler.DeleteDeadLetter)
+	r.Post("/admin/dead-letters/{deadLetterId}/replay", handler.ReplayDeadLetter)
 
 	// Register Swagger/OpenAPI routes without any authentication.
 	r.Get("/docs/openapi.yaml", func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"messaging-app/infrastructure/realtime"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// Comment lines are sent at this interval so proxies keep the stream open.
+	sseHeartbeatInterval = 30 * time.Second
+	// Time allowed to write a single event to the client.
+	sseWriteWait = 10 * time.Second
+)
+
+// StreamUserEvents handles GET /users/{userId}/events. It streams the same
+// events as the WebSocket endpoint as text/event-stream. message.created
+// events carry the message ID as their event ID; a client reconnecting with
+// Last-Event-ID first receives every message it missed.
+func (h *Handler) StreamUserEvents(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userId"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid userId", http.StatusBadRequest)
+		return
+	}
+	var lastEventID int64
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		lastEventID, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+	}
+	if _, apistatus := h.userService.GetUser(r.Context(), userID); apistatus != nil {
+		http.Error(w, apistatus.GetMessage(), apistatus.GetStatus())
+		return
+	}
+
+	// Subscribe before replaying so nothing sent in between is lost.
+	sub := h.hub.Subscribe(userID)
+	defer sub.Close()
+
+	var missed []*realtime.Event
+	if lastEventID > 0 {
+		messages, apistatus := h.messageService.GetMissedMessages(r.Context(), userID, lastEventID)
+		if apistatus != nil {
+			http.Error(w, apistatus.GetMessage(), apistatus.GetStatus())
+			return
+		}
+		for _, msg := range messages {
+			missed = append(missed, &realtime.Event{ID: msg.ID, Type: realtime.EventMessageCreated, ChatID: msg.ChatID, Data: msg})
+		}
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	// Messages up to this ID have been replayed and are skipped if they also
+	// arrive live.
+	replayedUpTo := lastEventID
+	for _, event := range missed {
+		if err := writeSSEEvent(w, rc, event); err != nil {
+			return
+		}
+		replayedUpTo = event.ID
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case event := <-sub.Events():
+			if event.Type == realtime.EventMessageCreated && event.ID <= replayedUpTo {
+				continue
+			}
+			if err := writeSSEEvent(w, rc, event); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			rc.SetWriteDeadline(time.Now().Add(sseWriteWait))
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case <-sub.Done():
+			// The hub dropped us for falling behind; the client will reconnect
+			// with Last-Event-ID.
+			return
+		case <-r.Context().Done():
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// writeSSEEvent writes event in text/event-stream format.
+func writeSSEEvent(w http.ResponseWriter, rc *http.ResponseController, event *realtime.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	rc.SetWriteDeadline(time.Now().Add(sseWriteWait))
+	if event.ID > 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", event.ID); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+	return err
+}
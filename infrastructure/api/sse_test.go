@@ -0,0 +1,108 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"messaging-app/infrastructure/mq"
+	"messaging-app/infrastructure/realtime"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// readSSEEvent reads lines up to the next blank line and returns the id and event fields.
+func readSSEEvent(t *testing.T, reader *bufio.Reader) (id, eventType string) {
+	t.Helper()
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read event stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			return id, eventType
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			eventType = strings.TrimPrefix(line, "event: ")
+		}
+	}
+}
+
+// TestStreamUserEvents verifies replay from Last-Event-ID followed by live events.
+func TestStreamUserEvents(t *testing.T) {
+	hub := realtime.NewHub(16)
+	handler := NewHandler(&dummyService{}, newDummyUserService(), newDummyDeadLetterService(), &dummyAttachmentService{}, hub, stubBroker(mq.StateConnected))
+	router := chi.NewRouter()
+	router.Get("/users/{userId}/events", handler.StreamUserEvents)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	req, err := http.NewRequest("GET", ts.URL+"/users/1/events", nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatalf("failed to perform request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("expected content type text/event-stream, got %s", ct)
+	}
+	reader := bufio.NewReader(resp.Body)
+
+	// Message 2 was missed and is replayed first.
+	id, eventType := readSSEEvent(t, reader)
+	if id != "2" || eventType != realtime.EventMessageCreated {
+		t.Fatalf("expected replayed message 2, got id %q type %q", id, eventType)
+	}
+
+	// A live copy of message 2 is skipped; message 3 and status changes come through.
+	hub.Publish([]int64{1}, &realtime.Event{ID: 2, Type: realtime.EventMessageCreated, ChatID: 1})
+	hub.Publish([]int64{1}, &realtime.Event{ID: 3, Type: realtime.EventMessageCreated, ChatID: 1})
+	hub.Publish([]int64{1}, &realtime.Event{Type: realtime.EventStatusChanged, ChatID: 1})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		id, eventType = readSSEEvent(t, reader)
+		if id != "3" || eventType != realtime.EventMessageCreated {
+			t.Errorf("expected live message 3, got id %q type %q", id, eventType)
+		}
+		id, eventType = readSSEEvent(t, reader)
+		if id != "" || eventType != realtime.EventStatusChanged {
+			t.Errorf("expected status change without id, got id %q type %q", id, eventType)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for live events")
+	}
+}
+
+// TestStreamUserEvents_InvalidLastEventID verifies that malformed resume IDs are rejected.
+func TestStreamUserEvents_InvalidLastEventID(t *testing.T) {
+	handler := setupTestHandler()
+
+	req := httptest.NewRequest("GET", "/users/1/events", nil)
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, newChiContext("userId", "1")))
+	req.Header.Set("Last-Event-ID", "abc")
+	rr := httptest.NewRecorder()
+	handler.StreamUserEvents(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
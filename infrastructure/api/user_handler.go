@@ -0,0 +1,97 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// UserRequest is the payload for creating or updating a user.
+type UserRequest struct {
+	Name string `json:"name"`
+}
+
+// CreateUser handles POST /users.
+func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var req UserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	user, apistatus := h.userService.CreateUser(r.Context(), req.Name)
+	if apistatus != nil {
+		http.Error(w, apistatus.GetMessage(), apistatus.GetStatus())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(user)
+}
+
+// ListUsers handles GET /users.
+func (h *Handler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	users, apistatus := h.userService.ListUsers(r.Context())
+	if apistatus != nil {
+		http.Error(w, apistatus.GetMessage(), apistatus.GetStatus())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(users)
+}
+
+// GetUser handles GET /users/{userId}.
+func (h *Handler) GetUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userId"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid userId", http.StatusBadRequest)
+		return
+	}
+	user, apistatus := h.userService.GetUser(r.Context(), userID)
+	if apistatus != nil {
+		http.Error(w, apistatus.GetMessage(), apistatus.GetStatus())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(user)
+}
+
+// UpdateUser handles PATCH /users/{userId}.
+func (h *Handler) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userId"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid userId", http.StatusBadRequest)
+		return
+	}
+	var req UserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	user, apistatus := h.userService.UpdateUser(r.Context(), userID, req.Name)
+	if apistatus != nil {
+		http.Error(w, apistatus.GetMessage(), apistatus.GetStatus())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(user)
+}
+
+// DeleteUser handles DELETE /users/{userId}.
+func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userId"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid userId", http.StatusBadRequest)
+		return
+	}
+	apistatus := h.userService.DeleteUser(r.Context(), userID)
+	if apistatus != nil {
+		http.Error(w, apistatus.GetMessage(), apistatus.GetStatus())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
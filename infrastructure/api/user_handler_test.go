@@ -0,0 +1,138 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"messaging-app/domain"
+	"messaging-app/pkg/apistatus"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// dummyUserService is a dummy implementation of the UserService interface for testing.
+type dummyUserService struct {
+	users map[int64]*domain.User
+}
+
+func newDummyUserService() *dummyUserService {
+	return &dummyUserService{users: map[int64]*domain.User{
+		1: {ID: 1, Name: "Red"},
+	}}
+}
+
+func (s *dummyUserService) CreateUser(ctx context.Context, name string) (*domain.User, apistatus.Status) {
+	if name == "" {
+		return nil, apistatus.New("name is required").UnprocessableEntity()
+	}
+	user := &domain.User{ID: int64(len(s.users) + 1), Name: name}
+	s.users[user.ID] = user
+	return user, nil
+}
+
+func (s *dummyUserService) GetUser(ctx context.Context, userID int64) (*domain.User, apistatus.Status) {
+	user, ok := s.users[userID]
+	if !ok {
+		return nil, apistatus.New("user not found").NotFound()
+	}
+	return user, nil
+}
+
+func (s *dummyUserService) ListUsers(ctx context.Context) ([]*domain.User, apistatus.Status) {
+	var users []*domain.User
+	for _, u := range s.users {
+		users = append(users, u)
+	}
+	return users, nil
+}
+
+func (s *dummyUserService) UpdateUser(ctx context.Context, userID int64, name string) (*domain.User, apistatus.Status) {
+	user, ok := s.users[userID]
+	if !ok {
+		return nil, apistatus.New("user not found").NotFound()
+	}
+	user.Name = name
+	return user, nil
+}
+
+func (s *dummyUserService) DeleteUser(ctx context.Context, userID int64) apistatus.Status {
+	if _, ok := s.users[userID]; !ok {
+		return apistatus.New("user not found").NotFound()
+	}
+	delete(s.users, userID)
+	return nil
+}
+
+// TestCreateUser verifies that the CreateUser endpoint returns the new user.
+func TestCreateUser(t *testing.T) {
+	handler := setupTestHandler()
+
+	req := httptest.NewRequest("POST", "/users", bytes.NewBufferString(`{"name": "Ayo"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+
+	handler.CreateUser(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status code %d, got %d", http.StatusCreated, rr.Code)
+	}
+	var user domain.User
+	if err := json.NewDecoder(rr.Body).Decode(&user); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if user.ID == 0 || user.Name != "Ayo" {
+		t.Errorf("unexpected user returned: %+v", user)
+	}
+}
+
+// TestUserLifecycle verifies get, update and delete on an existing user.
+func TestUserLifecycle(t *testing.T) {
+	handler := setupTestHandler()
+
+	// Get user 1.
+	req := httptest.NewRequest("GET", "/users/1", nil)
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, newChiContext("userId", "1")))
+	rr := httptest.NewRecorder()
+	handler.GetUser(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	// Rename user 1.
+	req = httptest.NewRequest("PATCH", "/users/1", bytes.NewBufferString(`{"name": "Rojo"}`))
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, newChiContext("userId", "1")))
+	rr = httptest.NewRecorder()
+	handler.UpdateUser(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d", http.StatusOK, rr.Code)
+	}
+	var user domain.User
+	if err := json.NewDecoder(rr.Body).Decode(&user); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if user.Name != "Rojo" {
+		t.Errorf("expected name 'Rojo', got '%s'", user.Name)
+	}
+
+	// Delete user 1.
+	req = httptest.NewRequest("DELETE", "/users/1", nil)
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, newChiContext("userId", "1")))
+	rr = httptest.NewRecorder()
+	handler.DeleteUser(rr, req)
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected status code %d, got %d", http.StatusNoContent, rr.Code)
+	}
+
+	// Getting the deleted user should fail.
+	req = httptest.NewRequest("GET", "/users/1", nil)
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, newChiContext("userId", "1")))
+	rr = httptest.NewRecorder()
+	handler.GetUser(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status code %d for deleted user, got %d", http.StatusNotFound, rr.Code)
+	}
+}
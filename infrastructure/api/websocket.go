@@ -0,0 +1,104 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"messaging-app/infrastructure/realtime"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// Time allowed to write a frame to the client.
+	wsWriteWait = 10 * time.Second
+	// Time allowed to read the next pong from the client.
+	wsPongWait = 60 * time.Second
+	// Send pings at this interval; must be less than wsPongWait.
+	wsPingPeriod = (wsPongWait * 9) / 10
+	// Clients only send control frames, so keep incoming messages small.
+	wsMaxMessageSize = 512
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// ServeWebSocket handles GET /ws?userId={userId}. It upgrades the connection
+// and pushes every event of the user's chats until the client goes away or
+// falls too far behind.
+func (h *Handler) ServeWebSocket(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(r.URL.Query().Get("userId"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid userId", http.StatusBadRequest)
+		return
+	}
+	// Only registered users may subscribe.
+	if _, apistatus := h.userService.GetUser(r.Context(), userID); apistatus != nil {
+		http.Error(w, apistatus.GetMessage(), apistatus.GetStatus())
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already replied to the client.
+		log.Printf("websocket upgrade failed: %v", err)
+		return
+	}
+	sub := h.hub.Subscribe(userID)
+	go wsWritePump(conn, sub)
+	wsReadPump(conn, sub)
+}
+
+// wsReadPump keeps the read deadline moving on pongs and ends the
+// subscription when the client disconnects.
+func wsReadPump(conn *websocket.Conn, sub *realtime.Subscription) {
+	defer sub.Close()
+	conn.SetReadLimit(wsMaxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+// wsWritePump writes events and heartbeats to the client. It owns all writes
+// on the connection.
+func wsWritePump(conn *websocket.Conn, sub *realtime.Subscription) {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		sub.Close()
+		conn.Close()
+	}()
+	for {
+		select {
+		case event := <-sub.Events():
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-sub.Done():
+			// Either the reader saw the client leave or the hub dropped us
+			// for falling behind.
+			conn.WriteControl(
+				websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "subscription closed"),
+				time.Now().Add(wsWriteWait),
+			)
+			return
+		}
+	}
+}
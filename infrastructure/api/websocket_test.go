@@ -0,0 +1,71 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"messaging-app/infrastructure/mq"
+	"messaging-app/infrastructure/realtime"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestServeWebSocket verifies that hub events reach a connected user.
+func TestServeWebSocket(t *testing.T) {
+	hub := realtime.NewHub(16)
+	handler := NewHandler(&dummyService{}, newDummyUserService(), newDummyDeadLetterService(), &dummyAttachmentService{}, hub, stubBroker(mq.StateConnected))
+	ts := httptest.NewServer(http.HandlerFunc(handler.ServeWebSocket))
+	defer ts.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws?userId=1"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("failed to dial websocket: %v", err)
+	}
+	defer conn.Close()
+
+	// Wait for the subscription to be registered before publishing.
+	deadline := time.Now().Add(time.Second)
+	for !hub.IsConnected(1) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for subscription")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	hub.Publish([]int64{1}, &realtime.Event{Type: realtime.EventMessageCreated, ChatID: 1})
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var event realtime.Event
+	if err := conn.ReadJSON(&event); err != nil {
+		t.Fatalf("failed to read event: %v", err)
+	}
+	if event.Type != realtime.EventMessageCreated || event.ChatID != 1 {
+		t.Errorf("unexpected event: %+v", event)
+	}
+
+	// Closing the client ends the subscription.
+	conn.Close()
+	deadline = time.Now().Add(time.Second)
+	for hub.IsConnected(1) {
+		if time.Now().After(deadline) {
+			t.Fatal("expected subscription to end after client disconnect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestServeWebSocket_UnknownUser verifies that unknown users are rejected before upgrading.
+func TestServeWebSocket_UnknownUser(t *testing.T) {
+	handler := setupTestHandler()
+
+	req := httptest.NewRequest("GET", "/ws?userId=42", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeWebSocket(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status code %d, got %d", http.StatusNotFound, rr.Code)
+	}
+}
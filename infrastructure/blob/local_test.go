@@ -0,0 +1,76 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLocalStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewLocalStore(dir)
+	if err != nil {
+		t.Fatalf("NewLocalStore failed: %v", err)
+	}
+	ctx := context.Background()
+
+	n, err := store.Put(ctx, "a1.pdf", strings.NewReader("%PDF-1.7"))
+	if err != nil || n != 8 {
+		t.Fatalf("Put failed: %d, %v", n, err)
+	}
+	r, err := store.Open(ctx, "a1.pdf")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	content, _ := io.ReadAll(r)
+	r.Close()
+	if string(content) != "%PDF-1.7" {
+		t.Errorf("unexpected content %q", content)
+	}
+
+	if err := store.Delete(ctx, "a1.pdf"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := store.Delete(ctx, "a1.pdf"); err != nil {
+		t.Errorf("expected deleting a missing blob to succeed, got %v", err)
+	}
+	if _, err := store.Open(ctx, "a1.pdf"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("expected no files left, got %v", entries)
+	}
+}
+
+func TestLocalStore_FailedPut(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := NewLocalStore(dir)
+	ctx := context.Background()
+
+	if _, err := store.Put(ctx, "blob", io.MultiReader(strings.NewReader("partial"), failingReader{})); err == nil {
+		t.Fatal("expected Put to fail")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("expected no partial blob, got %v", entries)
+	}
+}
+
+func TestLocalStore_InvalidKey(t *testing.T) {
+	store, _ := NewLocalStore(t.TempDir())
+	ctx := context.Background()
+	for _, key := range []string{"", "..", "../escape", "a/b", `a\b`} {
+		if _, err := store.Put(ctx, key, strings.NewReader("x")); err == nil {
+			t.Errorf("expected key %q to be rejected", key)
+		}
+		if _, err := store.Open(ctx, key); err == nil {
+			t.Errorf("expected key %q to be rejected", key)
+		}
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) { return 0, errors.New("connection reset") }
@@ -0,0 +1,83 @@
+package mq
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/streadway/amqp"
+)
+
+var (
+	// ErrNacked is returned when the broker refuses to take responsibility
+	// for a published message.
+	ErrNacked = errors.New("rabbitmq: publish nacked by broker")
+	// ErrConfirmLost is returned when the channel closes before the broker
+	// confirmed a publish; the message may or may not have been stored.
+	ErrConfirmLost = errors.New("rabbitmq: channel closed before confirm")
+)
+
+// confirmer matches publisher confirms to publishes by delivery tag, so
+// concurrent publishers on one channel each wait for their own ack.
+type confirmer struct {
+	publishMu sync.Mutex
+	nextTag   uint64
+
+	mu      sync.Mutex
+	waiters map[uint64]chan bool
+	closed  bool
+}
+
+func newConfirmer(confirms <-chan amqp.Confirmation) *confirmer {
+	c := &confirmer{waiters: make(map[uint64]chan bool)}
+	go c.run(confirms)
+	return c
+}
+
+// publish calls send and returns a channel that receives the broker's ack
+// (true) or nack (false). The channel is closed without a value when the
+// confirm is lost.
+func (c *confirmer) publish(send func() error) (<-chan bool, error) {
+	c.publishMu.Lock()
+	defer c.publishMu.Unlock()
+
+	// Register before sending; the confirm can arrive before send returns.
+	tag := c.nextTag + 1
+	result := make(chan bool, 1)
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrConfirmLost
+	}
+	c.waiters[tag] = result
+	c.mu.Unlock()
+
+	if err := send(); err != nil {
+		c.mu.Lock()
+		delete(c.waiters, tag)
+		c.mu.Unlock()
+		return nil, err
+	}
+	c.nextTag = tag
+	return result, nil
+}
+
+// run dispatches confirms until the channel closes, then releases every
+// publisher still waiting.
+func (c *confirmer) run(confirms <-chan amqp.Confirmation) {
+	for conf := range confirms {
+		c.mu.Lock()
+		result, ok := c.waiters[conf.DeliveryTag]
+		delete(c.waiters, conf.DeliveryTag)
+		c.mu.Unlock()
+		if ok {
+			result <- conf.Ack
+		}
+	}
+	c.mu.Lock()
+	c.closed = true
+	for tag, result := range c.waiters {
+		close(result)
+		delete(c.waiters, tag)
+	}
+	c.mu.Unlock()
+}
@@ -0,0 +1,69 @@
+package mq
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+func receive(t *testing.T, result <-chan bool) (bool, bool) {
+	t.Helper()
+	select {
+	case ack, ok := <-result:
+		return ack, ok
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for confirm")
+		return false, false
+	}
+}
+
+func TestConfirmer_MatchesConfirmsByTag(t *testing.T) {
+	confirms := make(chan amqp.Confirmation)
+	c := newConfirmer(confirms)
+	send := func() error { return nil }
+
+	first, _ := c.publish(send)
+	second, _ := c.publish(send)
+
+	// Confirms can arrive out of publish order.
+	confirms <- amqp.Confirmation{DeliveryTag: 2, Ack: false}
+	confirms <- amqp.Confirmation{DeliveryTag: 1, Ack: true}
+
+	if ack, ok := receive(t, first); !ok || !ack {
+		t.Errorf("expected first publish acked, got ack=%v ok=%v", ack, ok)
+	}
+	if ack, ok := receive(t, second); !ok || ack {
+		t.Errorf("expected second publish nacked, got ack=%v ok=%v", ack, ok)
+	}
+}
+
+func TestConfirmer_SendErrorDoesNotConsumeTag(t *testing.T) {
+	confirms := make(chan amqp.Confirmation)
+	c := newConfirmer(confirms)
+
+	boom := errors.New("channel closed")
+	if _, err := c.publish(func() error { return boom }); err != boom {
+		t.Fatalf("expected send error, got %v", err)
+	}
+	result, _ := c.publish(func() error { return nil })
+	confirms <- amqp.Confirmation{DeliveryTag: 1, Ack: true}
+	if ack, ok := receive(t, result); !ok || !ack {
+		t.Errorf("expected publish acked with tag 1, got ack=%v ok=%v", ack, ok)
+	}
+}
+
+func TestConfirmer_ClosedChannelReleasesWaiters(t *testing.T) {
+	confirms := make(chan amqp.Confirmation)
+	c := newConfirmer(confirms)
+
+	pending, _ := c.publish(func() error { return nil })
+	close(confirms)
+	if _, ok := receive(t, pending); ok {
+		t.Error("expected pending confirm to be released without a value")
+	}
+	if _, err := c.publish(func() error { return nil }); !errors.Is(err, ErrConfirmLost) {
+		t.Errorf("expected ErrConfirmLost after close, got %v", err)
+	}
+}
@@ -0,0 +1,297 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"messaging-app/domain"
+
+	"github.com/streadway/amqp"
+)
+
+// Headers used to track failed deliveries.
+const (
+	RetryCountHeader = "x-retry-count"
+	LastErrorHeader  = "x-last-error"
+	RoutingKeyHeader = "x-original-routing-key"
+)
+
+var (
+	// ErrConsumerClosed is returned when the broker stops delivering messages.
+	ErrConsumerClosed = errors.New("consumer channel closed")
+	// ErrDeadLetteringDisabled is returned by ConsumeDeadLetters when the
+	// topology has no dead-letter exchange.
+	ErrDeadLetteringDisabled = errors.New("rabbitmq: dead-lettering is disabled")
+)
+
+// Handler processes the body of a consumed message. Returning an error
+// rejects the message. The handler's context reports how often the message
+// was retried already; see RetryCountFromContext.
+type Handler func(ctx context.Context, body []byte) error
+
+type retryCountKey struct{}
+
+// WithRetryCount returns a copy of ctx carrying the retry count of the
+// message being handled.
+func WithRetryCount(ctx context.Context, retries int) context.Context {
+	return context.WithValue(ctx, retryCountKey{}, retries)
+}
+
+// RetryCountFromContext returns how often the message being handled was
+// retried before, or 0 on its first delivery. A handler that fails with
+// RetryCountFromContext(ctx) == MaxRetries has the message dead-lettered.
+func RetryCountFromContext(ctx context.Context) int {
+	retries, _ := ctx.Value(retryCountKey{}).(int)
+	return retries
+}
+
+// Consumer delivers queued messages to a handler.
+type Consumer interface {
+	Consume(ctx context.Context, handler Handler) error
+}
+
+// DeadLetterHandler stores a dead-lettered message. Returning an error
+// leaves the message in the dead-letter queue.
+type DeadLetterHandler func(ctx context.Context, dl *domain.DeadLetter) error
+
+// DeadLetterConsumer delivers dead-lettered messages to a handler.
+type DeadLetterConsumer interface {
+	ConsumeDeadLetters(ctx context.Context, handler DeadLetterHandler) error
+}
+
+// Consume reads from the queue until ctx is cancelled. Messages are acked
+// once handler succeeds. A failed message is republished with an
+// incremented x-retry-count header, after RetryDelay, until MaxRetries is
+// reached and then dead-lettered. A message whose handler fails because ctx was cancelled is
+// requeued untouched. When the connection drops, Consume waits for the
+// reconnect and subscribes again; it returns ErrConsumerClosed once the
+// RabbitMQ is closed.
+func (r *RabbitMQ) Consume(ctx context.Context, handler Handler) error {
+	return r.consume(ctx, func(s *session) string { return s.queue.Name }, func(d amqp.Delivery) {
+		r.handleDelivery(ctx, d, handler)
+	})
+}
+
+// handleDelivery passes d to handler and acks, requeues or retries it
+// depending on the outcome.
+func (r *RabbitMQ) handleDelivery(ctx context.Context, d amqp.Delivery, handler Handler) {
+	err := handler(WithRetryCount(ctx, retryCount(d.Headers)), d.Body)
+	if err == nil {
+		d.Ack(false)
+		return
+	}
+	if ctx.Err() != nil {
+		// Shutting down: the failure says nothing about the message, so
+		// hand it back to the broker without spending a retry.
+		d.Nack(false, true)
+		return
+	}
+	log.Printf("Failed to process message: %v", err)
+	r.retryOrDeadLetter(d, err)
+}
+
+// ConsumeDeadLetters reads from the dead-letter queue until ctx is
+// cancelled, passing every message to handler.
+func (r *RabbitMQ) ConsumeDeadLetters(ctx context.Context, handler DeadLetterHandler) error {
+	if r.topology.DeadLetterExchange == "" {
+		return ErrDeadLetteringDisabled
+	}
+	return r.consume(ctx, func(s *session) string { return s.deadLetter.Name }, func(d amqp.Delivery) {
+		dl := newDeadLetter(d)
+		if dl.Queue == "" {
+			dl.Queue = r.topology.Queue
+		}
+		if err := handler(ctx, dl); err != nil {
+			log.Printf("Failed to store dead letter: %v", err)
+			d.Nack(false, true)
+			return
+		}
+		d.Ack(false)
+	})
+}
+
+// consume subscribes to the queue returned by queueOf and passes every
+// delivery to process, re-subscribing after reconnects.
+func (r *RabbitMQ) consume(ctx context.Context, queueOf func(*session) string, process func(amqp.Delivery)) error {
+	for {
+		s, err := r.waitForSession(ctx)
+		if err != nil {
+			if errors.Is(err, ErrClosed) {
+				return ErrConsumerClosed
+			}
+			return nil
+		}
+		err = r.consumeSession(ctx, s, queueOf(s), process)
+		if ctx.Err() != nil {
+			return nil
+		}
+		log.Printf("Consumer interrupted: %v; resubscribing", err)
+		// The watcher may not have noticed the failure yet; give it time
+		// so we don't spin on a dead connection.
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(r.backoff):
+		}
+	}
+}
+
+// waitForSession blocks until a connection is available.
+func (r *RabbitMQ) waitForSession(ctx context.Context) (*session, error) {
+	for {
+		s, err := r.current()
+		if err != ErrNotConnected {
+			return s, err
+		}
+		r.mu.RLock()
+		ready := r.ready
+		r.mu.RUnlock()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ready:
+		}
+	}
+}
+
+// consumeSession consumes queue on a dedicated channel of s until ctx is
+// cancelled or the channel closes.
+func (r *RabbitMQ) consumeSession(ctx context.Context, s *session, queue string, process func(amqp.Delivery)) error {
+	// Use a dedicated channel so consuming never blocks publishing.
+	ch, err := s.conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+	if err := ch.Qos(1, 0, false); err != nil {
+		return err
+	}
+	deliveries, err := ch.Consume(
+		queue,
+		"",    // consumer tag
+		false, // auto-ack
+		false, // exclusive
+		false, // no-local
+		false, // no-wait
+		nil,   // arguments
+	)
+	if err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case d, ok := <-deliveries:
+			if !ok {
+				return ErrConsumerClosed
+			}
+			process(d)
+		}
+	}
+}
+
+// retryOrDeadLetter republishes a failed delivery with an incremented retry
+// count, through the retry queue when RetryDelay is set, or parks it once
+// the retries are used up. The original delivery is
+// acked only after its replacement was confirmed. The publish is detached
+// from the consumer's context so a shutdown does not abandon a copy the
+// broker is about to confirm.
+func (r *RabbitMQ) retryOrDeadLetter(d amqp.Delivery, cause error) {
+	retries := retryCount(d.Headers)
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[LastErrorHeader] = cause.Error()
+	// Retries go through the default exchange; remember the original key.
+	if _, ok := headers[RoutingKeyHeader]; !ok {
+		headers[RoutingKeyHeader] = d.RoutingKey
+	}
+	// The retry queue expires every retry into the service queue; that is
+	// not the reason it may get dead-lettered later.
+	delete(headers, "x-death")
+
+	exchange, key := "", "" // back to the service queue
+	if retries < r.topology.MaxRetries {
+		headers[RetryCountHeader] = int32(retries + 1)
+		key = retryRoute
+	} else if r.topology.DeadLetterExchange != "" {
+		exchange, key = r.topology.DeadLetterExchange, d.RoutingKey
+	} else {
+		log.Printf("Dropping message %s after %d retries", d.MessageId, retries)
+		d.Ack(false)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultConfirmTimeout)
+	defer cancel()
+	err := r.publish(ctx, exchange, key, amqp.Publishing{
+		Headers:       headers,
+		ContentType:   d.ContentType,
+		DeliveryMode:  amqp.Persistent,
+		CorrelationId: d.CorrelationId,
+		MessageId:     d.MessageId,
+		Timestamp:     d.Timestamp,
+		Type:          d.Type,
+		Body:          d.Body,
+	})
+	if err != nil {
+		// Let the queue's dead-letter exchange take it instead; it keeps
+		// the message but loses the retry count and error.
+		log.Printf("Failed to requeue message %s: %v", d.MessageId, err)
+		d.Nack(false, false)
+		return
+	}
+	d.Ack(false)
+}
+
+// retryCount reads the x-retry-count header, which AMQP may decode as any
+// integer type.
+func retryCount(headers amqp.Table) int {
+	switch v := headers[RetryCountHeader].(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case int:
+		return v
+	}
+	return 0
+}
+
+// newDeadLetter describes a delivery from the dead-letter queue. Messages
+// parked by retryOrDeadLetter carry x-last-error; messages the broker
+// dead-lettered itself (rejected, expired, over length) carry x-death.
+func newDeadLetter(d amqp.Delivery) *domain.DeadLetter {
+	dl := &domain.DeadLetter{
+		MessageID:      d.MessageId,
+		CorrelationID:  d.CorrelationId,
+		EventType:      d.Type,
+		RoutingKey:     d.RoutingKey,
+		Reason:         "retries exhausted",
+		RetryCount:     retryCount(d.Headers),
+		ContentType:    d.ContentType,
+		Body:           string(d.Body),
+		DeadLetteredAt: time.Now(),
+	}
+	if lastError, ok := d.Headers[LastErrorHeader].(string); ok {
+		dl.LastError = lastError
+	}
+	if key, ok := d.Headers[RoutingKeyHeader].(string); ok {
+		dl.RoutingKey = key
+	}
+	if deaths, ok := d.Headers["x-death"].([]interface{}); ok && len(deaths) > 0 {
+		if death, ok := deaths[0].(amqp.Table); ok {
+			if reason, ok := death["reason"].(string); ok {
+				dl.Reason = reason
+			}
+			if queue, ok := death["queue"].(string); ok {
+				dl.Queue = queue
+			}
+		}
+	}
+	return dl
+}
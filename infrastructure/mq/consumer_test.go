@@ -0,0 +1,120 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// recordingAcknowledger records how a delivery was settled.
+type recordingAcknowledger struct {
+	acks    int
+	nacks   int
+	requeue bool
+}
+
+func (a *recordingAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.acks++
+	return nil
+}
+
+func (a *recordingAcknowledger) Nack(tag uint64, multiple, requeue bool) error {
+	a.nacks++
+	a.requeue = requeue
+	return nil
+}
+
+func (a *recordingAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+func TestRetryCount(t *testing.T) {
+	for _, tt := range []struct {
+		headers amqp.Table
+		want    int
+	}{
+		{nil, 0},
+		{amqp.Table{RetryCountHeader: int32(2)}, 2},
+		{amqp.Table{RetryCountHeader: int64(3)}, 3},
+		{amqp.Table{RetryCountHeader: "x"}, 0},
+	} {
+		if got := retryCount(tt.headers); got != tt.want {
+			t.Errorf("retryCount(%v) = %d, want %d", tt.headers, got, tt.want)
+		}
+	}
+}
+
+func TestNewDeadLetter(t *testing.T) {
+	// Parked by the consumer after its retries.
+	dl := newDeadLetter(amqp.Delivery{
+		MessageId:  "evt-1",
+		Type:       "message.sent",
+		RoutingKey: "messages",
+		Headers: amqp.Table{
+			RetryCountHeader: int32(3),
+			LastErrorHeader:  "boom",
+			RoutingKeyHeader: "chat.1.message.sent",
+		},
+		Body: []byte("{}"),
+	})
+	if dl.Reason != "retries exhausted" || dl.LastError != "boom" || dl.RetryCount != 3 || dl.RoutingKey != "chat.1.message.sent" {
+		t.Errorf("unexpected dead letter: %+v", dl)
+	}
+
+	// Dead-lettered by the broker itself.
+	dl = newDeadLetter(amqp.Delivery{
+		RoutingKey: "chat.2.message.sent",
+		Headers: amqp.Table{
+			"x-death": []interface{}{amqp.Table{"reason": "rejected", "queue": "messages"}},
+		},
+	})
+	if dl.Reason != "rejected" || dl.Queue != "messages" || dl.RoutingKey != "chat.2.message.sent" {
+		t.Errorf("unexpected dead letter: %+v", dl)
+	}
+}
+
+func TestHandleDelivery_CancelledRequeues(t *testing.T) {
+	rmq := newRabbitMQ("amqp://localhost:1/", Topology{Queue: "messages", DeadLetterExchange: "messages.dlx", MaxRetries: 3}, time.Millisecond, time.Millisecond)
+	defer rmq.Close()
+	ack := &recordingAcknowledger{}
+	d := amqp.Delivery{Acknowledger: ack, MessageId: "evt-1", Body: []byte("{}")}
+
+	// The handler is interrupted by a shutdown.
+	ctx, cancel := context.WithCancel(context.Background())
+	handled := 0
+	rmq.handleDelivery(ctx, d, func(ctx context.Context, body []byte) error {
+		handled++
+		cancel()
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	if handled != 1 {
+		t.Errorf("expected the handler to run once, ran %d times", handled)
+	}
+	// A retry copy or a dead letter would need a publish, which fails
+	// without a connection and ends in a Nack without requeue.
+	if ack.acks != 0 || ack.nacks != 1 || !ack.requeue {
+		t.Errorf("expected a single requeueing nack, got %+v", ack)
+	}
+}
+
+func TestHandleDelivery_FailureIsRetried(t *testing.T) {
+	rmq := newRabbitMQ("amqp://localhost:1/", Topology{Queue: "messages", MaxRetries: 3}, time.Millisecond, time.Millisecond)
+	defer rmq.Close()
+	ack := &recordingAcknowledger{}
+	d := amqp.Delivery{Acknowledger: ack, MessageId: "evt-1", Body: []byte("{}")}
+
+	rmq.handleDelivery(context.Background(), d, func(ctx context.Context, body []byte) error {
+		return errors.New("boom")
+	})
+
+	// Without a connection the retry cannot be published, so the message is
+	// left to the queue's dead-letter exchange.
+	if ack.acks != 0 || ack.nacks != 1 || ack.requeue {
+		t.Errorf("expected a single nack without requeue, got %+v", ack)
+	}
+}
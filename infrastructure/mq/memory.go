@@ -0,0 +1,307 @@
+package mq
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"messaging-app/domain"
+)
+
+// defaultRedeliveryDelay is how long an InMemoryBroker waits before handing
+// out a dead letter again after its handler failed.
+const defaultRedeliveryDelay = 500 * time.Millisecond
+
+// memoryMessage is a message waiting in an InMemoryBroker queue.
+type memoryMessage struct {
+	messageID     string
+	correlationID string
+	eventType     string
+	routingKey    string
+	contentType   string
+	body          []byte
+	retries       int
+	lastError     string
+}
+
+// InMemoryBroker implements RabbitMQInterface inside the process, so
+// consumers and end-to-end flows run without a RabbitMQ server. It follows
+// the semantics of RabbitMQ with the same Topology:
+//
+//   - events are queued only when their routing key matches one of the
+//     binding keys (everything is queued when Exchange is empty);
+//   - consumers receive messages in publish order, each message going to a
+//     single consumer;
+//   - a message is removed once its handler succeeds; a failed message is
+//     re-queued at the back, after RetryDelay, up to MaxRetries times and
+//     then dead-lettered;
+//   - a message whose handler fails because ctx was cancelled is put back
+//     at the front untouched;
+//   - a dead letter whose handler fails is put back and handed out again
+//     after a short delay, without stopping the consumer.
+//
+// Messages live only as long as the process.
+type InMemoryBroker struct {
+	topology        Topology
+	redeliveryDelay time.Duration
+
+	mu          sync.Mutex
+	queue       []*memoryMessage
+	deadLetters []*memoryMessage
+	// signal is closed and replaced whenever a message is queued.
+	signal chan struct{}
+	closed bool
+}
+
+var _ RabbitMQInterface = (*InMemoryBroker)(nil)
+
+// NewInMemoryBroker creates a broker with the given topology.
+func NewInMemoryBroker(topology Topology) *InMemoryBroker {
+	return &InMemoryBroker{
+		topology:        topology,
+		redeliveryDelay: defaultRedeliveryDelay,
+		signal:          make(chan struct{}),
+	}
+}
+
+// State reports StateConnected until Close is called.
+func (b *InMemoryBroker) State() ConnectionState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return StateClosed
+	}
+	return StateConnected
+}
+
+// PublishMessage queues body on the service queue.
+func (b *InMemoryBroker) PublishMessage(body []byte) error {
+	return b.PublishWithConfirm(context.Background(), body)
+}
+
+// PublishWithConfirm queues body on the service queue. The message is
+// confirmed as soon as it is queued.
+func (b *InMemoryBroker) PublishWithConfirm(ctx context.Context, body []byte) error {
+	return b.push(&b.queue, &memoryMessage{
+		routingKey:  b.topology.Queue,
+		contentType: "text/plain",
+		body:        body,
+	})
+}
+
+// PublishEvent queues event when its routing key matches a binding key.
+// Unroutable events are dropped, as the exchange would.
+func (b *InMemoryBroker) PublishEvent(ctx context.Context, event *domain.Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	key := RoutingKey(event)
+	if b.topology.Exchange != "" && !b.bound(key) {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.closed {
+			return ErrClosed
+		}
+		return nil
+	}
+	return b.push(&b.queue, &memoryMessage{
+		messageID:     event.ID,
+		correlationID: event.CorrelationID,
+		eventType:     event.Type,
+		routingKey:    key,
+		contentType:   "application/json",
+		body:          body,
+	})
+}
+
+// Republish queues a dead letter on the service queue again with its
+// original properties and a fresh retry budget.
+func (b *InMemoryBroker) Republish(ctx context.Context, dl *domain.DeadLetter) error {
+	return b.push(&b.queue, &memoryMessage{
+		messageID:     dl.MessageID,
+		correlationID: dl.CorrelationID,
+		eventType:     dl.EventType,
+		routingKey:    dl.RoutingKey,
+		contentType:   dl.ContentType,
+		body:          []byte(dl.Body),
+	})
+}
+
+// Consume passes queued messages to handler until ctx is cancelled. It
+// returns ErrConsumerClosed once the broker is closed.
+func (b *InMemoryBroker) Consume(ctx context.Context, handler Handler) error {
+	for {
+		msg, err := b.pop(ctx, &b.queue)
+		if err != nil {
+			return b.stopped(err)
+		}
+		if err := handler(WithRetryCount(ctx, msg.retries), msg.body); err != nil {
+			if ctx.Err() != nil {
+				b.putBack(&b.queue, msg)
+				return nil
+			}
+			b.retryOrDeadLetter(msg, err)
+		}
+	}
+}
+
+// ConsumeDeadLetters passes dead-lettered messages to handler until ctx is
+// cancelled. A message whose handler fails is put back and handed out again
+// after a short delay.
+func (b *InMemoryBroker) ConsumeDeadLetters(ctx context.Context, handler DeadLetterHandler) error {
+	if b.topology.DeadLetterExchange == "" {
+		return ErrDeadLetteringDisabled
+	}
+	for {
+		msg, err := b.pop(ctx, &b.deadLetters)
+		if err != nil {
+			return b.stopped(err)
+		}
+		dl := &domain.DeadLetter{
+			MessageID:      msg.messageID,
+			CorrelationID:  msg.correlationID,
+			EventType:      msg.eventType,
+			RoutingKey:     msg.routingKey,
+			Queue:          b.topology.Queue,
+			Reason:         "retries exhausted",
+			LastError:      msg.lastError,
+			RetryCount:     msg.retries,
+			ContentType:    msg.contentType,
+			Body:           string(msg.body),
+			DeadLetteredAt: time.Now(),
+		}
+		if err := handler(ctx, dl); err != nil {
+			log.Printf("Failed to store dead letter: %v", err)
+			b.putBack(&b.deadLetters, msg)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(b.redeliveryDelay):
+			}
+		}
+	}
+}
+
+// Close stops every consumer and rejects further publishes.
+func (b *InMemoryBroker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	close(b.signal)
+}
+
+func (b *InMemoryBroker) retryOrDeadLetter(msg *memoryMessage, cause error) {
+	msg.lastError = cause.Error()
+	if msg.retries < b.topology.MaxRetries {
+		msg.retries++
+		if b.topology.RetryDelay <= 0 {
+			b.push(&b.queue, msg)
+			return
+		}
+		// A broker closed meanwhile drops the retry, as it would on restart.
+		time.AfterFunc(b.topology.RetryDelay, func() { b.push(&b.queue, msg) })
+		return
+	}
+	if b.topology.DeadLetterExchange != "" {
+		b.push(&b.deadLetters, msg)
+	}
+}
+
+// push appends msg to queue and wakes waiting consumers.
+func (b *InMemoryBroker) push(queue *[]*memoryMessage, msg *memoryMessage) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrClosed
+	}
+	*queue = append(*queue, msg)
+	close(b.signal)
+	b.signal = make(chan struct{})
+	return nil
+}
+
+// putBack returns msg to the front of queue, where pop took it from.
+func (b *InMemoryBroker) putBack(queue *[]*memoryMessage, msg *memoryMessage) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	*queue = append([]*memoryMessage{msg}, *queue...)
+	if !b.closed {
+		close(b.signal)
+		b.signal = make(chan struct{})
+	}
+}
+
+// pop removes the oldest message of queue, waiting for one if it is empty.
+// Nothing is handed out once ctx is cancelled.
+func (b *InMemoryBroker) pop(ctx context.Context, queue *[]*memoryMessage) (*memoryMessage, error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		b.mu.Lock()
+		if b.closed {
+			b.mu.Unlock()
+			return nil, ErrClosed
+		}
+		if len(*queue) > 0 {
+			msg := (*queue)[0]
+			*queue = (*queue)[1:]
+			b.mu.Unlock()
+			return msg, nil
+		}
+		signal := b.signal
+		b.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-signal:
+		}
+	}
+}
+
+// stopped maps the error that ended a consume loop to its return value.
+func (b *InMemoryBroker) stopped(err error) error {
+	if err == ErrClosed {
+		return ErrConsumerClosed
+	}
+	return nil
+}
+
+// bound reports whether key matches one of the binding keys.
+func (b *InMemoryBroker) bound(key string) bool {
+	for _, pattern := range b.topology.BindingKeys {
+		if matchTopic(strings.Split(pattern, "."), strings.Split(key, ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchTopic applies AMQP topic matching: "*" matches exactly one word and
+// "#" matches zero or more words.
+func matchTopic(pattern, words []string) bool {
+	if len(pattern) == 0 {
+		return len(words) == 0
+	}
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(words); i++ {
+			if matchTopic(pattern[1:], words[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(words) > 0 && matchTopic(pattern[1:], words[1:])
+	default:
+		return len(words) > 0 && words[0] == pattern[0] && matchTopic(pattern[1:], words[1:])
+	}
+}
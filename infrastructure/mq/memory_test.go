@@ -0,0 +1,332 @@
+package mq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"messaging-app/domain"
+)
+
+func newTestBroker() *InMemoryBroker {
+	return NewInMemoryBroker(Topology{
+		Exchange:           "events",
+		Queue:              "messages",
+		BindingKeys:        []string{"chat.*.message.sent"},
+		DeadLetterExchange: "dead-letter",
+		DeadLetterQueue:    "messages.dead-letter",
+		MaxRetries:         2,
+	})
+}
+
+func publishEvent(t *testing.T, b *InMemoryBroker, eventType string, chatID int64, payload string) {
+	t.Helper()
+	event, err := domain.NewEvent(eventType, chatID, "", payload)
+	if err != nil {
+		t.Fatalf("NewEvent failed: %v", err)
+	}
+	if err := b.PublishEvent(context.Background(), event); err != nil {
+		t.Fatalf("PublishEvent failed: %v", err)
+	}
+}
+
+// consumeN runs handler until n messages were handled successfully.
+func consumeN(t *testing.T, b *InMemoryBroker, n int, handler func(payload string) error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	handled := 0
+	b.Consume(ctx, func(ctx context.Context, body []byte) error {
+		var event domain.Event
+		json.Unmarshal(body, &event)
+		var payload string
+		event.DecodePayload(&payload)
+		if err := handler(payload); err != nil {
+			return err
+		}
+		if handled++; handled == n {
+			cancel()
+		}
+		return nil
+	})
+	if handled != n {
+		t.Fatalf("expected %d handled messages, got %d", n, handled)
+	}
+}
+
+func TestInMemoryBroker_OrderAndRouting(t *testing.T) {
+	b := newTestBroker()
+	publishEvent(t, b, domain.EventTypeMessageSent, 1, "first")
+	publishEvent(t, b, domain.EventTypeChatCreated, 1, "unbound")
+	publishEvent(t, b, domain.EventTypeMessageSent, 2, "second")
+
+	var got []string
+	consumeN(t, b, 2, func(payload string) error {
+		got = append(got, payload)
+		return nil
+	})
+	if strings.Join(got, ",") != "first,second" {
+		t.Errorf("expected first,second in order without the unbound event, got %v", got)
+	}
+}
+
+func TestInMemoryBroker_RetryThenDeadLetter(t *testing.T) {
+	b := newTestBroker()
+	publishEvent(t, b, domain.EventTypeMessageSent, 1, "poison")
+	publishEvent(t, b, domain.EventTypeMessageSent, 1, "flaky")
+
+	// "flaky" succeeds on its third attempt, after "poison" used up its
+	// retries.
+	attempts := map[string]int{}
+	consumeN(t, b, 1, func(payload string) error {
+		attempts[payload]++
+		if payload == "poison" || attempts[payload] < 3 {
+			return errors.New("boom")
+		}
+		return nil
+	})
+	if attempts["flaky"] != 3 {
+		t.Errorf("expected 3 attempts for flaky, got %d", attempts["flaky"])
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var parked *domain.DeadLetter
+	b.ConsumeDeadLetters(ctx, func(ctx context.Context, dl *domain.DeadLetter) error {
+		parked = dl
+		cancel()
+		return nil
+	})
+	if parked == nil {
+		t.Fatal("expected poison to be dead-lettered")
+	}
+	if attempts["poison"] != 3 || parked.RetryCount != 2 || parked.LastError != "boom" || parked.RoutingKey != "chat.1.message.sent" {
+		t.Errorf("unexpected dead letter after %d attempts: %+v", attempts["poison"], parked)
+	}
+}
+
+func TestInMemoryBroker_RetryDelay(t *testing.T) {
+	b := newTestBroker()
+	b.topology.RetryDelay = 100 * time.Millisecond
+	publishEvent(t, b, domain.EventTypeMessageSent, 1, "busy")
+	publishEvent(t, b, domain.EventTypeMessageSent, 1, "next")
+
+	// "busy" fails once; "next" must not wait for its retry, and the retry
+	// must wait for RetryDelay.
+	var got []string
+	attempts := map[string]time.Time{}
+	var retryDelay time.Duration
+	consumeN(t, b, 2, func(payload string) error {
+		got = append(got, payload)
+		if first, ok := attempts[payload]; ok {
+			retryDelay = time.Since(first)
+			return nil
+		}
+		attempts[payload] = time.Now()
+		if payload == "busy" {
+			return errors.New("boom")
+		}
+		return nil
+	})
+	if strings.Join(got, ",") != "busy,next,busy" {
+		t.Errorf("expected busy,next,busy, got %v", got)
+	}
+	if retryDelay < b.topology.RetryDelay {
+		t.Errorf("expected the retry after at least %v, got %v", b.topology.RetryDelay, retryDelay)
+	}
+}
+
+func TestInMemoryBroker_RetryCountInContext(t *testing.T) {
+	b := newTestBroker()
+	publishEvent(t, b, domain.EventTypeMessageSent, 1, "flaky")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var seen []int
+	b.Consume(ctx, func(ctx context.Context, body []byte) error {
+		seen = append(seen, RetryCountFromContext(ctx))
+		if len(seen) < 3 {
+			return errors.New("boom")
+		}
+		cancel()
+		return nil
+	})
+	if len(seen) != 3 || seen[0] != 0 || seen[1] != 1 || seen[2] != 2 {
+		t.Errorf("expected retry counts 0, 1, 2, got %v", seen)
+	}
+}
+
+func TestInMemoryBroker_CancelledHandlerKeepsMessage(t *testing.T) {
+	b := newTestBroker()
+	publishEvent(t, b, domain.EventTypeMessageSent, 1, "interrupted")
+
+	// The handler is interrupted by a shutdown.
+	ctx, cancel := context.WithCancel(context.Background())
+	b.Consume(ctx, func(ctx context.Context, body []byte) error {
+		cancel()
+		return ctx.Err()
+	})
+
+	// The message comes back once, without a retry spent on it.
+	attempts := 0
+	consumeN(t, b, 1, func(payload string) error {
+		attempts++
+		return nil
+	})
+	if attempts != 1 {
+		t.Errorf("expected a single delivery, got %d", attempts)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.queue) != 0 || len(b.deadLetters) != 0 {
+		t.Errorf("expected no copies left, got %d queued and %d dead letters", len(b.queue), len(b.deadLetters))
+	}
+}
+
+func TestInMemoryBroker_DeadLetterHandlerFailure(t *testing.T) {
+	b := newTestBroker()
+	b.redeliveryDelay = time.Millisecond
+	publishEvent(t, b, domain.EventTypeMessageSent, 1, "poison")
+	consumeN(t, b, 0, func(payload string) error { return errors.New("boom") })
+
+	// The collector keeps going after the store fails once.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	attempts := 0
+	err := b.ConsumeDeadLetters(ctx, func(ctx context.Context, dl *domain.DeadLetter) error {
+		if attempts++; attempts == 1 {
+			return errors.New("store down")
+		}
+		cancel()
+		return nil
+	})
+	if err != nil {
+		t.Errorf("expected nil after cancel, got %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("expected the dead letter to be handed out twice, got %d", attempts)
+	}
+}
+
+func TestInMemoryBroker_Republish(t *testing.T) {
+	b := newTestBroker()
+	event, _ := domain.NewEvent(domain.EventTypeMessageSent, 1, "req-1", "poison")
+	b.PublishEvent(context.Background(), event)
+	consumeN(t, b, 0, func(payload string) error { return errors.New("boom") })
+
+	collect := func() *domain.DeadLetter {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		var parked *domain.DeadLetter
+		b.ConsumeDeadLetters(ctx, func(ctx context.Context, dl *domain.DeadLetter) error {
+			parked = dl
+			cancel()
+			return nil
+		})
+		if parked == nil {
+			t.Fatal("expected a dead letter")
+		}
+		return parked
+	}
+	parked := collect()
+	if err := b.Republish(context.Background(), parked); err != nil {
+		t.Fatalf("Republish failed: %v", err)
+	}
+
+	// The replayed message starts over with a fresh retry budget and is
+	// parked again with its original properties.
+	var retries []int
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	b.Consume(ctx, func(ctx context.Context, body []byte) error {
+		retries = append(retries, RetryCountFromContext(ctx))
+		return errors.New("boom")
+	})
+	if len(retries) != 3 || retries[0] != 0 {
+		t.Errorf("expected retry counts 0 to 2, got %v", retries)
+	}
+	again := collect()
+	if again.MessageID != event.ID || again.CorrelationID != "req-1" || again.EventType != domain.EventTypeMessageSent ||
+		again.RoutingKey != "chat.1.message.sent" || again.ContentType != "application/json" || again.Body != parked.Body {
+		t.Errorf("expected the original properties after a replay, got %+v", again)
+	}
+}
+
+func TestInMemoryBroker_CompetingConsumers(t *testing.T) {
+	b := NewInMemoryBroker(Topology{Queue: "messages"})
+	const total = 50
+	var mu sync.Mutex
+	seen := map[string]int{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.Consume(ctx, func(ctx context.Context, body []byte) error {
+				mu.Lock()
+				defer mu.Unlock()
+				seen[string(body)]++
+				if len(seen) == total {
+					cancel()
+				}
+				return nil
+			})
+		}()
+	}
+	for i := 0; i < total; i++ {
+		b.PublishMessage([]byte{byte('A' + i)})
+	}
+	wg.Wait()
+	for body, n := range seen {
+		if n != 1 {
+			t.Errorf("message %q delivered %d times", body, n)
+		}
+	}
+}
+
+func TestInMemoryBroker_Close(t *testing.T) {
+	b := newTestBroker()
+	result := make(chan error, 1)
+	go func() {
+		result <- b.Consume(context.Background(), func(ctx context.Context, body []byte) error { return nil })
+	}()
+	b.Close()
+	select {
+	case err := <-result:
+		if !errors.Is(err, ErrConsumerClosed) {
+			t.Errorf("expected ErrConsumerClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Consume did not return after Close")
+	}
+	if b.State() != StateClosed {
+		t.Errorf("expected state %s, got %s", StateClosed, b.State())
+	}
+	if err := b.PublishMessage([]byte("late")); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestMatchTopic(t *testing.T) {
+	for _, tt := range []struct {
+		pattern, key string
+		want         bool
+	}{
+		{"chat.*.message.sent", "chat.1.message.sent", true},
+		{"chat.*.message.sent", "chat.1.chat.created", false},
+		{"chat.#", "chat.1.message.status_changed", true},
+		{"chat.1.#", "chat.2.message.sent", false},
+		{"#", "anything.at.all", true},
+		{"chat.*", "chat.1.message.sent", false},
+	} {
+		if got := matchTopic(strings.Split(tt.pattern, "."), strings.Split(tt.key, ".")); got != tt.want {
+			t.Errorf("matchTopic(%q, %q) = %v, want %v", tt.pattern, tt.key, got, tt.want)
+		}
+	}
+}
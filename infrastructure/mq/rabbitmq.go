@@ -1,73 +1,499 @@
 package mq
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"sync"
+	"time"
+
+	"messaging-app/domain"
 
 	"github.com/streadway/amqp"
 )
 
+var (
+	// ErrNotConnected is returned by PublishMessage while the broker is
+	// unreachable. Callers are expected to retry; the outbox relay does.
+	ErrNotConnected = errors.New("rabbitmq: not connected")
+	// ErrClosed is returned once Close has been called.
+	ErrClosed = errors.New("rabbitmq: closed")
+)
+
+// DefaultConfirmTimeout bounds how long PublishMessage waits for the broker
+// to confirm a publish.
+const DefaultConfirmTimeout = 5 * time.Second
+
+// ConnectionState describes the broker connection for health checks.
+type ConnectionState string
+
+const (
+	StateConnecting   ConnectionState = "connecting"
+	StateConnected    ConnectionState = "connected"
+	StateReconnecting ConnectionState = "reconnecting"
+	StateClosed       ConnectionState = "closed"
+)
+
+// HealthChecker reports the current broker connection state.
+type HealthChecker interface {
+	State() ConnectionState
+}
+
 // RabbitMQInterface defines the methods required by the messaging service.
 type RabbitMQInterface interface {
+	Consumer
+	HealthChecker
+	DeadLetterConsumer
 	PublishMessage(body []byte) error
+	PublishWithConfirm(ctx context.Context, body []byte) error
+	PublishEvent(ctx context.Context, event *domain.Event) error
+	Republish(ctx context.Context, dl *domain.DeadLetter) error
 	Close()
 }
 
-// RabbitMQ wraps the connection, channel, and queue declaration.
+// Topology describes what the service declares on the broker. Events are
+// published to Exchange, a topic exchange, with routing keys from RoutingKey;
+// Queue receives the events matching BindingKeys. With an empty Exchange
+// events go straight to Queue through the default exchange.
+//
+// Deliveries that fail are republished to Queue up to MaxRetries times and
+// then parked in DeadLetterQueue through DeadLetterExchange. Leaving
+// DeadLetterExchange empty disables dead-lettering: failed deliveries are
+// dropped after their retries.
+//
+// With a positive RetryDelay a retry waits that long in the retry queue,
+// named after Queue with a ".retry" suffix, whose messages expire back into
+// Queue; otherwise retries are requeued on Queue at once.
+type Topology struct {
+	Exchange           string
+	Queue              string
+	BindingKeys        []string
+	DeadLetterExchange string
+	DeadLetterQueue    string
+	MaxRetries         int
+	RetryDelay         time.Duration
+}
+
+// retryQueueSuffix is appended to the service queue name to name the retry
+// queue.
+const retryQueueSuffix = ".retry"
+
+// retryRoute is the key publish maps to the retry queue on the default
+// exchange.
+const retryRoute = "retry"
+
+// RoutingKey returns the topic routing key for event, e.g.
+// "chat.42.message.sent", so consumers can bind patterns such as
+// "chat.*.message.#" or "chat.42.#".
+func RoutingKey(event *domain.Event) string {
+	return fmt.Sprintf("chat.%d.%s", event.ChatID, event.Type)
+}
+
+// session is one live connection with its publishing channel, which runs in
+// confirm mode.
+type session struct {
+	conn       *amqp.Connection
+	channel    *amqp.Channel
+	queue      amqp.Queue
+	retry      amqp.Queue
+	deadLetter amqp.Queue
+	confirms   *confirmer
+	connClosed chan *amqp.Error
+	chanClosed chan *amqp.Error
+}
+
+// RabbitMQ manages a connection to the broker. When the connection or its
+// channel is closed by the broker, it re-dials with exponential backoff and
+// re-declares the topology. Publishes are rejected with ErrNotConnected until
+// the connection is back.
 type RabbitMQ struct {
-	Connection *amqp.Connection
-	Channel    *amqp.Channel
-	Queue      amqp.Queue
+	url        string
+	topology   Topology
+	backoff    time.Duration
+	maxBackoff time.Duration
+
+	mu      sync.RWMutex
+	session *session
+	state   ConnectionState
+	// ready is closed when the state leaves connecting/reconnecting.
+	ready chan struct{}
+	done  chan struct{}
 }
 
-// NewRabbitMQ creates and returns a new RabbitMQ instance.
-func NewRabbitMQ(connStr, queueName string) (*RabbitMQ, error) {
-	conn, err := amqp.Dial(connStr)
+// NewRabbitMQ dials the broker, declares the topology and starts watching
+// the connection. Reconnect attempts wait backoff, doubling up to maxBackoff.
+func NewRabbitMQ(connStr string, topology Topology, backoff, maxBackoff time.Duration) (*RabbitMQ, error) {
+	r := newRabbitMQ(connStr, topology, backoff, maxBackoff)
+	s, err := r.dial()
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.session = s
+	r.setState(StateConnected)
+	r.mu.Unlock()
+	go r.watch(s)
+	return r, nil
+}
+
+func newRabbitMQ(connStr string, topology Topology, backoff, maxBackoff time.Duration) *RabbitMQ {
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+	if maxBackoff < backoff {
+		maxBackoff = backoff
+	}
+	return &RabbitMQ{
+		url:        connStr,
+		topology:   topology,
+		backoff:    backoff,
+		maxBackoff: maxBackoff,
+		state:      StateConnecting,
+		ready:      make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+// dial opens a connection and channel and declares the topology.
+func (r *RabbitMQ) dial() (*session, error) {
+	conn, err := amqp.Dial(r.url)
 	if err != nil {
 		return nil, err
 	}
 	ch, err := conn.Channel()
 	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	q, dlq, err := r.declare(ch)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	retry, err := r.declareRetry(ch, q.Name)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := ch.Confirm(false); err != nil {
+		conn.Close()
 		return nil, err
 	}
-	q, err := ch.QueueDeclare(
-		queueName,
+	return &session{
+		conn:       conn,
+		channel:    ch,
+		queue:      q,
+		retry:      retry,
+		deadLetter: dlq,
+		confirms:   newConfirmer(ch.NotifyPublish(make(chan amqp.Confirmation, 64))),
+		connClosed: conn.NotifyClose(make(chan *amqp.Error, 1)),
+		chanClosed: ch.NotifyClose(make(chan *amqp.Error, 1)),
+	}, nil
+}
+
+// declare declares the exchanges and queues and binds them. Declarations
+// are idempotent, so this also runs after every reconnect. Note that the
+// broker refuses to redeclare an existing queue with different arguments,
+// so enabling dead-lettering on an existing queue requires deleting it first.
+func (r *RabbitMQ) declare(ch *amqp.Channel) (queue, deadLetter amqp.Queue, err error) {
+	t := r.topology
+	var args amqp.Table
+	if t.DeadLetterExchange != "" {
+		if err := ch.ExchangeDeclare(t.DeadLetterExchange, amqp.ExchangeFanout, true, false, false, false, nil); err != nil {
+			return queue, deadLetter, err
+		}
+		deadLetter, err = ch.QueueDeclare(t.DeadLetterQueue, true, false, false, false, nil)
+		if err != nil {
+			return queue, deadLetter, err
+		}
+		if err := ch.QueueBind(deadLetter.Name, "", t.DeadLetterExchange, false, nil); err != nil {
+			return queue, deadLetter, err
+		}
+		args = amqp.Table{"x-dead-letter-exchange": t.DeadLetterExchange}
+	}
+	if t.Exchange != "" {
+		err := ch.ExchangeDeclare(
+			t.Exchange,
+			amqp.ExchangeTopic,
+			true,  // durable
+			false, // auto-delete
+			false, // internal
+			false, // no-wait
+			nil,   // arguments
+		)
+		if err != nil {
+			return queue, deadLetter, err
+		}
+	}
+	queue, err = ch.QueueDeclare(
+		t.Queue,
 		true,  // durable
 		false, // auto-delete
 		false, // exclusive
 		false, // no-wait
-		nil,   // arguments
+		args,  // arguments
 	)
 	if err != nil {
-		return nil, err
+		return queue, deadLetter, err
 	}
-	return &RabbitMQ{
-		Connection: conn,
-		Channel:    ch,
-		Queue:      q,
-	}, nil
+	if t.Exchange == "" {
+		return queue, deadLetter, nil
+	}
+	for _, key := range t.BindingKeys {
+		if err := ch.QueueBind(queue.Name, key, t.Exchange, false, nil); err != nil {
+			return queue, deadLetter, err
+		}
+	}
+	return queue, deadLetter, nil
 }
 
-// PublishMessage sends a message to the RabbitMQ queue.
-func (r *RabbitMQ) PublishMessage(body []byte) error {
-	err := r.Channel.Publish(
-		"",           // exchange (using default exchange)
-		r.Queue.Name, // routing key (queue name)
-		false,
-		false,
-		amqp.Publishing{
-			ContentType: "text/plain",
-			Body:        body,
+// declareRetry declares the retry queue of the service queue named queue
+// when RetryDelay is set. Its messages expire after RetryDelay and are then
+// dead-lettered through the default exchange back to the service queue.
+// Changing RetryDelay requires deleting the retry queue first.
+func (r *RabbitMQ) declareRetry(ch *amqp.Channel, queue string) (amqp.Queue, error) {
+	if r.topology.RetryDelay <= 0 {
+		return amqp.Queue{}, nil
+	}
+	return ch.QueueDeclare(
+		queue+retryQueueSuffix,
+		true,  // durable
+		false, // auto-delete
+		false, // exclusive
+		false, // no-wait
+		amqp.Table{
+			"x-message-ttl":             r.topology.RetryDelay.Milliseconds(),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": queue,
 		},
 	)
+}
+
+// watch waits for the current session to close and replaces it until Close
+// is called.
+func (r *RabbitMQ) watch(s *session) {
+	for {
+		var reason *amqp.Error
+		select {
+		case <-r.done:
+			return
+		case reason = <-s.connClosed:
+		case reason = <-s.chanClosed:
+			// A channel exception leaves the connection open; drop it too
+			// so consumers re-subscribe on the new one.
+			s.conn.Close()
+		}
+
+		r.mu.Lock()
+		if r.state == StateClosed {
+			r.mu.Unlock()
+			return
+		}
+		r.setState(StateReconnecting)
+		r.mu.Unlock()
+		log.Printf("RabbitMQ connection lost: %v; reconnecting", reason)
+
+		if s = r.reconnect(); s == nil {
+			return
+		}
+	}
+}
+
+// reconnect dials until it succeeds or Close is called, in which case it
+// returns nil.
+func (r *RabbitMQ) reconnect() *session {
+	wait := r.backoff
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-r.done:
+			return nil
+		case <-time.After(wait):
+		}
+		s, err := r.dial()
+		if err != nil {
+			log.Printf("RabbitMQ reconnect attempt %d failed: %v", attempt, err)
+			wait = nextBackoff(wait, r.maxBackoff)
+			continue
+		}
+
+		r.mu.Lock()
+		if r.state == StateClosed {
+			r.mu.Unlock()
+			s.conn.Close()
+			return nil
+		}
+		r.session = s
+		r.setState(StateConnected)
+		r.mu.Unlock()
+		log.Printf("RabbitMQ reconnected after %d attempt(s)", attempt)
+		return s
+	}
+}
+
+// setState must be called with mu held.
+func (r *RabbitMQ) setState(state ConnectionState) {
+	waiting := func(s ConnectionState) bool {
+		return s == StateConnecting || s == StateReconnecting
+	}
+	if waiting(r.state) && !waiting(state) {
+		close(r.ready)
+	} else if !waiting(r.state) && waiting(state) {
+		r.ready = make(chan struct{})
+	}
+	r.state = state
+}
+
+// State returns the current connection state.
+func (r *RabbitMQ) State() ConnectionState {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.state
+}
+
+// current returns the live session, or an error when there is none.
+func (r *RabbitMQ) current() (*session, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	switch r.state {
+	case StateConnected:
+		return r.session, nil
+	case StateClosed:
+		return nil, ErrClosed
+	default:
+		return nil, ErrNotConnected
+	}
+}
+
+// PublishMessage sends a persistent message to the RabbitMQ queue and waits
+// up to DefaultConfirmTimeout for the broker to confirm it.
+func (r *RabbitMQ) PublishMessage(body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultConfirmTimeout)
+	defer cancel()
+	return r.PublishWithConfirm(ctx, body)
+}
+
+// PublishWithConfirm sends a persistent message straight to the RabbitMQ
+// queue, bypassing the exchange, and waits until the broker acks it. It
+// returns ErrNacked when the broker refuses the message, ErrConfirmLost when
+// the channel closes first, and ctx.Err() when ctx expires; in the last two
+// cases the message may still have been stored.
+func (r *RabbitMQ) PublishWithConfirm(ctx context.Context, body []byte) error {
+	return r.publish(ctx, "", "", amqp.Publishing{
+		ContentType:  "text/plain",
+		DeliveryMode: amqp.Persistent,
+		Body:         body,
+	})
+}
+
+// PublishEvent sends event as JSON to the topic exchange under
+// RoutingKey(event) and waits for the broker's confirm like
+// PublishWithConfirm. The envelope fields are mirrored in the AMQP
+// properties and headers so consumers can route and filter without decoding
+// the body.
+func (r *RabbitMQ) PublishEvent(ctx context.Context, event *domain.Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return r.publish(ctx, r.topology.Exchange, RoutingKey(event), amqp.Publishing{
+		ContentType:   "application/json",
+		DeliveryMode:  amqp.Persistent,
+		MessageId:     event.ID,
+		Type:          event.Type,
+		Timestamp:     event.OccurredAt,
+		CorrelationId: event.CorrelationID,
+		Headers: amqp.Table{
+			"schema-version": int32(event.SchemaVersion),
+			"chat-id":        event.ChatID,
+		},
+		Body: body,
+	})
+}
+
+// Republish puts a dead letter back on the service queue with the
+// properties it was parked with and a fresh retry budget, and waits for the
+// broker's confirm like PublishWithConfirm. The original routing key is kept
+// in the x-original-routing-key header.
+func (r *RabbitMQ) Republish(ctx context.Context, dl *domain.DeadLetter) error {
+	headers := amqp.Table{}
+	if dl.RoutingKey != "" {
+		headers[RoutingKeyHeader] = dl.RoutingKey
+	}
+	return r.publish(ctx, "", "", amqp.Publishing{
+		Headers:       headers,
+		ContentType:   dl.ContentType,
+		DeliveryMode:  amqp.Persistent,
+		CorrelationId: dl.CorrelationID,
+		MessageId:     dl.MessageID,
+		Type:          dl.EventType,
+		Body:          []byte(dl.Body),
+	})
+}
+
+// publish sends msg and waits for its confirm. The default exchange ("")
+// routes to the service queue, or to its retry queue when key is
+// retryRoute.
+func (r *RabbitMQ) publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+	s, err := r.current()
+	if err != nil {
+		return err
+	}
+	if exchange == "" {
+		if key == retryRoute && s.retry.Name != "" {
+			key = s.retry.Name
+		} else {
+			key = s.queue.Name
+		}
+	}
+	acked, err := s.confirms.publish(func() error {
+		return s.channel.Publish(
+			exchange,
+			key,
+			false, // mandatory
+			false, // immediate
+			msg,
+		)
+	})
 	if err != nil {
 		log.Printf("Failed to publish message: %v", err)
 		return err
 	}
-	return nil
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case ack, ok := <-acked:
+		if !ok {
+			return ErrConfirmLost
+		}
+		if !ack {
+			return ErrNacked
+		}
+		return nil
+	}
 }
 
-// Close cleans up the RabbitMQ connection and channel.
+// Close stops reconnecting and closes the current connection.
 func (r *RabbitMQ) Close() {
-	r.Channel.Close()
-	r.Connection.Close()
+	r.mu.Lock()
+	if r.state == StateClosed {
+		r.mu.Unlock()
+		return
+	}
+	s := r.session
+	r.setState(StateClosed)
+	close(r.done)
+	r.mu.Unlock()
+	if s != nil {
+		s.channel.Close()
+		s.conn.Close()
+	}
+}
+
+func nextBackoff(current, max time.Duration) time.Duration {
+	if next := current * 2; next < max {
+		return next
+	}
+	return max
 }
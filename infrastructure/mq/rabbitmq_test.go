@@ -1,8 +1,15 @@
 package mq
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"os"
+	"strconv"
 	"testing"
+	"time"
+
+	"messaging-app/domain"
 )
 
 func TestNewRabbitMQ(t *testing.T) {
@@ -12,7 +19,7 @@ func TestNewRabbitMQ(t *testing.T) {
 	if dsn == "" || queueName == "" {
 		t.Skip("RABBITMQ_DSN or RABBITMQ_QUEUE not set; skipping RabbitMQ integration test")
 	}
-	rmq, err := NewRabbitMQ(dsn, queueName)
+	rmq, err := NewRabbitMQ(dsn, Topology{Queue: queueName}, 100*time.Millisecond, time.Second)
 	if err != nil {
 		t.Fatalf("NewRabbitMQ failed: %v", err)
 	}
@@ -21,5 +28,220 @@ func TestNewRabbitMQ(t *testing.T) {
 	if err != nil {
 		t.Errorf("PublishMessage failed: %v", err)
 	}
+	// Test publish with an explicit confirm deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := rmq.PublishWithConfirm(ctx, []byte("confirmed message")); err != nil {
+		t.Errorf("PublishWithConfirm failed: %v", err)
+	}
 	rmq.Close()
 }
+
+func TestConsume(t *testing.T) {
+	dsn := os.Getenv("RABBITMQ_DSN")
+	queueName := os.Getenv("RABBITMQ_QUEUE")
+	if dsn == "" || queueName == "" {
+		t.Skip("RABBITMQ_DSN or RABBITMQ_QUEUE not set; skipping RabbitMQ integration test")
+	}
+	rmq, err := NewRabbitMQ(dsn, Topology{Queue: queueName}, 100*time.Millisecond, time.Second)
+	if err != nil {
+		t.Fatalf("NewRabbitMQ failed: %v", err)
+	}
+	defer rmq.Close()
+
+	if err := rmq.PublishMessage([]byte("consume me")); err != nil {
+		t.Fatalf("PublishMessage failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	received := make(chan []byte, 1)
+	go rmq.Consume(ctx, func(ctx context.Context, body []byte) error {
+		select {
+		case received <- body:
+		default:
+		}
+		return nil
+	})
+	select {
+	case <-received:
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for message")
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	dsn := os.Getenv("RABBITMQ_DSN")
+	queueName := os.Getenv("RABBITMQ_QUEUE")
+	if dsn == "" || queueName == "" {
+		t.Skip("RABBITMQ_DSN or RABBITMQ_QUEUE not set; skipping RabbitMQ integration test")
+	}
+	rmq, err := NewRabbitMQ(dsn, Topology{Queue: queueName}, 100*time.Millisecond, time.Second)
+	if err != nil {
+		t.Fatalf("NewRabbitMQ failed: %v", err)
+	}
+	defer rmq.Close()
+
+	// Drop the connection underneath the manager.
+	s, _ := rmq.current()
+	s.conn.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for rmq.State() != StateConnected || rmq.PublishMessage([]byte("after reconnect")) != nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("did not reconnect, state %s", rmq.State())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestPublishMessage_NotConnected(t *testing.T) {
+	rmq := newRabbitMQ("amqp://localhost:1/", Topology{Queue: "messages"}, time.Millisecond, time.Millisecond)
+	if state := rmq.State(); state != StateConnecting {
+		t.Errorf("expected state %s, got %s", StateConnecting, state)
+	}
+	if err := rmq.PublishMessage([]byte("lost")); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("expected ErrNotConnected, got %v", err)
+	}
+	if err := rmq.PublishWithConfirm(context.Background(), []byte("lost")); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("expected ErrNotConnected, got %v", err)
+	}
+
+	rmq.Close()
+	if state := rmq.State(); state != StateClosed {
+		t.Errorf("expected state %s, got %s", StateClosed, state)
+	}
+	if err := rmq.PublishMessage([]byte("lost")); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestConsume_WaitsForConnection(t *testing.T) {
+	rmq := newRabbitMQ("amqp://localhost:1/", Topology{Queue: "messages"}, time.Millisecond, time.Millisecond)
+	handler := func(ctx context.Context, body []byte) error { return nil }
+
+	// Without a connection Consume waits until ctx is cancelled.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := rmq.Consume(ctx, handler); err != nil {
+		t.Errorf("expected nil after cancel, got %v", err)
+	}
+
+	// Closing the RabbitMQ releases a waiting consumer.
+	result := make(chan error, 1)
+	go func() { result <- rmq.Consume(context.Background(), handler) }()
+	time.Sleep(10 * time.Millisecond)
+	rmq.Close()
+	select {
+	case err := <-result:
+		if !errors.Is(err, ErrConsumerClosed) {
+			t.Errorf("expected ErrConsumerClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Consume did not return after Close")
+	}
+}
+
+func TestNextBackoff(t *testing.T) {
+	if got := nextBackoff(100*time.Millisecond, time.Second); got != 200*time.Millisecond {
+		t.Errorf("expected 200ms, got %s", got)
+	}
+	if got := nextBackoff(800*time.Millisecond, time.Second); got != time.Second {
+		t.Errorf("expected backoff capped at 1s, got %s", got)
+	}
+}
+
+func TestRoutingKey(t *testing.T) {
+	event := &domain.Event{Type: domain.EventTypeMessageSent, ChatID: 42}
+	if key := RoutingKey(event); key != "chat.42.message.sent" {
+		t.Errorf("expected chat.42.message.sent, got %s", key)
+	}
+}
+
+func TestTopicExchange(t *testing.T) {
+	dsn := os.Getenv("RABBITMQ_DSN")
+	queueName := os.Getenv("RABBITMQ_QUEUE")
+	if dsn == "" || queueName == "" {
+		t.Skip("RABBITMQ_DSN or RABBITMQ_QUEUE not set; skipping RabbitMQ integration test")
+	}
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 10)
+	topology := Topology{
+		Exchange:    "test.events." + suffix,
+		Queue:       queueName + ".topic." + suffix,
+		BindingKeys: []string{"chat.*.message.sent"},
+	}
+	rmq, err := NewRabbitMQ(dsn, topology, 100*time.Millisecond, time.Second)
+	if err != nil {
+		t.Fatalf("NewRabbitMQ failed: %v", err)
+	}
+	defer rmq.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// Only the message.sent event matches the binding.
+	for _, eventType := range []string{domain.EventTypeChatCreated, domain.EventTypeMessageSent} {
+		event, _ := domain.NewEvent(eventType, 1, "", struct{}{})
+		if err := rmq.PublishEvent(ctx, event); err != nil {
+			t.Fatalf("PublishEvent failed: %v", err)
+		}
+	}
+	received := make(chan string, 2)
+	go rmq.Consume(ctx, func(ctx context.Context, body []byte) error {
+		var event domain.Event
+		json.Unmarshal(body, &event)
+		received <- event.Type
+		return nil
+	})
+	select {
+	case eventType := <-received:
+		if eventType != domain.EventTypeMessageSent {
+			t.Errorf("expected only message.sent, got %s", eventType)
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for event")
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	dsn := os.Getenv("RABBITMQ_DSN")
+	queueName := os.Getenv("RABBITMQ_QUEUE")
+	if dsn == "" || queueName == "" {
+		t.Skip("RABBITMQ_DSN or RABBITMQ_QUEUE not set; skipping RabbitMQ integration test")
+	}
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 10)
+	topology := Topology{
+		Queue:      queueName + ".retry-delay." + suffix,
+		MaxRetries: 1,
+		RetryDelay: 300 * time.Millisecond,
+	}
+	rmq, err := NewRabbitMQ(dsn, topology, 100*time.Millisecond, time.Second)
+	if err != nil {
+		t.Fatalf("NewRabbitMQ failed: %v", err)
+	}
+	defer rmq.Close()
+
+	if err := rmq.PublishMessage([]byte("flaky")); err != nil {
+		t.Fatalf("PublishMessage failed: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// The first delivery fails; the retry has to wait in the retry queue.
+	var attempts []time.Time
+	retried := make(chan struct{})
+	go rmq.Consume(ctx, func(ctx context.Context, body []byte) error {
+		attempts = append(attempts, time.Now())
+		if len(attempts) == 1 {
+			return errors.New("boom")
+		}
+		close(retried)
+		return nil
+	})
+	select {
+	case <-retried:
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for the retry")
+	}
+	if delay := attempts[1].Sub(attempts[0]); delay < topology.RetryDelay {
+		t.Errorf("expected the retry after at least %v, got %v", topology.RetryDelay, delay)
+	}
+}
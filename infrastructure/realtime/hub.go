@@ -0,0 +1,152 @@
+package realtime
+
+import (
+	"sync"
+)
+
+// Event types pushed to connected clients.
+const (
+	EventMessageCreated  = "message.created"
+	EventStatusChanged   = "message.status_changed"
+	EventMessageEdited   = "message.edited"
+	EventMessageDeleted  = "message.deleted"
+	EventReactionAdded   = "message.reaction_added"
+	EventReactionRemoved = "message.reaction_removed"
+	EventChatCreated     = "chat.created"
+	EventChatRead        = "chat.read"
+)
+
+// Event is a notification pushed to the participants of a chat. ID is set
+// for message.created events to the message ID so clients can resume from
+// the last message they saw.
+type Event struct {
+	ID     int64       `json:"id,omitempty"`
+	Type   string      `json:"type"`
+	ChatID int64       `json:"chatId"`
+	Data   interface{} `json:"data"`
+}
+
+// Publisher pushes events to the users they concern.
+type Publisher interface {
+	Publish(userIDs []int64, event *Event)
+}
+
+// Subscription receives the events of a single connected user. Events are
+// buffered per subscription; a subscriber that falls behind is dropped
+// instead of blocking the publisher.
+type Subscription struct {
+	UserID int64
+
+	hub       *Hub
+	events    chan *Event
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Events returns the channel on which events are delivered.
+func (s *Subscription) Events() <-chan *Event {
+	return s.events
+}
+
+// Done is closed when the subscription ends, either because Close was called
+// or because the hub dropped a slow subscriber.
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Close unsubscribes from the hub.
+func (s *Subscription) Close() {
+	s.hub.unsubscribe(s)
+}
+
+// Hub fans events out to every subscription of the recipient users.
+type Hub struct {
+	mu          sync.RWMutex
+	subscribers map[int64]map[*Subscription]struct{}
+	bufferSize  int
+}
+
+// NewHub creates a hub whose subscriptions buffer up to bufferSize events.
+func NewHub(bufferSize int) *Hub {
+	if bufferSize <= 0 {
+		bufferSize = 1
+	}
+	return &Hub{
+		subscribers: make(map[int64]map[*Subscription]struct{}),
+		bufferSize:  bufferSize,
+	}
+}
+
+// Subscribe registers a new subscription for userID.
+func (h *Hub) Subscribe(userID int64) *Subscription {
+	sub := &Subscription{
+		UserID: userID,
+		hub:    h,
+		events: make(chan *Event, h.bufferSize),
+		done:   make(chan struct{}),
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subscribers[userID] == nil {
+		h.subscribers[userID] = make(map[*Subscription]struct{})
+	}
+	h.subscribers[userID][sub] = struct{}{}
+	return sub
+}
+
+// Publish delivers event to every subscription of userIDs without blocking.
+// Subscriptions whose buffer is full are dropped.
+func (h *Hub) Publish(userIDs []int64, event *Event) {
+	var slow []*Subscription
+	h.mu.RLock()
+	for _, userID := range userIDs {
+		for sub := range h.subscribers[userID] {
+			select {
+			case sub.events <- event:
+			default:
+				slow = append(slow, sub)
+			}
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, sub := range slow {
+		h.unsubscribe(sub)
+	}
+}
+
+// Deliver sends event to every subscription of userID without blocking and
+// returns true if at least one of them accepted it. Unlike Publish, full
+// subscriptions are kept so the caller can retry.
+func (h *Hub) Deliver(userID int64, event *Event) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	delivered := false
+	for sub := range h.subscribers[userID] {
+		select {
+		case sub.events <- event:
+			delivered = true
+		default:
+		}
+	}
+	return delivered
+}
+
+// IsConnected returns true if userID has at least one active subscription.
+func (h *Hub) IsConnected(userID int64) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.subscribers[userID]) > 0
+}
+
+func (h *Hub) unsubscribe(sub *Subscription) {
+	sub.closeOnce.Do(func() {
+		h.mu.Lock()
+		delete(h.subscribers[sub.UserID], sub)
+		if len(h.subscribers[sub.UserID]) == 0 {
+			delete(h.subscribers, sub.UserID)
+		}
+		h.mu.Unlock()
+		close(sub.done)
+	})
+}
@@ -0,0 +1,65 @@
+package realtime
+
+import "testing"
+
+func TestHubPublish(t *testing.T) {
+	hub := NewHub(4)
+	sub1 := hub.Subscribe(1)
+	sub2 := hub.Subscribe(2)
+	defer sub1.Close()
+	defer sub2.Close()
+
+	hub.Publish([]int64{1}, &Event{Type: EventMessageCreated, ChatID: 1})
+
+	select {
+	case event := <-sub1.Events():
+		if event.Type != EventMessageCreated {
+			t.Errorf("expected event type %s, got %s", EventMessageCreated, event.Type)
+		}
+	default:
+		t.Fatal("expected user 1 to receive the event")
+	}
+	select {
+	case <-sub2.Events():
+		t.Fatal("expected user 2 not to receive the event")
+	default:
+	}
+}
+
+func TestHubDropsSlowSubscriber(t *testing.T) {
+	hub := NewHub(1)
+	sub := hub.Subscribe(1)
+
+	// The second event overflows the buffer.
+	hub.Publish([]int64{1}, &Event{Type: EventMessageCreated})
+	hub.Publish([]int64{1}, &Event{Type: EventMessageCreated})
+
+	select {
+	case <-sub.Done():
+	default:
+		t.Fatal("expected slow subscriber to be dropped")
+	}
+	if hub.IsConnected(1) {
+		t.Error("expected user 1 to be disconnected")
+	}
+}
+
+func TestHubDeliver(t *testing.T) {
+	hub := NewHub(1)
+	if hub.Deliver(1, &Event{Type: EventMessageCreated}) {
+		t.Error("expected delivery to an offline user to fail")
+	}
+
+	sub := hub.Subscribe(1)
+	defer sub.Close()
+	if !hub.Deliver(1, &Event{Type: EventMessageCreated}) {
+		t.Error("expected delivery to a connected user to succeed")
+	}
+	// The buffer is full, but the subscriber is kept for a retry.
+	if hub.Deliver(1, &Event{Type: EventMessageCreated}) {
+		t.Error("expected delivery to a full subscriber to fail")
+	}
+	if !hub.IsConnected(1) {
+		t.Error("expected full subscriber to stay connected")
+	}
+}
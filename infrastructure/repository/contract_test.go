@@ -0,0 +1,1244 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"messaging-app/domain"
+)
+
+// The contract suites run the same checks against every implementation of a
+// repository interface. newRepo must return an empty repository.
+
+func testMessageRepository(t *testing.T, newRepo func(t *testing.T) MessageRepository) {
+	t.Run("Basic", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+
+		// Create a message.
+		msg := &domain.Message{
+			ChatID:    1,
+			SenderID:  1,
+			Content:   "Test message",
+			Timestamp: time.Now(),
+			Status:    domain.MessageStatusSent,
+		}
+		createdMsg, err := repo.CreateMessage(ctx, msg)
+		if err != nil {
+			t.Fatalf("CreateMessage failed: %v", err)
+		}
+		if createdMsg.ID == 0 {
+			t.Error("expected non-zero message ID")
+		}
+
+		// Test GetMessagesByChatID.
+		messages, err := repo.GetMessagesByChatID(ctx, 1, 0)
+		if err != nil {
+			t.Fatalf("GetMessagesByChatID failed: %v", err)
+		}
+		if len(messages) != 1 {
+			t.Errorf("expected 1 message, got %d", len(messages))
+		}
+
+		// Test GetMessageByID for an existing message.
+		retrievedMsg, err := repo.GetMessageByID(ctx, createdMsg.ID)
+		if err != nil {
+			t.Fatalf("GetMessageByID failed: %v", err)
+		}
+		if retrievedMsg.ID != createdMsg.ID {
+			t.Errorf("expected message ID %d, got %d", createdMsg.ID, retrievedMsg.ID)
+		}
+
+		// Test GetMessageByID for a non-existent message.
+		_, err = repo.GetMessageByID(ctx, 999)
+		if err == nil {
+			t.Error("expected error for non-existent message, got nil")
+		}
+
+		// Test updating message status.
+		err = repo.UpdateMessageStatus(ctx, createdMsg.ID, domain.MessageStatusSent, domain.MessageStatusDelivered)
+		if err != nil {
+			t.Fatalf("UpdateMessageStatus failed: %v", err)
+		}
+
+		// Verify update.
+		messages, err = repo.GetMessagesByChatID(ctx, 1, 0)
+		if err != nil {
+			t.Fatalf("GetMessagesByChatID failed: %v", err)
+		}
+		if messages[0].Status != domain.MessageStatusDelivered {
+			t.Errorf("expected status 'delivered', got %s", messages[0].Status)
+		}
+
+		// Test updating a non-existent message.
+		err = repo.UpdateMessageStatus(ctx, 999, domain.MessageStatusSent, domain.MessageStatusDelivered)
+		if err == nil {
+			t.Error("expected error when updating non-existent message, got nil")
+		}
+	})
+	t.Run("GetMessagesSince", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+
+		for _, chatID := range []int64{1, 2, 1, 3, 1} {
+			if _, err := repo.CreateMessage(ctx, &domain.Message{ChatID: chatID, SenderID: 1, Timestamp: time.Now()}); err != nil {
+				t.Fatalf("CreateMessage failed: %v", err)
+			}
+		}
+
+		// Messages 3 and 5 are in chat 1; message 4 is in chat 3.
+		messages, err := repo.GetMessagesSince(ctx, []int64{1, 3}, 1, 2)
+		if err != nil {
+			t.Fatalf("GetMessagesSince failed: %v", err)
+		}
+		if len(messages) != 3 {
+			t.Fatalf("expected 3 messages, got %d", len(messages))
+		}
+		for i, expectedID := range []int64{3, 4, 5} {
+			if messages[i].ID != expectedID {
+				t.Errorf("expected message %d at position %d, got %d", expectedID, i, messages[i].ID)
+			}
+		}
+	})
+	t.Run("GetMessagesSince_Deleted", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+
+		var ids []int64
+		for _, content := range []string{"one", "two", "three"} {
+			msg, err := repo.CreateMessage(ctx, &domain.Message{ChatID: 1, SenderID: 1, Content: content, Timestamp: time.Now()})
+			if err != nil {
+				t.Fatalf("CreateMessage failed: %v", err)
+			}
+			ids = append(ids, msg.ID)
+		}
+		if err := repo.HideMessage(ctx, ids[0], 2); err != nil {
+			t.Fatalf("HideMessage failed: %v", err)
+		}
+		if _, err := repo.DeleteMessage(ctx, ids[1], time.Now()); err != nil {
+			t.Fatalf("DeleteMessage failed: %v", err)
+		}
+
+		// User 2 no longer sees the first message; the second is a tombstone.
+		messages, err := repo.GetMessagesSince(ctx, []int64{1}, 2, 0)
+		if err != nil {
+			t.Fatalf("GetMessagesSince failed: %v", err)
+		}
+		if len(messages) != 2 || messages[0].ID != ids[1] || messages[1].ID != ids[2] {
+			t.Fatalf("expected messages %d and %d, got %+v", ids[1], ids[2], messages)
+		}
+		if !messages[0].IsDeleted() || messages[0].Content != "" {
+			t.Errorf("expected a tombstone, got %+v", messages[0])
+		}
+		// The sender still sees the first message.
+		messages, _ = repo.GetMessagesSince(ctx, []int64{1}, 1, 0)
+		if len(messages) != 3 {
+			t.Errorf("expected 3 messages for the sender, got %d", len(messages))
+		}
+	})
+	t.Run("GetMessagePage", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+
+		// Insert five messages out of timestamp order.
+		base := time.Now()
+		for _, offset := range []int{2, 0, 4, 1, 3} {
+			if _, err := repo.CreateMessage(ctx, &domain.Message{
+				ChatID:    1,
+				SenderID:  1,
+				Content:   strconv.Itoa(offset),
+				Timestamp: base.Add(time.Duration(offset) * time.Second),
+			}); err != nil {
+				t.Fatalf("CreateMessage failed: %v", err)
+			}
+		}
+		contents := func(page *domain.MessagePage) string {
+			var s string
+			for _, msg := range page.Messages {
+				s += msg.Content
+			}
+			return s
+		}
+
+		// The first page holds the newest messages, oldest first.
+		page, err := repo.GetMessagePage(ctx, 1, domain.MessageQuery{Limit: 2})
+		if err != nil {
+			t.Fatalf("GetMessagePage failed: %v", err)
+		}
+		if contents(page) != "34" || page.NextCursor == "" {
+			t.Fatalf("expected messages 34 with a cursor, got %q cursor %q", contents(page), page.NextCursor)
+		}
+
+		// Page backwards.
+		before, _ := domain.DecodeMessageCursor(page.NextCursor)
+		page, _ = repo.GetMessagePage(ctx, 1, domain.MessageQuery{Before: before, Limit: 2})
+		if contents(page) != "12" || page.NextCursor == "" {
+			t.Fatalf("expected messages 12 with a cursor, got %q cursor %q", contents(page), page.NextCursor)
+		}
+		before, _ = domain.DecodeMessageCursor(page.NextCursor)
+		page, _ = repo.GetMessagePage(ctx, 1, domain.MessageQuery{Before: before, Limit: 2})
+		if contents(page) != "0" || page.NextCursor != "" {
+			t.Fatalf("expected last message 0 without a cursor, got %q cursor %q", contents(page), page.NextCursor)
+		}
+
+		// Page forwards from the oldest message.
+		after := domain.CursorFor(page.Messages[0])
+		page, _ = repo.GetMessagePage(ctx, 1, domain.MessageQuery{After: after, Limit: 3})
+		if contents(page) != "123" || page.NextCursor == "" {
+			t.Fatalf("expected messages 123 with a cursor, got %q cursor %q", contents(page), page.NextCursor)
+		}
+
+		// Unknown chats have an empty history.
+		page, err = repo.GetMessagePage(ctx, 999, domain.MessageQuery{Limit: 2})
+		if err != nil {
+			t.Fatalf("GetMessagePage failed: %v", err)
+		}
+		if len(page.Messages) != 0 {
+			t.Errorf("expected no messages, got %d", len(page.Messages))
+		}
+	})
+	t.Run("Outbox", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+
+		// A failing builder stores neither the message nor the entry.
+		_, err := repo.CreateMessageWithOutbox(ctx, &domain.Message{ChatID: 1}, func(msg *domain.Message) (*domain.OutboxEntry, error) {
+			return nil, errors.New("boom")
+		})
+		if err == nil {
+			t.Fatal("expected error from failing builder, got nil")
+		}
+		if _, err := repo.GetMessagesByChatID(ctx, 1, 0); err == nil {
+			t.Error("expected no messages after failed create")
+		}
+
+		msg, err := repo.CreateMessageWithOutbox(ctx, &domain.Message{ChatID: 1, Timestamp: time.Now()}, func(msg *domain.Message) (*domain.OutboxEntry, error) {
+			return &domain.OutboxEntry{EventType: domain.EventTypeMessageSent, AggregateID: msg.ID, Payload: []byte(`{}`)}, nil
+		})
+		if err != nil {
+			t.Fatalf("CreateMessageWithOutbox failed: %v", err)
+		}
+		entries, err := repo.GetPendingOutboxEntries(ctx, time.Now(), 10)
+		if err != nil {
+			t.Fatalf("GetPendingOutboxEntries failed: %v", err)
+		}
+		if len(entries) != 1 || entries[0].AggregateID != msg.ID {
+			t.Fatalf("expected 1 entry for message %d, got %+v", msg.ID, entries)
+		}
+
+		// A failed entry is hidden until its next attempt is due.
+		next := time.Now().Add(time.Minute)
+		if err := repo.MarkOutboxEntryFailed(ctx, entries[0].ID, "broker down", next); err != nil {
+			t.Fatalf("MarkOutboxEntryFailed failed: %v", err)
+		}
+		entries, _ = repo.GetPendingOutboxEntries(ctx, time.Now(), 10)
+		if len(entries) != 0 {
+			t.Errorf("expected no due entries, got %d", len(entries))
+		}
+		entries, _ = repo.GetPendingOutboxEntries(ctx, next, 10)
+		if len(entries) != 1 {
+			t.Fatalf("expected 1 due entry, got %d", len(entries))
+		}
+
+		sent := entries[0].ID
+		if err := repo.MarkOutboxEntrySent(ctx, sent); err != nil {
+			t.Fatalf("MarkOutboxEntrySent failed: %v", err)
+		}
+		entries, _ = repo.GetPendingOutboxEntries(ctx, next, 10)
+		if len(entries) != 0 {
+			t.Errorf("expected no pending entries after send, got %d", len(entries))
+		}
+		// Sent entries are removed rather than kept around.
+		if err := repo.MarkOutboxEntrySent(ctx, sent); err == nil || err.GetStatus() != http.StatusNotFound {
+			t.Errorf("expected not found for a sent entry, got %v", err)
+		}
+	})
+	t.Run("ClaimOutboxEntries", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+
+		for i := 0; i < 3; i++ {
+			if _, err := repo.CreateMessageWithOutbox(ctx, &domain.Message{ChatID: 1, Timestamp: time.Now()}, func(msg *domain.Message) (*domain.OutboxEntry, error) {
+				return &domain.OutboxEntry{EventType: domain.EventTypeMessageSent, AggregateID: msg.ID, Payload: []byte(`{}`)}, nil
+			}); err != nil {
+				t.Fatalf("CreateMessageWithOutbox failed: %v", err)
+			}
+		}
+
+		now := time.Now()
+		first, err := repo.ClaimOutboxEntries(ctx, now, time.Minute, 2)
+		if err != nil {
+			t.Fatalf("ClaimOutboxEntries failed: %v", err)
+		}
+		if len(first) != 2 || first[0].ID >= first[1].ID {
+			t.Fatalf("expected the 2 oldest entries in order, got %+v", first)
+		}
+
+		// A second relay only gets what the first one left.
+		second, _ := repo.ClaimOutboxEntries(ctx, now, time.Minute, 10)
+		if len(second) != 1 || second[0].ID == first[0].ID || second[0].ID == first[1].ID {
+			t.Fatalf("expected the remaining entry, got %+v", second)
+		}
+		if entries, _ := repo.ClaimOutboxEntries(ctx, now, time.Minute, 10); len(entries) != 0 {
+			t.Errorf("expected no entries while leased, got %d", len(entries))
+		}
+
+		// Entries of a relay that never reported back are claimed again.
+		if entries, _ := repo.ClaimOutboxEntries(ctx, now.Add(time.Minute), time.Minute, 10); len(entries) != 3 {
+			t.Errorf("expected 3 entries after the lease, got %d", len(entries))
+		}
+	})
+	t.Run("WritesWithOutbox", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+		msg, _ := repo.CreateMessage(ctx, &domain.Message{ChatID: 1, SenderID: 1, Content: "hello", Timestamp: time.Now(), Status: domain.MessageStatusSent})
+		failing := func(*domain.Message) (*domain.OutboxEntry, error) { return nil, errors.New("boom") }
+		entryFor := func(eventType string) OutboxEntryBuilder {
+			return func(m *domain.Message) (*domain.OutboxEntry, error) {
+				return &domain.OutboxEntry{EventType: eventType, AggregateID: m.ID, Payload: []byte(`{}`)}, nil
+			}
+		}
+
+		// A failing builder leaves the change undone.
+		if err := repo.UpdateMessageStatusWithOutbox(ctx, msg.ID, domain.MessageStatusSent, domain.MessageStatusDelivered, failing); err == nil {
+			t.Error("expected error from failing builder, got nil")
+		}
+		if stored, _ := repo.GetMessageByID(ctx, msg.ID); stored.Status != domain.MessageStatusSent {
+			t.Errorf("expected the status change to be undone, got %s", stored.Status)
+		}
+		if history, _ := repo.GetStatusHistory(ctx, msg.ID); len(history) != 0 {
+			t.Errorf("expected no transitions, got %+v", history)
+		}
+		if err := repo.UpdateMessageStatusWithOutbox(ctx, msg.ID, domain.MessageStatusSent, domain.MessageStatusDelivered, entryFor(domain.EventTypeMessageStatusChanged)); err != nil {
+			t.Fatalf("UpdateMessageStatusWithOutbox failed: %v", err)
+		}
+
+		if _, err := repo.EditMessageWithOutbox(ctx, msg.ID, "edited", time.Now(), failing); err == nil {
+			t.Error("expected error from failing builder, got nil")
+		}
+		if stored, _ := repo.GetMessageByID(ctx, msg.ID); stored.Content != "hello" {
+			t.Errorf("expected the edit to be undone, got %q", stored.Content)
+		}
+		if edited, err := repo.EditMessageWithOutbox(ctx, msg.ID, "edited", time.Now(), entryFor(domain.EventTypeMessageEdited)); err != nil || edited.Content != "edited" {
+			t.Fatalf("EditMessageWithOutbox failed: %v", err)
+		}
+
+		reaction := &domain.Reaction{MessageID: msg.ID, UserID: 2, Emoji: "👍", CreatedAt: time.Now()}
+		if err := repo.SetReactionWithOutbox(ctx, reaction, failing); err == nil {
+			t.Error("expected error from failing builder, got nil")
+		}
+		if reactions, _ := repo.GetReactions(ctx, msg.ID); len(reactions) != 0 {
+			t.Errorf("expected the reaction to be undone, got %+v", reactions)
+		}
+		if err := repo.SetReactionWithOutbox(ctx, reaction, entryFor(domain.EventTypeReactionAdded)); err != nil {
+			t.Fatalf("SetReactionWithOutbox failed: %v", err)
+		}
+		failingRemoval := func(*domain.Reaction) (*domain.OutboxEntry, error) { return nil, errors.New("boom") }
+		if _, err := repo.RemoveReactionWithOutbox(ctx, msg.ID, 2, failingRemoval); err == nil {
+			t.Error("expected error from failing builder, got nil")
+		}
+		if reactions, _ := repo.GetReactions(ctx, msg.ID); len(reactions) != 1 {
+			t.Errorf("expected the removal to be undone, got %+v", reactions)
+		}
+		removed, err := repo.RemoveReactionWithOutbox(ctx, msg.ID, 2, func(removed *domain.Reaction) (*domain.OutboxEntry, error) {
+			if removed.Emoji != "👍" {
+				t.Errorf("expected the removed reaction to be passed to the builder, got %+v", removed)
+			}
+			return &domain.OutboxEntry{EventType: domain.EventTypeReactionRemoved, AggregateID: removed.MessageID, Payload: []byte(`{}`)}, nil
+		})
+		if err != nil || removed.Emoji != "👍" {
+			t.Fatalf("RemoveReactionWithOutbox failed: %v", err)
+		}
+
+		failingRead := func([]*domain.Message) (*domain.OutboxEntry, error) { return nil, errors.New("boom") }
+		if _, err := repo.MarkChatReadWithOutbox(ctx, 1, 2, msg.ID, time.Now(), failingRead); err == nil {
+			t.Error("expected error from failing builder, got nil")
+		}
+		if stored, _ := repo.GetMessageByID(ctx, msg.ID); stored.ReceiptFor(2) != nil {
+			t.Errorf("expected the read to be undone, got %+v", stored.Receipts)
+		}
+		read, err := repo.MarkChatReadWithOutbox(ctx, 1, 2, msg.ID, time.Now(), func(messages []*domain.Message) (*domain.OutboxEntry, error) {
+			return &domain.OutboxEntry{EventType: domain.EventTypeChatRead, AggregateID: messages[0].ID, Payload: []byte(`{}`)}, nil
+		})
+		if err != nil || len(read) != 1 {
+			t.Fatalf("MarkChatReadWithOutbox failed: %v, %+v", err, read)
+		}
+		// Nothing is built when no message was newly read.
+		if read, err := repo.MarkChatReadWithOutbox(ctx, 1, 2, msg.ID, time.Now(), failingRead); err != nil || len(read) != 0 {
+			t.Errorf("expected nothing newly read, got %+v, %v", read, err)
+		}
+
+		if err := repo.HideMessageWithOutbox(ctx, msg.ID, 2, failing); err == nil {
+			t.Error("expected error from failing builder, got nil")
+		}
+		if messages, _ := repo.GetMessagesByChatID(ctx, 1, 2); len(messages) != 1 {
+			t.Errorf("expected the hide to be undone, got %+v", messages)
+		}
+		// Hiding twice records a single event.
+		for i := 0; i < 2; i++ {
+			if err := repo.HideMessageWithOutbox(ctx, msg.ID, 2, entryFor(domain.EventTypeMessageDeleted)); err != nil {
+				t.Fatalf("HideMessageWithOutbox failed: %v", err)
+			}
+		}
+
+		if _, err := repo.DeleteMessageWithOutbox(ctx, msg.ID, time.Now(), failing); err == nil {
+			t.Error("expected error from failing builder, got nil")
+		}
+		if stored, _ := repo.GetMessageByID(ctx, msg.ID); stored.IsDeleted() {
+			t.Error("expected the deletion to be undone")
+		}
+		if _, err := repo.DeleteMessageWithOutbox(ctx, msg.ID, time.Now(), entryFor(domain.EventTypeMessageDeleted)); err != nil {
+			t.Fatalf("DeleteMessageWithOutbox failed: %v", err)
+		}
+
+		entries, _ := repo.GetPendingOutboxEntries(ctx, time.Now(), 0)
+		expected := []string{
+			domain.EventTypeMessageStatusChanged,
+			domain.EventTypeMessageEdited,
+			domain.EventTypeReactionAdded,
+			domain.EventTypeReactionRemoved,
+			domain.EventTypeChatRead,
+			domain.EventTypeMessageDeleted,
+			domain.EventTypeMessageDeleted,
+		}
+		if len(entries) != len(expected) {
+			t.Fatalf("expected %d entries, got %+v", len(expected), entries)
+		}
+		for i, entry := range entries {
+			if entry.EventType != expected[i] || entry.AggregateID != msg.ID {
+				t.Errorf("entry %d: expected %s for message %d, got %+v", i, expected[i], msg.ID, entry)
+			}
+		}
+	})
+	t.Run("StatusHistory", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+
+		msg, _ := repo.CreateMessage(ctx, &domain.Message{ChatID: 1, SenderID: 1, Timestamp: time.Now(), Status: domain.MessageStatusSent})
+		history, err := repo.GetStatusHistory(ctx, msg.ID)
+		if err != nil {
+			t.Fatalf("GetStatusHistory failed: %v", err)
+		}
+		if len(history) != 0 {
+			t.Errorf("expected no transitions for a new message, got %+v", history)
+		}
+
+		if err := repo.UpdateMessageStatus(ctx, msg.ID, domain.MessageStatusSent, domain.MessageStatusDelivered); err != nil {
+			t.Fatalf("UpdateMessageStatus failed: %v", err)
+		}
+		if err := repo.UpdateMessageStatus(ctx, msg.ID, domain.MessageStatusDelivered, domain.MessageStatusRead); err != nil {
+			t.Fatalf("UpdateMessageStatus failed: %v", err)
+		}
+		// The message is no longer sent.
+		err = repo.UpdateMessageStatus(ctx, msg.ID, domain.MessageStatusSent, domain.MessageStatusFailed)
+		if err == nil || err.GetStatus() != http.StatusConflict {
+			t.Errorf("expected 409 for a stale status, got %v", err)
+		}
+
+		history, _ = repo.GetStatusHistory(ctx, msg.ID)
+		if len(history) != 2 {
+			t.Fatalf("expected 2 transitions, got %+v", history)
+		}
+		if history[0].From != domain.MessageStatusSent || history[0].To != domain.MessageStatusDelivered ||
+			history[1].From != domain.MessageStatusDelivered || history[1].To != domain.MessageStatusRead {
+			t.Errorf("unexpected transitions %+v, %+v", history[0], history[1])
+		}
+		if history[0].MessageID != msg.ID || history[0].At.IsZero() || history[1].At.Before(history[0].At) {
+			t.Errorf("unexpected transition details %+v, %+v", history[0], history[1])
+		}
+		if stored, _ := repo.GetMessageByID(ctx, msg.ID); stored.Status != domain.MessageStatusRead {
+			t.Errorf("expected status 'read', got %s", stored.Status)
+		}
+
+		if _, err := repo.GetStatusHistory(ctx, 999); err == nil || err.GetStatus() != http.StatusNotFound {
+			t.Errorf("expected 404 for non-existent message, got %v", err)
+		}
+	})
+	t.Run("Edits", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+		sent := time.Now().Truncate(time.Millisecond)
+		msg, _ := repo.CreateMessage(ctx, &domain.Message{ChatID: 1, SenderID: 1, Content: "helo", Timestamp: sent, Status: domain.MessageStatusSent})
+		if msg.IsEdited() {
+			t.Error("expected a new message not to be edited")
+		}
+
+		first, second := sent.Add(time.Second), sent.Add(time.Minute)
+		if _, err := repo.EditMessage(ctx, msg.ID, "hello", first); err != nil {
+			t.Fatalf("EditMessage failed: %v", err)
+		}
+		edited, err := repo.EditMessage(ctx, msg.ID, "hello!", second)
+		if err != nil {
+			t.Fatalf("EditMessage failed: %v", err)
+		}
+		if edited.Content != "hello!" || !edited.EditedAt.Equal(second) {
+			t.Errorf("unexpected edited message %+v", edited)
+		}
+		stored, _ := repo.GetMessageByID(ctx, msg.ID)
+		if stored.Content != "hello!" || stored.EditedAt == nil || !stored.EditedAt.Equal(second) || stored.Status != domain.MessageStatusSent {
+			t.Errorf("unexpected stored message %+v", stored)
+		}
+
+		revisions, err := repo.GetMessageRevisions(ctx, msg.ID)
+		if err != nil {
+			t.Fatalf("GetMessageRevisions failed: %v", err)
+		}
+		if len(revisions) != 2 {
+			t.Fatalf("expected 2 revisions, got %d", len(revisions))
+		}
+		if revisions[0].Content != "helo" || !revisions[0].CreatedAt.Equal(sent) || !revisions[0].ReplacedAt.Equal(first) {
+			t.Errorf("unexpected first revision %+v", revisions[0])
+		}
+		if revisions[1].Content != "hello" || !revisions[1].CreatedAt.Equal(first) || !revisions[1].ReplacedAt.Equal(second) {
+			t.Errorf("unexpected second revision %+v", revisions[1])
+		}
+
+		if _, err := repo.EditMessage(ctx, 999, "hello", second); err == nil || err.GetStatus() != http.StatusNotFound {
+			t.Errorf("expected not found for a non-existent message, got %v", err)
+		}
+		if _, err := repo.GetMessageRevisions(ctx, 999); err == nil || err.GetStatus() != http.StatusNotFound {
+			t.Errorf("expected not found for a non-existent message, got %v", err)
+		}
+
+		// A tombstone cannot be edited back to life.
+		if _, err := repo.DeleteMessage(ctx, msg.ID, time.Now()); err != nil {
+			t.Fatalf("DeleteMessage failed: %v", err)
+		}
+		if _, err := repo.EditMessage(ctx, msg.ID, "hello again", time.Now()); err == nil || err.GetStatus() != http.StatusConflict {
+			t.Errorf("expected conflict for a deleted message, got %v", err)
+		}
+		stored, _ = repo.GetMessageByID(ctx, msg.ID)
+		if stored.Content != "" || !stored.IsDeleted() {
+			t.Errorf("expected the tombstone to stay empty, got %+v", stored)
+		}
+		if revisions, _ := repo.GetMessageRevisions(ctx, msg.ID); len(revisions) != 0 {
+			t.Errorf("expected no revisions of a deleted message, got %+v", revisions)
+		}
+	})
+	t.Run("ConcurrentEdits", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+		msg, _ := repo.CreateMessage(ctx, &domain.Message{ChatID: 1, SenderID: 1, Content: "v0", Timestamp: time.Now(), Status: domain.MessageStatusSent})
+
+		const edits = 8
+		var wg sync.WaitGroup
+		for i := 1; i <= edits; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if _, err := repo.EditMessage(ctx, msg.ID, "v"+strconv.Itoa(i), time.Now()); err != nil {
+					t.Errorf("EditMessage failed: %v", err)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		// Every content is kept exactly once: each edit saw the one before.
+		revisions, _ := repo.GetMessageRevisions(ctx, msg.ID)
+		stored, _ := repo.GetMessageByID(ctx, msg.ID)
+		seen := map[string]bool{stored.Content: true}
+		for _, revision := range revisions {
+			if seen[revision.Content] {
+				t.Errorf("content %q was kept twice", revision.Content)
+			}
+			seen[revision.Content] = true
+		}
+		if len(revisions) != edits || len(seen) != edits+1 || !seen["v0"] {
+			t.Errorf("expected %d distinct revisions, got %+v", edits, revisions)
+		}
+	})
+
+	t.Run("Deletion", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+		var ids []int64
+		for i := 0; i < 3; i++ {
+			msg, _ := repo.CreateMessage(ctx, &domain.Message{ChatID: 1, SenderID: 1, Content: "msg" + strconv.Itoa(i), Timestamp: time.Now(), Status: domain.MessageStatusSent})
+			ids = append(ids, msg.ID)
+		}
+		if _, err := repo.EditMessage(ctx, ids[0], "edited", time.Now()); err != nil {
+			t.Fatalf("EditMessage failed: %v", err)
+		}
+
+		// The first message becomes a tombstone for everyone.
+		deletedAt := time.Now().Truncate(time.Millisecond)
+		deleted, err := repo.DeleteMessage(ctx, ids[0], deletedAt)
+		if err != nil {
+			t.Fatalf("DeleteMessage failed: %v", err)
+		}
+		if deleted.Content != "" || !deleted.IsDeleted() || !deleted.DeletedAt.Equal(deletedAt) {
+			t.Errorf("unexpected tombstone %+v", deleted)
+		}
+		if revisions, _ := repo.GetMessageRevisions(ctx, ids[0]); len(revisions) != 0 {
+			t.Errorf("expected the revisions to be dropped, got %+v", revisions)
+		}
+
+		// User 2 deletes the second message for themselves, twice.
+		for i := 0; i < 2; i++ {
+			if err := repo.HideMessage(ctx, ids[1], 2); err != nil {
+				t.Fatalf("HideMessage failed: %v", err)
+			}
+		}
+		messages, _ := repo.GetMessagesByChatID(ctx, 1, 2)
+		if len(messages) != 2 || messages[0].ID != ids[0] || messages[0].Content != "" || messages[1].ID != ids[2] {
+			t.Errorf("expected the tombstone and the last message for user 2, got %+v", messages)
+		}
+		if messages, _ := repo.GetMessagesByChatID(ctx, 1, 3); len(messages) != 3 {
+			t.Errorf("expected all 3 messages for user 3, got %d", len(messages))
+		}
+		page, _ := repo.GetMessagePage(ctx, 1, domain.MessageQuery{Limit: 2, UserID: 2})
+		if len(page.Messages) != 2 || page.Messages[0].ID != ids[0] || page.NextCursor != "" {
+			t.Errorf("expected a single page without the hidden message, got %+v", page)
+		}
+		if page, _ := repo.GetMessagePage(ctx, 1, domain.MessageQuery{Limit: 10}); len(page.Messages) != 3 {
+			t.Errorf("expected all 3 messages without a user, got %d", len(page.Messages))
+		}
+
+		if _, err := repo.DeleteMessage(ctx, 999, deletedAt); err == nil || err.GetStatus() != http.StatusNotFound {
+			t.Errorf("expected not found for a non-existent message, got %v", err)
+		}
+		if err := repo.HideMessage(ctx, 999, 2); err == nil || err.GetStatus() != http.StatusNotFound {
+			t.Errorf("expected not found for a non-existent message, got %v", err)
+		}
+	})
+
+	t.Run("Replies", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+		base := time.Now()
+		send := func(replyTo int64, offset time.Duration) int64 {
+			msg, err := repo.CreateMessage(ctx, &domain.Message{ChatID: 1, SenderID: 1, Content: "msg", Timestamp: base.Add(offset), Status: domain.MessageStatusSent, ReplyToMessageID: replyTo})
+			if err != nil {
+				t.Fatalf("CreateMessage failed: %v", err)
+			}
+			return msg.ID
+		}
+		// root <- a <- c, root <- b, and an unrelated message.
+		root := send(0, 0)
+		a := send(root, time.Second)
+		other := send(0, 2*time.Second)
+		b := send(root, 3*time.Second)
+		c := send(a, 4*time.Second)
+
+		stored, _ := repo.GetMessageByID(ctx, c)
+		if stored.ReplyToMessageID != a {
+			t.Errorf("expected message %d to reply to %d, got %d", c, a, stored.ReplyToMessageID)
+		}
+		counts := map[int64]int{root: 2, a: 1, other: 0, b: 0, c: 0}
+		messages, _ := repo.GetMessagesByChatID(ctx, 1, 0)
+		for _, msg := range messages {
+			if msg.ReplyCount != counts[msg.ID] {
+				t.Errorf("expected %d replies to message %d in the history, got %d", counts[msg.ID], msg.ID, msg.ReplyCount)
+			}
+		}
+		page, _ := repo.GetMessagePage(ctx, 1, domain.MessageQuery{Limit: 10})
+		if len(page.Messages) != 5 || page.Messages[0].ReplyCount != 2 {
+			t.Errorf("expected the page to carry reply counts, got %+v", page.Messages)
+		}
+
+		thread, err := repo.GetThread(ctx, root, 0)
+		if err != nil {
+			t.Fatalf("GetThread failed: %v", err)
+		}
+		if len(thread) != 4 || thread[0].ID != root || thread[1].ID != a || thread[2].ID != b || thread[3].ID != c {
+			t.Errorf("expected the thread %d, %d, %d, %d, got %+v", root, a, b, c, thread)
+		}
+		if thread[0].ReplyCount != 2 || thread[1].ReplyCount != 1 {
+			t.Errorf("expected the thread to carry reply counts, got %+v", thread)
+		}
+		if thread, _ := repo.GetThread(ctx, a, 0); len(thread) != 2 || thread[0].ID != a || thread[1].ID != c {
+			t.Errorf("expected the sub-thread %d, %d, got %+v", a, c, thread)
+		}
+		if err := repo.HideMessage(ctx, a, 2); err != nil {
+			t.Fatalf("HideMessage failed: %v", err)
+		}
+		if thread, _ := repo.GetThread(ctx, root, 2); len(thread) != 3 || thread[1].ID != b {
+			t.Errorf("expected the thread without the hidden reply, got %+v", thread)
+		}
+		if thread, err := repo.GetThread(ctx, a, 2); err != nil || len(thread) != 1 || thread[0].ID != c {
+			t.Errorf("expected only the reply under a hidden root, got %+v, %v", thread, err)
+		}
+
+		if _, err := repo.GetThread(ctx, 999, 0); err == nil || err.GetStatus() != http.StatusNotFound {
+			t.Errorf("expected not found for a non-existent message, got %v", err)
+		}
+	})
+
+	t.Run("Reactions", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+		msg, _ := repo.CreateMessage(ctx, &domain.Message{ChatID: 1, SenderID: 1, Content: "hello", Timestamp: time.Now(), Status: domain.MessageStatusSent})
+		base := time.Now().Truncate(time.Millisecond)
+		react := func(userID int64, emoji string, offset time.Duration) {
+			if err := repo.SetReaction(ctx, &domain.Reaction{MessageID: msg.ID, UserID: userID, Emoji: emoji, CreatedAt: base.Add(offset)}); err != nil {
+				t.Fatalf("SetReaction failed: %v", err)
+			}
+		}
+		react(2, "👍", 0)
+		react(3, "❤️", time.Second)
+		react(1, "👍", 2*time.Second)
+		// User 2 changes their mind.
+		react(2, "❤️", 3*time.Second)
+
+		reactions, err := repo.GetReactions(ctx, msg.ID)
+		if err != nil {
+			t.Fatalf("GetReactions failed: %v", err)
+		}
+		if len(reactions) != 3 || reactions[0].UserID != 3 || reactions[2].UserID != 2 || reactions[2].Emoji != "❤️" || !reactions[2].CreatedAt.Equal(base.Add(3*time.Second)) {
+			t.Errorf("expected the reactions of users 3, 1 and 2, got %+v", reactions)
+		}
+		stored, _ := repo.GetMessageByID(ctx, msg.ID)
+		if len(stored.Reactions) != 2 || stored.Reactions[0].Emoji != "❤️" || stored.Reactions[0].Count != 2 || stored.Reactions[1].Count != 1 {
+			t.Errorf("unexpected reaction counts %+v", stored.Reactions)
+		}
+		if messages, _ := repo.GetMessagesByChatID(ctx, 1, 0); len(messages[0].Reactions) != 2 {
+			t.Errorf("expected the history to carry reaction counts, got %+v", messages[0].Reactions)
+		}
+		if page, _ := repo.GetMessagePage(ctx, 1, domain.MessageQuery{}); len(page.Messages[0].Reactions) != 2 {
+			t.Errorf("expected the page to carry reaction counts, got %+v", page.Messages[0].Reactions)
+		}
+
+		removed, err := repo.RemoveReaction(ctx, msg.ID, 3)
+		if err != nil {
+			t.Fatalf("RemoveReaction failed: %v", err)
+		}
+		if removed.UserID != 3 || removed.Emoji != "❤️" {
+			t.Errorf("unexpected removed reaction %+v", removed)
+		}
+		if _, err := repo.RemoveReaction(ctx, msg.ID, 3); err == nil || err.GetStatus() != http.StatusNotFound {
+			t.Errorf("expected not found removing a missing reaction, got %v", err)
+		}
+		if reactions, _ := repo.GetReactions(ctx, msg.ID); len(reactions) != 2 {
+			t.Errorf("expected 2 reactions left, got %+v", reactions)
+		}
+
+		// A tombstone keeps no reactions.
+		if _, err := repo.DeleteMessage(ctx, msg.ID, time.Now()); err != nil {
+			t.Fatalf("DeleteMessage failed: %v", err)
+		}
+		if reactions, _ := repo.GetReactions(ctx, msg.ID); len(reactions) != 0 {
+			t.Errorf("expected the reactions to be dropped, got %+v", reactions)
+		}
+
+		if err := repo.SetReaction(ctx, &domain.Reaction{MessageID: 999, UserID: 1, Emoji: "👍", CreatedAt: base}); err == nil || err.GetStatus() != http.StatusNotFound {
+			t.Errorf("expected not found for a non-existent message, got %v", err)
+		}
+		if _, err := repo.GetReactions(ctx, 999); err == nil || err.GetStatus() != http.StatusNotFound {
+			t.Errorf("expected not found for a non-existent message, got %v", err)
+		}
+	})
+
+	t.Run("Attachments", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+		created := time.Now().Truncate(time.Millisecond)
+		upload := func(chatID, uploaderID int64, key string) *domain.Attachment {
+			attachment, err := repo.CreateAttachment(ctx, &domain.Attachment{
+				ChatID: chatID, UploaderID: uploaderID, FileName: key + ".png", ContentType: domain.AttachmentTypePNG,
+				Size: 42, StorageKey: key, CreatedAt: created,
+			})
+			if err != nil {
+				t.Fatalf("CreateAttachment failed: %v", err)
+			}
+			return attachment
+		}
+		first, second, foreign, others := upload(1, 1, "a"), upload(1, 1, "b"), upload(2, 1, "c"), upload(1, 2, "d")
+		if first.ID == 0 || second.ID == first.ID {
+			t.Fatalf("expected distinct IDs, got %d and %d", first.ID, second.ID)
+		}
+		stored, err := repo.GetAttachment(ctx, first.ID)
+		if err != nil {
+			t.Fatalf("GetAttachment failed: %v", err)
+		}
+		if stored.StorageKey != "a" || stored.Size != 42 || stored.ContentType != domain.AttachmentTypePNG || !stored.CreatedAt.Equal(created) || stored.MessageID != 0 {
+			t.Errorf("unexpected attachment %+v", stored)
+		}
+		if _, err := repo.GetAttachment(ctx, 999); err == nil || err.GetStatus() != http.StatusNotFound {
+			t.Errorf("expected not found for a non-existent attachment, got %v", err)
+		}
+		if found, _ := repo.GetAttachments(ctx, []int64{second.ID, 999, first.ID}); len(found) != 2 || found[0].ID != first.ID || found[1].ID != second.ID {
+			t.Errorf("expected the two existing attachments in ID order, got %+v", found)
+		}
+		if stored.Thumbnail != nil {
+			t.Errorf("expected no thumbnail before one is generated, got %+v", stored.Thumbnail)
+		}
+		thumbnail := &domain.Thumbnail{Width: 320, Height: 240, ContentType: domain.AttachmentTypePNG, Size: 7}
+		if err := repo.SetAttachmentThumbnail(ctx, first.ID, thumbnail); err != nil {
+			t.Fatalf("SetAttachmentThumbnail failed: %v", err)
+		}
+		if stored, _ := repo.GetAttachment(ctx, first.ID); stored.Thumbnail == nil || *stored.Thumbnail != *thumbnail {
+			t.Errorf("expected thumbnail %+v, got %+v", thumbnail, stored.Thumbnail)
+		}
+		if err := repo.SetAttachmentThumbnail(ctx, 999, thumbnail); err == nil || err.GetStatus() != http.StatusNotFound {
+			t.Errorf("expected not found for the thumbnail of a non-existent attachment, got %v", err)
+		}
+
+		for _, ids := range [][]int64{{foreign.ID}, {others.ID}, {999}, {first.ID, first.ID}} {
+			if _, err := repo.CreateMessage(ctx, &domain.Message{ChatID: 1, SenderID: 1, Timestamp: time.Now(), Status: domain.MessageStatusSent, AttachmentIDs: ids}); err == nil || err.GetStatus() != http.StatusConflict {
+				t.Errorf("expected a conflict sending attachments %v, got %v", ids, err)
+			}
+		}
+		msg, err := repo.CreateMessage(ctx, &domain.Message{ChatID: 1, SenderID: 1, Content: "files", Timestamp: time.Now(), Status: domain.MessageStatusSent, AttachmentIDs: []int64{second.ID, first.ID}})
+		if err != nil {
+			t.Fatalf("CreateMessage failed: %v", err)
+		}
+		if messages, _ := repo.GetMessagesByChatID(ctx, 1, 0); len(messages) != 1 || len(messages[0].AttachmentIDs) != 2 || messages[0].AttachmentIDs[0] != first.ID {
+			t.Errorf("expected the message to reference both attachments, got %+v", messages)
+		}
+		if linked, _ := repo.GetAttachment(ctx, first.ID); linked.MessageID != msg.ID {
+			t.Errorf("expected the attachment to reference message %d, got %+v", msg.ID, linked)
+		}
+		// An attachment is sent once.
+		if _, err := repo.CreateMessage(ctx, &domain.Message{ChatID: 1, SenderID: 1, Timestamp: time.Now(), Status: domain.MessageStatusSent, AttachmentIDs: []int64{first.ID}}); err == nil || err.GetStatus() != http.StatusConflict {
+			t.Errorf("expected a conflict resending an attachment, got %v", err)
+		}
+
+		deleted, err := repo.DeleteMessage(ctx, msg.ID, time.Now())
+		if err != nil {
+			t.Fatalf("DeleteMessage failed: %v", err)
+		}
+		if len(deleted.AttachmentIDs) != 0 {
+			t.Errorf("expected a tombstone without attachments, got %v", deleted.AttachmentIDs)
+		}
+	})
+	t.Run("AttachmentWithOutbox", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+		newAttachment := func() *domain.Attachment {
+			return &domain.Attachment{ChatID: 1, UploaderID: 1, FileName: "a.png", ContentType: domain.AttachmentTypePNG, Size: 42, StorageKey: "a", CreatedAt: time.Now()}
+		}
+
+		failed := newAttachment()
+		if _, err := repo.CreateAttachmentWithOutbox(ctx, failed, func(*domain.Attachment) (*domain.OutboxEntry, error) {
+			return nil, errors.New("boom")
+		}); err == nil {
+			t.Error("expected error from failing builder, got nil")
+		}
+		if failed.ID != 0 {
+			t.Errorf("expected no ID after a failed create, got %d", failed.ID)
+		}
+		attachment, err := repo.CreateAttachmentWithOutbox(ctx, newAttachment(), func(a *domain.Attachment) (*domain.OutboxEntry, error) {
+			return &domain.OutboxEntry{EventType: domain.EventTypeAttachmentUploaded, AggregateID: a.ID, Payload: []byte(`{}`)}, nil
+		})
+		if err != nil {
+			t.Fatalf("CreateAttachmentWithOutbox failed: %v", err)
+		}
+		if found, _ := repo.GetAttachments(ctx, []int64{1, 2, 3}); len(found) != 1 || found[0].ID != attachment.ID {
+			t.Errorf("expected only the second attachment to be stored, got %+v", found)
+		}
+		entries, _ := repo.GetPendingOutboxEntries(ctx, time.Now(), 0)
+		if len(entries) != 1 || entries[0].EventType != domain.EventTypeAttachmentUploaded || entries[0].AggregateID != attachment.ID {
+			t.Errorf("expected one %s entry for attachment %d, got %+v", domain.EventTypeAttachmentUploaded, attachment.ID, entries)
+		}
+	})
+
+	t.Run("Receipts", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+
+		// Users 1 and 2 chat with user 3; message 3 is user 2's own.
+		var ids []int64
+		for _, senderID := range []int64{1, 1, 2, 1} {
+			msg, err := repo.CreateMessage(ctx, &domain.Message{ChatID: 1, SenderID: senderID, Timestamp: time.Now(), Status: domain.MessageStatusSent})
+			if err != nil {
+				t.Fatalf("CreateMessage failed: %v", err)
+			}
+			ids = append(ids, msg.ID)
+		}
+
+		delivered := time.Now().Truncate(time.Millisecond)
+		msg, err := repo.MarkMessageDelivered(ctx, ids[0], []int64{3, 1, 2}, delivered)
+		if err != nil {
+			t.Fatalf("MarkMessageDelivered failed: %v", err)
+		}
+		if len(msg.Receipts) != 2 || msg.Receipts[0].UserID != 2 || msg.Receipts[1].UserID != 3 {
+			t.Fatalf("expected receipts for users 2 and 3, got %+v", msg.Receipts)
+		}
+		// A second delivery keeps the first time.
+		msg, _ = repo.MarkMessageDelivered(ctx, ids[0], []int64{2}, delivered.Add(time.Second))
+		if !msg.ReceiptFor(2).DeliveredAt.Equal(delivered) || msg.ReceiptFor(2).ReadAt != nil {
+			t.Errorf("expected user 2 to keep the first delivery time, got %+v", msg.ReceiptFor(2))
+		}
+		if _, err := repo.MarkMessageDelivered(ctx, 999, []int64{2}, delivered); err == nil {
+			t.Error("expected error for non-existent message, got nil")
+		}
+
+		// User 2 reads up to message 3, skipping their own.
+		read := delivered.Add(time.Minute)
+		messages, err := repo.MarkChatRead(ctx, 1, 2, ids[2], read)
+		if err != nil {
+			t.Fatalf("MarkChatRead failed: %v", err)
+		}
+		if len(messages) != 2 || messages[0].ID != ids[0] || messages[1].ID != ids[1] {
+			t.Fatalf("expected messages %v, got %+v", ids[:2], messages)
+		}
+		first := messages[0].ReceiptFor(2)
+		if !first.DeliveredAt.Equal(delivered) || !first.ReadAt.Equal(read) {
+			t.Errorf("expected message %d delivered then read, got %+v", ids[0], first)
+		}
+		second := messages[1].ReceiptFor(2)
+		if second == nil || !second.DeliveredAt.Equal(read) || !second.ReadAt.Equal(read) {
+			t.Errorf("expected message %d delivered and read together, got %+v", ids[1], second)
+		}
+
+		// Receipts come back with the history.
+		page, _ := repo.GetMessagePage(ctx, 1, domain.MessageQuery{Limit: 10})
+		if len(page.Messages) != 4 || page.Messages[0].ReceiptFor(3) == nil || page.Messages[3].ReceiptFor(2) != nil {
+			t.Errorf("unexpected receipts in history %+v", page.Messages)
+		}
+		stored, _ := repo.GetMessageByID(ctx, ids[1])
+		if stored.ReceiptFor(2) == nil || stored.ReceiptFor(2).ReadAt == nil {
+			t.Errorf("expected message %d to be read by user 2, got %+v", ids[1], stored.Receipts)
+		}
+
+		// Reading again marks only what is new.
+		messages, err = repo.MarkChatRead(ctx, 1, 2, ids[3], read.Add(time.Minute))
+		if err != nil {
+			t.Fatalf("MarkChatRead failed: %v", err)
+		}
+		if len(messages) != 1 || messages[0].ID != ids[3] {
+			t.Errorf("expected only message %d, got %+v", ids[3], messages)
+		}
+	})
+
+	t.Run("SummarizeChats", func(t *testing.T) {
+		repo := newRepo(t)
+		ctx := context.Background()
+		base := time.Now()
+		busy := &domain.Chat{ID: 1, Type: domain.ChatTypeGroup, ParticipantIDs: []int64{1, 2, 3}, CreatedAt: base}
+		empty := &domain.Chat{ID: 2, Participant1ID: 1, Participant2ID: 2, CreatedAt: base}
+
+		var ids []int64
+		for i, senderID := range []int64{2, 1, 3, 2} {
+			msg, err := repo.CreateMessage(ctx, &domain.Message{ChatID: 1, SenderID: senderID, Content: strconv.Itoa(i), Timestamp: base.Add(time.Duration(i+1) * time.Second), Status: domain.MessageStatusSent})
+			if err != nil {
+				t.Fatalf("CreateMessage failed: %v", err)
+			}
+			ids = append(ids, msg.ID)
+		}
+		// Another chat's messages do not count.
+		repo.CreateMessage(ctx, &domain.Message{ChatID: 3, SenderID: 2, Timestamp: base, Status: domain.MessageStatusSent})
+
+		summaries, err := repo.SummarizeChats(ctx, 1, []*domain.Chat{empty, busy})
+		if err != nil {
+			t.Fatalf("SummarizeChats failed: %v", err)
+		}
+		if len(summaries) != 2 || summaries[0].Chat != empty || summaries[1].Chat != busy {
+			t.Fatalf("expected summaries in the given order, got %+v", summaries)
+		}
+		if summaries[0].LastMessage != nil || summaries[0].UnreadCount != 0 || !summaries[0].LastActivityAt.Equal(base) {
+			t.Errorf("unexpected summary of the empty chat %+v", summaries[0])
+		}
+		if last := summaries[1].LastMessage; last == nil || last.ID != ids[3] || !summaries[1].LastActivityAt.Equal(last.Timestamp) {
+			t.Errorf("expected last message %d, got %+v", ids[3], last)
+		}
+		// User 1 has not read anything; their own message does not count.
+		if summaries[1].UnreadCount != 3 {
+			t.Errorf("expected 3 unread messages, got %d", summaries[1].UnreadCount)
+		}
+
+		if _, err := repo.MarkChatRead(ctx, 1, 1, ids[2], time.Now()); err != nil {
+			t.Fatalf("MarkChatRead failed: %v", err)
+		}
+		summaries, _ = repo.SummarizeChats(ctx, 1, []*domain.Chat{busy})
+		if len(summaries) != 1 || summaries[0].UnreadCount != 1 {
+			t.Errorf("expected 1 unread message after reading, got %+v", summaries)
+		}
+		// The sender of the last message has nothing unread after it.
+		summaries, _ = repo.SummarizeChats(ctx, 2, []*domain.Chat{busy})
+		if summaries[0].UnreadCount != 2 {
+			t.Errorf("expected 2 unread messages for user 2, got %d", summaries[0].UnreadCount)
+		}
+
+		// Hidden messages are left out for the user who hid them only, and
+		// tombstones for everyone.
+		if err := repo.HideMessage(ctx, ids[3], 1); err != nil {
+			t.Fatalf("HideMessage failed: %v", err)
+		}
+		if _, err := repo.DeleteMessage(ctx, ids[2], time.Now()); err != nil {
+			t.Fatalf("DeleteMessage failed: %v", err)
+		}
+		summaries, _ = repo.SummarizeChats(ctx, 1, []*domain.Chat{busy})
+		if last := summaries[0].LastMessage; last == nil || last.ID != ids[1] || summaries[0].UnreadCount != 0 {
+			t.Errorf("expected last message %d and nothing unread for user 1, got %+v", ids[1], summaries[0])
+		}
+		summaries, _ = repo.SummarizeChats(ctx, 2, []*domain.Chat{busy})
+		if last := summaries[0].LastMessage; last == nil || last.ID != ids[3] || summaries[0].UnreadCount != 1 {
+			t.Errorf("expected last message %d and 1 unread message for user 2, got %+v", ids[3], summaries[0])
+		}
+		if summaries, err := repo.SummarizeChats(ctx, 1, nil); err != nil || len(summaries) != 0 {
+			t.Errorf("expected no summaries, got %+v, %v", summaries, err)
+		}
+	})
+}
+
+// testChatRepository checks a chat repository. newRepo also returns the
+// message repository holding the outbox of the chat repository.
+func testChatRepository(t *testing.T, newRepo func(t *testing.T) (ChatRepository, MessageRepository)) {
+	t.Run("Basic", func(t *testing.T) {
+		repo, _ := newRepo(t)
+		ctx := context.Background()
+
+		// Create a chat.
+		chat := &domain.Chat{
+			Participant1ID: 1,
+			Participant2ID: 2,
+			Metadata:       "Test chat",
+			CreatedAt:      time.Now(),
+		}
+		createdChat, err := repo.CreateChat(ctx, chat)
+		if err != nil {
+			t.Fatalf("CreateChat failed: %v", err)
+		}
+		if createdChat.ID == 0 {
+			t.Error("expected non-zero chat ID")
+		}
+
+		// Fetch the chat by ID.
+		fetchedChat, err := repo.GetChatByID(ctx, createdChat.ID)
+		if err != nil {
+			t.Fatalf("GetChatByID failed: %v", err)
+		}
+		if fetchedChat.Metadata != "Test chat" {
+			t.Errorf("expected metadata 'Test chat', got '%s'", fetchedChat.Metadata)
+		}
+
+		// Test GetChatsByUserID for a user with chats.
+		chats, err := repo.GetChatsByUserID(ctx, 1)
+		if err != nil {
+			t.Fatalf("GetChatsByUserID failed: %v", err)
+		}
+		if len(chats) < 1 {
+			t.Errorf("expected at least 1 chat for user 1, got %d", len(chats))
+		}
+
+		// Test GetChatsByUserID for a user with no chats.
+		chats, err = repo.GetChatsByUserID(ctx, 999)
+		if err != nil {
+			t.Fatalf("GetChatsByUserID failed: %v", err)
+		}
+		if len(chats) != 0 {
+			t.Errorf("expected 0 chats for user 999, got %d", len(chats))
+		}
+	})
+	t.Run("GroupMembers", func(t *testing.T) {
+		repo, _ := newRepo(t)
+		ctx := context.Background()
+
+		group, err := repo.CreateChat(ctx, &domain.Chat{
+			Type:           domain.ChatTypeGroup,
+			Title:          "Team",
+			ParticipantIDs: []int64{1, 2, 3},
+		})
+		if err != nil {
+			t.Fatalf("CreateChat failed: %v", err)
+		}
+
+		// Add a member and find the group through them.
+		if _, err := repo.AddParticipant(ctx, group.ID, 4); err != nil {
+			t.Fatalf("AddParticipant failed: %v", err)
+		}
+		chats, err := repo.GetChatsByUserID(ctx, 4)
+		if err != nil {
+			t.Fatalf("GetChatsByUserID failed: %v", err)
+		}
+		if len(chats) != 1 || chats[0].ID != group.ID {
+			t.Errorf("expected user 4 to be in group %d, got %+v", group.ID, chats)
+		}
+
+		// Remove a member.
+		updated, err := repo.RemoveParticipant(ctx, group.ID, 2)
+		if err != nil {
+			t.Fatalf("RemoveParticipant failed: %v", err)
+		}
+		if updated.HasParticipant(2) {
+			t.Error("expected user 2 to be removed from the group")
+		}
+		if len(updated.ParticipantIDs) != 3 {
+			t.Errorf("expected 3 members, got %d", len(updated.ParticipantIDs))
+		}
+
+		// Unknown chat.
+		if _, err := repo.AddParticipant(ctx, 999, 1); err == nil {
+			t.Error("expected error when adding to non-existent chat, got nil")
+		}
+	})
+	t.Run("WritesWithOutbox", func(t *testing.T) {
+		repo, messages := newRepo(t)
+		ctx := context.Background()
+		failing := func(*domain.Chat) (*domain.OutboxEntry, error) { return nil, errors.New("boom") }
+		entryFor := func(eventType string) ChatOutboxEntryBuilder {
+			return func(chat *domain.Chat) (*domain.OutboxEntry, error) {
+				return &domain.OutboxEntry{EventType: eventType, AggregateID: chat.ID, Payload: []byte(`{}`)}, nil
+			}
+		}
+		newGroup := func() *domain.Chat {
+			return &domain.Chat{Type: domain.ChatTypeGroup, Title: "Team", ParticipantIDs: []int64{1, 2, 3}}
+		}
+
+		// A failing builder leaves the change undone.
+		failed := newGroup()
+		if _, err := repo.CreateChatWithOutbox(ctx, failed, failing); err == nil {
+			t.Error("expected error from failing builder, got nil")
+		}
+		if chats, _ := repo.GetChatsByUserID(ctx, 1); len(chats) != 0 || failed.ID != 0 {
+			t.Errorf("expected the chat to be undone, got %+v", chats)
+		}
+		group, err := repo.CreateChatWithOutbox(ctx, newGroup(), entryFor(domain.EventTypeChatCreated))
+		if err != nil {
+			t.Fatalf("CreateChatWithOutbox failed: %v", err)
+		}
+
+		if _, err := repo.AddParticipantWithOutbox(ctx, group.ID, 4, failing); err == nil {
+			t.Error("expected error from failing builder, got nil")
+		}
+		if _, err := repo.RemoveParticipantWithOutbox(ctx, group.ID, 2, failing); err == nil {
+			t.Error("expected error from failing builder, got nil")
+		}
+		if stored, _ := repo.GetChatByID(ctx, group.ID); len(stored.Participants()) != 3 || stored.HasParticipant(4) || !stored.HasParticipant(2) {
+			t.Errorf("expected the membership changes to be undone, got %+v", stored.Participants())
+		}
+		if added, err := repo.AddParticipantWithOutbox(ctx, group.ID, 4, entryFor(domain.EventTypeChatMemberAdded)); err != nil || !added.HasParticipant(4) {
+			t.Fatalf("AddParticipantWithOutbox failed: %v", err)
+		}
+		if removed, err := repo.RemoveParticipantWithOutbox(ctx, group.ID, 2, entryFor(domain.EventTypeChatMemberRemoved)); err != nil || removed.HasParticipant(2) {
+			t.Fatalf("RemoveParticipantWithOutbox failed: %v", err)
+		}
+
+		entries, _ := messages.GetPendingOutboxEntries(ctx, time.Now(), 0)
+		expected := []string{domain.EventTypeChatCreated, domain.EventTypeChatMemberAdded, domain.EventTypeChatMemberRemoved}
+		if len(entries) != len(expected) {
+			t.Fatalf("expected %d entries, got %+v", len(expected), entries)
+		}
+		for i, entry := range entries {
+			if entry.EventType != expected[i] || entry.AggregateID != group.ID {
+				t.Errorf("entry %d: expected %s for chat %d, got %+v", i, expected[i], group.ID, entry)
+			}
+		}
+	})
+}
+
+// testUserRepository checks a user repository. Unlike the other suites,
+// newRepo must return a repository holding only the four seeded users.
+func testUserRepository(t *testing.T, newRepo func(t *testing.T) UserRepository) {
+	repo := newRepo(t)
+	ctx := context.Background()
+
+	// The original four users are seeded.
+	users, err := repo.ListUsers(ctx)
+	if err != nil {
+		t.Fatalf("ListUsers failed: %v", err)
+	}
+	if len(users) != 4 {
+		t.Fatalf("expected 4 seeded users, got %d", len(users))
+	}
+	if users[0].Name != "Red" {
+		t.Errorf("expected first user 'Red', got '%s'", users[0].Name)
+	}
+
+	// Create a user.
+	createdUser, err := repo.CreateUser(ctx, &domain.User{Name: "Ayo"})
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	if createdUser.ID != 5 {
+		t.Errorf("expected user ID 5, got %d", createdUser.ID)
+	}
+
+	// Update the user.
+	_, err = repo.UpdateUser(ctx, &domain.User{ID: createdUser.ID, Name: "Ayomide"})
+	if err != nil {
+		t.Fatalf("UpdateUser failed: %v", err)
+	}
+	fetchedUser, err := repo.GetUserByID(ctx, createdUser.ID)
+	if err != nil {
+		t.Fatalf("GetUserByID failed: %v", err)
+	}
+	if fetchedUser.Name != "Ayomide" {
+		t.Errorf("expected name 'Ayomide', got '%s'", fetchedUser.Name)
+	}
+
+	// Delete the user.
+	if err := repo.DeleteUser(ctx, createdUser.ID); err != nil {
+		t.Fatalf("DeleteUser failed: %v", err)
+	}
+	if _, err := repo.GetUserByID(ctx, createdUser.ID); err == nil {
+		t.Error("expected error for deleted user, got nil")
+	}
+	if err := repo.DeleteUser(ctx, 999); err == nil {
+		t.Error("expected error when deleting non-existent user, got nil")
+	}
+}
+
+func testDeadLetterRepository(t *testing.T, newRepo func(t *testing.T) DeadLetterRepository) {
+	repo := newRepo(t)
+	ctx := context.Background()
+
+	// Every field is kept for replaying.
+	deadLetteredAt := time.Unix(1700000000, 123456789).UTC()
+	rejected := &domain.DeadLetter{
+		MessageID:      "msg-1",
+		CorrelationID:  "req-1",
+		EventType:      "message.created",
+		RoutingKey:     "messages",
+		Queue:          "messages",
+		Reason:         "rejected",
+		LastError:      "recipient busy",
+		RetryCount:     3,
+		ContentType:    "application/json",
+		Body:           `{"id":1}`,
+		DeadLetteredAt: deadLetteredAt,
+	}
+	for _, dl := range []*domain.DeadLetter{rejected, {Reason: "expired", DeadLetteredAt: deadLetteredAt}} {
+		if _, err := repo.AddDeadLetter(ctx, dl); err != nil {
+			t.Fatalf("AddDeadLetter failed: %s", err.GetMessage())
+		}
+	}
+	deadLetters, err := repo.ListDeadLetters(ctx)
+	if err != nil {
+		t.Fatalf("ListDeadLetters failed: %s", err.GetMessage())
+	}
+	if len(deadLetters) != 2 || deadLetters[0].Reason != "rejected" || deadLetters[1].Reason != "expired" {
+		t.Fatalf("expected 2 dead letters oldest first, got %+v", deadLetters)
+	}
+	if deadLetters[0].ID == 0 || deadLetters[1].ID <= deadLetters[0].ID {
+		t.Errorf("expected increasing dead letter IDs, got %d and %d", deadLetters[0].ID, deadLetters[1].ID)
+	}
+
+	fetched, err := repo.GetDeadLetter(ctx, deadLetters[0].ID)
+	if err != nil {
+		t.Fatalf("GetDeadLetter failed: %s", err.GetMessage())
+	}
+	want := *rejected
+	want.ID = deadLetters[0].ID
+	if !fetched.DeadLetteredAt.Equal(want.DeadLetteredAt) {
+		t.Errorf("expected dead-lettered at %v, got %v", want.DeadLetteredAt, fetched.DeadLetteredAt)
+	}
+	got := *fetched
+	got.DeadLetteredAt = want.DeadLetteredAt
+	if got != want {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+
+	// Delete one, then purge the rest.
+	if err := repo.DeleteDeadLetter(ctx, deadLetters[0].ID); err != nil {
+		t.Fatalf("DeleteDeadLetter failed: %s", err.GetMessage())
+	}
+	if _, err := repo.GetDeadLetter(ctx, deadLetters[0].ID); err == nil || err.GetStatus() != http.StatusNotFound {
+		t.Errorf("expected not found for deleted dead letter, got %v", err)
+	}
+	if err := repo.DeleteDeadLetter(ctx, deadLetters[0].ID); err == nil || err.GetStatus() != http.StatusNotFound {
+		t.Errorf("expected not found when deleting twice, got %v", err)
+	}
+	if n, _ := repo.DeleteAllDeadLetters(ctx); n != 1 {
+		t.Errorf("expected 1 purged dead letter, got %d", n)
+	}
+	if n, _ := repo.DeleteAllDeadLetters(ctx); n != 0 {
+		t.Errorf("expected nothing left to purge, got %d", n)
+	}
+}
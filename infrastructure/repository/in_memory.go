@@ -4,23 +4,290 @@ import (
 	"context"
 	"messaging-app/domain"
 	"messaging-app/pkg/apistatus"
+	"sort"
 	"sync"
 	"time"
 )
 
+// UserRepository defines methods for user data.
+type UserRepository interface {
+	CreateUser(ctx context.Context, user *domain.User) (*domain.User, apistatus.Status)
+	GetUserByID(ctx context.Context, userID int64) (*domain.User, apistatus.Status)
+	ListUsers(ctx context.Context) ([]*domain.User, apistatus.Status)
+	UpdateUser(ctx context.Context, user *domain.User) (*domain.User, apistatus.Status)
+	DeleteUser(ctx context.Context, userID int64) apistatus.Status
+}
+
 // ChatRepository defines methods for chat data.
 type ChatRepository interface {
 	CreateChat(ctx context.Context, chat *domain.Chat) (*domain.Chat, apistatus.Status)
+	// CreateChatWithOutbox creates the chat like CreateChat and stores the
+	// outbox entry built for it together with it.
+	CreateChatWithOutbox(ctx context.Context, chat *domain.Chat, buildEntry ChatOutboxEntryBuilder) (*domain.Chat, apistatus.Status)
 	GetChatByID(ctx context.Context, chatID int64) (*domain.Chat, apistatus.Status)
 	GetChatsByUserID(ctx context.Context, userID int64) ([]*domain.Chat, apistatus.Status)
+	AddParticipant(ctx context.Context, chatID, userID int64) (*domain.Chat, apistatus.Status)
+	// AddParticipantWithOutbox adds the participant like AddParticipant and
+	// stores the outbox entry built for the updated chat together with it.
+	AddParticipantWithOutbox(ctx context.Context, chatID, userID int64, buildEntry ChatOutboxEntryBuilder) (*domain.Chat, apistatus.Status)
+	RemoveParticipant(ctx context.Context, chatID, userID int64) (*domain.Chat, apistatus.Status)
+	// RemoveParticipantWithOutbox removes the participant like
+	// RemoveParticipant and stores the outbox entry built for the updated
+	// chat together with it.
+	RemoveParticipantWithOutbox(ctx context.Context, chatID, userID int64, buildEntry ChatOutboxEntryBuilder) (*domain.Chat, apistatus.Status)
 }
 
-// MessageRepository defines methods for message data.
+// ChatOutboxEntryBuilder builds the outbox entry for a chat once it has been
+// assigned an ID.
+type ChatOutboxEntryBuilder func(chat *domain.Chat) (*domain.OutboxEntry, error)
+
+// OutboxEntryBuilder builds the outbox entry for a message once it has been
+// assigned an ID.
+type OutboxEntryBuilder func(msg *domain.Message) (*domain.OutboxEntry, error)
+
+// ReactionOutboxEntryBuilder builds the outbox entry for a removed reaction.
+type ReactionOutboxEntryBuilder func(removed *domain.Reaction) (*domain.OutboxEntry, error)
+
+// AttachmentOutboxEntryBuilder builds the outbox entry for an attachment once
+// it has been assigned an ID.
+type AttachmentOutboxEntryBuilder func(attachment *domain.Attachment) (*domain.OutboxEntry, error)
+
+// ReadOutboxEntryBuilder builds the outbox entry for the messages a user
+// newly read.
+type ReadOutboxEntryBuilder func(messages []*domain.Message) (*domain.OutboxEntry, error)
+
+// OutboxRepository defines methods for the transactional outbox.
+type OutboxRepository interface {
+	AddOutboxEntry(ctx context.Context, entry *domain.OutboxEntry) (*domain.OutboxEntry, apistatus.Status)
+	GetPendingOutboxEntries(ctx context.Context, now time.Time, limit int) ([]*domain.OutboxEntry, apistatus.Status)
+	// ClaimOutboxEntries returns up to limit pending entries that are due at
+	// now, oldest first, and moves their next attempt to now+lease so relays
+	// sharing the outbox do not publish the same entry. Entries of a relay
+	// that dies mid-batch are claimed again once the lease expires.
+	ClaimOutboxEntries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*domain.OutboxEntry, apistatus.Status)
+	// MarkOutboxEntrySent removes a published entry, so the outbox only
+	// ever holds the entries still waiting for the broker.
+	MarkOutboxEntrySent(ctx context.Context, entryID int64) apistatus.Status
+	MarkOutboxEntryFailed(ctx context.Context, entryID int64, reason string, nextAttemptAt time.Time) apistatus.Status
+}
+
+// MessageRepository defines methods for message data. The outbox is stored
+// alongside messages so both are written together.
 type MessageRepository interface {
+	OutboxRepository
 	CreateMessage(ctx context.Context, msg *domain.Message) (*domain.Message, apistatus.Status)
-	GetMessagesByChatID(ctx context.Context, chatID int64) ([]*domain.Message, apistatus.Status)
-	UpdateMessageStatus(ctx context.Context, messageID int64, status domain.MessageStatus) apistatus.Status
+	CreateMessageWithOutbox(ctx context.Context, msg *domain.Message, buildEntry OutboxEntryBuilder) (*domain.Message, apistatus.Status)
+	// GetMessagesByChatID returns the history of chatID. Unless userID is
+	// zero, messages userID deleted for themselves are left out. Like
+	// GetMessagePage, GetThread and GetMessageByID, it fills in the
+	// ReplyCount and Reactions of each message; other reads leave them empty.
+	GetMessagesByChatID(ctx context.Context, chatID, userID int64) ([]*domain.Message, apistatus.Status)
+	// GetThread returns rootID and every message replying to it, directly or
+	// through other replies, ordered by timestamp and ID. Unless userID is
+	// zero, messages userID deleted for themselves are left out.
+	GetThread(ctx context.Context, rootID, userID int64) ([]*domain.Message, apistatus.Status)
+	// UpdateMessageStatus moves the message from status from to status to and
+	// records the transition. It fails with Conflict when the message is no
+	// longer in status from.
+	UpdateMessageStatus(ctx context.Context, messageID int64, from, to domain.MessageStatus) apistatus.Status
+	// UpdateMessageStatusWithOutbox changes the status like
+	// UpdateMessageStatus and stores the outbox entry built for the updated
+	// message together with it.
+	UpdateMessageStatusWithOutbox(ctx context.Context, messageID int64, from, to domain.MessageStatus, buildEntry OutboxEntryBuilder) apistatus.Status
+	// GetStatusHistory returns the status transitions of the message, oldest
+	// first.
+	GetStatusHistory(ctx context.Context, messageID int64) ([]*domain.StatusTransition, apistatus.Status)
+	// EditMessage replaces the content of the message and sets its EditedAt
+	// to at, keeping the previous content as a revision. It fails with
+	// Conflict when the message has been deleted for everyone.
+	EditMessage(ctx context.Context, messageID int64, content string, at time.Time) (*domain.Message, apistatus.Status)
+	// EditMessageWithOutbox edits the message like EditMessage and stores
+	// the outbox entry built for the edited message together with it.
+	EditMessageWithOutbox(ctx context.Context, messageID int64, content string, at time.Time, buildEntry OutboxEntryBuilder) (*domain.Message, apistatus.Status)
+	// GetMessageRevisions returns the earlier contents of the message, oldest
+	// first.
+	GetMessageRevisions(ctx context.Context, messageID int64) ([]*domain.MessageRevision, apistatus.Status)
+	// DeleteMessage turns the message into a tombstone deleted at at,
+	// dropping its content and revisions.
+	DeleteMessage(ctx context.Context, messageID int64, at time.Time) (*domain.Message, apistatus.Status)
+	// DeleteMessageWithOutbox deletes the message like DeleteMessage and
+	// stores the outbox entry built for the tombstone together with it.
+	DeleteMessageWithOutbox(ctx context.Context, messageID int64, at time.Time, buildEntry OutboxEntryBuilder) (*domain.Message, apistatus.Status)
+	// HideMessage deletes the message for userID only.
+	HideMessage(ctx context.Context, messageID, userID int64) apistatus.Status
+	// HideMessageWithOutbox hides the message like HideMessage and stores
+	// the outbox entry built for it together with it. Nothing is stored when
+	// userID had already hidden the message.
+	HideMessageWithOutbox(ctx context.Context, messageID, userID int64, buildEntry OutboxEntryBuilder) apistatus.Status
+	// GetReactions returns the reactions to the message, oldest first.
+	GetReactions(ctx context.Context, messageID int64) ([]*domain.Reaction, apistatus.Status)
+	// SetReaction stores reaction, replacing any earlier reaction of the same
+	// user to the message.
+	SetReaction(ctx context.Context, reaction *domain.Reaction) apistatus.Status
+	// SetReactionWithOutbox stores reaction like SetReaction and stores the
+	// outbox entry built for the message together with it.
+	SetReactionWithOutbox(ctx context.Context, reaction *domain.Reaction, buildEntry OutboxEntryBuilder) apistatus.Status
+	// RemoveReaction removes the reaction of userID to the message and
+	// returns it. It fails with NotFound when there is none.
+	RemoveReaction(ctx context.Context, messageID, userID int64) (*domain.Reaction, apistatus.Status)
+	// RemoveReactionWithOutbox removes the reaction like RemoveReaction and
+	// stores the outbox entry built for the removed reaction together with it.
+	RemoveReactionWithOutbox(ctx context.Context, messageID, userID int64, buildEntry ReactionOutboxEntryBuilder) (*domain.Reaction, apistatus.Status)
+	// CreateAttachment stores the description of an uploaded file.
+	CreateAttachment(ctx context.Context, attachment *domain.Attachment) (*domain.Attachment, apistatus.Status)
+	// CreateAttachmentWithOutbox stores the attachment like CreateAttachment
+	// and stores the outbox entry built for it together with it.
+	CreateAttachmentWithOutbox(ctx context.Context, attachment *domain.Attachment, buildEntry AttachmentOutboxEntryBuilder) (*domain.Attachment, apistatus.Status)
+	GetAttachment(ctx context.Context, attachmentID int64) (*domain.Attachment, apistatus.Status)
+	// GetAttachments returns the attachments among attachmentIDs that exist,
+	// ordered by ID.
+	GetAttachments(ctx context.Context, attachmentIDs []int64) ([]*domain.Attachment, apistatus.Status)
+	// SetAttachmentThumbnail records the thumbnail generated for an image
+	// attachment, replacing any earlier one.
+	SetAttachmentThumbnail(ctx context.Context, attachmentID int64, thumbnail *domain.Thumbnail) apistatus.Status
 	GetMessageByID(ctx context.Context, messageID int64) (*domain.Message, apistatus.Status)
+	// GetMessagesSince returns the messages of chatIDs with an ID greater
+	// than afterID, ordered by ID. Messages userID deleted for themselves are
+	// left out; messages deleted for everyone come back as tombstones.
+	GetMessagesSince(ctx context.Context, chatIDs []int64, userID, afterID int64) ([]*domain.Message, apistatus.Status)
+	GetMessagePage(ctx context.Context, chatID int64, query domain.MessageQuery) (*domain.MessagePage, apistatus.Status)
+	// MarkMessageDelivered records that userIDs received the message at at,
+	// keeping earlier delivery times, and returns the updated message. The
+	// sender gets no receipt.
+	MarkMessageDelivered(ctx context.Context, messageID int64, userIDs []int64, at time.Time) (*domain.Message, apistatus.Status)
+	// MarkChatRead records that userID read every message of chatID up to and
+	// including upToMessageID at at, and returns the messages it had not read
+	// yet, ordered by ID. Messages sent by userID are skipped.
+	MarkChatRead(ctx context.Context, chatID, userID, upToMessageID int64, at time.Time) ([]*domain.Message, apistatus.Status)
+	// MarkChatReadWithOutbox marks the chat read like MarkChatRead and stores
+	// the outbox entry built for the newly read messages together with them.
+	// Nothing is built when there are none.
+	MarkChatReadWithOutbox(ctx context.Context, chatID, userID, upToMessageID int64, at time.Time, buildEntry ReadOutboxEntryBuilder) ([]*domain.Message, apistatus.Status)
+	// SummarizeChats returns the summary of each of chats for userID, in the
+	// same order. Messages after the last one userID read, other than their
+	// own, count as unread. Messages userID hid and messages deleted for
+	// everyone are neither previewed nor counted.
+	SummarizeChats(ctx context.Context, userID int64, chats []*domain.Chat) ([]*domain.ChatSummary, apistatus.Status)
+}
+
+// DeadLetterRepository defines methods for parked messages.
+type DeadLetterRepository interface {
+	AddDeadLetter(ctx context.Context, dl *domain.DeadLetter) (*domain.DeadLetter, apistatus.Status)
+	ListDeadLetters(ctx context.Context) ([]*domain.DeadLetter, apistatus.Status)
+	GetDeadLetter(ctx context.Context, id int64) (*domain.DeadLetter, apistatus.Status)
+	DeleteDeadLetter(ctx context.Context, id int64) apistatus.Status
+	DeleteAllDeadLetters(ctx context.Context) (int, apistatus.Status)
+}
+
+// seedUserNames are the original four users every user store starts with,
+// so existing chats keep working.
+var seedUserNames = []string{"Red", "Jrue", "Miro", "Joann"}
+
+// InMemoryUserRepository implements UserRepository in memory.
+type InMemoryUserRepository struct {
+	users  map[int64]*domain.User
+	mu     sync.RWMutex
+	nextID int64
+	// journal, when set, records every write before it is applied.
+	journal *Journal
+}
+
+// NewInMemoryUserRepository creates a new repository and seeds the original
+// four users (Red, Jrue, Miro, Joann) so existing chats keep working.
+func NewInMemoryUserRepository() UserRepository {
+	repo := newInMemoryUserRepository()
+	repo.seed()
+	return repo
+}
+
+func newInMemoryUserRepository() *InMemoryUserRepository {
+	return &InMemoryUserRepository{
+		users:  make(map[int64]*domain.User),
+		nextID: 1,
+	}
+}
+
+// seed creates the original four users.
+func (r *InMemoryUserRepository) seed() apistatus.Status {
+	for _, name := range seedUserNames {
+		if _, as := r.CreateUser(context.Background(), &domain.User{Name: name}); as != nil {
+			return as
+		}
+	}
+	return nil
+}
+
+// putUser stores user as is, or removes it when it has no name. It must be
+// called with mu held.
+func (r *InMemoryUserRepository) putUser(user *domain.User) {
+	if user.Name == "" {
+		delete(r.users, user.ID)
+	} else {
+		r.users[user.ID] = user
+	}
+	if user.ID >= r.nextID {
+		r.nextID = user.ID + 1
+	}
+}
+
+func (r *InMemoryUserRepository) CreateUser(ctx context.Context, user *domain.User) (*domain.User, apistatus.Status) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user.ID = r.nextID
+	if as := r.journal.record(&journalRecord{User: user}); as != nil {
+		user.ID = 0
+		return nil, as
+	}
+	r.putUser(user)
+	return user, nil
+}
+
+func (r *InMemoryUserRepository) GetUserByID(ctx context.Context, userID int64) (*domain.User, apistatus.Status) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	user, exists := r.users[userID]
+	if !exists {
+		return nil, apistatus.New("user not found").NotFound()
+	}
+	return user, nil
+}
+
+func (r *InMemoryUserRepository) ListUsers(ctx context.Context) ([]*domain.User, apistatus.Status) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	result := make([]*domain.User, 0, len(r.users))
+	for _, user := range r.users {
+		result = append(result, user)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result, nil
+}
+
+func (r *InMemoryUserRepository) UpdateUser(ctx context.Context, user *domain.User) (*domain.User, apistatus.Status) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.users[user.ID]; !exists {
+		return nil, apistatus.New("user not found").NotFound()
+	}
+	if as := r.journal.record(&journalRecord{User: user}); as != nil {
+		return nil, as
+	}
+	r.putUser(user)
+	return user, nil
+}
+
+func (r *InMemoryUserRepository) DeleteUser(ctx context.Context, userID int64) apistatus.Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.users[userID]; !exists {
+		return apistatus.New("user not found").NotFound()
+	}
+	removal := &domain.User{ID: userID}
+	if as := r.journal.record(&journalRecord{User: removal}); as != nil {
+		return as
+	}
+	r.putUser(removal)
+	return nil
 }
 
 // InMemoryChatRepository implements ChatRepository in memory.
@@ -28,24 +295,76 @@ type InMemoryChatRepository struct {
 	chats  map[int64]*domain.Chat
 	mu     sync.RWMutex
 	nextID int64
+	// journal, when set, records every write before it is applied.
+	journal *Journal
+	// outbox holds the outbox entries of chat writes. Its lock is taken
+	// after mu.
+	outbox *InMemoryMessageRepository
+}
+
+// NewInMemoryChatRepository creates a new repository whose chat events go to
+// the outbox of messages, an in-memory message repository.
+func NewInMemoryChatRepository(messages MessageRepository) ChatRepository {
+	r := newInMemoryChatRepository()
+	r.outbox, _ = messages.(*InMemoryMessageRepository)
+	return r
 }
 
-// NewInMemoryChatRepository creates a new repository and seeds a chat.
-func NewInMemoryChatRepository() ChatRepository {
-	repo := &InMemoryChatRepository{
+func newInMemoryChatRepository() *InMemoryChatRepository {
+	return &InMemoryChatRepository{
 		chats:  make(map[int64]*domain.Chat),
 		nextID: 1,
 	}
-	return repo
+}
+
+// write builds the outbox entry for chat, records chat and the entry in one
+// journal record and applies both. It must be called with mu held.
+func (r *InMemoryChatRepository) write(chat *domain.Chat, buildEntry ChatOutboxEntryBuilder) apistatus.Status {
+	if buildEntry == nil {
+		if as := r.journal.record(&journalRecord{Chat: chat}); as != nil {
+			return as
+		}
+		r.putChat(chat)
+		return nil
+	}
+	if r.outbox == nil {
+		return apistatus.New("chat repository has no outbox").InternalServerError()
+	}
+	r.outbox.mu.Lock()
+	defer r.outbox.mu.Unlock()
+	entry, as := r.outbox.takeOutboxEntry(buildEntry(chat))
+	if as != nil {
+		return as
+	}
+	if as := r.journal.record(&journalRecord{Chat: chat, Outbox: entry}); as != nil {
+		return as
+	}
+	r.putChat(chat)
+	r.outbox.putOutboxEntry(entry)
+	return nil
+}
+
+// putChat stores chat as is. It must be called with mu held.
+func (r *InMemoryChatRepository) putChat(chat *domain.Chat) {
+	r.chats[chat.ID] = chat
+	if chat.ID >= r.nextID {
+		r.nextID = chat.ID + 1
+	}
 }
 
 func (r *InMemoryChatRepository) CreateChat(ctx context.Context, chat *domain.Chat) (*domain.Chat, apistatus.Status) {
+	return r.CreateChatWithOutbox(ctx, chat, nil)
+}
+
+func (r *InMemoryChatRepository) CreateChatWithOutbox(ctx context.Context, chat *domain.Chat, buildEntry ChatOutboxEntryBuilder) (*domain.Chat, apistatus.Status) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	chat.ID = r.nextID
-	r.nextID++
 	chat.CreatedAt = time.Now()
-	r.chats[chat.ID] = chat
+	if as := r.write(chat, buildEntry); as != nil {
+		chat.ID = 0
+		return nil, as
+	}
 	return chat, nil
 }
 
@@ -64,62 +383,790 @@ func (r *InMemoryChatRepository) GetChatsByUserID(ctx context.Context, userID in
 	defer r.mu.RUnlock()
 	var result []*domain.Chat
 	for _, chat := range r.chats {
-		if chat.Participant1ID == userID || chat.Participant2ID == userID {
+		if chat.HasParticipant(userID) {
 			result = append(result, chat)
 		}
 	}
 	return result, nil
 }
 
+func (r *InMemoryChatRepository) AddParticipant(ctx context.Context, chatID, userID int64) (*domain.Chat, apistatus.Status) {
+	return r.AddParticipantWithOutbox(ctx, chatID, userID, nil)
+}
+
+func (r *InMemoryChatRepository) AddParticipantWithOutbox(ctx context.Context, chatID, userID int64, buildEntry ChatOutboxEntryBuilder) (*domain.Chat, apistatus.Status) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	chat, exists := r.chats[chatID]
+	if !exists {
+		return nil, apistatus.New("chat not found").NotFound()
+	}
+	// Copy the chat so readers holding the old pointer are not affected.
+	updated := *chat
+	updated.ParticipantIDs = append(append([]int64{}, chat.Participants()...), userID)
+	if as := r.write(&updated, buildEntry); as != nil {
+		return nil, as
+	}
+	return &updated, nil
+}
+
+func (r *InMemoryChatRepository) RemoveParticipant(ctx context.Context, chatID, userID int64) (*domain.Chat, apistatus.Status) {
+	return r.RemoveParticipantWithOutbox(ctx, chatID, userID, nil)
+}
+
+func (r *InMemoryChatRepository) RemoveParticipantWithOutbox(ctx context.Context, chatID, userID int64, buildEntry ChatOutboxEntryBuilder) (*domain.Chat, apistatus.Status) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	chat, exists := r.chats[chatID]
+	if !exists {
+		return nil, apistatus.New("chat not found").NotFound()
+	}
+	updated := *chat
+	updated.ParticipantIDs = make([]int64, 0, len(chat.Participants()))
+	for _, id := range chat.Participants() {
+		if id != userID {
+			updated.ParticipantIDs = append(updated.ParticipantIDs, id)
+		}
+	}
+	if as := r.write(&updated, buildEntry); as != nil {
+		return nil, as
+	}
+	return &updated, nil
+}
+
 // InMemoryMessageRepository implements MessageRepository in memory.
 type InMemoryMessageRepository struct {
 	messages map[int64]*domain.Message
-	mu       sync.RWMutex
-	nextID   int64
+	// byChat holds the message IDs of each chat ordered by timestamp and ID.
+	byChat map[int64][]int64
+	// transitions holds the status history of each message.
+	transitions map[int64][]*domain.StatusTransition
+	// revisions holds the earlier contents of each edited message.
+	revisions map[int64][]*domain.MessageRevision
+	// hidden holds, per message, the users who deleted it for themselves.
+	hidden map[int64]map[int64]bool
+	// replies holds the IDs of the direct replies to each message.
+	replies map[int64][]int64
+	// reactions holds the reactions to each message, oldest first.
+	reactions map[int64][]*domain.Reaction
+	// attachments holds the uploaded files by ID.
+	attachments map[int64]*domain.Attachment
+	// lastRead holds, per chat and user, the highest message ID the user
+	// has read.
+	lastRead     map[int64]map[int64]int64
+	outbox       map[int64]*domain.OutboxEntry
+	mu           sync.RWMutex
+	nextID       int64
+	nextOutboxID int64
+	// nextAttachmentID is the ID the next uploaded attachment gets.
+	nextAttachmentID int64
+	// journal, when set, records every write before it is applied.
+	journal *Journal
 }
 
 func NewInMemoryMessageRepository() MessageRepository {
+	return newInMemoryMessageRepository()
+}
+
+func newInMemoryMessageRepository() *InMemoryMessageRepository {
 	return &InMemoryMessageRepository{
-		messages: make(map[int64]*domain.Message),
-		nextID:   1,
+		messages:         make(map[int64]*domain.Message),
+		byChat:           make(map[int64][]int64),
+		transitions:      make(map[int64][]*domain.StatusTransition),
+		revisions:        make(map[int64][]*domain.MessageRevision),
+		hidden:           make(map[int64]map[int64]bool),
+		replies:          make(map[int64][]int64),
+		reactions:        make(map[int64][]*domain.Reaction),
+		attachments:      make(map[int64]*domain.Attachment),
+		lastRead:         make(map[int64]map[int64]int64),
+		outbox:           make(map[int64]*domain.OutboxEntry),
+		nextID:           1,
+		nextOutboxID:     1,
+		nextAttachmentID: 1,
 	}
 }
 
+// putMessage stores msg as is, indexing it if it is new. It must be called
+// with mu held.
+func (r *InMemoryMessageRepository) putMessage(msg *domain.Message) {
+	_, exists := r.messages[msg.ID]
+	r.messages[msg.ID] = msg
+	if !exists {
+		r.index(msg)
+		if msg.ReplyToMessageID != 0 {
+			r.replies[msg.ReplyToMessageID] = append(r.replies[msg.ReplyToMessageID], msg.ID)
+		}
+		for _, id := range msg.AttachmentIDs {
+			if attachment, ok := r.attachments[id]; ok {
+				linked := *attachment
+				linked.MessageID = msg.ID
+				r.attachments[id] = &linked
+			}
+		}
+	}
+	if msg.IsDeleted() {
+		delete(r.revisions, msg.ID)
+		delete(r.reactions, msg.ID)
+	}
+	for _, receipt := range msg.Receipts {
+		if receipt.ReadAt != nil {
+			r.markRead(msg.ChatID, receipt.UserID, msg.ID)
+		}
+	}
+	if msg.ID >= r.nextID {
+		r.nextID = msg.ID + 1
+	}
+}
+
+// markRead moves the read position of userID in chatID forward to messageID.
+// It must be called with mu held.
+func (r *InMemoryMessageRepository) markRead(chatID, userID, messageID int64) {
+	users := r.lastRead[chatID]
+	if users == nil {
+		users = make(map[int64]int64)
+		r.lastRead[chatID] = users
+	}
+	if messageID > users[userID] {
+		users[userID] = messageID
+	}
+}
+
+// putOutboxEntry stores entry as is, or drops it once it has been sent. A nil
+// entry is ignored. It must be called with mu held.
+func (r *InMemoryMessageRepository) putOutboxEntry(entry *domain.OutboxEntry) {
+	if entry == nil {
+		return
+	}
+	if entry.Status == domain.OutboxStatusSent {
+		delete(r.outbox, entry.ID)
+	} else {
+		r.outbox[entry.ID] = entry
+	}
+	if entry.ID >= r.nextOutboxID {
+		r.nextOutboxID = entry.ID + 1
+	}
+}
+
+// checkAttachments sorts the attachment IDs of msg and verifies that each
+// one is an upload of the sender to the chat no message references yet. It
+// must be called with mu held.
+func (r *InMemoryMessageRepository) checkAttachments(msg *domain.Message) apistatus.Status {
+	if len(msg.AttachmentIDs) == 0 {
+		return nil
+	}
+	ids := append([]int64{}, msg.AttachmentIDs...)
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for i, id := range ids {
+		attachment, ok := r.attachments[id]
+		if !ok || attachment.ChatID != msg.ChatID || attachment.UploaderID != msg.SenderID || attachment.MessageID != 0 || (i > 0 && ids[i-1] == id) {
+			return errAttachmentsUnavailable()
+		}
+	}
+	msg.AttachmentIDs = ids
+	return nil
+}
+
+// errAttachmentsUnavailable is returned when a new message references
+// attachments it cannot be sent with.
+func errAttachmentsUnavailable() apistatus.Status {
+	return apistatus.New("attachments must be unsent uploads of the sender to the chat").Conflict()
+}
+
 func (r *InMemoryMessageRepository) CreateMessage(ctx context.Context, msg *domain.Message) (*domain.Message, apistatus.Status) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	if as := r.checkAttachments(msg); as != nil {
+		return nil, as
+	}
 	msg.ID = r.nextID
-	r.nextID++
-	r.messages[msg.ID] = msg
+	if as := r.journal.record(&journalRecord{Message: msg}); as != nil {
+		msg.ID = 0
+		return nil, as
+	}
+	r.putMessage(msg)
 	return msg, nil
 }
 
-func (r *InMemoryMessageRepository) GetMessagesByChatID(ctx context.Context, chatID int64) ([]*domain.Message, apistatus.Status) {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
-	var result []*domain.Message
-	for _, msg := range r.messages {
-		if msg.ChatID == chatID {
-			result = append(result, msg)
+// CreateMessageWithOutbox stores msg and the outbox entry built for it under
+// the same lock and in the same journal record, so neither is visible without
+// the other.
+func (r *InMemoryMessageRepository) CreateMessageWithOutbox(ctx context.Context, msg *domain.Message, buildEntry OutboxEntryBuilder) (*domain.Message, apistatus.Status) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if as := r.checkAttachments(msg); as != nil {
+		return nil, as
+	}
+	msg.ID = r.nextID
+	entry, err := buildEntry(msg)
+	if err != nil {
+		msg.ID = 0
+		return nil, apistatus.New(err).InternalServerError()
+	}
+	r.newOutboxEntry(entry)
+	if as := r.journal.record(&journalRecord{Message: msg, Outbox: entry}); as != nil {
+		msg.ID = 0
+		return nil, as
+	}
+	r.putMessage(msg)
+	r.putOutboxEntry(entry)
+	return msg, nil
+}
+
+// index inserts msg into its chat's ordered history. Messages almost always
+// arrive in order, so this is usually an append.
+func (r *InMemoryMessageRepository) index(msg *domain.Message) {
+	ids := r.byChat[msg.ChatID]
+	i := sort.Search(len(ids), func(i int) bool {
+		return domain.MessageLess(msg, r.messages[ids[i]])
+	})
+	ids = append(ids, 0)
+	copy(ids[i+1:], ids[i:])
+	ids[i] = msg.ID
+	r.byChat[msg.ChatID] = ids
+}
+
+// history returns the messages of chatID ordered by timestamp and ID,
+// leaving out those userID deleted for themselves.
+func (r *InMemoryMessageRepository) history(chatID, userID int64) []*domain.Message {
+	ids := r.byChat[chatID]
+	result := make([]*domain.Message, 0, len(ids))
+	for _, id := range ids {
+		if !r.hidden[id][userID] {
+			result = append(result, r.view(r.messages[id]))
 		}
 	}
+	return result
+}
+
+// view returns msg, or a copy of it carrying its reply count and reactions
+// when it has any. It must be called with mu held.
+func (r *InMemoryMessageRepository) view(msg *domain.Message) *domain.Message {
+	count, reactions := len(r.replies[msg.ID]), r.reactions[msg.ID]
+	if count == 0 && len(reactions) == 0 {
+		return msg
+	}
+	viewed := *msg
+	viewed.ReplyCount = count
+	viewed.Reactions = domain.CountReactions(reactions)
+	return &viewed
+}
+
+func (r *InMemoryMessageRepository) GetMessagesByChatID(ctx context.Context, chatID, userID int64) ([]*domain.Message, apistatus.Status) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	result := r.history(chatID, userID)
 	if len(result) == 0 {
 		return nil, apistatus.New("messages not found").NotFound()
 	}
 	return result, nil
 }
 
-func (r *InMemoryMessageRepository) UpdateMessageStatus(ctx context.Context, messageID int64, status domain.MessageStatus) apistatus.Status {
+func (r *InMemoryMessageRepository) GetMessagePage(ctx context.Context, chatID int64, query domain.MessageQuery) (*domain.MessagePage, apistatus.Status) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return paginate(r.history(chatID, query.UserID), query), nil
+}
+
+// paginate applies query to a history ordered by timestamp and ID.
+func paginate(history []*domain.Message, query domain.MessageQuery) *domain.MessagePage {
+	limit := query.Limit
+	if limit <= 0 {
+		limit = domain.DefaultMessagePageSize
+	}
+
+	// Narrow the history to the cursor range.
+	start, end := 0, len(history)
+	if query.After != nil {
+		start = sort.Search(len(history), func(i int) bool { return query.After.IsAfter(history[i]) })
+	}
+	if query.Before != nil {
+		end = sort.Search(len(history), func(i int) bool { return !query.Before.IsBefore(history[i]) })
+	}
+	if start > end {
+		start = end
+	}
+
+	page := &domain.MessagePage{}
+	window := history[start:end]
+	if len(window) > limit {
+		if query.After != nil {
+			window = window[:limit]
+			page.NextCursor = domain.CursorFor(window[len(window)-1]).Encode()
+		} else {
+			window = window[len(window)-limit:]
+			page.NextCursor = domain.CursorFor(window[0]).Encode()
+		}
+	}
+	page.Messages = append([]*domain.Message{}, window...)
+	return page
+}
+
+func (r *InMemoryMessageRepository) GetThread(ctx context.Context, rootID, userID int64) ([]*domain.Message, apistatus.Status) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if _, exists := r.messages[rootID]; !exists {
+		return nil, apistatus.New("message not found").NotFound()
+	}
+	result := []*domain.Message{}
+	for queue := []int64{rootID}; len(queue) > 0; queue = queue[1:] {
+		id := queue[0]
+		if !r.hidden[id][userID] {
+			result = append(result, r.view(r.messages[id]))
+		}
+		queue = append(queue, r.replies[id]...)
+	}
+	sort.Slice(result, func(i, j int) bool { return domain.MessageLess(result[i], result[j]) })
+	return result, nil
+}
+
+func (r *InMemoryMessageRepository) UpdateMessageStatus(ctx context.Context, messageID int64, from, to domain.MessageStatus) apistatus.Status {
+	return r.UpdateMessageStatusWithOutbox(ctx, messageID, from, to, nil)
+}
+
+func (r *InMemoryMessageRepository) UpdateMessageStatusWithOutbox(ctx context.Context, messageID int64, from, to domain.MessageStatus, buildEntry OutboxEntryBuilder) apistatus.Status {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	msg, exists := r.messages[messageID]
 	if !exists {
 		return apistatus.New("message not found").NotFound()
 	}
-	msg.Status = status
+	if msg.Status != from {
+		return apistatus.New("message status is %s, not %s", msg.Status, from).Conflict()
+	}
+	// Copy the message so readers holding the old pointer are not affected.
+	updated := *msg
+	updated.Status = to
+	transition := &domain.StatusTransition{MessageID: messageID, From: from, To: to, At: time.Now()}
+	entry, as := r.buildOutboxEntry(buildEntry, &updated)
+	if as != nil {
+		return as
+	}
+	if as := r.journal.record(&journalRecord{Message: &updated, Transition: transition, Outbox: entry}); as != nil {
+		return as
+	}
+	r.putMessage(&updated)
+	r.putTransition(transition)
+	r.putOutboxEntry(entry)
 	return nil
 }
 
+// putTransition appends transition to the history of its message. It must
+// be called with mu held.
+func (r *InMemoryMessageRepository) putTransition(transition *domain.StatusTransition) {
+	r.transitions[transition.MessageID] = append(r.transitions[transition.MessageID], transition)
+}
+
+func (r *InMemoryMessageRepository) GetStatusHistory(ctx context.Context, messageID int64) ([]*domain.StatusTransition, apistatus.Status) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if _, exists := r.messages[messageID]; !exists {
+		return nil, apistatus.New("message not found").NotFound()
+	}
+	return append([]*domain.StatusTransition{}, r.transitions[messageID]...), nil
+}
+
+func (r *InMemoryMessageRepository) EditMessage(ctx context.Context, messageID int64, content string, at time.Time) (*domain.Message, apistatus.Status) {
+	return r.EditMessageWithOutbox(ctx, messageID, content, at, nil)
+}
+
+// EditMessageWithOutbox checks for a tombstone and reads the content to keep
+// under the same lock as the write, so a concurrent deletion or edit cannot
+// slip in between.
+func (r *InMemoryMessageRepository) EditMessageWithOutbox(ctx context.Context, messageID int64, content string, at time.Time, buildEntry OutboxEntryBuilder) (*domain.Message, apistatus.Status) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	msg, exists := r.messages[messageID]
+	if !exists {
+		return nil, apistatus.New("message not found").NotFound()
+	}
+	if msg.IsDeleted() {
+		return nil, errMessageDeleted()
+	}
+	revision := &domain.MessageRevision{MessageID: messageID, Content: msg.Content, CreatedAt: msg.Timestamp, ReplacedAt: at}
+	if msg.EditedAt != nil {
+		revision.CreatedAt = *msg.EditedAt
+	}
+	updated := *msg
+	updated.Content = content
+	updated.EditedAt = &at
+	entry, as := r.buildOutboxEntry(buildEntry, &updated)
+	if as != nil {
+		return nil, as
+	}
+	if as := r.journal.record(&journalRecord{Message: &updated, Revision: revision, Outbox: entry}); as != nil {
+		return nil, as
+	}
+	r.putMessage(&updated)
+	r.putRevision(revision)
+	r.putOutboxEntry(entry)
+	return &updated, nil
+}
+
+// errMessageDeleted is returned when a write targets a message deleted for
+// everyone.
+func errMessageDeleted() apistatus.Status {
+	return apistatus.New("message has been deleted").Conflict()
+}
+
+// putRevision appends revision to the revisions of its message. It must be
+// called with mu held.
+func (r *InMemoryMessageRepository) putRevision(revision *domain.MessageRevision) {
+	r.revisions[revision.MessageID] = append(r.revisions[revision.MessageID], revision)
+}
+
+func (r *InMemoryMessageRepository) GetMessageRevisions(ctx context.Context, messageID int64) ([]*domain.MessageRevision, apistatus.Status) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if _, exists := r.messages[messageID]; !exists {
+		return nil, apistatus.New("message not found").NotFound()
+	}
+	return append([]*domain.MessageRevision{}, r.revisions[messageID]...), nil
+}
+
+func (r *InMemoryMessageRepository) DeleteMessage(ctx context.Context, messageID int64, at time.Time) (*domain.Message, apistatus.Status) {
+	return r.DeleteMessageWithOutbox(ctx, messageID, at, nil)
+}
+
+func (r *InMemoryMessageRepository) DeleteMessageWithOutbox(ctx context.Context, messageID int64, at time.Time, buildEntry OutboxEntryBuilder) (*domain.Message, apistatus.Status) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	msg, exists := r.messages[messageID]
+	if !exists {
+		return nil, apistatus.New("message not found").NotFound()
+	}
+	updated := *msg
+	updated.Content = ""
+	updated.AttachmentIDs = nil
+	updated.DeletedAt = &at
+	entry, as := r.buildOutboxEntry(buildEntry, &updated)
+	if as != nil {
+		return nil, as
+	}
+	if as := r.journal.record(&journalRecord{Message: &updated, Outbox: entry}); as != nil {
+		return nil, as
+	}
+	r.putMessage(&updated)
+	r.putOutboxEntry(entry)
+	return &updated, nil
+}
+
+func (r *InMemoryMessageRepository) HideMessage(ctx context.Context, messageID, userID int64) apistatus.Status {
+	return r.HideMessageWithOutbox(ctx, messageID, userID, nil)
+}
+
+func (r *InMemoryMessageRepository) HideMessageWithOutbox(ctx context.Context, messageID, userID int64, buildEntry OutboxEntryBuilder) apistatus.Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	msg, exists := r.messages[messageID]
+	if !exists {
+		return apistatus.New("message not found").NotFound()
+	}
+	if r.hidden[messageID][userID] {
+		return nil
+	}
+	entry, as := r.buildOutboxEntry(buildEntry, msg)
+	if as != nil {
+		return as
+	}
+	hidden := &hiddenMessage{MessageID: messageID, UserID: userID}
+	if as := r.journal.record(&journalRecord{Hidden: hidden, Outbox: entry}); as != nil {
+		return as
+	}
+	r.putHidden(hidden)
+	r.putOutboxEntry(entry)
+	return nil
+}
+
+// hiddenMessage records that UserID deleted MessageID for themselves.
+type hiddenMessage struct {
+	MessageID int64 `json:"messageId"`
+	UserID    int64 `json:"userId"`
+}
+
+// putHidden hides a message from a user. It must be called with mu held.
+func (r *InMemoryMessageRepository) putHidden(hidden *hiddenMessage) {
+	users := r.hidden[hidden.MessageID]
+	if users == nil {
+		users = make(map[int64]bool)
+		r.hidden[hidden.MessageID] = users
+	}
+	users[hidden.UserID] = true
+}
+
+func (r *InMemoryMessageRepository) GetReactions(ctx context.Context, messageID int64) ([]*domain.Reaction, apistatus.Status) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if _, exists := r.messages[messageID]; !exists {
+		return nil, apistatus.New("message not found").NotFound()
+	}
+	return append([]*domain.Reaction{}, r.reactions[messageID]...), nil
+}
+
+func (r *InMemoryMessageRepository) SetReaction(ctx context.Context, reaction *domain.Reaction) apistatus.Status {
+	return r.SetReactionWithOutbox(ctx, reaction, nil)
+}
+
+func (r *InMemoryMessageRepository) SetReactionWithOutbox(ctx context.Context, reaction *domain.Reaction, buildEntry OutboxEntryBuilder) apistatus.Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	msg, exists := r.messages[reaction.MessageID]
+	if !exists {
+		return apistatus.New("message not found").NotFound()
+	}
+	entry, as := r.buildOutboxEntry(buildEntry, msg)
+	if as != nil {
+		return as
+	}
+	if as := r.journal.record(&journalRecord{Reaction: reaction, Outbox: entry}); as != nil {
+		return as
+	}
+	r.putReaction(reaction)
+	r.putOutboxEntry(entry)
+	return nil
+}
+
+func (r *InMemoryMessageRepository) RemoveReaction(ctx context.Context, messageID, userID int64) (*domain.Reaction, apistatus.Status) {
+	return r.RemoveReactionWithOutbox(ctx, messageID, userID, nil)
+}
+
+func (r *InMemoryMessageRepository) RemoveReactionWithOutbox(ctx context.Context, messageID, userID int64, buildEntry ReactionOutboxEntryBuilder) (*domain.Reaction, apistatus.Status) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var removed *domain.Reaction
+	for _, reaction := range r.reactions[messageID] {
+		if reaction.UserID == userID {
+			removed = reaction
+		}
+	}
+	if removed == nil {
+		return nil, apistatus.New("reaction not found").NotFound()
+	}
+	var entry *domain.OutboxEntry
+	if buildEntry != nil {
+		var as apistatus.Status
+		if entry, as = r.takeOutboxEntry(buildEntry(removed)); as != nil {
+			return nil, as
+		}
+	}
+	removal := &domain.Reaction{MessageID: messageID, UserID: userID}
+	if as := r.journal.record(&journalRecord{Reaction: removal, Outbox: entry}); as != nil {
+		return nil, as
+	}
+	r.putReaction(removal)
+	r.putOutboxEntry(entry)
+	return removed, nil
+}
+
+// putReaction replaces the reaction of reaction.UserID to the message with
+// reaction, or removes it when reaction has no Emoji. It must be called with
+// mu held.
+func (r *InMemoryMessageRepository) putReaction(reaction *domain.Reaction) {
+	var kept []*domain.Reaction
+	for _, existing := range r.reactions[reaction.MessageID] {
+		if existing.UserID != reaction.UserID {
+			kept = append(kept, existing)
+		}
+	}
+	if reaction.Emoji != "" {
+		kept = append(kept, reaction)
+	}
+	if len(kept) == 0 {
+		delete(r.reactions, reaction.MessageID)
+		return
+	}
+	r.reactions[reaction.MessageID] = kept
+}
+
+func (r *InMemoryMessageRepository) CreateAttachment(ctx context.Context, attachment *domain.Attachment) (*domain.Attachment, apistatus.Status) {
+	return r.CreateAttachmentWithOutbox(ctx, attachment, nil)
+}
+
+func (r *InMemoryMessageRepository) CreateAttachmentWithOutbox(ctx context.Context, attachment *domain.Attachment, buildEntry AttachmentOutboxEntryBuilder) (*domain.Attachment, apistatus.Status) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	attachment.ID = r.nextAttachmentID
+	var entry *domain.OutboxEntry
+	if buildEntry != nil {
+		var as apistatus.Status
+		if entry, as = r.takeOutboxEntry(buildEntry(attachment)); as != nil {
+			attachment.ID = 0
+			return nil, as
+		}
+	}
+	if as := r.journal.record(&journalRecord{Attachment: storeAttachment(attachment), Outbox: entry}); as != nil {
+		attachment.ID = 0
+		return nil, as
+	}
+	r.putAttachment(attachment)
+	r.putOutboxEntry(entry)
+	return attachment, nil
+}
+
+// putAttachment stores attachment as is. It must be called with mu held.
+func (r *InMemoryMessageRepository) putAttachment(attachment *domain.Attachment) {
+	r.attachments[attachment.ID] = attachment
+	if attachment.ID >= r.nextAttachmentID {
+		r.nextAttachmentID = attachment.ID + 1
+	}
+}
+
+func (r *InMemoryMessageRepository) GetAttachment(ctx context.Context, attachmentID int64) (*domain.Attachment, apistatus.Status) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	attachment, ok := r.attachments[attachmentID]
+	if !ok {
+		return nil, apistatus.New("attachment not found").NotFound()
+	}
+	return attachment, nil
+}
+
+func (r *InMemoryMessageRepository) GetAttachments(ctx context.Context, attachmentIDs []int64) ([]*domain.Attachment, apistatus.Status) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	result := []*domain.Attachment{}
+	for _, id := range attachmentIDs {
+		if attachment, ok := r.attachments[id]; ok {
+			result = append(result, attachment)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result, nil
+}
+
+func (r *InMemoryMessageRepository) SetAttachmentThumbnail(ctx context.Context, attachmentID int64, thumbnail *domain.Thumbnail) apistatus.Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	attachment, ok := r.attachments[attachmentID]
+	if !ok {
+		return apistatus.New("attachment not found").NotFound()
+	}
+	updated := *attachment
+	stored := *thumbnail
+	stored.URL = ""
+	updated.Thumbnail = &stored
+	if as := r.journal.record(&journalRecord{Attachment: storeAttachment(&updated)}); as != nil {
+		return as
+	}
+	r.putAttachment(&updated)
+	return nil
+}
+
+func (r *InMemoryMessageRepository) MarkMessageDelivered(ctx context.Context, messageID int64, userIDs []int64, at time.Time) (*domain.Message, apistatus.Status) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	msg, exists := r.messages[messageID]
+	if !exists {
+		return nil, apistatus.New("message not found").NotFound()
+	}
+	recipients := make([]int64, 0, len(userIDs))
+	for _, id := range userIDs {
+		if id != msg.SenderID {
+			recipients = append(recipients, id)
+		}
+	}
+	updated := *msg
+	updated.Receipts = updateReceipts(msg.Receipts, recipients, func(receipt *domain.Receipt) {
+		if receipt.DeliveredAt == nil {
+			receipt.DeliveredAt = &at
+		}
+	})
+	if as := r.journal.record(&journalRecord{Message: &updated}); as != nil {
+		return nil, as
+	}
+	r.putMessage(&updated)
+	return &updated, nil
+}
+
+func (r *InMemoryMessageRepository) MarkChatRead(ctx context.Context, chatID, userID, upToMessageID int64, at time.Time) ([]*domain.Message, apistatus.Status) {
+	return r.MarkChatReadWithOutbox(ctx, chatID, userID, upToMessageID, at, nil)
+}
+
+func (r *InMemoryMessageRepository) MarkChatReadWithOutbox(ctx context.Context, chatID, userID, upToMessageID int64, at time.Time, buildEntry ReadOutboxEntryBuilder) ([]*domain.Message, apistatus.Status) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result := []*domain.Message{}
+	for _, id := range r.byChat[chatID] {
+		msg := r.messages[id]
+		if msg.ID > upToMessageID || msg.SenderID == userID {
+			continue
+		}
+		if receipt := msg.ReceiptFor(userID); receipt != nil && receipt.ReadAt != nil {
+			continue
+		}
+		updated := *msg
+		updated.Receipts = updateReceipts(msg.Receipts, []int64{userID}, func(receipt *domain.Receipt) {
+			if receipt.DeliveredAt == nil {
+				receipt.DeliveredAt = &at
+			}
+			receipt.ReadAt = &at
+		})
+		result = append(result, &updated)
+	}
+	if len(result) == 0 {
+		return result, nil
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	var entry *domain.OutboxEntry
+	if buildEntry != nil {
+		var as apistatus.Status
+		if entry, as = r.takeOutboxEntry(buildEntry(result)); as != nil {
+			return nil, as
+		}
+	}
+	if as := r.journal.record(&journalRecord{Messages: result, Outbox: entry}); as != nil {
+		return nil, as
+	}
+	for _, msg := range result {
+		r.putMessage(msg)
+	}
+	r.putOutboxEntry(entry)
+	return result, nil
+}
+
+func (r *InMemoryMessageRepository) SummarizeChats(ctx context.Context, userID int64, chats []*domain.Chat) ([]*domain.ChatSummary, apistatus.Status) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	result := make([]*domain.ChatSummary, 0, len(chats))
+	for _, chat := range chats {
+		ids := r.byChat[chat.ID]
+		// Walk back from the newest message until both the last visible
+		// message and the last read one are found, so only the unread tail
+		// of the history is visited.
+		var last *domain.Message
+		lastRead, unread := r.lastRead[chat.ID][userID], 0
+		for i := len(ids) - 1; i >= 0 && (last == nil || ids[i] > lastRead); i-- {
+			msg := r.messages[ids[i]]
+			if msg.DeletedAt != nil || r.hidden[msg.ID][userID] {
+				continue
+			}
+			if last == nil {
+				last = msg
+			}
+			if msg.ID > lastRead && msg.SenderID != userID {
+				unread++
+			}
+		}
+		result = append(result, domain.NewChatSummary(chat, last, unread))
+	}
+	return result, nil
+}
+
+// updateReceipts returns a copy of receipts in which apply has been called on
+// the receipt of each of userIDs, adding the receipts that are missing.
+func updateReceipts(receipts []domain.Receipt, userIDs []int64, apply func(*domain.Receipt)) []domain.Receipt {
+	result := append([]domain.Receipt{}, receipts...)
+	for _, id := range userIDs {
+		i := sort.Search(len(result), func(i int) bool { return result[i].UserID >= id })
+		if i == len(result) || result[i].UserID != id {
+			result = append(result, domain.Receipt{})
+			copy(result[i+1:], result[i:])
+			result[i] = domain.Receipt{UserID: id}
+		}
+		apply(&result[i])
+	}
+	return result
+}
+
 func (r *InMemoryMessageRepository) GetMessageByID(ctx context.Context, messageID int64) (*domain.Message, apistatus.Status) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -127,5 +1174,239 @@ func (r *InMemoryMessageRepository) GetMessageByID(ctx context.Context, messageI
 	if !exists {
 		return nil, apistatus.New("message not found").NotFound()
 	}
-	return msg, nil
+	return r.view(msg), nil
+}
+
+// GetMessagesSince returns the messages of chatIDs with an ID greater than
+// afterID that userID has not hidden, ordered by ID.
+func (r *InMemoryMessageRepository) GetMessagesSince(ctx context.Context, chatIDs []int64, userID, afterID int64) ([]*domain.Message, apistatus.Status) {
+	inChats := make(map[int64]bool, len(chatIDs))
+	for _, id := range chatIDs {
+		inChats[id] = true
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	result := []*domain.Message{}
+	for _, msg := range r.messages {
+		if msg.ID > afterID && inChats[msg.ChatID] && !r.hidden[msg.ID][userID] {
+			result = append(result, r.view(msg))
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result, nil
+}
+
+func (r *InMemoryMessageRepository) AddOutboxEntry(ctx context.Context, entry *domain.OutboxEntry) (*domain.OutboxEntry, apistatus.Status) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.newOutboxEntry(entry)
+	if as := r.journal.record(&journalRecord{Outbox: entry}); as != nil {
+		return nil, as
+	}
+	r.putOutboxEntry(entry)
+	return entry, nil
+}
+
+// buildOutboxEntry builds the outbox entry for msg and assigns it the next
+// ID, or returns nil when buildEntry is nil. It must be called with mu held.
+func (r *InMemoryMessageRepository) buildOutboxEntry(buildEntry OutboxEntryBuilder, msg *domain.Message) (*domain.OutboxEntry, apistatus.Status) {
+	if buildEntry == nil {
+		return nil, nil
+	}
+	return r.takeOutboxEntry(buildEntry(msg))
+}
+
+// takeOutboxEntry passes on the result of an outbox entry builder, with the
+// entry assigned the next ID. It must be called with mu held.
+func (r *InMemoryMessageRepository) takeOutboxEntry(entry *domain.OutboxEntry, err error) (*domain.OutboxEntry, apistatus.Status) {
+	if err != nil {
+		return nil, apistatus.New(err).InternalServerError()
+	}
+	r.newOutboxEntry(entry)
+	return entry, nil
+}
+
+// newOutboxEntry assigns entry the next ID and fills in its defaults. It must
+// be called with mu held.
+func (r *InMemoryMessageRepository) newOutboxEntry(entry *domain.OutboxEntry) {
+	entry.ID = r.nextOutboxID
+	entry.Status = domain.OutboxStatusPending
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	if entry.NextAttemptAt.IsZero() {
+		entry.NextAttemptAt = entry.CreatedAt
+	}
+}
+
+// GetPendingOutboxEntries returns up to limit pending entries that are due at
+// now, oldest first.
+func (r *InMemoryMessageRepository) GetPendingOutboxEntries(ctx context.Context, now time.Time, limit int) ([]*domain.OutboxEntry, apistatus.Status) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.pendingOutboxEntries(now, limit), nil
+}
+
+// pendingOutboxEntries returns up to limit pending entries that are due at
+// now, oldest first. It must be called with mu held.
+func (r *InMemoryMessageRepository) pendingOutboxEntries(now time.Time, limit int) []*domain.OutboxEntry {
+	result := []*domain.OutboxEntry{}
+	for _, entry := range r.outbox {
+		if entry.Status == domain.OutboxStatusPending && !entry.NextAttemptAt.After(now) {
+			result = append(result, entry)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result
+}
+
+// ClaimOutboxEntries leases the entries without journaling the lease; after a
+// restart they are due again at their last recorded attempt time.
+func (r *InMemoryMessageRepository) ClaimOutboxEntries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*domain.OutboxEntry, apistatus.Status) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result := r.pendingOutboxEntries(now, limit)
+	for i, entry := range result {
+		claimed := *entry
+		claimed.NextAttemptAt = now.Add(lease)
+		r.outbox[claimed.ID] = &claimed
+		result[i] = &claimed
+	}
+	return result, nil
+}
+
+func (r *InMemoryMessageRepository) MarkOutboxEntrySent(ctx context.Context, entryID int64) apistatus.Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry, exists := r.outbox[entryID]
+	if !exists {
+		return apistatus.New("outbox entry not found").NotFound()
+	}
+	updated := *entry
+	now := time.Now()
+	updated.Status = domain.OutboxStatusSent
+	updated.Attempts++
+	updated.SentAt = &now
+	if as := r.journal.record(&journalRecord{Outbox: &updated}); as != nil {
+		return as
+	}
+	r.putOutboxEntry(&updated)
+	return nil
+}
+
+func (r *InMemoryMessageRepository) MarkOutboxEntryFailed(ctx context.Context, entryID int64, reason string, nextAttemptAt time.Time) apistatus.Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry, exists := r.outbox[entryID]
+	if !exists {
+		return apistatus.New("outbox entry not found").NotFound()
+	}
+	updated := *entry
+	updated.Attempts++
+	updated.LastError = reason
+	updated.NextAttemptAt = nextAttemptAt
+	if as := r.journal.record(&journalRecord{Outbox: &updated}); as != nil {
+		return as
+	}
+	r.putOutboxEntry(&updated)
+	return nil
+}
+
+// InMemoryDeadLetterRepository implements DeadLetterRepository in memory.
+type InMemoryDeadLetterRepository struct {
+	deadLetters map[int64]*domain.DeadLetter
+	mu          sync.RWMutex
+	nextID      int64
+	// journal, when set, records every write before it is applied.
+	journal *Journal
+}
+
+// NewInMemoryDeadLetterRepository creates a new repository.
+func NewInMemoryDeadLetterRepository() DeadLetterRepository {
+	return newInMemoryDeadLetterRepository()
+}
+
+func newInMemoryDeadLetterRepository() *InMemoryDeadLetterRepository {
+	return &InMemoryDeadLetterRepository{
+		deadLetters: make(map[int64]*domain.DeadLetter),
+		nextID:      1,
+	}
+}
+
+func (r *InMemoryDeadLetterRepository) AddDeadLetter(ctx context.Context, dl *domain.DeadLetter) (*domain.DeadLetter, apistatus.Status) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	dl.ID = r.nextID
+	if as := r.journal.record(&journalRecord{DeadLetter: dl}); as != nil {
+		dl.ID = 0
+		return nil, as
+	}
+	r.putDeadLetter(dl)
+	return dl, nil
+}
+
+// putDeadLetter stores dl as is. It must be called with mu held.
+func (r *InMemoryDeadLetterRepository) putDeadLetter(dl *domain.DeadLetter) {
+	r.deadLetters[dl.ID] = dl
+	if dl.ID >= r.nextID {
+		r.nextID = dl.ID + 1
+	}
+}
+
+// ListDeadLetters returns every dead letter, oldest first.
+func (r *InMemoryDeadLetterRepository) ListDeadLetters(ctx context.Context) ([]*domain.DeadLetter, apistatus.Status) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	result := make([]*domain.DeadLetter, 0, len(r.deadLetters))
+	for _, dl := range r.deadLetters {
+		result = append(result, dl)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result, nil
+}
+
+func (r *InMemoryDeadLetterRepository) GetDeadLetter(ctx context.Context, id int64) (*domain.DeadLetter, apistatus.Status) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	dl, exists := r.deadLetters[id]
+	if !exists {
+		return nil, apistatus.New("dead letter not found").NotFound()
+	}
+	return dl, nil
+}
+
+func (r *InMemoryDeadLetterRepository) DeleteDeadLetter(ctx context.Context, id int64) apistatus.Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.deadLetters[id]; !exists {
+		return apistatus.New("dead letter not found").NotFound()
+	}
+	if as := r.journal.record(&journalRecord{DeadLettersRemoved: []int64{id}}); as != nil {
+		return as
+	}
+	delete(r.deadLetters, id)
+	return nil
+}
+
+// DeleteAllDeadLetters removes every dead letter and returns how many there
+// were.
+func (r *InMemoryDeadLetterRepository) DeleteAllDeadLetters(ctx context.Context) (int, apistatus.Status) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := len(r.deadLetters)
+	if n == 0 {
+		return 0, nil
+	}
+	ids := make([]int64, 0, n)
+	for id := range r.deadLetters {
+		ids = append(ids, id)
+	}
+	if as := r.journal.record(&journalRecord{DeadLettersRemoved: ids}); as != nil {
+		return 0, as
+	}
+	r.deadLetters = make(map[int64]*domain.DeadLetter)
+	return n, nil
 }
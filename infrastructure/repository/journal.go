@@ -0,0 +1,577 @@
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"messaging-app/domain"
+	"messaging-app/pkg/apistatus"
+)
+
+const (
+	journalLogFile      = "journal.log"
+	journalSnapshotFile = "snapshot.json"
+)
+
+// journalRecord is one line of the journal log. It holds the rows a write
+// left behind rather than the operation, so replaying it is a plain put.
+type journalRecord struct {
+	Seq int64 `json:"seq"`
+	// User without a name records the deletion of the user.
+	User    *domain.User    `json:"user,omitempty"`
+	Chat    *domain.Chat    `json:"chat,omitempty"`
+	Message *domain.Message `json:"message,omitempty"`
+	// Messages holds the messages changed together by one write.
+	Messages   []*domain.Message        `json:"messages,omitempty"`
+	Transition *domain.StatusTransition `json:"transition,omitempty"`
+	Revision   *domain.MessageRevision  `json:"revision,omitempty"`
+	Hidden     *hiddenMessage           `json:"hidden,omitempty"`
+	// Reaction without an emoji records the removal of a reaction.
+	Reaction   *domain.Reaction  `json:"reaction,omitempty"`
+	Attachment *storedAttachment `json:"attachment,omitempty"`
+	// Outbox with the sent status records the removal of the entry.
+	Outbox     *domain.OutboxEntry `json:"outbox,omitempty"`
+	DeadLetter *domain.DeadLetter  `json:"deadLetter,omitempty"`
+	// DeadLettersRemoved lists the IDs of the dead letters a write removed.
+	DeadLettersRemoved []int64 `json:"deadLettersRemoved,omitempty"`
+}
+
+// journalSnapshot is the full state of the repositories after record Seq.
+type journalSnapshot struct {
+	Seq              int64             `json:"seq"`
+	NextUserID       int64             `json:"nextUserId"`
+	NextChatID       int64             `json:"nextChatId"`
+	NextMessageID    int64             `json:"nextMessageId"`
+	NextOutboxID     int64             `json:"nextOutboxId"`
+	NextAttachmentID int64             `json:"nextAttachmentId"`
+	NextDeadLetterID int64             `json:"nextDeadLetterId"`
+	Users            []*domain.User    `json:"users"`
+	Chats            []*domain.Chat    `json:"chats"`
+	Messages         []*domain.Message `json:"messages"`
+	// Transitions are ordered by message, oldest first.
+	Transitions []*domain.StatusTransition `json:"transitions"`
+	// Revisions are ordered by message, oldest first.
+	Revisions []*domain.MessageRevision `json:"revisions"`
+	Hidden    []*hiddenMessage          `json:"hidden"`
+	// Reactions are ordered by message, oldest first.
+	Reactions   []*domain.Reaction    `json:"reactions"`
+	Attachments []*storedAttachment   `json:"attachments"`
+	Outbox      []*domain.OutboxEntry `json:"outbox"`
+	DeadLetters []*domain.DeadLetter  `json:"deadLetters"`
+}
+
+// storedAttachment is the journal form of an attachment. Unlike API
+// responses, it has to keep the storage key.
+type storedAttachment struct {
+	*domain.Attachment
+	StorageKey string `json:"storageKey"`
+}
+
+func storeAttachment(attachment *domain.Attachment) *storedAttachment {
+	return &storedAttachment{Attachment: attachment, StorageKey: attachment.StorageKey}
+}
+
+func (s *storedAttachment) attachment() *domain.Attachment {
+	attachment := *s.Attachment
+	attachment.StorageKey = s.StorageKey
+	return &attachment
+}
+
+// journalFile is the part of *os.File the journal writes the log through.
+type journalFile interface {
+	io.Writer
+	Sync() error
+	Truncate(size int64) error
+	Close() error
+}
+
+// Journal gives the in-memory user, chat, message and dead letter
+// repositories crash recovery without a database. Every write is appended to journal.log before it is
+// applied; Snapshot writes the whole state to snapshot.json and empties the
+// log. OpenJournal rebuilds the repositories from the snapshot and the
+// records after it.
+type Journal struct {
+	dir           string
+	fsync         bool
+	snapshotEvery int
+	users         *InMemoryUserRepository
+	chats         *InMemoryChatRepository
+	messages      *InMemoryMessageRepository
+	deadLetters   *InMemoryDeadLetterRepository
+
+	mu   sync.Mutex
+	file journalFile
+	// size is the length of the log, where a failed write is cut back to.
+	size int64
+	// broken is set when a failed write could not be cut off; later writes
+	// fail with it until a snapshot empties the log.
+	broken error
+	seq    int64
+	// pending counts the records appended since the last snapshot.
+	pending int
+	// full is signalled once pending reaches snapshotEvery.
+	full chan struct{}
+}
+
+// OpenJournal restores the repositories from dir, creating it if needed.
+// With fsync set every record is synced to disk, which also survives power
+// loss; otherwise records survive process crashes only. Run takes a snapshot
+// whenever snapshotEvery records have been appended.
+func OpenJournal(dir string, snapshotEvery int, fsync bool) (*Journal, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	j := &Journal{
+		dir:           dir,
+		fsync:         fsync,
+		snapshotEvery: snapshotEvery,
+		users:         newInMemoryUserRepository(),
+		chats:         newInMemoryChatRepository(),
+		messages:      newInMemoryMessageRepository(),
+		deadLetters:   newInMemoryDeadLetterRepository(),
+		full:          make(chan struct{}, 1),
+	}
+	if err := j.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := j.replay(); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(filepath.Join(dir, journalLogFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	j.file = file
+	j.size = info.Size()
+	j.users.journal = j
+	j.chats.journal = j
+	j.chats.outbox = j.messages
+	j.messages.journal = j
+	j.deadLetters.journal = j
+	if j.users.nextID == 1 {
+		if err := j.seedUsers(); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return j, nil
+}
+
+// seedUsers creates the original four users in a journal that has never held
+// users. Journals written before users were journaled may reference users
+// that were lost on restart, so new users get IDs past every participant and
+// sender; the snapshot keeps that counter.
+func (j *Journal) seedUsers() error {
+	if as := j.users.seed(); as != nil {
+		return as.GetError()
+	}
+	j.users.mu.Lock()
+	for _, chat := range j.chats.chats {
+		for _, userID := range chat.Participants() {
+			j.users.nextID = maxInt64(j.users.nextID, userID+1)
+		}
+	}
+	for _, msg := range j.messages.messages {
+		j.users.nextID = maxInt64(j.users.nextID, msg.SenderID+1)
+	}
+	j.users.mu.Unlock()
+	return j.Snapshot()
+}
+
+// UserRepository returns the journaled user repository.
+func (j *Journal) UserRepository() UserRepository {
+	return j.users
+}
+
+// ChatRepository returns the journaled chat repository.
+func (j *Journal) ChatRepository() ChatRepository {
+	return j.chats
+}
+
+// MessageRepository returns the journaled message repository.
+func (j *Journal) MessageRepository() MessageRepository {
+	return j.messages
+}
+
+// DeadLetterRepository returns the journaled dead letter repository.
+func (j *Journal) DeadLetterRepository() DeadLetterRepository {
+	return j.deadLetters
+}
+
+func (j *Journal) loadSnapshot() error {
+	data, err := os.ReadFile(filepath.Join(j.dir, journalSnapshotFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var snap journalSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("journal: corrupt snapshot: %w", err)
+	}
+	for _, user := range snap.Users {
+		j.users.putUser(user)
+	}
+	for _, chat := range snap.Chats {
+		j.chats.putChat(chat)
+	}
+	for _, msg := range snap.Messages {
+		j.messages.putMessage(msg)
+	}
+	for _, transition := range snap.Transitions {
+		j.messages.putTransition(transition)
+	}
+	for _, revision := range snap.Revisions {
+		j.messages.putRevision(revision)
+	}
+	for _, hidden := range snap.Hidden {
+		j.messages.putHidden(hidden)
+	}
+	for _, reaction := range snap.Reactions {
+		j.messages.putReaction(reaction)
+	}
+	for _, attachment := range snap.Attachments {
+		j.messages.putAttachment(attachment.attachment())
+	}
+	for _, entry := range snap.Outbox {
+		j.messages.putOutboxEntry(entry)
+	}
+	for _, dl := range snap.DeadLetters {
+		j.deadLetters.putDeadLetter(dl)
+	}
+	// IDs are never reused, even those of rows that no longer exist.
+	j.users.nextID = maxInt64(j.users.nextID, snap.NextUserID)
+	j.chats.nextID = maxInt64(j.chats.nextID, snap.NextChatID)
+	j.messages.nextID = maxInt64(j.messages.nextID, snap.NextMessageID)
+	j.messages.nextOutboxID = maxInt64(j.messages.nextOutboxID, snap.NextOutboxID)
+	j.messages.nextAttachmentID = maxInt64(j.messages.nextAttachmentID, snap.NextAttachmentID)
+	j.deadLetters.nextID = maxInt64(j.deadLetters.nextID, snap.NextDeadLetterID)
+	j.seq = snap.Seq
+	return nil
+}
+
+// replay applies the log records newer than the snapshot. A torn last line,
+// left by a crash in the middle of a write, is cut off; any other unreadable
+// line is an error.
+func (j *Journal) replay() error {
+	path := filepath.Join(j.dir, journalLogFile)
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(bytes.TrimSpace(line)) > 0 {
+				log.Printf("journal: dropping torn record at offset %d", offset)
+				return os.Truncate(path, offset)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var rec journalRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return fmt.Errorf("journal: corrupt record at offset %d: %w", offset, err)
+		}
+		offset += int64(len(line))
+		if rec.Seq <= j.seq {
+			// Already in the snapshot.
+			continue
+		}
+		j.apply(&rec)
+		j.seq = rec.Seq
+		j.pending++
+	}
+}
+
+func (j *Journal) apply(rec *journalRecord) {
+	if rec.User != nil {
+		j.users.putUser(rec.User)
+	}
+	if rec.Chat != nil {
+		j.chats.putChat(rec.Chat)
+	}
+	if rec.Message != nil {
+		j.messages.putMessage(rec.Message)
+	}
+	for _, msg := range rec.Messages {
+		j.messages.putMessage(msg)
+	}
+	if rec.Transition != nil {
+		j.messages.putTransition(rec.Transition)
+	}
+	if rec.Revision != nil {
+		j.messages.putRevision(rec.Revision)
+	}
+	if rec.Hidden != nil {
+		j.messages.putHidden(rec.Hidden)
+	}
+	if rec.Reaction != nil {
+		j.messages.putReaction(rec.Reaction)
+	}
+	if rec.Attachment != nil {
+		j.messages.putAttachment(rec.Attachment.attachment())
+	}
+	if rec.Outbox != nil {
+		j.messages.putOutboxEntry(rec.Outbox)
+	}
+	if rec.DeadLetter != nil {
+		j.deadLetters.putDeadLetter(rec.DeadLetter)
+	}
+	for _, id := range rec.DeadLettersRemoved {
+		delete(j.deadLetters.deadLetters, id)
+	}
+}
+
+// record appends rec to the journal. Repositories call it with their lock
+// held, before applying the write, so the log order matches the order writes
+// become visible. A nil journal records nothing.
+func (j *Journal) record(rec *journalRecord) apistatus.Status {
+	if j == nil {
+		return nil
+	}
+	if err := j.append(rec); err != nil {
+		return apistatus.New(err).InternalServerError()
+	}
+	return nil
+}
+
+func (j *Journal) append(rec *journalRecord) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return errors.New("journal: closed")
+	}
+	if j.broken != nil {
+		return j.broken
+	}
+	rec.Seq = j.seq + 1
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if err := j.write(append(line, '\n')); err != nil {
+		return err
+	}
+	j.seq = rec.Seq
+	j.pending++
+	if j.snapshotEvery > 0 && j.pending >= j.snapshotEvery {
+		select {
+		case j.full <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// write appends line to the log. When the write or its sync fails, the part
+// of line that reached the file is cut off again, so the next record does
+// not follow a torn line that replay would take for corruption. It must be
+// called with mu held.
+func (j *Journal) write(line []byte) error {
+	_, err := j.file.Write(line)
+	if err == nil && j.fsync {
+		err = j.file.Sync()
+	}
+	if err == nil {
+		j.size += int64(len(line))
+		return nil
+	}
+	if terr := j.file.Truncate(j.size); terr != nil {
+		j.broken = fmt.Errorf("journal: cannot cut off failed record: %w", terr)
+	}
+	return err
+}
+
+// Snapshot writes the state of the repositories to snapshot.json and empties
+// the log. Writes wait until it is done.
+func (j *Journal) Snapshot() error {
+	// Same lock order as writers: repository first, then journal.
+	j.users.mu.RLock()
+	defer j.users.mu.RUnlock()
+	j.chats.mu.RLock()
+	defer j.chats.mu.RUnlock()
+	j.messages.mu.RLock()
+	defer j.messages.mu.RUnlock()
+	j.deadLetters.mu.RLock()
+	defer j.deadLetters.mu.RUnlock()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return errors.New("journal: closed")
+	}
+
+	snap := journalSnapshot{
+		Seq:              j.seq,
+		NextUserID:       j.users.nextID,
+		NextChatID:       j.chats.nextID,
+		NextMessageID:    j.messages.nextID,
+		NextOutboxID:     j.messages.nextOutboxID,
+		NextAttachmentID: j.messages.nextAttachmentID,
+		NextDeadLetterID: j.deadLetters.nextID,
+		Users:            make([]*domain.User, 0, len(j.users.users)),
+		Chats:            make([]*domain.Chat, 0, len(j.chats.chats)),
+		Messages:         make([]*domain.Message, 0, len(j.messages.messages)),
+		Transitions:      []*domain.StatusTransition{},
+		Revisions:        []*domain.MessageRevision{},
+		Hidden:           []*hiddenMessage{},
+		Reactions:        []*domain.Reaction{},
+		Attachments:      make([]*storedAttachment, 0, len(j.messages.attachments)),
+		Outbox:           make([]*domain.OutboxEntry, 0, len(j.messages.outbox)),
+		DeadLetters:      make([]*domain.DeadLetter, 0, len(j.deadLetters.deadLetters)),
+	}
+	for _, user := range j.users.users {
+		snap.Users = append(snap.Users, user)
+	}
+	for _, chat := range j.chats.chats {
+		snap.Chats = append(snap.Chats, chat)
+	}
+	for _, msg := range j.messages.messages {
+		snap.Messages = append(snap.Messages, msg)
+	}
+	for _, entry := range j.messages.outbox {
+		snap.Outbox = append(snap.Outbox, entry)
+	}
+	for _, attachment := range j.messages.attachments {
+		snap.Attachments = append(snap.Attachments, storeAttachment(attachment))
+	}
+	sort.Slice(snap.Users, func(a, b int) bool { return snap.Users[a].ID < snap.Users[b].ID })
+	sort.Slice(snap.Chats, func(a, b int) bool { return snap.Chats[a].ID < snap.Chats[b].ID })
+	sort.Slice(snap.Messages, func(a, b int) bool { return snap.Messages[a].ID < snap.Messages[b].ID })
+	sort.Slice(snap.Outbox, func(a, b int) bool { return snap.Outbox[a].ID < snap.Outbox[b].ID })
+	for _, dl := range j.deadLetters.deadLetters {
+		snap.DeadLetters = append(snap.DeadLetters, dl)
+	}
+	sort.Slice(snap.Attachments, func(a, b int) bool { return snap.Attachments[a].ID < snap.Attachments[b].ID })
+	sort.Slice(snap.DeadLetters, func(a, b int) bool { return snap.DeadLetters[a].ID < snap.DeadLetters[b].ID })
+	for _, msg := range snap.Messages {
+		snap.Transitions = append(snap.Transitions, j.messages.transitions[msg.ID]...)
+		snap.Revisions = append(snap.Revisions, j.messages.revisions[msg.ID]...)
+		for _, userID := range sortedKeys(j.messages.hidden[msg.ID]) {
+			snap.Hidden = append(snap.Hidden, &hiddenMessage{MessageID: msg.ID, UserID: userID})
+		}
+		snap.Reactions = append(snap.Reactions, j.messages.reactions[msg.ID]...)
+	}
+
+	if err := writeFileAtomic(filepath.Join(j.dir, journalSnapshotFile), snap); err != nil {
+		return err
+	}
+	// A crash before the truncate only leaves records the snapshot already
+	// covers; replay skips them by sequence number.
+	if err := j.file.Truncate(0); err != nil {
+		return err
+	}
+	j.size = 0
+	j.broken = nil
+	j.pending = 0
+	return j.file.Sync()
+}
+
+// writeFileAtomic writes v as JSON to a temporary file and renames it over
+// path, so readers see either the old or the new file.
+func writeFileAtomic(path string, v interface{}) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := json.NewEncoder(tmp).Encode(v); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	// Persist the rename itself.
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+// Run takes a snapshot every interval, and whenever snapshotEvery records
+// have piled up, until ctx is cancelled. Intervals without writes are skipped.
+func (j *Journal) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-j.full:
+		}
+		j.mu.Lock()
+		pending := j.pending
+		j.mu.Unlock()
+		if pending == 0 {
+			continue
+		}
+		if err := j.Snapshot(); err != nil {
+			log.Printf("journal: snapshot failed: %v", err)
+		}
+	}
+}
+
+// Close closes the log. Later writes to the repositories fail.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
+
+// sortedKeys returns the keys of set in ascending order.
+func sortedKeys(set map[int64]bool) []int64 {
+	keys := make([]int64, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(a, b int) bool { return keys[a] < keys[b] })
+	return keys
+}
+
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
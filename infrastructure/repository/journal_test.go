@@ -0,0 +1,384 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"testing"
+	"time"
+
+	"messaging-app/domain"
+)
+
+// openTestJournal opens the journal in dir and closes it when the test ends.
+func openTestJournal(t *testing.T, dir string) *Journal {
+	t.Helper()
+	j, err := OpenJournal(dir, 0, false)
+	if err != nil {
+		t.Fatalf("OpenJournal failed: %v", err)
+	}
+	t.Cleanup(func() { j.Close() })
+	return j
+}
+
+func TestJournalMessageRepository(t *testing.T) {
+	testMessageRepository(t, func(t *testing.T) MessageRepository {
+		return openTestJournal(t, t.TempDir()).MessageRepository()
+	})
+}
+
+func TestJournalChatRepository(t *testing.T) {
+	testChatRepository(t, func(t *testing.T) (ChatRepository, MessageRepository) {
+		j := openTestJournal(t, t.TempDir())
+		return j.ChatRepository(), j.MessageRepository()
+	})
+}
+
+func TestJournalUserRepository(t *testing.T) {
+	testUserRepository(t, func(t *testing.T) UserRepository {
+		return openTestJournal(t, t.TempDir()).UserRepository()
+	})
+}
+
+func TestJournalDeadLetterRepository(t *testing.T) {
+	testDeadLetterRepository(t, func(t *testing.T) DeadLetterRepository {
+		return openTestJournal(t, t.TempDir()).DeadLetterRepository()
+	})
+}
+
+// TestJournal_ReplaysUsers tests that users are restored instead of seeded
+// again after a restart.
+func TestJournal_ReplaysUsers(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	j := openTestJournal(t, dir)
+	created, _ := j.UserRepository().CreateUser(ctx, &domain.User{Name: "Ayo"})
+	if err := j.UserRepository().DeleteUser(ctx, 2); err != nil {
+		t.Fatalf("DeleteUser failed: %v", err)
+	}
+	j.Close()
+
+	j = openTestJournal(t, dir)
+	users, _ := j.UserRepository().ListUsers(ctx)
+	if len(users) != 4 || users[1].ID != 3 || users[3].ID != created.ID || users[3].Name != "Ayo" {
+		t.Errorf("expected users 1, 3, 4 and %d, got %+v", created.ID, users)
+	}
+	next, _ := j.UserRepository().CreateUser(ctx, &domain.User{Name: "Ada"})
+	if next.ID != created.ID+1 {
+		t.Errorf("expected next user ID %d, got %d", created.ID+1, next.ID)
+	}
+}
+
+// TestJournal_ReplaysDeadLetters tests that collected dead letters survive a
+// restart, from the snapshot and the log, and that their IDs are not handed
+// out again.
+func TestJournal_ReplaysDeadLetters(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	j := openTestJournal(t, dir)
+	first, _ := j.DeadLetterRepository().AddDeadLetter(ctx, &domain.DeadLetter{Reason: "rejected"})
+	second, _ := j.DeadLetterRepository().AddDeadLetter(ctx, &domain.DeadLetter{Reason: "expired", Body: "{}"})
+	if err := j.DeadLetterRepository().DeleteDeadLetter(ctx, first.ID); err != nil {
+		t.Fatalf("DeleteDeadLetter failed: %v", err)
+	}
+	if err := j.Snapshot(); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	third, _ := j.DeadLetterRepository().AddDeadLetter(ctx, &domain.DeadLetter{Reason: "rejected"})
+	j.Close()
+
+	j = openTestJournal(t, dir)
+	deadLetters, _ := j.DeadLetterRepository().ListDeadLetters(ctx)
+	if len(deadLetters) != 2 || deadLetters[0].ID != second.ID || deadLetters[0].Body != "{}" || deadLetters[1].ID != third.ID {
+		t.Errorf("expected dead letters %d and %d, got %+v", second.ID, third.ID, deadLetters)
+	}
+	next, _ := j.DeadLetterRepository().AddDeadLetter(ctx, &domain.DeadLetter{Reason: "rejected"})
+	if next.ID != third.ID+1 {
+		t.Errorf("expected next dead letter ID %d, got %d", third.ID+1, next.ID)
+	}
+}
+
+// TestJournal_SeedsUsersPastParticipants tests that a journal written before
+// users were journaled does not hand out the IDs of lost users again.
+func TestJournal_SeedsUsersPastParticipants(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	j := openTestJournal(t, dir)
+	if _, err := j.ChatRepository().CreateChat(ctx, &domain.Chat{Type: domain.ChatTypeGroup, Title: "Team", ParticipantIDs: []int64{1, 7}}); err != nil {
+		t.Fatalf("CreateChat failed: %v", err)
+	}
+	j.Close()
+	// Forget the users, as an older journal would have.
+	snap := journalSnapshot{}
+	data, _ := os.ReadFile(filepath.Join(dir, journalSnapshotFile))
+	json.Unmarshal(data, &snap)
+	snap.Users, snap.NextUserID = nil, 0
+	if err := writeFileAtomic(filepath.Join(dir, journalSnapshotFile), snap); err != nil {
+		t.Fatalf("writeFileAtomic failed: %v", err)
+	}
+
+	j = openTestJournal(t, dir)
+	users, _ := j.UserRepository().ListUsers(ctx)
+	if len(users) != 4 {
+		t.Errorf("expected the 4 seeded users, got %+v", users)
+	}
+	user, _ := j.UserRepository().CreateUser(ctx, &domain.User{Name: "Ayo"})
+	if user.ID != 8 {
+		t.Errorf("expected user ID 8 after participant 7, got %d", user.ID)
+	}
+}
+
+// seedJournal writes a group chat with two messages, the first one failed and
+// deleted by user 2, the second one replying to it with an attachment, edited
+// and reacted to by user 1, and both read by user 3, and returns the ID of the second message.
+// Only the outbox entry of the second message is still pending; the one of
+// the first message has been sent.
+func seedJournal(t *testing.T, j *Journal) int64 {
+	t.Helper()
+	ctx := context.Background()
+	chat, err := j.ChatRepository().CreateChat(ctx, &domain.Chat{Type: domain.ChatTypeGroup, Title: "Team", ParticipantIDs: []int64{1, 2}})
+	if err != nil {
+		t.Fatalf("CreateChat failed: %v", err)
+	}
+	if _, err := j.ChatRepository().AddParticipant(ctx, chat.ID, 3); err != nil {
+		t.Fatalf("AddParticipant failed: %v", err)
+	}
+	messages := j.MessageRepository()
+	first, _ := messages.CreateMessage(ctx, &domain.Message{ChatID: chat.ID, SenderID: 1, Content: "one", Timestamp: time.Now(), Status: domain.MessageStatusSent})
+	attachment, err := messages.CreateAttachment(ctx, &domain.Attachment{ChatID: chat.ID, UploaderID: 2, FileName: "plan.png", ContentType: domain.AttachmentTypePNG, Size: 3, StorageKey: "plan", CreatedAt: time.Now()})
+	if err != nil {
+		t.Fatalf("CreateAttachment failed: %v", err)
+	}
+	second, err := messages.CreateMessageWithOutbox(ctx, &domain.Message{ChatID: chat.ID, SenderID: 2, Content: "two", Timestamp: time.Now(), Status: domain.MessageStatusSent, ReplyToMessageID: first.ID, AttachmentIDs: []int64{attachment.ID}},
+		func(msg *domain.Message) (*domain.OutboxEntry, error) {
+			return &domain.OutboxEntry{EventType: domain.EventTypeMessageSent, AggregateID: msg.ID, Payload: []byte(`{}`)}, nil
+		})
+	if err != nil {
+		t.Fatalf("CreateMessageWithOutbox failed: %v", err)
+	}
+	published, err := messages.AddOutboxEntry(ctx, &domain.OutboxEntry{EventType: domain.EventTypeMessageSent, AggregateID: first.ID, Payload: []byte(`{}`)})
+	if err != nil {
+		t.Fatalf("AddOutboxEntry failed: %v", err)
+	}
+	if err := messages.MarkOutboxEntrySent(ctx, published.ID); err != nil {
+		t.Fatalf("MarkOutboxEntrySent failed: %v", err)
+	}
+	if err := messages.SetAttachmentThumbnail(ctx, attachment.ID, &domain.Thumbnail{Width: 2, Height: 1, ContentType: domain.AttachmentTypePNG, Size: 1}); err != nil {
+		t.Fatalf("SetAttachmentThumbnail failed: %v", err)
+	}
+	if err := messages.UpdateMessageStatus(ctx, first.ID, domain.MessageStatusSent, domain.MessageStatusFailed); err != nil {
+		t.Fatalf("UpdateMessageStatus failed: %v", err)
+	}
+	if _, err := messages.EditMessage(ctx, second.ID, "two!", time.Now()); err != nil {
+		t.Fatalf("EditMessage failed: %v", err)
+	}
+	if _, err := messages.MarkChatRead(ctx, chat.ID, 3, second.ID, time.Now()); err != nil {
+		t.Fatalf("MarkChatRead failed: %v", err)
+	}
+	if err := messages.HideMessage(ctx, first.ID, 2); err != nil {
+		t.Fatalf("HideMessage failed: %v", err)
+	}
+	for _, userID := range []int64{1, 3} {
+		if err := messages.SetReaction(ctx, &domain.Reaction{MessageID: second.ID, UserID: userID, Emoji: "👍", CreatedAt: time.Now()}); err != nil {
+			t.Fatalf("SetReaction failed: %v", err)
+		}
+	}
+	if _, err := messages.RemoveReaction(ctx, second.ID, 3); err != nil {
+		t.Fatalf("RemoveReaction failed: %v", err)
+	}
+	return second.ID
+}
+
+// checkJournal verifies the state written by seedJournal.
+func checkJournal(t *testing.T, j *Journal, secondID int64) {
+	t.Helper()
+	ctx := context.Background()
+	chats, _ := j.ChatRepository().GetChatsByUserID(ctx, 3)
+	if len(chats) != 1 || chats[0].Title != "Team" || len(chats[0].ParticipantIDs) != 3 {
+		t.Fatalf("expected the group with three members, got %+v", chats)
+	}
+	messages, _ := j.MessageRepository().GetMessagesByChatID(ctx, chats[0].ID, 0)
+	if len(messages) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(messages))
+	}
+	if messages[0].Status != domain.MessageStatusFailed || messages[1].ID != secondID {
+		t.Errorf("unexpected messages %+v, %+v", messages[0], messages[1])
+	}
+	if messages[0].ReplyCount != 1 || messages[1].ReplyToMessageID != messages[0].ID {
+		t.Errorf("expected message %d to reply to %d, got %+v, %+v", secondID, messages[0].ID, messages[0], messages[1])
+	}
+	history, _ := j.MessageRepository().GetStatusHistory(ctx, messages[0].ID)
+	if len(history) != 1 || history[0].To != domain.MessageStatusFailed {
+		t.Errorf("expected the transition to failed, got %+v", history)
+	}
+	if revisions, _ := j.MessageRepository().GetMessageRevisions(ctx, secondID); messages[1].Content != "two!" || len(revisions) != 1 || revisions[0].Content != "two" {
+		t.Errorf("expected the edit of message %d, got %+v and %+v", secondID, messages[1], revisions)
+	}
+	if reactions := messages[1].Reactions; len(reactions) != 1 || reactions[0].Count != 1 || reactions[0].UserIDs[0] != 1 {
+		t.Errorf("expected the reaction of user 1 only, got %+v", reactions)
+	}
+	if len(messages[1].AttachmentIDs) != 1 {
+		t.Fatalf("expected message %d to carry its attachment, got %+v", secondID, messages[1])
+	}
+	if attachment, _ := j.MessageRepository().GetAttachment(ctx, messages[1].AttachmentIDs[0]); attachment == nil || attachment.StorageKey != "plan" || attachment.MessageID != secondID || attachment.Thumbnail == nil || attachment.Thumbnail.Width != 2 {
+		t.Errorf("expected the attachment of message %d with its storage key and thumbnail, got %+v", secondID, attachment)
+	}
+	if !messages[0].ReadByAll([]int64{3}) || !messages[1].ReadByAll([]int64{3}) {
+		t.Errorf("expected user 3 to have read both messages, got %+v, %+v", messages[0].Receipts, messages[1].Receipts)
+	}
+	if hidden, _ := j.MessageRepository().GetMessagesByChatID(ctx, chats[0].ID, 2); len(hidden) != 1 || hidden[0].ID != secondID {
+		t.Errorf("expected only message %d for user 2, got %+v", secondID, hidden)
+	}
+	if summaries, _ := j.MessageRepository().SummarizeChats(ctx, 3, chats); summaries[0].UnreadCount != 0 {
+		t.Errorf("expected user 3 to have nothing unread, got %d", summaries[0].UnreadCount)
+	}
+	pending, _ := j.MessageRepository().GetPendingOutboxEntries(ctx, time.Now(), 10)
+	if len(pending) != 1 || pending[0].AggregateID != secondID {
+		t.Errorf("expected the outbox entry for message %d, got %+v", secondID, pending)
+	}
+
+	// IDs continue where they left off.
+	next, _ := j.MessageRepository().CreateMessage(ctx, &domain.Message{ChatID: chats[0].ID, Timestamp: time.Now()})
+	if next.ID != secondID+1 {
+		t.Errorf("expected next message ID %d, got %d", secondID+1, next.ID)
+	}
+	if attachment, _ := j.MessageRepository().CreateAttachment(ctx, &domain.Attachment{ChatID: chats[0].ID, CreatedAt: time.Now()}); attachment.ID != messages[1].AttachmentIDs[0]+1 {
+		t.Errorf("expected next attachment ID %d, got %d", messages[1].AttachmentIDs[0]+1, attachment.ID)
+	}
+}
+
+func TestJournal_ReplaysLog(t *testing.T) {
+	dir := t.TempDir()
+	j := openTestJournal(t, dir)
+	secondID := seedJournal(t, j)
+	j.Close()
+
+	checkJournal(t, openTestJournal(t, dir), secondID)
+}
+
+func TestJournal_ReplaysSnapshotAndLog(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	j := openTestJournal(t, dir)
+	secondID := seedJournal(t, j)
+	if err := j.Snapshot(); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if info, _ := os.Stat(filepath.Join(dir, journalLogFile)); info.Size() != 0 {
+		t.Errorf("expected an empty log after the snapshot, got %d bytes", info.Size())
+	}
+	chats, _ := j.ChatRepository().GetChatsByUserID(ctx, 1)
+	if _, err := j.ChatRepository().RemoveParticipant(ctx, chats[0].ID, 2); err != nil {
+		t.Fatalf("RemoveParticipant failed: %v", err)
+	}
+	j.Close()
+
+	reopened := openTestJournal(t, dir)
+	chats, _ = reopened.ChatRepository().GetChatsByUserID(ctx, 2)
+	if len(chats) != 0 {
+		t.Errorf("expected user 2 to have left the group, got %+v", chats)
+	}
+	messages, _ := reopened.MessageRepository().GetMessagesByChatID(ctx, 1, 0)
+	if len(messages) != 2 || messages[1].ID != secondID {
+		t.Errorf("expected both messages from the snapshot, got %+v", messages)
+	}
+	if history, _ := reopened.MessageRepository().GetStatusHistory(ctx, messages[0].ID); len(history) != 1 {
+		t.Errorf("expected the status history from the snapshot, got %+v", history)
+	}
+	if revisions, _ := reopened.MessageRepository().GetMessageRevisions(ctx, secondID); len(revisions) != 1 {
+		t.Errorf("expected the revisions from the snapshot, got %+v", revisions)
+	}
+	if hidden, _ := reopened.MessageRepository().GetMessagesByChatID(ctx, 1, 2); len(hidden) != 1 {
+		t.Errorf("expected the hidden message from the snapshot, got %+v", hidden)
+	}
+	if reactions, _ := reopened.MessageRepository().GetReactions(ctx, secondID); len(reactions) != 1 || reactions[0].UserID != 1 {
+		t.Errorf("expected the reaction from the snapshot, got %+v", reactions)
+	}
+	if attachment, _ := reopened.MessageRepository().GetAttachment(ctx, messages[1].AttachmentIDs[0]); attachment == nil || attachment.StorageKey != "plan" || attachment.Thumbnail == nil {
+		t.Errorf("expected the attachment from the snapshot, got %+v", attachment)
+	}
+}
+
+func TestJournal_TornRecord(t *testing.T) {
+	dir := t.TempDir()
+	j := openTestJournal(t, dir)
+	secondID := seedJournal(t, j)
+	j.Close()
+
+	// A crash in the middle of a write leaves half a line behind.
+	f, err := os.OpenFile(filepath.Join(dir, journalLogFile), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"seq":99,"message":{"id":`)
+	f.Close()
+
+	checkJournal(t, openTestJournal(t, dir), secondID)
+}
+
+func TestJournal_CorruptRecord(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, journalLogFile), []byte("not json\n"), 0o644)
+	if _, err := OpenJournal(dir, 0, false); err == nil {
+		t.Error("expected error for a corrupt record, got nil")
+	}
+}
+
+func TestJournal_Closed(t *testing.T) {
+	j := openTestJournal(t, t.TempDir())
+	j.Close()
+	if _, err := j.ChatRepository().CreateChat(context.Background(), &domain.Chat{Type: domain.ChatTypeGroup, Title: "Team"}); err == nil {
+		t.Error("expected error after Close, got nil")
+	}
+}
+
+// failingFile writes only part of the first record it is given and then
+// fails, like a disk running full in the middle of a write.
+type failingFile struct {
+	journalFile
+	failed bool
+}
+
+func (f *failingFile) Write(p []byte) (int, error) {
+	if f.failed {
+		return f.journalFile.Write(p)
+	}
+	f.failed = true
+	n, _ := f.journalFile.Write(p[:len(p)/2])
+	return n, syscall.ENOSPC
+}
+
+// TestJournal_FailedWrite tests that a write failing halfway leaves no torn
+// line in front of the records that follow it.
+func TestJournal_FailedWrite(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	j := openTestJournal(t, dir)
+	chats := j.ChatRepository()
+	if _, err := chats.CreateChat(ctx, &domain.Chat{Type: domain.ChatTypeGroup, Title: "Before", ParticipantIDs: []int64{1, 2}}); err != nil {
+		t.Fatalf("CreateChat failed: %v", err)
+	}
+	j.file = &failingFile{journalFile: j.file}
+	if _, err := chats.CreateChat(ctx, &domain.Chat{Type: domain.ChatTypeGroup, Title: "Lost", ParticipantIDs: []int64{1, 2}}); err == nil {
+		t.Fatal("expected the failed write to be reported")
+	}
+	after, err := chats.CreateChat(ctx, &domain.Chat{Type: domain.ChatTypeGroup, Title: "After", ParticipantIDs: []int64{1, 2}})
+	if err != nil {
+		t.Fatalf("CreateChat failed: %v", err)
+	}
+	j.Close()
+
+	reopened, openErr := OpenJournal(dir, 0, false)
+	if openErr != nil {
+		t.Fatalf("OpenJournal failed: %v", openErr)
+	}
+	defer reopened.Close()
+	chatList, _ := reopened.ChatRepository().GetChatsByUserID(ctx, 1)
+	sort.Slice(chatList, func(a, b int) bool { return chatList[a].ID < chatList[b].ID })
+	if len(chatList) != 2 || chatList[0].Title != "Before" || chatList[1].ID != after.ID || chatList[1].Title != "After" {
+		t.Errorf("expected the chats before and after the failed write, got %+v", chatList)
+	}
+}
@@ -0,0 +1,144 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations
+var migrations embed.FS
+
+// Migration is one versioned schema change. Files are named
+// "<version>_<description>.sql", e.g. "0001_create_chats_and_messages.sql".
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// loadMigrations returns the migrations embedded for dialect, ordered by
+// version.
+func loadMigrations(dialect Dialect) ([]Migration, error) {
+	dir := path.Join("migrations", string(dialect))
+	entries, err := fs.ReadDir(migrations, dir)
+	if err != nil {
+		return nil, err
+	}
+	var result []Migration
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".sql") {
+			continue
+		}
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: version prefix is not a number", name)
+		}
+		body, err := fs.ReadFile(migrations, path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, Migration{Version: version, Name: strings.TrimSuffix(name, ".sql"), SQL: string(body)})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Version < result[j].Version })
+	for i := 1; i < len(result); i++ {
+		if result[i].Version == result[i-1].Version {
+			return nil, fmt.Errorf("duplicate migration version %d", result[i].Version)
+		}
+	}
+	return result, nil
+}
+
+// Migrate applies the embedded migrations for dialect that db has not seen
+// yet, each in its own transaction, and returns the ones it applied. Applied
+// versions are recorded in schema_migrations, so instances starting together
+// apply each migration once.
+func Migrate(ctx context.Context, db *sql.DB, dialect Dialect) ([]Migration, error) {
+	all, err := loadMigrations(dialect)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		name       TEXT    NOT NULL,
+		applied_at BIGINT  NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	applied := map[int]bool{}
+	rows, err := db.QueryContext(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		applied[version] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, m := range all {
+		if applied[m.Version] {
+			continue
+		}
+		ok, err := applyMigration(ctx, db, dialect, m)
+		if err != nil {
+			return done, fmt.Errorf("migration %s: %w", m.Name, err)
+		}
+		if ok {
+			done = append(done, m)
+		}
+	}
+	return done, nil
+}
+
+// applyMigration applies m unless another instance got there first, and
+// reports whether it did.
+func applyMigration(ctx context.Context, db *sql.DB, dialect Dialect, m Migration) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	c := conn{tx, dialect}
+	if dialect == DialectPostgres {
+		// Held until commit; SQLite already serializes writers.
+		if _, err := c.ExecContext(ctx, `LOCK TABLE schema_migrations IN EXCLUSIVE MODE`); err != nil {
+			return false, err
+		}
+	}
+	var applied int
+	if err := c.QueryRowContext(ctx, `SELECT COUNT(*) FROM schema_migrations WHERE version = ?`, m.Version).Scan(&applied); err != nil {
+		return false, err
+	}
+	if applied > 0 {
+		return false, nil
+	}
+	// Migrations are run verbatim, without rebinding.
+	if _, err := tx.ExecContext(ctx, m.SQL); err != nil {
+		return false, err
+	}
+	_, err = c.ExecContext(ctx, `INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+		m.Version, m.Name, time.Now().UnixNano())
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
@@ -0,0 +1,50 @@
+-- Times are stored as Unix nanoseconds so message cursors round-trip exactly;
+-- timestamptz only keeps microseconds.
+
+CREATE TABLE chats (
+    id              BIGSERIAL PRIMARY KEY,
+    type            TEXT      NOT NULL,
+    title           TEXT      NOT NULL DEFAULT '',
+    participant1_id BIGINT    NOT NULL DEFAULT 0,
+    participant2_id BIGINT    NOT NULL DEFAULT 0,
+    metadata        TEXT      NOT NULL DEFAULT '',
+    created_at      BIGINT    NOT NULL
+);
+
+-- Members of every chat, direct or group, in the order they joined.
+CREATE TABLE chat_participants (
+    chat_id  BIGINT  NOT NULL REFERENCES chats (id) ON DELETE CASCADE,
+    user_id  BIGINT  NOT NULL,
+    position INTEGER NOT NULL,
+    PRIMARY KEY (chat_id, user_id)
+);
+
+CREATE INDEX chat_participants_user_id ON chat_participants (user_id, chat_id);
+
+CREATE TABLE messages (
+    id        BIGSERIAL PRIMARY KEY,
+    chat_id   BIGINT    NOT NULL,
+    sender_id BIGINT    NOT NULL,
+    content   TEXT      NOT NULL,
+    timestamp BIGINT    NOT NULL,
+    status    TEXT      NOT NULL
+);
+
+-- Chat history is read in (timestamp, id) order.
+CREATE INDEX messages_chat_id_timestamp ON messages (chat_id, timestamp, id);
+
+CREATE TABLE outbox (
+    id              BIGSERIAL PRIMARY KEY,
+    event_type      TEXT      NOT NULL,
+    aggregate_id    BIGINT    NOT NULL,
+    payload         BYTEA     NOT NULL,
+    status          TEXT      NOT NULL,
+    attempts        INTEGER   NOT NULL DEFAULT 0,
+    last_error      TEXT      NOT NULL DEFAULT '',
+    created_at      BIGINT    NOT NULL,
+    next_attempt_at BIGINT    NOT NULL,
+    sent_at         BIGINT
+);
+
+-- Only pending entries are polled; sent ones stay out of the index.
+CREATE INDEX outbox_pending ON outbox (next_attempt_at, id) WHERE status = 'pending';
@@ -0,0 +1,9 @@
+-- When each recipient received and read a message. A row exists once the
+-- message reached the recipient; read_at stays NULL until they read it.
+CREATE TABLE message_receipts (
+    message_id   BIGINT NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+    user_id      BIGINT NOT NULL,
+    delivered_at BIGINT,
+    read_at      BIGINT,
+    PRIMARY KEY (message_id, user_id)
+);
@@ -0,0 +1,10 @@
+-- Every status change of a message, in the order it happened.
+CREATE TABLE message_status_transitions (
+    id          BIGSERIAL PRIMARY KEY,
+    message_id  BIGINT    NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+    from_status TEXT      NOT NULL,
+    to_status   TEXT      NOT NULL,
+    at          BIGINT    NOT NULL
+);
+
+CREATE INDEX message_status_transitions_message_id ON message_status_transitions (message_id, id);
@@ -0,0 +1,16 @@
+-- The last message each member has read in each chat. Unread counts are the
+-- messages after it, so listing chats reads only the unread tail of each.
+CREATE TABLE chat_reads (
+    chat_id              BIGINT NOT NULL,
+    user_id              BIGINT NOT NULL,
+    last_read_message_id BIGINT NOT NULL,
+    PRIMARY KEY (chat_id, user_id)
+);
+
+INSERT INTO chat_reads (chat_id, user_id, last_read_message_id)
+SELECT m.chat_id, r.user_id, MAX(m.id)
+FROM message_receipts r JOIN messages m ON m.id = r.message_id
+WHERE r.read_at IS NOT NULL
+GROUP BY m.chat_id, r.user_id;
+
+CREATE INDEX messages_chat_id_id ON messages (chat_id, id);
@@ -0,0 +1,4 @@
+-- The message of the same chat a message replies to, if any.
+ALTER TABLE messages ADD COLUMN reply_to_message_id BIGINT REFERENCES messages (id);
+
+CREATE INDEX messages_reply_to_message_id ON messages (reply_to_message_id);
@@ -0,0 +1,15 @@
+-- Files uploaded to a chat. The content lives in the blob store under
+-- storage_key; message_id is set once a message references the file.
+CREATE TABLE attachments (
+    id           BIGSERIAL PRIMARY KEY,
+    chat_id      BIGINT    NOT NULL,
+    uploader_id  BIGINT    NOT NULL,
+    file_name    TEXT      NOT NULL,
+    content_type TEXT      NOT NULL,
+    size         BIGINT    NOT NULL,
+    storage_key  TEXT      NOT NULL,
+    created_at   BIGINT    NOT NULL,
+    message_id   BIGINT    REFERENCES messages (id) ON DELETE SET NULL
+);
+
+CREATE INDEX attachments_message_id ON attachments (message_id, id);
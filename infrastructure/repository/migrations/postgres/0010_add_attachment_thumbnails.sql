@@ -0,0 +1,6 @@
+-- The thumbnail generated for an image attachment, stored in the blob store
+-- next to its content. The columns stay NULL until it exists.
+ALTER TABLE attachments ADD COLUMN thumbnail_width INTEGER;
+ALTER TABLE attachments ADD COLUMN thumbnail_height INTEGER;
+ALTER TABLE attachments ADD COLUMN thumbnail_content_type TEXT;
+ALTER TABLE attachments ADD COLUMN thumbnail_size BIGINT;
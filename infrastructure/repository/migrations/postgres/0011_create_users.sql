@@ -0,0 +1,17 @@
+-- Users, seeded with the four users the service has always started with.
+CREATE TABLE users (
+    id   BIGSERIAL PRIMARY KEY,
+    name TEXT      NOT NULL
+);
+
+INSERT INTO users (id, name) VALUES (1, 'Red'), (2, 'Jrue'), (3, 'Miro'), (4, 'Joann');
+
+-- Users created before this table existed were lost on every restart, but
+-- chats and messages may still reference them; never hand out their IDs.
+SELECT setval(pg_get_serial_sequence('users', 'id'), (
+    SELECT MAX(id) FROM (
+        SELECT 4 AS id
+        UNION ALL SELECT user_id FROM chat_participants
+        UNION ALL SELECT sender_id FROM messages
+    ) ids
+));
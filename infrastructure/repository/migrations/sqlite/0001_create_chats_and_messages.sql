@@ -0,0 +1,48 @@
+-- Times are stored as Unix nanoseconds so message cursors round-trip exactly.
+
+CREATE TABLE chats (
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    type            TEXT    NOT NULL,
+    title           TEXT    NOT NULL DEFAULT '',
+    participant1_id INTEGER NOT NULL DEFAULT 0,
+    participant2_id INTEGER NOT NULL DEFAULT 0,
+    metadata        TEXT    NOT NULL DEFAULT '',
+    created_at      INTEGER NOT NULL
+);
+
+-- Members of every chat, direct or group, in the order they joined.
+CREATE TABLE chat_participants (
+    chat_id  INTEGER NOT NULL REFERENCES chats (id) ON DELETE CASCADE,
+    user_id  INTEGER NOT NULL,
+    position INTEGER NOT NULL,
+    PRIMARY KEY (chat_id, user_id)
+);
+
+CREATE INDEX chat_participants_user_id ON chat_participants (user_id, chat_id);
+
+CREATE TABLE messages (
+    id        INTEGER PRIMARY KEY AUTOINCREMENT,
+    chat_id   INTEGER NOT NULL,
+    sender_id INTEGER NOT NULL,
+    content   TEXT    NOT NULL,
+    timestamp INTEGER NOT NULL,
+    status    TEXT    NOT NULL
+);
+
+-- Chat history is read in (timestamp, id) order.
+CREATE INDEX messages_chat_id_timestamp ON messages (chat_id, timestamp, id);
+
+CREATE TABLE outbox (
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    event_type      TEXT    NOT NULL,
+    aggregate_id    INTEGER NOT NULL,
+    payload         BLOB    NOT NULL,
+    status          TEXT    NOT NULL,
+    attempts        INTEGER NOT NULL DEFAULT 0,
+    last_error      TEXT    NOT NULL DEFAULT '',
+    created_at      INTEGER NOT NULL,
+    next_attempt_at INTEGER NOT NULL,
+    sent_at         INTEGER
+);
+
+CREATE INDEX outbox_pending ON outbox (status, next_attempt_at, id);
@@ -0,0 +1,13 @@
+-- Set once the sender edits a message.
+ALTER TABLE messages ADD COLUMN edited_at INTEGER;
+
+-- The contents a message had before each edit, oldest first.
+CREATE TABLE message_revisions (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    message_id  INTEGER NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+    content     TEXT    NOT NULL,
+    created_at  INTEGER NOT NULL,
+    replaced_at INTEGER NOT NULL
+);
+
+CREATE INDEX message_revisions_message_id ON message_revisions (message_id, id);
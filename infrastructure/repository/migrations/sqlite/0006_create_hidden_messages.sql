@@ -0,0 +1,10 @@
+-- Set once the sender deletes a message for everyone; the row stays as a
+-- tombstone.
+ALTER TABLE messages ADD COLUMN deleted_at INTEGER;
+
+-- Messages each user deleted for themselves only.
+CREATE TABLE hidden_messages (
+    message_id INTEGER NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+    user_id    INTEGER NOT NULL,
+    PRIMARY KEY (message_id, user_id)
+);
@@ -0,0 +1,8 @@
+-- The reaction of each user to a message; reacting again replaces it.
+CREATE TABLE message_reactions (
+    message_id INTEGER NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+    user_id    INTEGER NOT NULL,
+    emoji      TEXT    NOT NULL,
+    created_at INTEGER NOT NULL,
+    PRIMARY KEY (message_id, user_id)
+);
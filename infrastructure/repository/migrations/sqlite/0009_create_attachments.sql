@@ -0,0 +1,15 @@
+-- Files uploaded to a chat. The content lives in the blob store under
+-- storage_key; message_id is set once a message references the file.
+CREATE TABLE attachments (
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    chat_id      INTEGER NOT NULL,
+    uploader_id  INTEGER NOT NULL,
+    file_name    TEXT    NOT NULL,
+    content_type TEXT    NOT NULL,
+    size         INTEGER NOT NULL,
+    storage_key  TEXT    NOT NULL,
+    created_at   INTEGER NOT NULL,
+    message_id   INTEGER REFERENCES messages (id) ON DELETE SET NULL
+);
+
+CREATE INDEX attachments_message_id ON attachments (message_id, id);
@@ -0,0 +1,16 @@
+-- Messages the broker parked after repeated processing failures, kept here
+-- until an operator replays or discards them.
+CREATE TABLE dead_letters (
+    id               INTEGER PRIMARY KEY AUTOINCREMENT,
+    message_id       TEXT    NOT NULL DEFAULT '',
+    correlation_id   TEXT    NOT NULL DEFAULT '',
+    event_type       TEXT    NOT NULL DEFAULT '',
+    routing_key      TEXT    NOT NULL DEFAULT '',
+    queue            TEXT    NOT NULL DEFAULT '',
+    reason           TEXT    NOT NULL,
+    last_error       TEXT    NOT NULL DEFAULT '',
+    retry_count      INTEGER NOT NULL DEFAULT 0,
+    content_type     TEXT    NOT NULL DEFAULT '',
+    body             BLOB    NOT NULL,
+    dead_lettered_at INTEGER NOT NULL
+);
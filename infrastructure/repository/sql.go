@@ -0,0 +1,1627 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"messaging-app/domain"
+	"messaging-app/pkg/apistatus"
+)
+
+// scanner is implemented by *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// Times are stored as Unix nanoseconds so cursors round-trip exactly.
+func toNanos(t time.Time) int64 {
+	return t.UnixNano()
+}
+
+func fromNanos(n int64) time.Time {
+	return time.Unix(0, n).UTC()
+}
+
+func fromNullNanos(n sql.NullInt64) *time.Time {
+	if !n.Valid {
+		return nil
+	}
+	t := fromNanos(n.Int64)
+	return &t
+}
+
+func internalError(err error) apistatus.Status {
+	return apistatus.New(err).InternalServerError()
+}
+
+// placeholders returns "?, ?, ..." for n arguments.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// SQLUserRepository implements UserRepository on a SQL database. The
+// original four users are seeded by the migration that creates the table.
+type SQLUserRepository struct {
+	db      *sql.DB
+	dialect Dialect
+}
+
+// NewSQLUserRepository creates a repository on a migrated database.
+func NewSQLUserRepository(db *sql.DB, dialect Dialect) UserRepository {
+	return &SQLUserRepository{db: db, dialect: dialect}
+}
+
+func (r *SQLUserRepository) conn() conn {
+	return conn{r.db, r.dialect}
+}
+
+func (r *SQLUserRepository) CreateUser(ctx context.Context, user *domain.User) (*domain.User, apistatus.Status) {
+	err := r.conn().QueryRowContext(ctx, `INSERT INTO users (name) VALUES (?) RETURNING id`, user.Name).Scan(&user.ID)
+	if err != nil {
+		return nil, internalError(err)
+	}
+	return user, nil
+}
+
+func (r *SQLUserRepository) GetUserByID(ctx context.Context, userID int64) (*domain.User, apistatus.Status) {
+	var user domain.User
+	err := r.conn().QueryRowContext(ctx, `SELECT id, name FROM users WHERE id = ?`, userID).Scan(&user.ID, &user.Name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apistatus.New("user not found").NotFound()
+	}
+	if err != nil {
+		return nil, internalError(err)
+	}
+	return &user, nil
+}
+
+func (r *SQLUserRepository) ListUsers(ctx context.Context) ([]*domain.User, apistatus.Status) {
+	rows, err := r.conn().QueryContext(ctx, `SELECT id, name FROM users ORDER BY id`)
+	if err != nil {
+		return nil, internalError(err)
+	}
+	defer rows.Close()
+	result := []*domain.User{}
+	for rows.Next() {
+		var user domain.User
+		if err := rows.Scan(&user.ID, &user.Name); err != nil {
+			return nil, internalError(err)
+		}
+		result = append(result, &user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, internalError(err)
+	}
+	return result, nil
+}
+
+func (r *SQLUserRepository) UpdateUser(ctx context.Context, user *domain.User) (*domain.User, apistatus.Status) {
+	if as := r.exec(ctx, `UPDATE users SET name = ? WHERE id = ?`, user.Name, user.ID); as != nil {
+		return nil, as
+	}
+	return user, nil
+}
+
+func (r *SQLUserRepository) DeleteUser(ctx context.Context, userID int64) apistatus.Status {
+	return r.exec(ctx, `DELETE FROM users WHERE id = ?`, userID)
+}
+
+// exec runs a statement on a single user and fails with NotFound when no row
+// was affected.
+func (r *SQLUserRepository) exec(ctx context.Context, stmt string, args ...interface{}) apistatus.Status {
+	res, err := r.conn().ExecContext(ctx, stmt, args...)
+	if err != nil {
+		return internalError(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return internalError(err)
+	}
+	if n == 0 {
+		return apistatus.New("user not found").NotFound()
+	}
+	return nil
+}
+
+// SQLChatRepository implements ChatRepository on a SQL database. Members of
+// every chat live in chat_participants, indexed by user, so listing a user's
+// chats does not scan all chats.
+type SQLChatRepository struct {
+	db      *sql.DB
+	dialect Dialect
+}
+
+// NewSQLChatRepository creates a repository on a migrated database.
+func NewSQLChatRepository(db *sql.DB, dialect Dialect) ChatRepository {
+	return &SQLChatRepository{db: db, dialect: dialect}
+}
+
+func (r *SQLChatRepository) CreateChat(ctx context.Context, chat *domain.Chat) (*domain.Chat, apistatus.Status) {
+	return r.CreateChatWithOutbox(ctx, chat, nil)
+}
+
+func (r *SQLChatRepository) CreateChatWithOutbox(ctx context.Context, chat *domain.Chat, buildEntry ChatOutboxEntryBuilder) (*domain.Chat, apistatus.Status) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, internalError(err)
+	}
+	defer tx.Rollback()
+
+	c := conn{tx, r.dialect}
+	createdAt := time.Now()
+	var id int64
+	err = c.QueryRowContext(ctx,
+		`INSERT INTO chats (type, title, participant1_id, participant2_id, metadata, created_at) VALUES (?, ?, ?, ?, ?, ?) RETURNING id`,
+		chat.Type, chat.Title, chat.Participant1ID, chat.Participant2ID, chat.Metadata, toNanos(createdAt)).Scan(&id)
+	if err != nil {
+		return nil, internalError(err)
+	}
+	for i, userID := range chat.Participants() {
+		_, err := c.ExecContext(ctx,
+			`INSERT INTO chat_participants (chat_id, user_id, position) VALUES (?, ?, ?)`, id, userID, i)
+		if err != nil {
+			return nil, internalError(err)
+		}
+	}
+	chat.ID = id
+	chat.CreatedAt = createdAt
+	err = addChatOutboxEntry(ctx, c, buildEntry, chat)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		chat.ID = 0
+		return nil, internalError(err)
+	}
+	return chat, nil
+}
+
+func (r *SQLChatRepository) GetChatByID(ctx context.Context, chatID int64) (*domain.Chat, apistatus.Status) {
+	return r.getChat(ctx, conn{r.db, r.dialect}, chatID)
+}
+
+func (r *SQLChatRepository) getChat(ctx context.Context, q conn, chatID int64) (*domain.Chat, apistatus.Status) {
+	chat, err := scanChat(q.QueryRowContext(ctx,
+		`SELECT id, type, title, participant1_id, participant2_id, metadata, created_at FROM chats WHERE id = ?`, chatID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apistatus.New("chat not found").NotFound()
+	}
+	if err != nil {
+		return nil, internalError(err)
+	}
+	members, err := loadParticipants(ctx, q,
+		`SELECT chat_id, user_id FROM chat_participants WHERE chat_id = ? ORDER BY position`, chatID)
+	if err != nil {
+		return nil, internalError(err)
+	}
+	chat.ParticipantIDs = members[chat.ID]
+	return chat, nil
+}
+
+func (r *SQLChatRepository) GetChatsByUserID(ctx context.Context, userID int64) ([]*domain.Chat, apistatus.Status) {
+	c := conn{r.db, r.dialect}
+	rows, err := c.QueryContext(ctx,
+		`SELECT c.id, c.type, c.title, c.participant1_id, c.participant2_id, c.metadata, c.created_at
+		FROM chats c JOIN chat_participants p ON p.chat_id = c.id
+		WHERE p.user_id = ? ORDER BY c.id`, userID)
+	if err != nil {
+		return nil, internalError(err)
+	}
+	defer rows.Close()
+	var result []*domain.Chat
+	for rows.Next() {
+		chat, err := scanChat(rows)
+		if err != nil {
+			return nil, internalError(err)
+		}
+		result = append(result, chat)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, internalError(err)
+	}
+	rows.Close()
+
+	members, err := loadParticipants(ctx, c,
+		`SELECT chat_id, user_id FROM chat_participants
+		WHERE chat_id IN (SELECT chat_id FROM chat_participants WHERE user_id = ?)
+		ORDER BY chat_id, position`, userID)
+	if err != nil {
+		return nil, internalError(err)
+	}
+	for _, chat := range result {
+		chat.ParticipantIDs = members[chat.ID]
+	}
+	return result, nil
+}
+
+func (r *SQLChatRepository) AddParticipant(ctx context.Context, chatID, userID int64) (*domain.Chat, apistatus.Status) {
+	return r.AddParticipantWithOutbox(ctx, chatID, userID, nil)
+}
+
+func (r *SQLChatRepository) AddParticipantWithOutbox(ctx context.Context, chatID, userID int64, buildEntry ChatOutboxEntryBuilder) (*domain.Chat, apistatus.Status) {
+	return r.updateParticipants(ctx, chatID, buildEntry,
+		`INSERT INTO chat_participants (chat_id, user_id, position)
+		SELECT CAST(? AS BIGINT), CAST(? AS BIGINT), COALESCE(MAX(position), -1) + 1 FROM chat_participants WHERE chat_id = ?
+		ON CONFLICT (chat_id, user_id) DO NOTHING`, chatID, userID, chatID)
+}
+
+func (r *SQLChatRepository) RemoveParticipant(ctx context.Context, chatID, userID int64) (*domain.Chat, apistatus.Status) {
+	return r.RemoveParticipantWithOutbox(ctx, chatID, userID, nil)
+}
+
+func (r *SQLChatRepository) RemoveParticipantWithOutbox(ctx context.Context, chatID, userID int64, buildEntry ChatOutboxEntryBuilder) (*domain.Chat, apistatus.Status) {
+	return r.updateParticipants(ctx, chatID, buildEntry,
+		`DELETE FROM chat_participants WHERE chat_id = ? AND user_id = ?`, chatID, userID)
+}
+
+// updateParticipants runs stmt for an existing chat and returns the chat as
+// updated, storing the outbox entry built for it in the same transaction.
+func (r *SQLChatRepository) updateParticipants(ctx context.Context, chatID int64, buildEntry ChatOutboxEntryBuilder, stmt string, args ...interface{}) (*domain.Chat, apistatus.Status) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, internalError(err)
+	}
+	defer tx.Rollback()
+	c := conn{tx, r.dialect}
+	if _, as := r.getChat(ctx, c, chatID); as != nil {
+		return nil, as
+	}
+	if _, err := c.ExecContext(ctx, stmt, args...); err != nil {
+		return nil, internalError(err)
+	}
+	chat, as := r.getChat(ctx, c, chatID)
+	if as != nil {
+		return nil, as
+	}
+	if err := addChatOutboxEntry(ctx, c, buildEntry, chat); err != nil {
+		return nil, internalError(err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, internalError(err)
+	}
+	return chat, nil
+}
+
+// addChatOutboxEntry is addOutboxEntry for chat writes.
+func addChatOutboxEntry(ctx context.Context, c conn, buildEntry ChatOutboxEntryBuilder, chat *domain.Chat) error {
+	if buildEntry == nil {
+		return nil
+	}
+	entry, err := buildEntry(chat)
+	if err != nil {
+		return err
+	}
+	return insertOutboxEntry(ctx, c, entry)
+}
+
+func scanChat(row scanner) (*domain.Chat, error) {
+	var chat domain.Chat
+	var createdAt int64
+	err := row.Scan(&chat.ID, &chat.Type, &chat.Title, &chat.Participant1ID, &chat.Participant2ID, &chat.Metadata, &createdAt)
+	if err != nil {
+		return nil, err
+	}
+	chat.CreatedAt = fromNanos(createdAt)
+	return &chat, nil
+}
+
+// loadParticipants runs a query selecting (chat_id, user_id) pairs and groups
+// the user IDs by chat, keeping their order.
+func loadParticipants(ctx context.Context, q conn, query string, args ...interface{}) (map[int64][]int64, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	members := map[int64][]int64{}
+	for rows.Next() {
+		var chatID, userID int64
+		if err := rows.Scan(&chatID, &userID); err != nil {
+			return nil, err
+		}
+		members[chatID] = append(members[chatID], userID)
+	}
+	return members, rows.Err()
+}
+
+// SQLMessageRepository implements MessageRepository on a SQL database. Messages
+// and their outbox entries are written in one transaction.
+type SQLMessageRepository struct {
+	db      *sql.DB
+	dialect Dialect
+}
+
+// NewSQLMessageRepository creates a repository on a migrated database.
+func NewSQLMessageRepository(db *sql.DB, dialect Dialect) MessageRepository {
+	return &SQLMessageRepository{db: db, dialect: dialect}
+}
+
+func (r *SQLMessageRepository) conn() conn {
+	return conn{r.db, r.dialect}
+}
+
+const messageColumns = `id, chat_id, sender_id, content, timestamp, status, edited_at, deleted_at, reply_to_message_id`
+
+func insertMessage(ctx context.Context, c conn, msg *domain.Message) (int64, error) {
+	var replyTo sql.NullInt64
+	if msg.ReplyToMessageID != 0 {
+		replyTo = sql.NullInt64{Int64: msg.ReplyToMessageID, Valid: true}
+	}
+	var id int64
+	err := c.QueryRowContext(ctx,
+		`INSERT INTO messages (chat_id, sender_id, content, timestamp, status, reply_to_message_id) VALUES (?, ?, ?, ?, ?, ?) RETURNING id`,
+		msg.ChatID, msg.SenderID, msg.Content, toNanos(msg.Timestamp), msg.Status, replyTo).Scan(&id)
+	return id, err
+}
+
+func (r *SQLMessageRepository) CreateMessage(ctx context.Context, msg *domain.Message) (*domain.Message, apistatus.Status) {
+	return r.createMessage(ctx, msg, nil)
+}
+
+// CreateMessageWithOutbox stores msg and the outbox entry built for it in one
+// transaction, so neither is visible without the other.
+func (r *SQLMessageRepository) CreateMessageWithOutbox(ctx context.Context, msg *domain.Message, buildEntry OutboxEntryBuilder) (*domain.Message, apistatus.Status) {
+	return r.createMessage(ctx, msg, buildEntry)
+}
+
+// createMessage stores msg, links its attachments to it and, unless
+// buildEntry is nil, stores the outbox entry built for it, all in one
+// transaction.
+func (r *SQLMessageRepository) createMessage(ctx context.Context, msg *domain.Message, buildEntry OutboxEntryBuilder) (*domain.Message, apistatus.Status) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, internalError(err)
+	}
+	defer tx.Rollback()
+	c := conn{tx, r.dialect}
+	id, err := insertMessage(ctx, c, msg)
+	if err != nil {
+		return nil, internalError(err)
+	}
+	msg.ID = id
+	if as := linkAttachments(ctx, c, msg); as != nil {
+		msg.ID = 0
+		return nil, as
+	}
+	if buildEntry != nil {
+		var entry *domain.OutboxEntry
+		entry, err = buildEntry(msg)
+		if err == nil {
+			err = insertOutboxEntry(ctx, c, entry)
+		}
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		msg.ID = 0
+		return nil, internalError(err)
+	}
+	return msg, nil
+}
+
+// linkAttachments sorts the attachment IDs of msg and points each of them at
+// msg. Only uploads of the sender to the chat that no message references yet
+// can be linked.
+func linkAttachments(ctx context.Context, c conn, msg *domain.Message) apistatus.Status {
+	if len(msg.AttachmentIDs) == 0 {
+		return nil
+	}
+	ids := append([]int64{}, msg.AttachmentIDs...)
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	args := []interface{}{msg.ID, msg.ChatID, msg.SenderID}
+	for i, id := range ids {
+		if i > 0 && ids[i-1] == id {
+			return errAttachmentsUnavailable()
+		}
+		args = append(args, id)
+	}
+	res, err := c.ExecContext(ctx,
+		`UPDATE attachments SET message_id = ? WHERE message_id IS NULL AND chat_id = ? AND uploader_id = ?
+		AND id IN (`+placeholders(len(ids))+`)`, args...)
+	if err != nil {
+		return internalError(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return internalError(err)
+	}
+	if n != int64(len(ids)) {
+		return errAttachmentsUnavailable()
+	}
+	msg.AttachmentIDs = ids
+	return nil
+}
+
+// queryMessages runs query, scans every row into a message and loads the
+// receipts of the messages.
+func queryMessages(ctx context.Context, c conn, query string, args ...interface{}) ([]*domain.Message, error) {
+	rows, err := c.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := []*domain.Message{}
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	if err := loadReceipts(ctx, c, result); err != nil {
+		return nil, err
+	}
+	return result, loadAttachmentIDs(ctx, c, result)
+}
+
+// messageBatchSize bounds the number of placeholders in one query over a
+// batch of messages.
+const messageBatchSize = 500
+
+// messageIDBatches splits the IDs of msgs into query arguments of at most
+// messageBatchSize each.
+func messageIDBatches(msgs []*domain.Message) [][]interface{} {
+	var batches [][]interface{}
+	for start := 0; start < len(msgs); start += messageBatchSize {
+		batch := msgs[start:]
+		if len(batch) > messageBatchSize {
+			batch = batch[:messageBatchSize]
+		}
+		args := make([]interface{}, len(batch))
+		for i, msg := range batch {
+			args[i] = msg.ID
+		}
+		batches = append(batches, args)
+	}
+	return batches
+}
+
+// loadReceipts fills in the receipts of msgs.
+func loadReceipts(ctx context.Context, c conn, msgs []*domain.Message) error {
+	byID := make(map[int64]*domain.Message, len(msgs))
+	for _, msg := range msgs {
+		byID[msg.ID] = msg
+	}
+	for _, args := range messageIDBatches(msgs) {
+		rows, err := c.QueryContext(ctx,
+			`SELECT message_id, user_id, delivered_at, read_at FROM message_receipts
+			WHERE message_id IN (`+placeholders(len(args))+`) ORDER BY message_id, user_id`, args...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var messageID int64
+			var receipt domain.Receipt
+			var deliveredAt, readAt sql.NullInt64
+			if err := rows.Scan(&messageID, &receipt.UserID, &deliveredAt, &readAt); err != nil {
+				rows.Close()
+				return err
+			}
+			receipt.DeliveredAt = fromNullNanos(deliveredAt)
+			receipt.ReadAt = fromNullNanos(readAt)
+			msg := byID[messageID]
+			msg.Receipts = append(msg.Receipts, receipt)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadAttachmentIDs fills in the attachments of msgs that are not deleted.
+func loadAttachmentIDs(ctx context.Context, c conn, msgs []*domain.Message) error {
+	byID := make(map[int64]*domain.Message, len(msgs))
+	for _, msg := range msgs {
+		byID[msg.ID] = msg
+	}
+	for _, args := range messageIDBatches(msgs) {
+		rows, err := c.QueryContext(ctx,
+			`SELECT message_id, id FROM attachments
+			WHERE message_id IN (`+placeholders(len(args))+`) ORDER BY message_id, id`, args...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var messageID, attachmentID int64
+			if err := rows.Scan(&messageID, &attachmentID); err != nil {
+				rows.Close()
+				return err
+			}
+			if msg := byID[messageID]; msg.DeletedAt == nil {
+				msg.AttachmentIDs = append(msg.AttachmentIDs, attachmentID)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadCounts fills in the reply counts and reactions of msgs.
+func loadCounts(ctx context.Context, c conn, msgs []*domain.Message) error {
+	if err := loadReplyCounts(ctx, c, msgs); err != nil {
+		return err
+	}
+	return loadReactions(ctx, c, msgs)
+}
+
+// loadReactions fills in the reactions to each of msgs, counted per emoji.
+func loadReactions(ctx context.Context, c conn, msgs []*domain.Message) error {
+	byMessage := make(map[int64][]*domain.Reaction)
+	for _, args := range messageIDBatches(msgs) {
+		rows, err := c.QueryContext(ctx,
+			`SELECT message_id, user_id, emoji, created_at FROM message_reactions
+			WHERE message_id IN (`+placeholders(len(args))+`) ORDER BY message_id, created_at, user_id`, args...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			reaction, err := scanReaction(rows)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			byMessage[reaction.MessageID] = append(byMessage[reaction.MessageID], reaction)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	for _, msg := range msgs {
+		msg.Reactions = domain.CountReactions(byMessage[msg.ID])
+	}
+	return nil
+}
+
+func scanReaction(row scanner) (*domain.Reaction, error) {
+	var reaction domain.Reaction
+	var createdAt int64
+	if err := row.Scan(&reaction.MessageID, &reaction.UserID, &reaction.Emoji, &createdAt); err != nil {
+		return nil, err
+	}
+	reaction.CreatedAt = fromNanos(createdAt)
+	return &reaction, nil
+}
+
+// loadReplyCounts fills in the number of direct replies to each of msgs.
+func loadReplyCounts(ctx context.Context, c conn, msgs []*domain.Message) error {
+	byID := make(map[int64]*domain.Message, len(msgs))
+	for _, msg := range msgs {
+		byID[msg.ID] = msg
+	}
+	for _, args := range messageIDBatches(msgs) {
+		rows, err := c.QueryContext(ctx,
+			`SELECT reply_to_message_id, COUNT(*) FROM messages
+			WHERE reply_to_message_id IN (`+placeholders(len(args))+`) GROUP BY reply_to_message_id`, args...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var messageID int64
+			var count int
+			if err := rows.Scan(&messageID, &count); err != nil {
+				rows.Close()
+				return err
+			}
+			byID[messageID].ReplyCount = count
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func scanMessage(row scanner) (*domain.Message, error) {
+	var msg domain.Message
+	var timestamp int64
+	var editedAt, deletedAt, replyTo sql.NullInt64
+	if err := row.Scan(&msg.ID, &msg.ChatID, &msg.SenderID, &msg.Content, &timestamp, &msg.Status, &editedAt, &deletedAt, &replyTo); err != nil {
+		return nil, err
+	}
+	msg.ReplyToMessageID = replyTo.Int64
+	msg.Timestamp = fromNanos(timestamp)
+	msg.EditedAt = fromNullNanos(editedAt)
+	msg.DeletedAt = fromNullNanos(deletedAt)
+	return &msg, nil
+}
+
+// notHiddenFrom leaves out the messages a user deleted for themselves.
+const notHiddenFrom = `NOT EXISTS (SELECT 1 FROM hidden_messages h WHERE h.message_id = messages.id AND h.user_id = ?)`
+
+func (r *SQLMessageRepository) GetMessagesByChatID(ctx context.Context, chatID, userID int64) ([]*domain.Message, apistatus.Status) {
+	result, err := queryMessages(ctx, r.conn(),
+		`SELECT `+messageColumns+` FROM messages WHERE chat_id = ? AND `+notHiddenFrom+` ORDER BY timestamp, id`, chatID, userID)
+	if err == nil {
+		err = loadCounts(ctx, r.conn(), result)
+	}
+	if err != nil {
+		return nil, internalError(err)
+	}
+	if len(result) == 0 {
+		return nil, apistatus.New("messages not found").NotFound()
+	}
+	return result, nil
+}
+
+// GetThread walks the replies down from rootID with a recursive query. An
+// empty thread is told apart from a missing root by a second lookup.
+func (r *SQLMessageRepository) GetThread(ctx context.Context, rootID, userID int64) ([]*domain.Message, apistatus.Status) {
+	result, err := queryMessages(ctx, r.conn(),
+		`WITH RECURSIVE thread (id) AS (
+			SELECT id FROM messages WHERE id = ?
+			UNION ALL
+			SELECT m.id FROM messages m JOIN thread t ON m.reply_to_message_id = t.id
+		)
+		SELECT `+messageColumns+` FROM messages WHERE id IN (SELECT id FROM thread) AND `+notHiddenFrom+` ORDER BY timestamp, id`,
+		rootID, userID)
+	if err == nil {
+		err = loadCounts(ctx, r.conn(), result)
+	}
+	if err != nil {
+		return nil, internalError(err)
+	}
+	if len(result) == 0 {
+		if _, as := getMessage(ctx, r.conn(), rootID); as != nil {
+			return nil, as
+		}
+	}
+	return result, nil
+}
+
+// GetMessagePage reads one more message than the limit to tell whether
+// another page follows. Without After the newest messages are read in
+// descending order and reversed.
+func (r *SQLMessageRepository) GetMessagePage(ctx context.Context, chatID int64, query domain.MessageQuery) (*domain.MessagePage, apistatus.Status) {
+	limit := query.Limit
+	if limit <= 0 {
+		limit = domain.DefaultMessagePageSize
+	}
+	where := []string{"chat_id = ?"}
+	args := []interface{}{chatID}
+	if query.UserID != 0 {
+		where = append(where, notHiddenFrom)
+		args = append(args, query.UserID)
+	}
+	if query.After != nil {
+		where = append(where, "(timestamp > ? OR (timestamp = ? AND id > ?))")
+		ts := toNanos(query.After.Timestamp)
+		args = append(args, ts, ts, query.After.ID)
+	}
+	if query.Before != nil {
+		where = append(where, "(timestamp < ? OR (timestamp = ? AND id < ?))")
+		ts := toNanos(query.Before.Timestamp)
+		args = append(args, ts, ts, query.Before.ID)
+	}
+	order := "timestamp DESC, id DESC"
+	if query.After != nil {
+		order = "timestamp, id"
+	}
+	args = append(args, limit+1)
+	window, err := queryMessages(ctx, r.conn(),
+		`SELECT `+messageColumns+` FROM messages WHERE `+strings.Join(where, " AND ")+` ORDER BY `+order+` LIMIT ?`, args...)
+	if err == nil {
+		err = loadCounts(ctx, r.conn(), window)
+	}
+	if err != nil {
+		return nil, internalError(err)
+	}
+
+	page := &domain.MessagePage{}
+	more := len(window) > limit
+	if more {
+		window = window[:limit]
+	}
+	if query.After == nil {
+		for i, j := 0, len(window)-1; i < j; i, j = i+1, j-1 {
+			window[i], window[j] = window[j], window[i]
+		}
+	}
+	if more {
+		if query.After != nil {
+			page.NextCursor = domain.CursorFor(window[len(window)-1]).Encode()
+		} else {
+			page.NextCursor = domain.CursorFor(window[0]).Encode()
+		}
+	}
+	page.Messages = window
+	return page, nil
+}
+
+func (r *SQLMessageRepository) UpdateMessageStatus(ctx context.Context, messageID int64, from, to domain.MessageStatus) apistatus.Status {
+	return r.UpdateMessageStatusWithOutbox(ctx, messageID, from, to, nil)
+}
+
+// UpdateMessageStatusWithOutbox only updates a message still in status from,
+// and tells a missing message from a changed one when nothing matched.
+func (r *SQLMessageRepository) UpdateMessageStatusWithOutbox(ctx context.Context, messageID int64, from, to domain.MessageStatus, buildEntry OutboxEntryBuilder) apistatus.Status {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return internalError(err)
+	}
+	defer tx.Rollback()
+	c := conn{tx, r.dialect}
+	res, err := c.ExecContext(ctx, `UPDATE messages SET status = ? WHERE id = ? AND status = ?`, to, messageID, from)
+	if err != nil {
+		return internalError(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return internalError(err)
+	}
+	if n == 0 {
+		var status domain.MessageStatus
+		err := c.QueryRowContext(ctx, `SELECT status FROM messages WHERE id = ?`, messageID).Scan(&status)
+		if errors.Is(err, sql.ErrNoRows) {
+			return apistatus.New("message not found").NotFound()
+		}
+		if err != nil {
+			return internalError(err)
+		}
+		return apistatus.New("message status is %s, not %s", status, from).Conflict()
+	}
+	_, err = c.ExecContext(ctx,
+		`INSERT INTO message_status_transitions (message_id, from_status, to_status, at) VALUES (?, ?, ?, ?)`,
+		messageID, from, to, toNanos(time.Now()))
+	if err != nil {
+		return internalError(err)
+	}
+	if buildEntry != nil {
+		msg, as := getMessage(ctx, c, messageID)
+		if as != nil {
+			return as
+		}
+		if err := addOutboxEntry(ctx, c, buildEntry, msg); err != nil {
+			return internalError(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return internalError(err)
+	}
+	return nil
+}
+
+func (r *SQLMessageRepository) GetStatusHistory(ctx context.Context, messageID int64) ([]*domain.StatusTransition, apistatus.Status) {
+	if _, as := getMessage(ctx, r.conn(), messageID); as != nil {
+		return nil, as
+	}
+	rows, err := r.conn().QueryContext(ctx,
+		`SELECT from_status, to_status, at FROM message_status_transitions WHERE message_id = ? ORDER BY id`, messageID)
+	if err != nil {
+		return nil, internalError(err)
+	}
+	defer rows.Close()
+	result := []*domain.StatusTransition{}
+	for rows.Next() {
+		transition := &domain.StatusTransition{MessageID: messageID}
+		var at int64
+		if err := rows.Scan(&transition.From, &transition.To, &at); err != nil {
+			return nil, internalError(err)
+		}
+		transition.At = fromNanos(at)
+		result = append(result, transition)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, internalError(err)
+	}
+	return result, nil
+}
+
+func (r *SQLMessageRepository) EditMessage(ctx context.Context, messageID int64, content string, at time.Time) (*domain.Message, apistatus.Status) {
+	return r.EditMessageWithOutbox(ctx, messageID, content, at, nil)
+}
+
+// EditMessageWithOutbox copies the current content into a revision and
+// replaces it in one transaction, both only while the message is not a
+// tombstone. On PostgreSQL the row is locked first, so concurrent edits
+// queue up instead of keeping the same content twice; SQLite has a single
+// writer anyway.
+func (r *SQLMessageRepository) EditMessageWithOutbox(ctx context.Context, messageID int64, content string, at time.Time, buildEntry OutboxEntryBuilder) (*domain.Message, apistatus.Status) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, internalError(err)
+	}
+	defer tx.Rollback()
+	c := conn{tx, r.dialect}
+	if r.dialect == DialectPostgres {
+		if _, err := c.ExecContext(ctx, `SELECT id FROM messages WHERE id = ? FOR UPDATE`, messageID); err != nil {
+			return nil, internalError(err)
+		}
+	}
+	res, err := c.ExecContext(ctx,
+		`INSERT INTO message_revisions (message_id, content, created_at, replaced_at)
+		SELECT id, content, COALESCE(edited_at, timestamp), ? FROM messages WHERE id = ? AND deleted_at IS NULL`,
+		toNanos(at), messageID)
+	if err != nil {
+		return nil, internalError(err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, internalError(err)
+	} else if n == 0 {
+		if _, as := getMessage(ctx, c, messageID); as != nil {
+			return nil, as
+		}
+		return nil, errMessageDeleted()
+	}
+	if _, err := c.ExecContext(ctx, `UPDATE messages SET content = ?, edited_at = ? WHERE id = ?`, content, toNanos(at), messageID); err != nil {
+		return nil, internalError(err)
+	}
+	msg, as := getMessage(ctx, c, messageID)
+	if as != nil {
+		return nil, as
+	}
+	if err := addOutboxEntry(ctx, c, buildEntry, msg); err != nil {
+		return nil, internalError(err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, internalError(err)
+	}
+	return msg, nil
+}
+
+func (r *SQLMessageRepository) GetMessageRevisions(ctx context.Context, messageID int64) ([]*domain.MessageRevision, apistatus.Status) {
+	if _, as := getMessage(ctx, r.conn(), messageID); as != nil {
+		return nil, as
+	}
+	rows, err := r.conn().QueryContext(ctx,
+		`SELECT content, created_at, replaced_at FROM message_revisions WHERE message_id = ? ORDER BY id`, messageID)
+	if err != nil {
+		return nil, internalError(err)
+	}
+	defer rows.Close()
+	result := []*domain.MessageRevision{}
+	for rows.Next() {
+		revision := &domain.MessageRevision{MessageID: messageID}
+		var createdAt, replacedAt int64
+		if err := rows.Scan(&revision.Content, &createdAt, &replacedAt); err != nil {
+			return nil, internalError(err)
+		}
+		revision.CreatedAt = fromNanos(createdAt)
+		revision.ReplacedAt = fromNanos(replacedAt)
+		result = append(result, revision)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, internalError(err)
+	}
+	return result, nil
+}
+
+func (r *SQLMessageRepository) DeleteMessage(ctx context.Context, messageID int64, at time.Time) (*domain.Message, apistatus.Status) {
+	return r.DeleteMessageWithOutbox(ctx, messageID, at, nil)
+}
+
+func (r *SQLMessageRepository) DeleteMessageWithOutbox(ctx context.Context, messageID int64, at time.Time, buildEntry OutboxEntryBuilder) (*domain.Message, apistatus.Status) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, internalError(err)
+	}
+	defer tx.Rollback()
+	c := conn{tx, r.dialect}
+	res, err := c.ExecContext(ctx, `UPDATE messages SET content = '', deleted_at = ? WHERE id = ?`, toNanos(at), messageID)
+	if err != nil {
+		return nil, internalError(err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, internalError(err)
+	} else if n == 0 {
+		return nil, apistatus.New("message not found").NotFound()
+	}
+	for _, table := range []string{"message_revisions", "message_reactions"} {
+		if _, err := c.ExecContext(ctx, `DELETE FROM `+table+` WHERE message_id = ?`, messageID); err != nil {
+			return nil, internalError(err)
+		}
+	}
+	msg, as := getMessage(ctx, c, messageID)
+	if as != nil {
+		return nil, as
+	}
+	if err := addOutboxEntry(ctx, c, buildEntry, msg); err != nil {
+		return nil, internalError(err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, internalError(err)
+	}
+	return msg, nil
+}
+
+func (r *SQLMessageRepository) HideMessage(ctx context.Context, messageID, userID int64) apistatus.Status {
+	return r.HideMessageWithOutbox(ctx, messageID, userID, nil)
+}
+
+func (r *SQLMessageRepository) HideMessageWithOutbox(ctx context.Context, messageID, userID int64, buildEntry OutboxEntryBuilder) apistatus.Status {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return internalError(err)
+	}
+	defer tx.Rollback()
+	c := conn{tx, r.dialect}
+	msg, as := getMessage(ctx, c, messageID)
+	if as != nil {
+		return as
+	}
+	res, err := c.ExecContext(ctx,
+		`INSERT INTO hidden_messages (message_id, user_id) VALUES (?, ?) ON CONFLICT (message_id, user_id) DO NOTHING`,
+		messageID, userID)
+	if err != nil {
+		return internalError(err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return internalError(err)
+	} else if n == 0 {
+		// Already hidden.
+		return nil
+	}
+	if err := addOutboxEntry(ctx, c, buildEntry, msg); err != nil {
+		return internalError(err)
+	}
+	if err := tx.Commit(); err != nil {
+		return internalError(err)
+	}
+	return nil
+}
+
+func (r *SQLMessageRepository) GetReactions(ctx context.Context, messageID int64) ([]*domain.Reaction, apistatus.Status) {
+	if _, as := getMessage(ctx, r.conn(), messageID); as != nil {
+		return nil, as
+	}
+	rows, err := r.conn().QueryContext(ctx,
+		`SELECT message_id, user_id, emoji, created_at FROM message_reactions WHERE message_id = ? ORDER BY created_at, user_id`, messageID)
+	if err != nil {
+		return nil, internalError(err)
+	}
+	defer rows.Close()
+	result := []*domain.Reaction{}
+	for rows.Next() {
+		reaction, err := scanReaction(rows)
+		if err != nil {
+			return nil, internalError(err)
+		}
+		result = append(result, reaction)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, internalError(err)
+	}
+	return result, nil
+}
+
+func (r *SQLMessageRepository) SetReaction(ctx context.Context, reaction *domain.Reaction) apistatus.Status {
+	return r.SetReactionWithOutbox(ctx, reaction, nil)
+}
+
+func (r *SQLMessageRepository) SetReactionWithOutbox(ctx context.Context, reaction *domain.Reaction, buildEntry OutboxEntryBuilder) apistatus.Status {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return internalError(err)
+	}
+	defer tx.Rollback()
+	c := conn{tx, r.dialect}
+	msg, as := getMessage(ctx, c, reaction.MessageID)
+	if as != nil {
+		return as
+	}
+	_, err = c.ExecContext(ctx,
+		`INSERT INTO message_reactions (message_id, user_id, emoji, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (message_id, user_id) DO UPDATE SET emoji = excluded.emoji, created_at = excluded.created_at`,
+		reaction.MessageID, reaction.UserID, reaction.Emoji, toNanos(reaction.CreatedAt))
+	if err != nil {
+		return internalError(err)
+	}
+	if err := addOutboxEntry(ctx, c, buildEntry, msg); err != nil {
+		return internalError(err)
+	}
+	if err := tx.Commit(); err != nil {
+		return internalError(err)
+	}
+	return nil
+}
+
+func (r *SQLMessageRepository) RemoveReaction(ctx context.Context, messageID, userID int64) (*domain.Reaction, apistatus.Status) {
+	return r.RemoveReactionWithOutbox(ctx, messageID, userID, nil)
+}
+
+func (r *SQLMessageRepository) RemoveReactionWithOutbox(ctx context.Context, messageID, userID int64, buildEntry ReactionOutboxEntryBuilder) (*domain.Reaction, apistatus.Status) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, internalError(err)
+	}
+	defer tx.Rollback()
+	c := conn{tx, r.dialect}
+	removed, err := scanReaction(c.QueryRowContext(ctx,
+		`DELETE FROM message_reactions WHERE message_id = ? AND user_id = ? RETURNING message_id, user_id, emoji, created_at`,
+		messageID, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apistatus.New("reaction not found").NotFound()
+	}
+	if err != nil {
+		return nil, internalError(err)
+	}
+	if buildEntry != nil {
+		entry, err := buildEntry(removed)
+		if err != nil {
+			return nil, internalError(err)
+		}
+		if err := insertOutboxEntry(ctx, c, entry); err != nil {
+			return nil, internalError(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, internalError(err)
+	}
+	return removed, nil
+}
+
+const attachmentColumns = `id, chat_id, uploader_id, file_name, content_type, size, storage_key, created_at, message_id,
+	thumbnail_width, thumbnail_height, thumbnail_content_type, thumbnail_size`
+
+func (r *SQLMessageRepository) CreateAttachment(ctx context.Context, attachment *domain.Attachment) (*domain.Attachment, apistatus.Status) {
+	return r.CreateAttachmentWithOutbox(ctx, attachment, nil)
+}
+
+func (r *SQLMessageRepository) CreateAttachmentWithOutbox(ctx context.Context, attachment *domain.Attachment, buildEntry AttachmentOutboxEntryBuilder) (*domain.Attachment, apistatus.Status) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, internalError(err)
+	}
+	defer tx.Rollback()
+	c := conn{tx, r.dialect}
+	var id int64
+	err = c.QueryRowContext(ctx,
+		`INSERT INTO attachments (chat_id, uploader_id, file_name, content_type, size, storage_key, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+		attachment.ChatID, attachment.UploaderID, attachment.FileName, attachment.ContentType, attachment.Size,
+		attachment.StorageKey, toNanos(attachment.CreatedAt)).Scan(&id)
+	if err != nil {
+		return nil, internalError(err)
+	}
+	attachment.ID = id
+	if buildEntry != nil {
+		entry, err := buildEntry(attachment)
+		if err == nil {
+			err = insertOutboxEntry(ctx, c, entry)
+		}
+		if err != nil {
+			attachment.ID = 0
+			return nil, internalError(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		attachment.ID = 0
+		return nil, internalError(err)
+	}
+	return attachment, nil
+}
+
+func (r *SQLMessageRepository) GetAttachment(ctx context.Context, attachmentID int64) (*domain.Attachment, apistatus.Status) {
+	attachment, err := scanAttachment(r.conn().QueryRowContext(ctx,
+		`SELECT `+attachmentColumns+` FROM attachments WHERE id = ?`, attachmentID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apistatus.New("attachment not found").NotFound()
+	}
+	if err != nil {
+		return nil, internalError(err)
+	}
+	return attachment, nil
+}
+
+func (r *SQLMessageRepository) GetAttachments(ctx context.Context, attachmentIDs []int64) ([]*domain.Attachment, apistatus.Status) {
+	result := []*domain.Attachment{}
+	if len(attachmentIDs) == 0 {
+		return result, nil
+	}
+	args := make([]interface{}, len(attachmentIDs))
+	for i, id := range attachmentIDs {
+		args[i] = id
+	}
+	rows, err := r.conn().QueryContext(ctx,
+		`SELECT `+attachmentColumns+` FROM attachments WHERE id IN (`+placeholders(len(args))+`) ORDER BY id`, args...)
+	if err != nil {
+		return nil, internalError(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			return nil, internalError(err)
+		}
+		result = append(result, attachment)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, internalError(err)
+	}
+	return result, nil
+}
+
+func (r *SQLMessageRepository) SetAttachmentThumbnail(ctx context.Context, attachmentID int64, thumbnail *domain.Thumbnail) apistatus.Status {
+	return r.update(ctx, "attachment not found",
+		`UPDATE attachments SET thumbnail_width = ?, thumbnail_height = ?, thumbnail_content_type = ?, thumbnail_size = ?
+		WHERE id = ?`,
+		thumbnail.Width, thumbnail.Height, thumbnail.ContentType, thumbnail.Size, attachmentID)
+}
+
+func scanAttachment(row scanner) (*domain.Attachment, error) {
+	var attachment domain.Attachment
+	var createdAt int64
+	var messageID, thumbnailWidth, thumbnailHeight, thumbnailSize sql.NullInt64
+	var thumbnailType sql.NullString
+	if err := row.Scan(&attachment.ID, &attachment.ChatID, &attachment.UploaderID, &attachment.FileName,
+		&attachment.ContentType, &attachment.Size, &attachment.StorageKey, &createdAt, &messageID,
+		&thumbnailWidth, &thumbnailHeight, &thumbnailType, &thumbnailSize); err != nil {
+		return nil, err
+	}
+	attachment.CreatedAt = fromNanos(createdAt)
+	attachment.MessageID = messageID.Int64
+	if thumbnailType.Valid {
+		attachment.Thumbnail = &domain.Thumbnail{
+			Width:       int(thumbnailWidth.Int64),
+			Height:      int(thumbnailHeight.Int64),
+			ContentType: thumbnailType.String,
+			Size:        thumbnailSize.Int64,
+		}
+	}
+	return &attachment, nil
+}
+
+// update runs stmt and reports notFound when it matched no row.
+func (r *SQLMessageRepository) update(ctx context.Context, notFound string, stmt string, args ...interface{}) apistatus.Status {
+	res, err := r.conn().ExecContext(ctx, stmt, args...)
+	if err != nil {
+		return internalError(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return internalError(err)
+	}
+	if n == 0 {
+		return apistatus.New(notFound).NotFound()
+	}
+	return nil
+}
+
+func (r *SQLMessageRepository) GetMessageByID(ctx context.Context, messageID int64) (*domain.Message, apistatus.Status) {
+	msg, as := getMessage(ctx, r.conn(), messageID)
+	if as != nil {
+		return nil, as
+	}
+	if err := loadCounts(ctx, r.conn(), []*domain.Message{msg}); err != nil {
+		return nil, internalError(err)
+	}
+	return msg, nil
+}
+
+func getMessage(ctx context.Context, c conn, messageID int64) (*domain.Message, apistatus.Status) {
+	result, err := queryMessages(ctx, c, `SELECT `+messageColumns+` FROM messages WHERE id = ?`, messageID)
+	if err != nil {
+		return nil, internalError(err)
+	}
+	if len(result) == 0 {
+		return nil, apistatus.New("message not found").NotFound()
+	}
+	return result[0], nil
+}
+
+// upsertReceipt sets the delivery time of the receipt of userID unless it has
+// one, and its read time when readAt is not nil.
+func upsertReceipt(ctx context.Context, c conn, messageID, userID int64, deliveredAt time.Time, readAt *time.Time) error {
+	var read interface{}
+	if readAt != nil {
+		read = toNanos(*readAt)
+	}
+	_, err := c.ExecContext(ctx,
+		`INSERT INTO message_receipts (message_id, user_id, delivered_at, read_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (message_id, user_id) DO UPDATE SET
+			delivered_at = COALESCE(message_receipts.delivered_at, excluded.delivered_at),
+			read_at = COALESCE(excluded.read_at, message_receipts.read_at)`,
+		messageID, userID, toNanos(deliveredAt), read)
+	return err
+}
+
+func (r *SQLMessageRepository) MarkMessageDelivered(ctx context.Context, messageID int64, userIDs []int64, at time.Time) (*domain.Message, apistatus.Status) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, internalError(err)
+	}
+	defer tx.Rollback()
+	c := conn{tx, r.dialect}
+	msg, as := getMessage(ctx, c, messageID)
+	if as != nil {
+		return nil, as
+	}
+	for _, id := range userIDs {
+		if id == msg.SenderID {
+			continue
+		}
+		if err := upsertReceipt(ctx, c, messageID, id, at, nil); err != nil {
+			return nil, internalError(err)
+		}
+	}
+	msg, as = getMessage(ctx, c, messageID)
+	if as != nil {
+		return nil, as
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, internalError(err)
+	}
+	return msg, nil
+}
+
+func (r *SQLMessageRepository) MarkChatRead(ctx context.Context, chatID, userID, upToMessageID int64, at time.Time) ([]*domain.Message, apistatus.Status) {
+	return r.MarkChatReadWithOutbox(ctx, chatID, userID, upToMessageID, at, nil)
+}
+
+func (r *SQLMessageRepository) MarkChatReadWithOutbox(ctx context.Context, chatID, userID, upToMessageID int64, at time.Time, buildEntry ReadOutboxEntryBuilder) ([]*domain.Message, apistatus.Status) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, internalError(err)
+	}
+	defer tx.Rollback()
+	c := conn{tx, r.dialect}
+	rows, err := c.QueryContext(ctx,
+		`SELECT `+messageColumns+` FROM messages m WHERE chat_id = ? AND id <= ? AND sender_id <> ?
+		AND NOT EXISTS (SELECT 1 FROM message_receipts r WHERE r.message_id = m.id AND r.user_id = ? AND r.read_at IS NOT NULL)
+		ORDER BY id`, chatID, upToMessageID, userID, userID)
+	if err != nil {
+		return nil, internalError(err)
+	}
+	result := []*domain.Message{}
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			rows.Close()
+			return nil, internalError(err)
+		}
+		result = append(result, msg)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, internalError(err)
+	}
+	for _, msg := range result {
+		if err := upsertReceipt(ctx, c, msg.ID, userID, at, &at); err != nil {
+			return nil, internalError(err)
+		}
+	}
+	// Move the read position forward, never back.
+	_, err = c.ExecContext(ctx,
+		`INSERT INTO chat_reads (chat_id, user_id, last_read_message_id) VALUES (?, ?, ?)
+		ON CONFLICT (chat_id, user_id) DO UPDATE SET last_read_message_id = CASE
+			WHEN excluded.last_read_message_id > chat_reads.last_read_message_id THEN excluded.last_read_message_id
+			ELSE chat_reads.last_read_message_id END`,
+		chatID, userID, upToMessageID)
+	if err == nil {
+		err = loadReceipts(ctx, c, result)
+	}
+	if err == nil && buildEntry != nil && len(result) > 0 {
+		var entry *domain.OutboxEntry
+		if entry, err = buildEntry(result); err == nil {
+			err = insertOutboxEntry(ctx, c, entry)
+		}
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		return nil, internalError(err)
+	}
+	return result, nil
+}
+
+// SummarizeChats finds the last visible message of each chat with one index
+// lookup per chat, and counts unread messages from the read positions in
+// chat_reads.
+func (r *SQLMessageRepository) SummarizeChats(ctx context.Context, userID int64, chats []*domain.Chat) ([]*domain.ChatSummary, apistatus.Status) {
+	if len(chats) == 0 {
+		return []*domain.ChatSummary{}, nil
+	}
+	c := r.conn()
+	chatIDs := make([]interface{}, len(chats))
+	lastIDs := make([]string, len(chats))
+	lastArgs := make([]interface{}, 0, 2*len(chats))
+	for i, chat := range chats {
+		chatIDs[i] = chat.ID
+		lastIDs[i] = `(SELECT id FROM messages WHERE chat_id = ? AND deleted_at IS NULL AND ` + notHiddenFrom + `
+			ORDER BY timestamp DESC, id DESC LIMIT 1)`
+		lastArgs = append(lastArgs, chat.ID, userID)
+	}
+	lastMessages, err := queryMessages(ctx, c,
+		`SELECT `+messageColumns+` FROM messages WHERE id IN (`+strings.Join(lastIDs, ", ")+`)`, lastArgs...)
+	if err != nil {
+		return nil, internalError(err)
+	}
+	last := make(map[int64]*domain.Message, len(lastMessages))
+	for _, msg := range lastMessages {
+		last[msg.ChatID] = msg
+	}
+
+	args := append([]interface{}{userID, userID}, chatIDs...)
+	args = append(args, userID)
+	rows, err := c.QueryContext(ctx,
+		`SELECT messages.chat_id, COUNT(*) FROM messages
+		LEFT JOIN chat_reads r ON r.chat_id = messages.chat_id AND r.user_id = ?
+		WHERE messages.sender_id <> ? AND messages.id > COALESCE(r.last_read_message_id, 0)
+		AND messages.chat_id IN (`+placeholders(len(chats))+`)
+		AND messages.deleted_at IS NULL AND `+notHiddenFrom+`
+		GROUP BY messages.chat_id`, args...)
+	if err != nil {
+		return nil, internalError(err)
+	}
+	defer rows.Close()
+	unread := make(map[int64]int, len(chats))
+	for rows.Next() {
+		var chatID int64
+		var count int
+		if err := rows.Scan(&chatID, &count); err != nil {
+			return nil, internalError(err)
+		}
+		unread[chatID] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, internalError(err)
+	}
+
+	result := make([]*domain.ChatSummary, len(chats))
+	for i, chat := range chats {
+		result[i] = domain.NewChatSummary(chat, last[chat.ID], unread[chat.ID])
+	}
+	return result, nil
+}
+
+// GetMessagesSince returns the messages of chatIDs with an ID greater than
+// afterID that userID has not hidden, ordered by ID.
+func (r *SQLMessageRepository) GetMessagesSince(ctx context.Context, chatIDs []int64, userID, afterID int64) ([]*domain.Message, apistatus.Status) {
+	if len(chatIDs) == 0 {
+		return []*domain.Message{}, nil
+	}
+	args := []interface{}{afterID}
+	for _, id := range chatIDs {
+		args = append(args, id)
+	}
+	args = append(args, userID)
+	result, err := queryMessages(ctx, r.conn(),
+		`SELECT `+messageColumns+` FROM messages WHERE id > ? AND chat_id IN (`+placeholders(len(chatIDs))+`) AND `+notHiddenFrom+` ORDER BY id`, args...)
+	if err == nil {
+		err = loadCounts(ctx, r.conn(), result)
+	}
+	if err != nil {
+		return nil, internalError(err)
+	}
+	return result, nil
+}
+
+func (r *SQLMessageRepository) AddOutboxEntry(ctx context.Context, entry *domain.OutboxEntry) (*domain.OutboxEntry, apistatus.Status) {
+	if err := insertOutboxEntry(ctx, r.conn(), entry); err != nil {
+		return nil, internalError(err)
+	}
+	return entry, nil
+}
+
+// addOutboxEntry stores the outbox entry built for msg, unless buildEntry is
+// nil.
+func addOutboxEntry(ctx context.Context, c conn, buildEntry OutboxEntryBuilder, msg *domain.Message) error {
+	if buildEntry == nil {
+		return nil
+	}
+	entry, err := buildEntry(msg)
+	if err != nil {
+		return err
+	}
+	return insertOutboxEntry(ctx, c, entry)
+}
+
+func insertOutboxEntry(ctx context.Context, c conn, entry *domain.OutboxEntry) error {
+	entry.Status = domain.OutboxStatusPending
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	if entry.NextAttemptAt.IsZero() {
+		entry.NextAttemptAt = entry.CreatedAt
+	}
+	return c.QueryRowContext(ctx,
+		`INSERT INTO outbox (event_type, aggregate_id, payload, status, attempts, last_error, created_at, next_attempt_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+		entry.EventType, entry.AggregateID, []byte(entry.Payload), entry.Status, entry.Attempts, entry.LastError,
+		toNanos(entry.CreatedAt), toNanos(entry.NextAttemptAt)).Scan(&entry.ID)
+}
+
+// GetPendingOutboxEntries returns up to limit pending entries that are due at
+// now, oldest first.
+func (r *SQLMessageRepository) GetPendingOutboxEntries(ctx context.Context, now time.Time, limit int) ([]*domain.OutboxEntry, apistatus.Status) {
+	query := `SELECT ` + outboxColumns + ` FROM outbox WHERE status = ? AND next_attempt_at <= ? ORDER BY id`
+	args := []interface{}{domain.OutboxStatusPending, toNanos(now)}
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+	return queryOutboxEntries(ctx, r.conn(), query, args...)
+}
+
+// ClaimOutboxEntries selects the due rows and moves their next attempt to
+// the end of the lease in one statement. On PostgreSQL the rows are locked
+// with SKIP LOCKED, so concurrent relays claim disjoint rows instead of
+// waiting for each other; SQLite serializes writers anyway.
+func (r *SQLMessageRepository) ClaimOutboxEntries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*domain.OutboxEntry, apistatus.Status) {
+	due := `SELECT id FROM outbox WHERE status = ? AND next_attempt_at <= ? ORDER BY id`
+	args := []interface{}{toNanos(now.Add(lease)), domain.OutboxStatusPending, toNanos(now)}
+	if limit > 0 {
+		due += ` LIMIT ?`
+		args = append(args, limit)
+	}
+	if r.dialect == DialectPostgres {
+		due += ` FOR UPDATE SKIP LOCKED`
+	}
+	result, as := queryOutboxEntries(ctx, r.conn(),
+		`UPDATE outbox SET next_attempt_at = ? WHERE id IN (`+due+`) RETURNING `+outboxColumns, args...)
+	if as != nil {
+		return nil, as
+	}
+	// RETURNING does not keep the order of the subquery.
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result, nil
+}
+
+const outboxColumns = `id, event_type, aggregate_id, payload, status, attempts, last_error, created_at, next_attempt_at, sent_at`
+
+// queryOutboxEntries runs a query returning outboxColumns.
+func queryOutboxEntries(ctx context.Context, c conn, query string, args ...interface{}) ([]*domain.OutboxEntry, apistatus.Status) {
+	rows, err := c.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, internalError(err)
+	}
+	defer rows.Close()
+	result := []*domain.OutboxEntry{}
+	for rows.Next() {
+		var entry domain.OutboxEntry
+		var payload []byte
+		var createdAt, nextAttemptAt int64
+		var sentAt sql.NullInt64
+		err := rows.Scan(&entry.ID, &entry.EventType, &entry.AggregateID, &payload, &entry.Status, &entry.Attempts,
+			&entry.LastError, &createdAt, &nextAttemptAt, &sentAt)
+		if err != nil {
+			return nil, internalError(err)
+		}
+		entry.Payload = payload
+		entry.CreatedAt = fromNanos(createdAt)
+		entry.NextAttemptAt = fromNanos(nextAttemptAt)
+		if sentAt.Valid {
+			t := fromNanos(sentAt.Int64)
+			entry.SentAt = &t
+		}
+		result = append(result, &entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, internalError(err)
+	}
+	return result, nil
+}
+
+// MarkOutboxEntrySent deletes the row; published events are not kept.
+func (r *SQLMessageRepository) MarkOutboxEntrySent(ctx context.Context, entryID int64) apistatus.Status {
+	return r.update(ctx, "outbox entry not found", `DELETE FROM outbox WHERE id = ?`, entryID)
+}
+
+func (r *SQLMessageRepository) MarkOutboxEntryFailed(ctx context.Context, entryID int64, reason string, nextAttemptAt time.Time) apistatus.Status {
+	return r.update(ctx, "outbox entry not found",
+		`UPDATE outbox SET attempts = attempts + 1, last_error = ?, next_attempt_at = ? WHERE id = ?`,
+		reason, toNanos(nextAttemptAt), entryID)
+}
+
+// SQLDeadLetterRepository implements DeadLetterRepository on a SQL database,
+// so parked messages survive restarts like the rest of the data.
+type SQLDeadLetterRepository struct {
+	db      *sql.DB
+	dialect Dialect
+}
+
+// NewSQLDeadLetterRepository creates a repository on a migrated database.
+func NewSQLDeadLetterRepository(db *sql.DB, dialect Dialect) DeadLetterRepository {
+	return &SQLDeadLetterRepository{db: db, dialect: dialect}
+}
+
+func (r *SQLDeadLetterRepository) conn() conn {
+	return conn{r.db, r.dialect}
+}
+
+const deadLetterColumns = `id, message_id, correlation_id, event_type, routing_key, queue, reason, last_error, retry_count,
+	content_type, body, dead_lettered_at`
+
+func (r *SQLDeadLetterRepository) AddDeadLetter(ctx context.Context, dl *domain.DeadLetter) (*domain.DeadLetter, apistatus.Status) {
+	err := r.conn().QueryRowContext(ctx,
+		`INSERT INTO dead_letters (message_id, correlation_id, event_type, routing_key, queue, reason, last_error, retry_count,
+			content_type, body, dead_lettered_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+		dl.MessageID, dl.CorrelationID, dl.EventType, dl.RoutingKey, dl.Queue, dl.Reason, dl.LastError, dl.RetryCount,
+		dl.ContentType, []byte(dl.Body), toNanos(dl.DeadLetteredAt)).Scan(&dl.ID)
+	if err != nil {
+		return nil, internalError(err)
+	}
+	return dl, nil
+}
+
+// ListDeadLetters returns every dead letter, oldest first.
+func (r *SQLDeadLetterRepository) ListDeadLetters(ctx context.Context) ([]*domain.DeadLetter, apistatus.Status) {
+	rows, err := r.conn().QueryContext(ctx, `SELECT `+deadLetterColumns+` FROM dead_letters ORDER BY id`)
+	if err != nil {
+		return nil, internalError(err)
+	}
+	defer rows.Close()
+	result := []*domain.DeadLetter{}
+	for rows.Next() {
+		dl, err := scanDeadLetter(rows)
+		if err != nil {
+			return nil, internalError(err)
+		}
+		result = append(result, dl)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, internalError(err)
+	}
+	return result, nil
+}
+
+func (r *SQLDeadLetterRepository) GetDeadLetter(ctx context.Context, id int64) (*domain.DeadLetter, apistatus.Status) {
+	dl, err := scanDeadLetter(r.conn().QueryRowContext(ctx, `SELECT `+deadLetterColumns+` FROM dead_letters WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apistatus.New("dead letter not found").NotFound()
+	}
+	if err != nil {
+		return nil, internalError(err)
+	}
+	return dl, nil
+}
+
+func (r *SQLDeadLetterRepository) DeleteDeadLetter(ctx context.Context, id int64) apistatus.Status {
+	res, err := r.conn().ExecContext(ctx, `DELETE FROM dead_letters WHERE id = ?`, id)
+	if err != nil {
+		return internalError(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return internalError(err)
+	}
+	if n == 0 {
+		return apistatus.New("dead letter not found").NotFound()
+	}
+	return nil
+}
+
+// DeleteAllDeadLetters removes every dead letter and returns how many there
+// were.
+func (r *SQLDeadLetterRepository) DeleteAllDeadLetters(ctx context.Context) (int, apistatus.Status) {
+	res, err := r.conn().ExecContext(ctx, `DELETE FROM dead_letters`)
+	if err != nil {
+		return 0, internalError(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, internalError(err)
+	}
+	return int(n), nil
+}
+
+func scanDeadLetter(row scanner) (*domain.DeadLetter, error) {
+	var dl domain.DeadLetter
+	var body []byte
+	var deadLetteredAt int64
+	err := row.Scan(&dl.ID, &dl.MessageID, &dl.CorrelationID, &dl.EventType, &dl.RoutingKey, &dl.Queue, &dl.Reason,
+		&dl.LastError, &dl.RetryCount, &dl.ContentType, &body, &deadLetteredAt)
+	if err != nil {
+		return nil, err
+	}
+	dl.Body = string(body)
+	dl.DeadLetteredAt = fromNanos(deadLetteredAt)
+	return &dl, nil
+}
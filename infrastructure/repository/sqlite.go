@@ -0,0 +1,36 @@
+package repository
+
+import (
+	"database/sql"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	// Registers the pure-Go "sqlite" driver.
+	_ "modernc.org/sqlite"
+)
+
+// OpenSQLite opens the SQLite database at path, creating the file and its
+// directory if needed. Use ":memory:" for a throwaway database. Run Migrate
+// before using it.
+func OpenSQLite(path string) (*sql.DB, error) {
+	if path != ":memory:" {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, err
+		}
+	}
+	// WAL lets readers proceed during a write; the busy timeout covers the
+	// short window where a writer holds the lock.
+	pragmas := url.Values{}
+	pragmas.Add("_pragma", "foreign_keys(1)")
+	pragmas.Add("_pragma", "busy_timeout(5000)")
+	pragmas.Add("_pragma", "journal_mode(WAL)")
+	db, err := sql.Open("sqlite", "file:"+path+"?"+pragmas.Encode())
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer. One connection serializes writes instead
+	// of failing them with SQLITE_BUSY, and keeps a ":memory:" database alive.
+	db.SetMaxOpenConns(1)
+	return db, nil
+}
@@ -0,0 +1,20 @@
+package middleware
+
+import (
+	"net/http"
+
+	"messaging-app/pkg/correlation"
+)
+
+// CorrelationIDMiddleware reuses the caller's X-Correlation-ID or generates
+// one, echoes it in the response and stores it in the request context.
+func CorrelationIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(correlation.Header)
+		if id == "" || len(id) > 128 {
+			id = correlation.NewID()
+		}
+		w.Header().Set(correlation.Header, id)
+		next.ServeHTTP(w, r.WithContext(correlation.WithID(r.Context(), id)))
+	})
+}
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"messaging-app/pkg/correlation"
+)
+
+func TestCorrelationIDMiddleware(t *testing.T) {
+	var seen string
+	handler := CorrelationIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = correlation.FromContext(r.Context())
+	}))
+
+	// An incoming ID is propagated.
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set(correlation.Header, "abc123")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if seen != "abc123" || rr.Header().Get(correlation.Header) != "abc123" {
+		t.Errorf("expected abc123 in context and response, got %q and %q", seen, rr.Header().Get(correlation.Header))
+	}
+
+	// Without one, an ID is generated.
+	req, _ = http.NewRequest("GET", "/", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if seen == "" || rr.Header().Get(correlation.Header) != seen {
+		t.Errorf("expected a generated ID echoed in the response, got %q and %q", seen, rr.Header().Get(correlation.Header))
+	}
+}
@@ -51,10 +51,14 @@ type Status interface {
 	Unauthorized() Status
 	Forbidden() Status
 	NotFound() Status
+	Conflict() Status
+	RequestEntityTooLarge() Status
+	UnsupportedMediaType() Status
 	UnprocessableEntity() Status
 
 	// 5xx Server Error Statuses
 	InternalServerError() Status
+	ServiceUnavailable() Status
 
 	// Checks if the status represents an error (HTTP 4xx or 5xx).
 	IsError() bool
@@ -226,6 +230,21 @@ func (s *status) NotFound() Status {
 	return s.update("not found", http.StatusNotFound)
 }
 
+// Conflict sets the status to 409 Conflict.
+func (s *status) Conflict() Status {
+	return s.update("conflict", http.StatusConflict)
+}
+
+// RequestEntityTooLarge sets the status to 413 Request Entity Too Large.
+func (s *status) RequestEntityTooLarge() Status {
+	return s.update("request entity too large", http.StatusRequestEntityTooLarge)
+}
+
+// UnsupportedMediaType sets the status to 415 Unsupported Media Type.
+func (s *status) UnsupportedMediaType() Status {
+	return s.update("unsupported media type", http.StatusUnsupportedMediaType)
+}
+
 // UnprocessableEntity sets the status to 422 Unprocessable Entity.
 func (s *status) UnprocessableEntity() Status {
 	return s.update("unprocessable entity", http.StatusUnprocessableEntity)
@@ -238,6 +257,11 @@ func (s *status) InternalServerError() Status {
 	return s.update("internal server error", http.StatusInternalServerError)
 }
 
+// ServiceUnavailable sets the status to 503 Service Unavailable.
+func (s *status) ServiceUnavailable() Status {
+	return s.update("service unavailable", http.StatusServiceUnavailable)
+}
+
 // IsError returns true if the HTTP status code indicates an error (i.e. 400 or above).
 func (s *status) IsError() bool {
 	return s.httpStatus >= http.StatusBadRequest
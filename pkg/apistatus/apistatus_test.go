@@ -85,8 +85,12 @@ func TestHTTPStatusMethods(t *testing.T) {
 		{"Unauthorized", func(s Status) Status { return s.Unauthorized() }, http.StatusUnauthorized, "unauthorized"},
 		{"Forbidden", func(s Status) Status { return s.Forbidden() }, http.StatusForbidden, "forbidden"},
 		{"NotFound", func(s Status) Status { return s.NotFound() }, http.StatusNotFound, "not found"},
+		{"Conflict", func(s Status) Status { return s.Conflict() }, http.StatusConflict, "conflict"},
+		{"RequestEntityTooLarge", func(s Status) Status { return s.RequestEntityTooLarge() }, http.StatusRequestEntityTooLarge, "request entity too large"},
+		{"UnsupportedMediaType", func(s Status) Status { return s.UnsupportedMediaType() }, http.StatusUnsupportedMediaType, "unsupported media type"},
 		{"UnprocessableEntity", func(s Status) Status { return s.UnprocessableEntity() }, http.StatusUnprocessableEntity, "unprocessable entity"},
 		{"InternalServerError", func(s Status) Status { return s.InternalServerError() }, http.StatusInternalServerError, "internal server error"},
+		{"ServiceUnavailable", func(s Status) Status { return s.ServiceUnavailable() }, http.StatusServiceUnavailable, "service unavailable"},
 	}
 
 	baseMsg := "test message"
@@ -0,0 +1,24 @@
+package correlation
+
+import (
+	"context"
+	"testing"
+)
+
+func TestWithID(t *testing.T) {
+	ctx := context.Background()
+	if id := FromContext(ctx); id != "" {
+		t.Errorf("expected empty ID, got %q", id)
+	}
+	ctx = WithID(ctx, "abc")
+	if id := FromContext(ctx); id != "abc" {
+		t.Errorf("expected abc, got %q", id)
+	}
+}
+
+func TestNewID(t *testing.T) {
+	a, b := NewID(), NewID()
+	if len(a) != 32 || a == b {
+		t.Errorf("expected two distinct 32-char IDs, got %q and %q", a, b)
+	}
+}
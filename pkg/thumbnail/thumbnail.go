@@ -0,0 +1,116 @@
+// Package thumbnail scales JPEG and PNG images down to thumbnails using the
+// standard library only.
+package thumbnail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+// MaxSourcePixels is the largest image, in pixels, Generate decodes. Larger
+// images are rejected before decoding so a small file cannot expand into
+// gigabytes of pixels.
+const MaxSourcePixels = 50000000
+
+// jpegQuality is the quality thumbnails of JPEG images are encoded with.
+const jpegQuality = 80
+
+// ErrTooLarge is returned for images of more than MaxSourcePixels.
+var ErrTooLarge = errors.New("image is too large for a thumbnail")
+
+// Generate decodes a JPEG or PNG image from r and writes it to w scaled down
+// to fit in a maxSize square, in the format of the original. Images that
+// already fit keep their size. It returns the content type and the
+// dimensions of the thumbnail.
+func Generate(w io.Writer, r io.Reader, maxSize int) (string, image.Point, error) {
+	if maxSize <= 0 {
+		return "", image.Point{}, fmt.Errorf("invalid thumbnail size %d", maxSize)
+	}
+	// Check the dimensions from the header, then decode from the start again.
+	var head bytes.Buffer
+	config, format, err := image.DecodeConfig(io.TeeReader(r, &head))
+	if err != nil {
+		return "", image.Point{}, err
+	}
+	if format != "jpeg" && format != "png" {
+		return "", image.Point{}, fmt.Errorf("unsupported image format %s", format)
+	}
+	if config.Width <= 0 || config.Height <= 0 || int64(config.Width)*int64(config.Height) > MaxSourcePixels {
+		return "", image.Point{}, ErrTooLarge
+	}
+	src, _, err := image.Decode(io.MultiReader(&head, r))
+	if err != nil {
+		return "", image.Point{}, err
+	}
+
+	size := fit(src.Bounds().Size(), maxSize)
+	thumbnail := scale(src, size)
+	if format == "jpeg" {
+		err = jpeg.Encode(w, thumbnail, &jpeg.Options{Quality: jpegQuality})
+	} else {
+		err = png.Encode(w, thumbnail)
+	}
+	if err != nil {
+		return "", image.Point{}, err
+	}
+	return "image/" + format, size, nil
+}
+
+// fit returns size scaled down, keeping its aspect ratio, so neither side
+// exceeds maxSize. Sides are at least one pixel.
+func fit(size image.Point, maxSize int) image.Point {
+	if size.X <= maxSize && size.Y <= maxSize {
+		return size
+	}
+	if size.X >= size.Y {
+		return image.Pt(maxSize, max(1, size.Y*maxSize/size.X))
+	}
+	return image.Pt(max(1, size.X*maxSize/size.Y), maxSize)
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// scale returns src reduced to size, which must not be larger, by averaging
+// the source pixels each thumbnail pixel covers. Colors are premultiplied by
+// alpha, so transparent pixels do not darken their neighbours. Source rows are
+// converted one band at a time to keep memory proportional to the width.
+func scale(src image.Image, size image.Point) *image.RGBA {
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, size.X, size.Y))
+	band := image.NewRGBA(image.Rect(0, 0, srcW, (srcH+size.Y-1)/size.Y))
+	for y := 0; y < size.Y; y++ {
+		y0, y1 := y*srcH/size.Y, (y+1)*srcH/size.Y
+		draw.Draw(band, image.Rect(0, 0, srcW, y1-y0), src, image.Pt(bounds.Min.X, bounds.Min.Y+y0), draw.Src)
+		for x := 0; x < size.X; x++ {
+			x0, x1 := x*srcW/size.X, (x+1)*srcW/size.X
+			var sum [4]int
+			for row := 0; row < y1-y0; row++ {
+				pix := band.Pix[row*band.Stride+x0*4 : row*band.Stride+x1*4]
+				for i := 0; i < len(pix); i += 4 {
+					sum[0] += int(pix[i])
+					sum[1] += int(pix[i+1])
+					sum[2] += int(pix[i+2])
+					sum[3] += int(pix[i+3])
+				}
+			}
+			n := (x1 - x0) * (y1 - y0)
+			offset := dst.PixOffset(x, y)
+			for c := range sum {
+				dst.Pix[offset+c] = uint8((sum[c] + n/2) / n)
+			}
+		}
+	}
+	return dst
+}
@@ -0,0 +1,104 @@
+package thumbnail
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+// encode returns img as PNG, or as JPEG if asJPEG is set.
+func encode(t *testing.T, img image.Image, asJPEG bool) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var err error
+	if asJPEG {
+		err = jpeg.Encode(&buf, img, nil)
+	} else {
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		t.Fatalf("failed to encode the source image: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// TestGenerate checks the size and format of thumbnails.
+func TestGenerate(t *testing.T) {
+	tests := []struct {
+		name     string
+		width    int
+		height   int
+		asJPEG   bool
+		expected image.Point
+	}{
+		{"landscape png", 800, 600, false, image.Pt(320, 240)},
+		{"portrait jpeg", 300, 1200, true, image.Pt(80, 320)},
+		{"small", 100, 50, false, image.Pt(100, 50)},
+		{"thin", 2000, 3, true, image.Pt(320, 1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := image.NewRGBA(image.Rect(0, 0, tt.width, tt.height))
+			var out bytes.Buffer
+			contentType, size, err := Generate(&out, bytes.NewReader(encode(t, src, tt.asJPEG)), 320)
+			if err != nil {
+				t.Fatalf("Generate failed: %v", err)
+			}
+			if size != tt.expected {
+				t.Errorf("expected size %v, got %v", tt.expected, size)
+			}
+			decoded, format, err := image.Decode(&out)
+			if err != nil {
+				t.Fatalf("failed to decode the thumbnail: %v", err)
+			}
+			if decoded.Bounds().Size() != tt.expected || contentType != "image/"+format || (format == "jpeg") != tt.asJPEG {
+				t.Errorf("expected a %v thumbnail in the source format, got %v %s as %s", tt.expected, decoded.Bounds().Size(), format, contentType)
+			}
+		})
+	}
+}
+
+// TestGenerate_Averages checks that each thumbnail pixel averages the pixels
+// it covers, transparency included.
+func TestGenerate_Averages(t *testing.T) {
+	// Left half opaque red, right half transparent.
+	src := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 2; x++ {
+			src.Set(x, y, color.NRGBA{R: 255, A: 255})
+		}
+	}
+	var out bytes.Buffer
+	if _, _, err := Generate(&out, bytes.NewReader(encode(t, src, false)), 1); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	thumbnail, err := png.Decode(&out)
+	if err != nil {
+		t.Fatalf("failed to decode the thumbnail: %v", err)
+	}
+	if got := color.NRGBAModel.Convert(thumbnail.At(0, 0)).(color.NRGBA); got.R != 255 || got.G != 0 || got.A < 127 || got.A > 128 {
+		t.Errorf("expected half-transparent red, got %+v", got)
+	}
+}
+
+// TestGenerate_Rejects checks that invalid and oversized images are refused.
+func TestGenerate_Rejects(t *testing.T) {
+	if _, _, err := Generate(&bytes.Buffer{}, strings.NewReader("not an image"), 320); err == nil {
+		t.Error("expected an error for content that is not an image")
+	}
+	// An IHDR chunk claiming 10000x10000 pixels is rejected without decoding.
+	huge := encode(t, image.NewGray(image.Rect(0, 0, 1, 1)), false)
+	huge[16], huge[17], huge[18], huge[19] = 0, 0, 0x27, 0x10
+	huge[20], huge[21], huge[22], huge[23] = 0, 0, 0x27, 0x10
+	binary.BigEndian.PutUint32(huge[29:], crc32.ChecksumIEEE(huge[12:29]))
+	if _, _, err := Generate(&bytes.Buffer{}, bytes.NewReader(huge), 320); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+}